@@ -147,6 +147,9 @@ var BatchUpdateInterval int
 
 var RelayTimeout int // unit is second
 
+// FileStoragePath is the directory of files uploaded through the Files API and batch outputs
+var FileStoragePath string
+
 var GeminiSafetySetting string
 
 // https://docs.cohere.com/docs/safety-modes Type; NONE/CONTEXTUAL/STRICT
@@ -268,6 +271,7 @@ const (
 	ChannelTypeXinference     = 47
 	ChannelTypeXai            = 48
 	ChannelTypeGitHub         = 49
+	ChannelTypeCustomProvider = 50
 	ChannelTypeDummy          // this one is only for count, do not add any channel after this
 
 )
@@ -323,4 +327,5 @@ var ChannelBaseURLs = []string{
 	"",                                          //47
 	"https://api.x.ai",                          //48
 	"https://models.github.ai/inference",        //49
+	"",                                          //50
 }
@@ -19,13 +19,19 @@ package common
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"io"
+	"mime"
+	"mime/multipart"
 	"strings"
 )
 
 const KeyRequestBody = "key_request_body"
 
+// 与 gin 默认的 MaxMultipartMemory 保持一致
+const multipartMemoryLimit = 32 << 20
+
 func GetRequestBody(c *gin.Context) ([]byte, error) {
 	requestBody, _ := c.Get(KeyRequestBody)
 	if requestBody != nil {
@@ -59,3 +65,35 @@ func UnmarshalBodyReusable(c *gin.Context, v any) error {
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 	return nil
 }
+
+// ParseMultipartFormReusable 从缓存的请求体中解析 multipart 表单，请求体可以被重复解析（例如重试时）。
+// 调用方使用完毕后需要调用 form.RemoveAll 清理临时文件
+func ParseMultipartFormReusable(c *gin.Context) (*multipart.Form, error) {
+	requestBody, err := GetRequestBody(c)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, params, err := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, errors.New("content type must be multipart/form-data")
+	}
+	form, err := multipart.NewReader(bytes.NewReader(requestBody), params["boundary"]).ReadForm(multipartMemoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	// Reset request body
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	return form, nil
+}
+
+// GetMultipartBoundary 返回请求 multipart 表单的分界线
+func GetMultipartBoundary(c *gin.Context) string {
+	_, params, err := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return params["boundary"]
+}
@@ -17,9 +17,22 @@
 package constant
 
 var (
-	ForceFormat                     = "force_format"        // ForceFormat 强制格式化为OpenAI格式
-	ChanelSettingProxy              = "proxy"               // Proxy 代理
-	ChannelSettingThinkingToContent = "thinking_to_content" // ThinkingToContent
-	ChannelSettingStreamSupport     = "stream_support"      // StreamSupport 控制上游流式请求行为
-	StreamSupportNonStreamOnly      = "NON_STREAM_ONLY"     // StreamSupport 仅非流式请求
+	ForceFormat                           = "force_format"               // ForceFormat 强制格式化为OpenAI格式
+	ChanelSettingProxy                    = "proxy"                      // Proxy 代理
+	ChannelSettingThinkingToContent       = "thinking_to_content"        // ThinkingToContent
+	ChannelSettingStreamSupport           = "stream_support"             // StreamSupport 控制上游流式请求行为
+	StreamSupportNonStreamOnly            = "NON_STREAM_ONLY"            // StreamSupport 仅非流式请求
+	ChannelSettingMaxConcurrency          = "max_concurrency"            // MaxConcurrency 渠道最大并发请求数
+	ChannelSettingKeyMaxConcurrency       = "key_max_concurrency"        // KeyMaxConcurrency 多 key 渠道中每个 key 的最大并发请求数
+	ChannelSettingShadowChannelId         = "shadow_channel_id"          // ShadowChannelId 影子流量镜像到的候选渠道
+	ChannelSettingShadowTag               = "shadow_tag"                 // ShadowTag 影子流量镜像到该标签下的渠道
+	ChannelSettingShadowRatio             = "shadow_ratio"               // ShadowRatio 镜像的请求百分比
+	ChannelSettingShadowDiff              = "shadow_diff"                // ShadowDiff 记录主请求和影子请求的响应内容用于对比
+	ChannelSettingSchedule                = "schedule"                   // Schedule 渠道的可用时间段，类 cron 表达式
+	ChannelSettingScheduleBlackout        = "schedule_blackout"          // ScheduleBlackout 渠道的禁用时间段，优先于可用时间段
+	ChannelSettingScheduleTimezone        = "schedule_timezone"          // ScheduleTimezone 可用时间段使用的时区，默认为服务器时区
+	ChannelSettingCanaryRamp              = "canary_ramp"                // CanaryRamp 为 false 时渠道新增或重新启用后不参与灰度放量
+	ChannelSettingAwsModelIds             = "aws_model_ids"              // AwsModelIds AWS 渠道中模型名到模型 ID、推理配置文件 ID 或 ARN 的映射
+	ChannelSettingAzureRealtimeApiVersion = "azure_realtime_api_version" // AzureRealtimeApiVersion Azure 实时接口使用的 api-version，为 v1 时使用 GA 版接口
+	ChannelSettingCustomProvider          = "custom_provider"            // CustomProvider 自定义供应商渠道的请求/响应映射配置
 )
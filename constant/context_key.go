@@ -23,4 +23,10 @@ const (
 	ContextKeyUserStatus       = "user_status"
 	ContextKeyUserEmail        = "user_email"
 	ContextKeyUserGroup        = "user_group"
+
+	// ContextKeyRelayInfo 当前尝试的 RelayInfo，用于请求结束后统计渠道延迟
+	ContextKeyRelayInfo = "relay_info"
+
+	// ContextKeyResponsesResponse 最终的 Responses API 响应对象，由处理响应的 handler 设置
+	ContextKeyResponsesResponse = "responses_response"
 )
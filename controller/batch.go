@@ -0,0 +1,163 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/model"
+	"veloera/service"
+	"veloera/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// https://platform.openai.com/docs/api-reference/batch
+
+func getUserBatch(c *gin.Context) (*model.Batch, bool) {
+	batchId := c.Param("id")
+	batch, err := model.GetBatchByBatchId(batchId, c.GetInt("id"))
+	if err != nil {
+		openAIErrorResponse(c, http.StatusNotFound, "invalid_request_error", "batch_not_found", fmt.Sprintf("No such Batch object: %s", batchId))
+		return nil, false
+	}
+	service.CheckStaleBatch(batch)
+	return batch, true
+}
+
+func CreateBatch(c *gin.Context) {
+	if !model_setting.GetBatchSettings().Enabled {
+		openAIErrorResponse(c, http.StatusForbidden, "veloera_error", "batch_disabled", "Batch API is disabled")
+		return
+	}
+	var request dto.BatchRequest
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "invalid_request", err.Error())
+		return
+	}
+	if !service.BatchEndpoints[request.Endpoint] {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "invalid_endpoint", fmt.Sprintf("Unsupported endpoint: '%s'", request.Endpoint))
+		return
+	}
+	if request.CompletionWindow != service.BatchCompletionWindow {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "invalid_completion_window", fmt.Sprintf("Only the '%s' completion window is supported", service.BatchCompletionWindow))
+		return
+	}
+	userId := c.GetInt("id")
+	inputFile, err := model.GetFileByFileId(request.InputFileId, userId)
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "file_not_found", fmt.Sprintf("No such File object: %s", request.InputFileId))
+		return
+	}
+	if inputFile.Purpose != service.FilePurposeBatch {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "invalid_file_purpose", "The input file must be uploaded with purpose 'batch'")
+		return
+	}
+
+	now := time.Now()
+	batch := &model.Batch{
+		BatchId:          fmt.Sprintf("batch_%s", common.GetUUID()),
+		UserId:           userId,
+		TokenId:          c.GetInt("token_id"),
+		Endpoint:         request.Endpoint,
+		InputFileId:      request.InputFileId,
+		CompletionWindow: request.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		Metadata:         request.Metadata,
+		CreatedAt:        now.Unix(),
+		ExpiresAt:        now.Add(24 * time.Hour).Unix(),
+		HeartbeatAt:      now.Unix(),
+		ClientIP:         c.ClientIP(),
+	}
+	if err = batch.Insert(); err != nil {
+		common.LogError(c, "create batch error: "+err.Error())
+		openAIErrorResponse(c, http.StatusInternalServerError, "veloera_error", "create_batch_failed", "Failed to create batch")
+		return
+	}
+	service.StartBatch(batch)
+	c.JSON(http.StatusOK, service.BatchToOpenAI(batch))
+}
+
+func RetrieveBatch(c *gin.Context) {
+	batch, ok := getUserBatch(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, service.BatchToOpenAI(batch))
+}
+
+func CancelBatch(c *gin.Context) {
+	batch, ok := getUserBatch(c)
+	if !ok {
+		return
+	}
+	if batch.Status != model.BatchStatusValidating && batch.Status != model.BatchStatusInProgress {
+		openAIErrorResponse(c, http.StatusConflict, "invalid_request_error", "batch_not_cancellable", fmt.Sprintf("Cannot cancel a batch with status '%s'", batch.Status))
+		return
+	}
+	if err := service.CancelBatch(batch); err != nil {
+		common.LogError(c, "cancel batch error: "+err.Error())
+		openAIErrorResponse(c, http.StatusInternalServerError, "veloera_error", "cancel_batch_failed", "Failed to cancel batch")
+		return
+	}
+	c.JSON(http.StatusOK, service.BatchToOpenAI(batch))
+}
+
+func ListBatches(c *gin.Context) {
+	userId := c.GetInt("id")
+	limit := getListLimit(c, 20, 100)
+	afterId := 0
+	if after := c.Query("after"); after != "" {
+		afterBatch, err := model.GetBatchByBatchId(after, userId)
+		if err != nil {
+			openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "batch_not_found", fmt.Sprintf("No such Batch object: %s", after))
+			return
+		}
+		afterId = afterBatch.Id
+	}
+	// 多取一条用于判断是否还有更多
+	batches, err := model.GetUserBatches(userId, afterId, limit+1)
+	if err != nil {
+		common.LogError(c, "list batches error: "+err.Error())
+		openAIErrorResponse(c, http.StatusInternalServerError, "veloera_error", "list_batches_failed", "Failed to list batches")
+		return
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	data := make([]dto.OpenAIBatch, 0, len(batches))
+	for _, batch := range batches {
+		service.CheckStaleBatch(batch)
+		data = append(data, service.BatchToOpenAI(batch))
+	}
+	var firstId, lastId *string
+	if len(data) > 0 {
+		firstId = &data[0].Id
+		lastId = &data[len(data)-1].Id
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     data,
+		"first_id": firstId,
+		"last_id":  lastId,
+		"has_more": hasMore,
+	})
+}
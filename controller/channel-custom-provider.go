@@ -0,0 +1,202 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/model"
+	"veloera/relay/channel/customprovider"
+	relaycommon "veloera/relay/common"
+	relayconstant "veloera/relay/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+const customProviderValidateBodyLimit = 4096
+
+func truncateValidateBody(body []byte) string {
+	if len(body) > customProviderValidateBodyLimit {
+		return string(body[:customProviderValidateBodyLimit]) + "..."
+	}
+	return string(body)
+}
+
+// ValidateCustomProvider 校验自定义供应商配置，并用其向上游发送一个示例请求
+func ValidateCustomProvider(c *gin.Context) {
+	var req struct {
+		ChannelId int    `json:"channel_id"`
+		BaseURL   string `json:"base_url"`
+		Key       string `json:"key"`
+		Model     string `json:"model"`
+		Setting   string `json:"setting"`
+		Stream    bool   `json:"stream"`
+		Prompt    string `json:"prompt"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "Invalid request",
+		})
+		return
+	}
+
+	channelSetting := make(map[string]interface{})
+	if req.Setting != "" {
+		if err := json.Unmarshal([]byte(req.Setting), &channelSetting); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "渠道额外设置不是合法的 JSON: " + err.Error(),
+			})
+			return
+		}
+	}
+	info := &relaycommon.RelayInfo{
+		ChannelType:       common.ChannelTypeCustomProvider,
+		ChannelId:         req.ChannelId,
+		BaseUrl:           req.BaseURL,
+		OriginModelName:   req.Model,
+		UpstreamModelName: req.Model,
+		IsStream:          req.Stream,
+		RelayMode:         relayconstant.RelayModeChatCompletions,
+		RelayFormat:       relaycommon.RelayFormatOpenAI,
+		RequestURLPath:    "/v1/chat/completions",
+		ChannelSetting:    channelSetting,
+		StartTime:         time.Now(),
+	}
+	if _, err := customprovider.GetConfig(info); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Model == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "配置格式正确，填写模型后可发送示例请求",
+		})
+		return
+	}
+
+	// 编辑已有渠道时未填写密钥，使用渠道中保存的密钥
+	key := strings.TrimSpace(req.Key)
+	if key == "" && req.ChannelId != 0 {
+		channel, err := model.GetChannelById(req.ChannelId, true)
+		if err == nil {
+			key = strings.TrimSpace(channel.Key)
+		}
+	}
+	info.ApiKey = strings.Split(key, "\n")[0]
+
+	prompt := req.Prompt
+	if prompt == "" {
+		prompt = "hi"
+	}
+	message := dto.Message{
+		Role: "user",
+	}
+	message.SetStringContent(prompt)
+	request := &dto.GeneralOpenAIRequest{
+		Model:    req.Model,
+		Stream:   req.Stream,
+		Messages: []dto.Message{message},
+	}
+
+	w := httptest.NewRecorder()
+	testCtx, _ := gin.CreateTestContext(w)
+	testCtx.Request = &http.Request{
+		Method: "POST",
+		URL:    &url.URL{Path: info.RequestURLPath},
+		Body:   io.NopCloser(bytes.NewReader(nil)),
+		Header: make(http.Header),
+	}
+	testCtx.Request.Header.Set("Content-Type", "application/json")
+
+	adaptor := &customprovider.Adaptor{}
+	adaptor.Init(info)
+	data := gin.H{}
+	fail := func(message string) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+			"data":    data,
+		})
+	}
+
+	requestURL, err := adaptor.GetRequestURL(info)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	data["request_url"] = requestURL
+	convertedRequest, err := adaptor.ConvertOpenAIRequest(testCtx, info, request)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	jsonData, err := json.Marshal(convertedRequest)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	data["request_body"] = string(jsonData)
+
+	tik := time.Now()
+	resp, err := adaptor.DoRequest(testCtx, info, bytes.NewBuffer(jsonData))
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	httpResp := resp.(*http.Response)
+	upstreamBody, err := io.ReadAll(httpResp.Body)
+	_ = httpResp.Body.Close()
+	data["status_code"] = httpResp.StatusCode
+	data["upstream_response"] = truncateValidateBody(upstreamBody)
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		fail(fmt.Sprintf("上游返回状态码 %d", httpResp.StatusCode))
+		return
+	}
+
+	httpResp.Body = io.NopCloser(bytes.NewReader(upstreamBody))
+	usage, openaiErr := adaptor.DoResponse(testCtx, httpResp, info)
+	data["time"] = time.Since(tik).Seconds()
+	data["response"] = truncateValidateBody(w.Body.Bytes())
+	if openaiErr != nil {
+		fail(openaiErr.Error.Message)
+		return
+	}
+	data["usage"] = usage
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    data,
+	})
+}
@@ -285,6 +285,10 @@ func testAllChannels(notify bool) error {
 	}
 	gopool.Go(func() {
 		for _, channel := range channels {
+			// 不在可用时间段内的渠道不测试，避免在不允许使用的时间访问上游
+			if !channel.IsInSchedule(time.Now()) {
+				continue
+			}
 			isChannelEnabled := channel.Status == common.ChannelStatusEnabled
 			tik := time.Now()
 			err, openaiWithStatusErr := testChannel(channel, "")
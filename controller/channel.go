@@ -23,7 +23,6 @@ import (
 	"strconv"
 	"strings"
 	"veloera/common"
-	"veloera/middleware"
 	"veloera/model"
 
 	"github.com/gin-gonic/gin"
@@ -307,6 +306,14 @@ func AddChannel(c *gin.Context) {
 		}
 	}
 
+	if err := model.ValidateChannelSchedule(channel.Setting); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
 	// 验证模型名称长度
 	models := strings.Split(channel.Models, ",")
 	for _, model := range models {
@@ -328,8 +335,7 @@ func AddChannel(c *gin.Context) {
 		return
 	}
 
-	// refresh prefix cache for the groups this channel belongs to
-	middleware.RefreshPrefixChannelsCache(channel.Group)
+	model.RefreshChannelCache()
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -349,6 +355,7 @@ func DeleteChannel(c *gin.Context) {
 		})
 		return
 	}
+	model.RefreshChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -401,20 +408,7 @@ func DisableTagChannels(c *gin.Context) {
 		})
 		return
 	}
-	if channels, err := model.GetChannelsByTag(channelTag.Tag, false); err == nil {
-		groupSet := make(map[string]struct{})
-		for _, ch := range channels {
-			for _, g := range strings.Split(ch.Group, ",") {
-				g = strings.TrimSpace(g)
-				if g != "" {
-					groupSet[g] = struct{}{}
-				}
-			}
-		}
-		for g := range groupSet {
-			middleware.RefreshPrefixChannelsCache(g)
-		}
-	}
+	model.RefreshChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -440,20 +434,7 @@ func EnableTagChannels(c *gin.Context) {
 		})
 		return
 	}
-	if channels, err := model.GetChannelsByTag(channelTag.Tag, false); err == nil {
-		groupSet := make(map[string]struct{})
-		for _, ch := range channels {
-			for _, g := range strings.Split(ch.Group, ",") {
-				g = strings.TrimSpace(g)
-				if g != "" {
-					groupSet[g] = struct{}{}
-				}
-			}
-		}
-		for g := range groupSet {
-			middleware.RefreshPrefixChannelsCache(g)
-		}
-	}
+	model.RefreshChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -486,6 +467,7 @@ func EditTagChannels(c *gin.Context) {
 		})
 		return
 	}
+	model.RefreshChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -516,6 +498,7 @@ func DeleteChannelBatch(c *gin.Context) {
 		})
 		return
 	}
+	model.RefreshChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -555,6 +538,13 @@ func UpdateChannel(c *gin.Context) {
 			}
 		}
 	}
+	if err := model.ValidateChannelSchedule(channel.Setting); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	err = channel.Update()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -564,8 +554,7 @@ func UpdateChannel(c *gin.Context) {
 		return
 	}
 
-	// refresh prefix cache as channel configuration may change
-	middleware.RefreshPrefixChannelsCache(channel.Group)
+	model.RefreshChannelCache()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -684,3 +673,201 @@ func BatchSetChannelTag(c *gin.Context) {
 	})
 	return
 }
+
+func GetChannelBreakers(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetChannelBreakerStates(channelId),
+	})
+	return
+}
+
+// GetChannelSchedules 获取配置了可用时间段的渠道当前是否可用以及下一次变化的时间
+func GetChannelSchedules(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	states, err := model.GetChannelScheduleStates(channelId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    states,
+	})
+}
+
+// GetChannelCanaries 获取放量中的渠道及其当前流量比例
+func GetChannelCanaries(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetChannelCanaryStates(channelId),
+	})
+}
+
+// RestartChannelCanary 让渠道重新从初始比例开始放量
+func RestartChannelCanary(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err == nil {
+		err = model.RestartChannelCanary(id)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// FinishChannelCanary 结束渠道的放量，渠道立即承担全部流量
+func FinishChannelCanary(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.FinishChannelCanary(id)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func ResetChannelBreaker(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.ResetChannelBreaker(id, c.Query("model"))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+func GetChannelStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetAllChannelStats(),
+	})
+	return
+}
+
+type ChannelKeyStatusRequest struct {
+	KeyHash string `json:"key_hash"`
+}
+
+func GetChannelKeys(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	keys, err := model.GetChannelKeyInfos(channel)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    keys,
+	})
+	return
+}
+
+func EnableChannelKey(c *gin.Context) {
+	updateChannelKeyStatus(c, common.ChannelStatusEnabled)
+}
+
+func DisableChannelKey(c *gin.Context) {
+	updateChannelKeyStatus(c, common.ChannelStatusManuallyDisabled)
+}
+
+func updateChannelKeyStatus(c *gin.Context, status int) {
+	id, err := strconv.Atoi(c.Param("id"))
+	req := ChannelKeyStatusRequest{}
+	if err == nil {
+		err = c.ShouldBindJSON(&req)
+	}
+	if err != nil || req.KeyHash == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	found := false
+	for _, key := range model.GetChannelKeyList(channel.Key) {
+		if model.GetChannelKeyHash(key) == req.KeyHash {
+			found = true
+			break
+		}
+	}
+	if !found {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "渠道中不存在该 key",
+		})
+		return
+	}
+	reason := ""
+	if status != common.ChannelStatusEnabled {
+		reason = "手动禁用"
+	}
+	if _, err = model.UpdateChannelKeyStatus(channel.Id, req.KeyHash, status, reason); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
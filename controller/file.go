@@ -0,0 +1,181 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/model"
+	"veloera/service"
+	"veloera/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// https://platform.openai.com/docs/api-reference/files
+
+func openAIErrorResponse(c *gin.Context, statusCode int, errType string, code string, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": dto.OpenAIError{
+			Message: message,
+			Type:    errType,
+			Code:    code,
+		},
+	})
+}
+
+// getListLimit 解析列表接口的 limit 参数
+func getListLimit(c *gin.Context, defaultLimit int, maxLimit int) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 {
+		return defaultLimit
+	}
+	if limit > maxLimit {
+		return maxLimit
+	}
+	return limit
+}
+
+func getUserFile(c *gin.Context) (*model.File, bool) {
+	fileId := c.Param("id")
+	file, err := model.GetFileByFileId(fileId, c.GetInt("id"))
+	if err != nil {
+		openAIErrorResponse(c, http.StatusNotFound, "invalid_request_error", "file_not_found", fmt.Sprintf("No such File object: %s", fileId))
+		return nil, false
+	}
+	return file, true
+}
+
+func UploadFile(c *gin.Context) {
+	if !model_setting.GetBatchSettings().Enabled {
+		openAIErrorResponse(c, http.StatusForbidden, "veloera_error", "files_disabled", "Files API is disabled")
+		return
+	}
+	purpose := c.PostForm("purpose")
+	if !service.IsValidUploadFilePurpose(purpose) {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "invalid_purpose", fmt.Sprintf("Invalid purpose: '%s'", purpose))
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "missing_file", "The file parameter is required")
+		return
+	}
+	maxBytes := int64(model_setting.GetBatchSettings().MaxFileSizeMB) << 20
+	if fileHeader.Size > maxBytes {
+		openAIErrorResponse(c, http.StatusRequestEntityTooLarge, "invalid_request_error", "file_too_large", fmt.Sprintf("File exceeds the maximum allowed size of %d MB", model_setting.GetBatchSettings().MaxFileSizeMB))
+		return
+	}
+	content, err := fileHeader.Open()
+	if err != nil {
+		openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "invalid_file", err.Error())
+		return
+	}
+	defer content.Close()
+
+	file, err := service.SaveUploadedFile(c.GetInt("id"), fileHeader.Filename, purpose, content)
+	if err != nil {
+		if errors.Is(err, service.ErrFileTooLarge) {
+			openAIErrorResponse(c, http.StatusRequestEntityTooLarge, "invalid_request_error", "file_too_large", err.Error())
+			return
+		}
+		common.LogError(c, "save uploaded file error: "+err.Error())
+		openAIErrorResponse(c, http.StatusInternalServerError, "veloera_error", "save_file_failed", "Failed to save file")
+		return
+	}
+	c.JSON(http.StatusOK, service.FileToOpenAI(file))
+}
+
+func ListFiles(c *gin.Context) {
+	userId := c.GetInt("id")
+	limit := getListLimit(c, 10000, 10000)
+	asc := c.Query("order") == "asc"
+	afterId := 0
+	if after := c.Query("after"); after != "" {
+		afterFile, err := model.GetFileByFileId(after, userId)
+		if err != nil {
+			openAIErrorResponse(c, http.StatusBadRequest, "invalid_request_error", "file_not_found", fmt.Sprintf("No such File object: %s", after))
+			return
+		}
+		afterId = afterFile.Id
+	}
+	// 多取一条用于判断是否还有更多
+	files, err := model.GetUserFiles(userId, c.Query("purpose"), afterId, limit+1, asc)
+	if err != nil {
+		common.LogError(c, "list files error: "+err.Error())
+		openAIErrorResponse(c, http.StatusInternalServerError, "veloera_error", "list_files_failed", "Failed to list files")
+		return
+	}
+	hasMore := len(files) > limit
+	if hasMore {
+		files = files[:limit]
+	}
+	data := make([]dto.OpenAIFile, 0, len(files))
+	for _, file := range files {
+		data = append(data, service.FileToOpenAI(file))
+	}
+	var firstId, lastId *string
+	if len(data) > 0 {
+		firstId = &data[0].Id
+		lastId = &data[len(data)-1].Id
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     data,
+		"first_id": firstId,
+		"last_id":  lastId,
+		"has_more": hasMore,
+	})
+}
+
+func RetrieveFile(c *gin.Context) {
+	file, ok := getUserFile(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, service.FileToOpenAI(file))
+}
+
+func DeleteFile(c *gin.Context) {
+	file, ok := getUserFile(c)
+	if !ok {
+		return
+	}
+	if err := service.DeleteFile(file); err != nil {
+		common.LogError(c, "delete file error: "+err.Error())
+		openAIErrorResponse(c, http.StatusInternalServerError, "veloera_error", "delete_file_failed", "Failed to delete file")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      file.FileId,
+		"object":  "file",
+		"deleted": true,
+	})
+}
+
+func GetFileContent(c *gin.Context) {
+	file, ok := getUserFile(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Type", "application/octet-stream")
+	c.File(service.FileAbsPath(file))
+}
@@ -24,7 +24,6 @@ import (
 	"veloera/common"
 	"veloera/constant"
 	"veloera/dto"
-	"veloera/middleware"
 	"veloera/model"
 	"veloera/relay"
 	"veloera/relay/channel/ai360"
@@ -176,7 +175,7 @@ func ListModels(c *gin.Context) {
 	}
 
 	// Get channels with prefixes
-	prefixChannels := middleware.GetPrefixChannels(group)
+	prefixChannels := model.CacheGetPrefixChannels(group)
 	for prefix, channels := range prefixChannels {
 		if prefix == "" {
 			continue // Skip channels without prefixes
@@ -406,7 +405,7 @@ func RetrieveModel(c *gin.Context) {
 			group = tokenGroup
 		}
 
-		prefixChannels := middleware.GetPrefixChannels(group)
+		prefixChannels := model.CacheGetPrefixChannels(group)
 		for prefix := range prefixChannels {
 			if prefix != "" && strings.HasPrefix(modelId, prefix) {
 				// We found a model with a prefix, try to retrieve the base model
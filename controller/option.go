@@ -23,6 +23,7 @@ import (
 	"veloera/common"
 	"veloera/model"
 	"veloera/setting"
+	"veloera/setting/model_setting"
 	"veloera/setting/operation_setting"
 	"veloera/setting/system_setting"
 
@@ -127,6 +128,14 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "routing_rules.rules":
+		if _, err = model_setting.ParseRoutingRules(option.Value); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 	case "ReverseProxyProvider":
 		if option.Value != "nginx" && option.Value != "cloudflare" {
 			c.JSON(http.StatusOK, gin.H{
@@ -21,13 +21,11 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
-	"strings"
 	"time"
 	"veloera/common"
 	"veloera/constant"
 	"veloera/dto"
 	"veloera/middleware"
-	"veloera/model"
 	"veloera/service"
 	"veloera/setting"
 )
@@ -75,37 +73,18 @@ func Playground(c *gin.Context) {
 	}
 	c.Set("token_name", "playground-"+group)
 
-	// Handle model prefix for channel selection (similar to middleware/distributor.go)
+	// Prefixed models are routed by the prefixed name, the prefix is stripped again for the upstream request
 	originalModel := playgroundRequest.Model
-	modelToQuery := playgroundRequest.Model
-	var channel *model.Channel
-
-	// Check if the model has a prefix that should be used for routing
-	modelPrefix := ""
-	for prefix := range middleware.GetPrefixChannels(group) {
-		if prefix != "" && strings.HasPrefix(originalModel, prefix) {
-			modelPrefix = prefix
-			// Strip the prefix for channel selection
-			modelToQuery = strings.TrimPrefix(originalModel, prefix)
-			break
-		}
-	}
-
-	// Select channel based on whether we found a prefix
-	if modelPrefix != "" {
-		// Use prefix-based channel selection
-		channel, err = middleware.SelectChannelByPrefix(group, modelPrefix, modelToQuery)
-	} else {
-		// Use normal channel selection
-		channel, err = model.CacheGetRandomSatisfiedChannel(group, modelToQuery, 0)
-	}
+	modelPrefix, _ := middleware.SplitModelPrefix(group, originalModel)
+	c.Set("model_prefix", modelPrefix)
+	channel, err := middleware.SelectChannel(c, group, originalModel, 0)
 
 	if err != nil {
 		message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", group, originalModel)
 		openaiErr = service.OpenAIErrorWrapperLocal(errors.New(message), "get_playground_channel_failed", http.StatusInternalServerError)
 		return
 	}
-	middleware.SetupContextForSelectedChannel(c, channel, modelToQuery)
+	middleware.SetupContextForSelectedChannel(c, channel, originalModel)
 	c.Set(constant.ContextKeyRequestStartTime, time.Now())
 	Relay(c)
 }
@@ -18,7 +18,6 @@ package controller
 
 import (
 	"github.com/gin-gonic/gin"
-	"veloera/middleware"
 	"veloera/model"
 	"veloera/setting"
 	"veloera/setting/operation_setting"
@@ -63,7 +62,7 @@ func GetPricing(c *gin.Context) {
 // and removes non-prefixed models that are also available with prefixes
 func enhancePricingWithPrefixes(pricing []model.Pricing, group string) []model.Pricing {
 	// Get all channels with prefixes for this group
-	prefixChannels := middleware.GetPrefixChannels(group)
+	prefixChannels := model.CacheGetPrefixChannels(group)
 
 	// Track which models have prefixed versions
 	modelsWithPrefix := make(map[string]bool)
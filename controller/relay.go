@@ -24,11 +24,14 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 	"veloera/common"
+	commonconstant "veloera/constant"
 	"veloera/dto"
 	"veloera/middleware"
 	"veloera/model"
 	"veloera/relay"
+	relaycommon "veloera/relay/common"
 	"veloera/relay/constant"
 	relayconstant "veloera/relay/constant"
 	"veloera/relay/helper"
@@ -42,7 +45,7 @@ import (
 func relayHandler(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 	var err *dto.OpenAIErrorWithStatusCode
 	switch relayMode {
-	case relayconstant.RelayModeImagesGenerations:
+	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits, relayconstant.RelayModeImagesVariations:
 		err = relay.ImageHelper(c)
 	case relayconstant.RelayModeAudioSpeech:
 		fallthrough
@@ -60,6 +63,10 @@ func relayHandler(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode
 		err = relay.TextHelper(c)
 	}
 
+	// 对冲请求中落后的一方被取消，影子请求的错误记录在影子日志中，都不记录错误日志
+	if err != nil && (isHedgeLoser(c) || relaycommon.IsShadowRequest(c)) {
+		return err
+	}
 	if err != nil && common.LogErrorEnabled { // If error log is enabled
 		// 保存错误日志到mysql中
 		userId := c.GetInt("id")
@@ -99,49 +106,69 @@ func Relay(c *gin.Context) {
 		}
 	}
 
-	autoRetryCount := model_setting.GetAutoRetryCount()
-	maxRetries := autoRetryCount
-
-	for i := 0; i <= maxRetries; i++ {
-		// 如果启用了强制切换渠道，且不是第一次尝试，则增加重试索引以获取不同渠道
-		retryIndex := i
-		if i > 0 && model_setting.ShouldForceChannelSwitch() {
-			retryIndex = i + 1 // 强制获取不同的渠道
-		}
+	maxRetries := getRetryTimes(c, model_setting.GetAutoRetryCount())
 
-		channel, err := getChannel(c, group, originalModel, retryIndex)
-		if err != nil {
-			common.LogError(c, err.Error())
-			openaiErr = service.OpenAIErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
-			break
-		}
+	for {
+		for i := 0; i <= maxRetries; i++ {
+			// 如果启用了强制切换渠道，且不是第一次尝试，则增加重试索引以获取不同渠道
+			retryIndex := i
+			if i > 0 && model_setting.ShouldForceChannelSwitch() {
+				retryIndex = i + 1 // 强制获取不同的渠道
+			}
 
-		// 记录响应前的状态，用于检测空回复
-		c.Set("response_written", false)
-		openaiErr = relayRequest(c, relayMode, channel)
+			channel, err := getChannel(c, group, originalModel, retryIndex)
+			if err != nil {
+				common.LogError(c, err.Error())
+				openaiErr = service.OpenAIErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
+				break
+			}
 
-		// 检测空回复的情况
-		if openaiErr == nil {
-			// 检查是否实际写入了响应内容
-			responseWritten := c.GetBool("response_written")
-			if !responseWritten && model_setting.GetGlobalSettings().AutoRetryEnabled {
-				// 创建一个表示空回复的错误，以便触发重试
-				openaiErr = service.OpenAIErrorWrapperLocal(
-					fmt.Errorf("empty response from upstream"),
-					"empty_response",
-					http.StatusInternalServerError,
-				)
-				common.LogWarn(c, fmt.Sprintf("detected empty response from channel #%d, will retry", channel.Id))
+			// 记录响应前的状态，用于检测空回复
+			c.Set("response_written", false)
+			prepareShadowRequest(c, relayMode)
+			if i == 0 && shouldHedge(c, relayMode) {
+				channel, openaiErr = hedgedRelayRequest(c, relayMode, channel, group, originalModel)
 			} else {
-				return // 成功处理请求，直接返回
+				openaiErr = relayRequest(c, relayMode, channel)
+			}
+
+			// 检测空回复的情况
+			if openaiErr == nil {
+				// 检查是否实际写入了响应内容
+				responseWritten := c.GetBool("response_written")
+				if !responseWritten && model_setting.GetGlobalSettings().AutoRetryEnabled {
+					// 创建一个表示空回复的错误，以便触发重试
+					openaiErr = service.OpenAIErrorWrapperLocal(
+						fmt.Errorf("empty response from upstream"),
+						"empty_response",
+						http.StatusInternalServerError,
+					)
+					common.LogWarn(c, fmt.Sprintf("detected empty response from channel #%d, will retry", channel.Id))
+				} else {
+					recordChannelResult(c, channel.Id, nil)
+					startShadowRequest(c, relayMode, channel.Id)
+					return // 成功处理请求，直接返回
+				}
 			}
-		}
 
-		go processChannelError(c, channel.Id, channel.Type, channel.Name, channel.GetAutoBan(), openaiErr)
+			recordChannelResult(c, channel.Id, openaiErr)
+			go processChannelError(c, channel.Id, channel.Type, channel.Name, c.GetString("channel_key_hash"), channel.GetAutoBan(), openaiErr)
 
-		if !shouldRetryWithAutoConfig(c, openaiErr, maxRetries-i) {
+			if !shouldRetryWithAutoConfig(c, openaiErr, maxRetries-i) {
+				break
+			}
+		}
+
+		// 当前模型的渠道都失败后按降级链切换到下一个模型
+		if !shouldFallbackModel(c, openaiErr) {
 			break
 		}
+		channel, fallbackModel, ok := middleware.SelectFallbackChannel(c, group)
+		if !ok {
+			break
+		}
+		middleware.SetupContextForSelectedChannel(c, channel, fallbackModel)
+		originalModel = fallbackModel
 	}
 	useChannel := c.GetStringSlice("use_channel")
 	if len(useChannel) > 1 {
@@ -187,7 +214,8 @@ func WssRelay(c *gin.Context) {
 	originalModel := c.GetString("original_model")
 	var openaiErr *dto.OpenAIErrorWithStatusCode
 
-	for i := 0; i <= common.RetryTimes; i++ {
+	retryTimes := getRetryTimes(c, common.RetryTimes)
+	for i := 0; i <= retryTimes; i++ {
 		channel, err := getChannel(c, group, originalModel, i)
 		if err != nil {
 			common.LogError(c, err.Error())
@@ -198,12 +226,14 @@ func WssRelay(c *gin.Context) {
 		openaiErr = wssRequest(c, ws, relayMode, channel)
 
 		if openaiErr == nil {
+			recordChannelResult(c, channel.Id, nil)
 			return // 成功处理请求，直接返回
 		}
 
-		go processChannelError(c, channel.Id, channel.Type, channel.Name, channel.GetAutoBan(), openaiErr)
+		recordChannelResult(c, channel.Id, openaiErr)
+		go processChannelError(c, channel.Id, channel.Type, channel.Name, c.GetString("channel_key_hash"), channel.GetAutoBan(), openaiErr)
 
-		if !shouldRetry(c, openaiErr, common.RetryTimes-i) {
+		if !shouldRetry(c, openaiErr, retryTimes-i) {
 			break
 		}
 	}
@@ -229,7 +259,8 @@ func RelayClaude(c *gin.Context) {
 	originalModel := c.GetString("original_model")
 	var claudeErr *dto.ClaudeErrorWithStatusCode
 
-	for i := 0; i <= common.RetryTimes; i++ {
+	retryTimes := getRetryTimes(c, common.RetryTimes)
+	for i := 0; i <= retryTimes; i++ {
 		channel, err := getChannel(c, group, originalModel, i)
 		if err != nil {
 			common.LogError(c, err.Error())
@@ -240,14 +271,16 @@ func RelayClaude(c *gin.Context) {
 		claudeErr = claudeRequest(c, channel)
 
 		if claudeErr == nil {
+			recordChannelResult(c, channel.Id, nil)
 			return // 成功处理请求，直接返回
 		}
 
 		openaiErr := service.ClaudeErrorToOpenAIError(claudeErr)
 
-		go processChannelError(c, channel.Id, channel.Type, channel.Name, channel.GetAutoBan(), openaiErr)
+		recordChannelResult(c, channel.Id, openaiErr)
+		go processChannelError(c, channel.Id, channel.Type, channel.Name, c.GetString("channel_key_hash"), channel.GetAutoBan(), openaiErr)
 
-		if !shouldRetry(c, openaiErr, common.RetryTimes-i) {
+		if !shouldRetry(c, openaiErr, retryTimes-i) {
 			break
 		}
 	}
@@ -266,33 +299,173 @@ func RelayClaude(c *gin.Context) {
 	}
 }
 
-func relayRequest(c *gin.Context, relayMode int, channel *model.Channel) *dto.OpenAIErrorWithStatusCode {
+func RelayGemini(c *gin.Context) {
+	requestId := c.GetString(common.RequestIdKey)
+	group := c.GetString("group")
+	originalModel := c.GetString("original_model")
+	var openaiErr *dto.OpenAIErrorWithStatusCode
+
+	retryTimes := getRetryTimes(c, common.RetryTimes)
+	for i := 0; i <= retryTimes; i++ {
+		channel, err := getChannel(c, group, originalModel, i)
+		if err != nil {
+			common.LogError(c, err.Error())
+			openaiErr = service.OpenAIErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
+			break
+		}
+
+		openaiErr = geminiRequest(c, channel)
+
+		if openaiErr == nil {
+			recordChannelResult(c, channel.Id, nil)
+			return // 成功处理请求，直接返回
+		}
+
+		recordChannelResult(c, channel.Id, openaiErr)
+		go processChannelError(c, channel.Id, channel.Type, channel.Name, c.GetString("channel_key_hash"), channel.GetAutoBan(), openaiErr)
+
+		if !shouldRetry(c, openaiErr, retryTimes-i) {
+			break
+		}
+	}
+	useChannel := c.GetStringSlice("use_channel")
+	if len(useChannel) > 1 {
+		retryLogStr := fmt.Sprintf("重试：%s", strings.Trim(strings.Join(strings.Fields(fmt.Sprint(useChannel)), "->"), "[]"))
+		common.LogInfo(c, retryLogStr)
+	}
+
+	if openaiErr != nil {
+		openaiErr.Error.Message = common.MessageWithRequestId(openaiErr.Error.Message, requestId)
+		c.JSON(openaiErr.StatusCode, gin.H{
+			"error": service.OpenAIErrorToGeminiError(openaiErr),
+		})
+	}
+}
+
+func relayRequest(c *gin.Context, relayMode int, channel *model.Channel) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	addUsedChannel(c, channel.Id)
+	release, ok := acquireChannelConcurrency(c, channel.Id)
+	if !ok {
+		return service.OpenAIErrorWrapper(channelConcurrencyLimitError(channel.Id), "channel_concurrency_limit", http.StatusTooManyRequests)
+	}
+	defer release()
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	// 影子请求占用并发名额，但不计入进行中的请求数和延迟统计
+	if relaycommon.IsShadowRequest(c) {
+		return relayHandler(c, relayMode)
+	}
+	attemptStart := startChannelRequest(channel.Id)
+	defer func() {
+		finishChannelRequest(c, channel.Id, attemptStart, openaiErr == nil)
+	}()
 	return relayHandler(c, relayMode)
 }
 
-func wssRequest(c *gin.Context, ws *websocket.Conn, relayMode int, channel *model.Channel) *dto.OpenAIErrorWithStatusCode {
+func wssRequest(c *gin.Context, ws *websocket.Conn, relayMode int, channel *model.Channel) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	addUsedChannel(c, channel.Id)
+	release, ok := acquireChannelConcurrency(c, channel.Id)
+	if !ok {
+		return service.OpenAIErrorWrapper(channelConcurrencyLimitError(channel.Id), "channel_concurrency_limit", http.StatusTooManyRequests)
+	}
+	defer release()
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	attemptStart := startChannelRequest(channel.Id)
+	defer func() {
+		finishChannelRequest(c, channel.Id, attemptStart, openaiErr == nil)
+	}()
 	return relay.WssHelper(c, ws)
 }
 
-func claudeRequest(c *gin.Context, channel *model.Channel) *dto.ClaudeErrorWithStatusCode {
+func claudeRequest(c *gin.Context, channel *model.Channel) (claudeErr *dto.ClaudeErrorWithStatusCode) {
 	addUsedChannel(c, channel.Id)
+	release, ok := acquireChannelConcurrency(c, channel.Id)
+	if !ok {
+		return service.ClaudeErrorWrapper(channelConcurrencyLimitError(channel.Id), "channel_concurrency_limit", http.StatusTooManyRequests)
+	}
+	defer release()
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	attemptStart := startChannelRequest(channel.Id)
+	defer func() {
+		finishChannelRequest(c, channel.Id, attemptStart, claudeErr == nil)
+	}()
 	return relay.ClaudeHelper(c)
 }
 
+func geminiRequest(c *gin.Context, channel *model.Channel) (openaiErr *dto.OpenAIErrorWithStatusCode) {
+	addUsedChannel(c, channel.Id)
+	release, ok := acquireChannelConcurrency(c, channel.Id)
+	if !ok {
+		return service.OpenAIErrorWrapper(channelConcurrencyLimitError(channel.Id), "channel_concurrency_limit", http.StatusTooManyRequests)
+	}
+	defer release()
+	requestBody, _ := common.GetRequestBody(c)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	attemptStart := startChannelRequest(channel.Id)
+	defer func() {
+		finishChannelRequest(c, channel.Id, attemptStart, openaiErr == nil)
+	}()
+	return relay.GeminiHelper(c)
+}
+
+// acquireChannelConcurrency 占用渠道和 key 的并发名额，名额已满时返回 false，成功时返回释放名额的函数
+func acquireChannelConcurrency(c *gin.Context, channelId int) (func(), bool) {
+	limits := model.ParseChannelConcurrencyLimits(c.GetStringMap("channel_setting"))
+	release, ok := model.AcquireChannelConcurrency(channelId, c.GetString("channel_key_hash"), limits)
+	// 并发已满的请求没有发送到上游，不计入熔断和 key 的统计
+	c.Set("channel_concurrency_limited", !ok)
+	return release, ok
+}
+
+func channelConcurrencyLimitError(channelId int) error {
+	return fmt.Errorf("渠道 #%d 并发已满", channelId)
+}
+
+// startChannelRequest 记录渠道进行中的请求数，返回本次尝试的开始时间
+func startChannelRequest(channelId int) time.Time {
+	model.IncreaseChannelInFlight(channelId)
+	return time.Now()
+}
+
+// finishChannelRequest 结束渠道请求，成功时根据 RelayInfo 记录耗时和首字时间
+func finishChannelRequest(c *gin.Context, channelId int, attemptStart time.Time, success bool) {
+	model.DecreaseChannelInFlight(channelId)
+	if !success {
+		return
+	}
+	info, ok := c.Value(commonconstant.ContextKeyRelayInfo).(*relaycommon.RelayInfo)
+	if !ok || info.ChannelId != channelId {
+		return
+	}
+	// RelayInfo.StartTime 是整个请求的开始时间，重试时以本次尝试的开始时间为准
+	start := info.StartTime
+	if attemptStart.After(start) {
+		start = attemptStart
+	}
+	var ttft time.Duration
+	// 伪流式会提前设置首字时间，只统计真实的流式请求
+	if info.IsStream && info.FirstResponseTime.After(start) {
+		ttft = info.FirstResponseTime.Sub(start)
+	}
+	model.RecordChannelLatency(channelId, time.Since(start), ttft)
+}
+
 func addUsedChannel(c *gin.Context, channelId int) {
 	useChannel := c.GetStringSlice("use_channel")
 	useChannel = append(useChannel, fmt.Sprintf("%d", channelId))
 	c.Set("use_channel", useChannel)
 }
 
+// getRetryTimes 返回请求的重试次数，路由规则设置了重试次数时以规则为准
+func getRetryTimes(c *gin.Context, defaultTimes int) int {
+	if retryTimes, ok := c.Get("routing_retry_times"); ok {
+		return retryTimes.(int)
+	}
+	return defaultTimes
+}
+
 func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*model.Channel, error) {
 	if retryCount == 0 {
 		autoBan := c.GetBool("auto_ban")
@@ -307,7 +480,10 @@ func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*m
 			AutoBan: &autoBanInt,
 		}, nil
 	}
-	channel, err := model.CacheGetRandomSatisfiedChannel(group, originalModel, retryCount)
+	channel, err := middleware.SelectChannel(c, group, originalModel, retryCount)
+	if errors.Is(err, model.ErrChannelSaturated) {
+		channel, err = middleware.WaitForChannel(c, group, originalModel, retryCount)
+	}
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("获取重试渠道失败: %s", err.Error()))
 	}
@@ -401,16 +577,82 @@ func shouldRetryWithAutoConfig(c *gin.Context, openaiErr *dto.OpenAIErrorWithSta
 	return true
 }
 
-func processChannelError(c *gin.Context, channelId int, channelType int, channelName string, autoBan bool, err *dto.OpenAIErrorWithStatusCode) {
+// shouldFallbackModel 判断失败的请求是否应该切换到降级模型
+func shouldFallbackModel(c *gin.Context, openaiErr *dto.OpenAIErrorWithStatusCode) bool {
+	if openaiErr == nil {
+		return false
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	// 没有可用的重试渠道
+	if openaiErr.Error.Code == "get_channel_failed" {
+		return true
+	}
+	if openaiErr.LocalError {
+		return false
+	}
+	return openaiErr.StatusCode == http.StatusTooManyRequests || openaiErr.StatusCode == http.StatusRequestTimeout || openaiErr.StatusCode/100 == 5
+}
+
+func processChannelError(c *gin.Context, channelId int, channelType int, channelName string, keyHash string, autoBan bool, err *dto.OpenAIErrorWithStatusCode) {
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
 	common.LogError(c, fmt.Sprintf("relay error (channel #%d, status code: %d): %s", channelId, err.StatusCode, err.Error.Message))
-	if service.ShouldDisableChannel(channelType, err) && autoBan {
-		service.DisableChannel(channelId, channelName, err.Error.Message)
+	// 上游限流的渠道已经进入冷却，不自动禁用
+	if service.ShouldDisableChannel(channelType, err) && autoBan && !service.IsUpstreamRateLimited(channelId, keyHash, err) {
+		// 多 key 渠道只禁用出错的 key
+		if keyHash != "" {
+			service.DisableChannelKey(channelId, channelName, keyHash, err.Error.Message)
+		} else {
+			service.DisableChannel(channelId, channelName, err.Error.Message)
+		}
+	}
+}
+
+// recordChannelResult 记录渠道熔断和放量统计、key 的使用统计和会话绑定，必须在重试选择下一个渠道前调用以释放半开探测名额
+func recordChannelResult(c *gin.Context, channelId int, err *dto.OpenAIErrorWithStatusCode) {
+	modelName := c.GetString("original_model")
+	if err != nil && c.GetBool("channel_concurrency_limited") {
+		releaseChannelBreaker(c, channelId)
+		return
+	}
+	if keyHash := c.GetString("channel_key_hash"); keyHash != "" && (err == nil || !err.LocalError) {
+		errMessage := ""
+		if err != nil {
+			errMessage = fmt.Sprintf("status code %d: %s", err.StatusCode, err.Error.Message)
+		}
+		model.RecordChannelKeyUsage(channelId, keyHash, errMessage)
+	}
+	if err == nil {
+		service.SaveStickySession(c.GetString("sticky_session_key"), channelId, c.GetInt("channel_key_index"))
+	}
+	// 上游限流的渠道已经进入冷却，不再计入熔断
+	if service.IsUpstreamRateLimited(channelId, c.GetString("channel_key_hash"), err) {
+		releaseChannelBreaker(c, channelId)
+		return
+	}
+	outcome, ok := service.ClassifyChannelBreakerOutcome(err)
+	if !ok {
+		releaseChannelBreaker(c, channelId)
+		return
+	}
+	probe := c.GetBool("channel_breaker_probe")
+	c.Set("channel_breaker_probe", false)
+	model.RecordChannelCanaryResult(channelId, outcome != model.ChannelBreakerSuccess)
+	model.RecordChannelBreakerResult(channelId, modelName, outcome, probe)
+}
+
+// releaseChannelBreaker 释放选择渠道时占用的半开探测名额，没有占用名额时不做任何事
+func releaseChannelBreaker(c *gin.Context, channelId int) {
+	if c.GetBool("channel_breaker_probe") {
+		c.Set("channel_breaker_probe", false)
+		model.ReleaseChannelBreaker(channelId, c.GetString("original_model"))
 	}
 }
 
 func RelayMidjourney(c *gin.Context) {
+	defer releaseChannelBreaker(c, c.GetInt("channel_id"))
 	relayMode := c.GetInt("relay_mode")
 	var err *dto.MidjourneyResponse
 	switch relayMode {
@@ -468,20 +710,21 @@ func RelayNotFound(c *gin.Context) {
 }
 
 func RelayTask(c *gin.Context) {
-	retryTimes := model_setting.GetAutoRetryCount()
+	retryTimes := getRetryTimes(c, model_setting.GetAutoRetryCount())
 	channelId := c.GetInt("channel_id")
 	relayMode := c.GetInt("relay_mode")
 	group := c.GetString("group")
 	originalModel := c.GetString("original_model")
 	c.Set("use_channel", []string{fmt.Sprintf("%d", channelId)})
 	taskErr := taskRelayHandler(c, relayMode)
+	releaseChannelBreaker(c, channelId)
 	if taskErr == nil {
 		retryTimes = 0
 	}
 	for i := 0; shouldRetryTaskRelay(c, channelId, taskErr, retryTimes) && i < retryTimes; i++ {
-		channel, err := model.CacheGetRandomSatisfiedChannel(group, originalModel, i)
+		channel, err := middleware.SelectChannel(c, group, originalModel, i)
 		if err != nil {
-			common.LogError(c, fmt.Sprintf("CacheGetRandomSatisfiedChannel failed: %s", err.Error()))
+			common.LogError(c, fmt.Sprintf("SelectChannel failed: %s", err.Error()))
 			break
 		}
 		channelId = channel.Id
@@ -494,6 +737,7 @@ func RelayTask(c *gin.Context) {
 		requestBody, err := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 		taskErr = taskRelayHandler(c, relayMode)
+		releaseChannelBreaker(c, channelId)
 	}
 	useChannel := c.GetStringSlice("use_channel")
 	if len(useChannel) > 1 {
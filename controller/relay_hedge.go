@@ -0,0 +1,283 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/middleware"
+	"veloera/model"
+	relaycommon "veloera/relay/common"
+	relayconstant "veloera/relay/constant"
+	"veloera/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 对冲请求：非流式的对话和向量请求在主请求耗时超过渠道耗时分位数后，向另一个渠道再发一次相同的请求，
+// 先成功返回的一方胜出，另一方被取消。两个请求都写入各自的缓冲区，只有胜出的一方会被计费、记录日志并写回客户端。
+
+type hedgeResult struct {
+	ctx     *gin.Context
+	writer  *hedgeResponseWriter
+	channel *model.Channel
+	err     *dto.OpenAIErrorWithStatusCode
+	cancel  context.CancelFunc
+}
+
+// shouldHedge 判断请求是否可以对冲
+func shouldHedge(c *gin.Context, relayMode int) bool {
+	if !model_setting.GetHedgeSettings().Enabled {
+		return false
+	}
+	if relayMode != relayconstant.RelayModeChatCompletions && relayMode != relayconstant.RelayModeEmbeddings {
+		return false
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	var request struct {
+		Stream bool `json:"stream"`
+	}
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+		return false
+	}
+	return !request.Stream
+}
+
+// isHedgeLoser 判断失败的请求是否是对冲请求中落后的一方
+func isHedgeLoser(c *gin.Context) bool {
+	attempt := relaycommon.GetHedgeAttempt(c)
+	return attempt != nil && attempt.State.Claimed()
+}
+
+// isHedgeLostError 判断错误是否是对冲中落后的一方被网关放弃，这类错误不是渠道的失败
+func isHedgeLostError(err *dto.OpenAIErrorWithStatusCode) bool {
+	return err != nil && err.LocalError && err.Error.Code == relaycommon.HedgeLostErrorCode
+}
+
+// getHedgeDelay 获取发出对冲请求前的等待时间
+func getHedgeDelay(channelId int) time.Duration {
+	settings := model_setting.GetHedgeSettings()
+	delay, ok := model.GetChannelLatencyPercentile(channelId, settings.Percentile, settings.MinSamples)
+	if !ok {
+		delay = time.Duration(settings.DefaultDelayMs) * time.Millisecond
+	}
+	if minDelay := time.Duration(settings.MinDelayMs) * time.Millisecond; delay < minDelay {
+		delay = minDelay
+	}
+	return delay
+}
+
+// pickHedgeChannel 为对冲请求选择一个与主请求不同的渠道，没有可用渠道时返回 nil
+func pickHedgeChannel(c *gin.Context, group, originalModel string, primaryChannelId int) *model.Channel {
+	for i := 0; i < 3; i++ {
+		channel, err := middleware.SelectChannel(c, group, originalModel, 0)
+		if err != nil || channel == nil {
+			return nil
+		}
+		if channel.Id != primaryChannelId {
+			return channel
+		}
+		// 选中的渠道可能占用了半开探测名额
+		releaseChannelBreaker(c, channel.Id)
+	}
+	return nil
+}
+
+// newHedgeContext 为一次对冲尝试复制请求上下文，响应写入缓冲区
+func newHedgeContext(c *gin.Context, ctx context.Context, attempt *relaycommon.HedgeAttempt) (*gin.Context, *hedgeResponseWriter) {
+	hc := c.Copy()
+	hc.Request = c.Request.Clone(relaycommon.WithHedgeAttempt(ctx, attempt))
+	writer := newHedgeResponseWriter()
+	hc.Writer = writer
+	// 避免两次尝试追加到同一个底层数组
+	hc.Set("use_channel", append([]string(nil), c.GetStringSlice("use_channel")...))
+	return hc, writer
+}
+
+func startHedgeAttempt(c *gin.Context, relayMode int, channel *model.Channel, state *relaycommon.HedgeState, role string, results chan<- *hedgeResult) *hedgeResult {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	hc, writer := newHedgeContext(c, ctx, &relaycommon.HedgeAttempt{State: state, Role: role})
+	result := &hedgeResult{ctx: hc, writer: writer, channel: channel, cancel: cancel}
+	if role == relaycommon.HedgeRoleHedge {
+		middleware.SetupContextForSelectedChannel(hc, channel, c.GetString("original_model"))
+		state.MarkLaunched(channel.Id)
+	}
+	hc.Set("response_written", false)
+	go func() {
+		result.err = relayRequest(hc, relayMode, channel)
+		results <- result
+	}()
+	return result
+}
+
+// hedgedRelayRequest 发出主请求，超过等待时间仍未返回时向另一个渠道发出对冲请求。
+// 返回的渠道和错误由调用方按普通请求处理，另一方的失败在这里记录。
+func hedgedRelayRequest(c *gin.Context, relayMode int, primary *model.Channel, group, originalModel string) (*model.Channel, *dto.OpenAIErrorWithStatusCode) {
+	useChannel := c.GetStringSlice("use_channel")
+	state := &relaycommon.HedgeState{PrimaryChannelId: primary.Id}
+	results := make(chan *hedgeResult, 2)
+	primaryResult := startHedgeAttempt(c, relayMode, primary, state, relaycommon.HedgeRolePrimary, results)
+
+	timer := time.NewTimer(getHedgeDelay(primary.Id))
+	defer timer.Stop()
+	select {
+	case result := <-results:
+		return finishHedgedRequest(c, result)
+	case <-timer.C:
+	}
+
+	// 主请求已经胜出，只是还没有返回
+	if state.Claimed() {
+		return finishHedgedRequest(c, <-results)
+	}
+	hedgeChannel := pickHedgeChannel(c, group, originalModel, primary.Id)
+	if hedgeChannel == nil {
+		return finishHedgedRequest(c, <-results)
+	}
+	common.LogInfo(c, fmt.Sprintf("hedging request of channel #%d to channel #%d", primary.Id, hedgeChannel.Id))
+	startHedgeAttempt(c, relayMode, hedgeChannel, state, relaycommon.HedgeRoleHedge, results)
+	// 在写回返回方的上下文之后记录两个渠道
+	defer c.Set("use_channel", append(useChannel, fmt.Sprintf("%d", primary.Id), fmt.Sprintf("%d", hedgeChannel.Id)))
+
+	first := <-results
+	if first.err == nil {
+		// 取消落后的请求，它不会被计费，也不计入熔断统计
+		go func() {
+			loser := <-results
+			loser.cancel()
+			releaseChannelBreaker(loser.ctx, loser.channel.Id)
+		}()
+		return finishHedgedRequest(c, first)
+	}
+
+	second := <-results
+	// 都失败时返回主请求的错误
+	returned, failed := second, first
+	if second.err != nil && second != primaryResult {
+		returned, failed = first, second
+	}
+	failed.cancel()
+	if isHedgeLostError(failed.err) {
+		// 落后的一方先于胜出的一方返回，只释放探测名额
+		releaseChannelBreaker(failed.ctx, failed.channel.Id)
+		return finishHedgedRequest(c, returned)
+	}
+	recordChannelResult(failed.ctx, failed.channel.Id, failed.err)
+	go processChannelError(failed.ctx, failed.channel.Id, failed.channel.Type, failed.channel.Name, failed.ctx.GetString("channel_key_hash"), failed.channel.GetAutoBan(), failed.err)
+	return finishHedgedRequest(c, returned)
+}
+
+// finishHedgedRequest 将返回的一方的上下文和响应写回原请求
+func finishHedgedRequest(c *gin.Context, result *hedgeResult) (*model.Channel, *dto.OpenAIErrorWithStatusCode) {
+	defer result.cancel()
+	for key, value := range result.ctx.Keys {
+		c.Set(key, value)
+	}
+	if result.err == nil {
+		result.writer.flushTo(c.Writer)
+	}
+	return result.channel, result.err
+}
+
+// hedgeResponseWriter 缓存一次对冲尝试的响应，胜出后再写回客户端
+type hedgeResponseWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+	size   int
+}
+
+func newHedgeResponseWriter() *hedgeResponseWriter {
+	return &hedgeResponseWriter{
+		header: make(http.Header),
+		status: http.StatusOK,
+		size:   -1,
+	}
+}
+
+func (w *hedgeResponseWriter) flushTo(writer gin.ResponseWriter) {
+	for key, values := range w.header {
+		writer.Header()[key] = values
+	}
+	writer.WriteHeader(w.status)
+	_, _ = writer.Write(w.body.Bytes())
+}
+
+func (w *hedgeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *hedgeResponseWriter) WriteHeader(code int) {
+	if code > 0 && !w.Written() {
+		w.status = code
+	}
+}
+
+func (w *hedgeResponseWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+	}
+}
+
+func (w *hedgeResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	n, err := w.body.Write(data)
+	w.size += n
+	return n, err
+}
+
+func (w *hedgeResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *hedgeResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *hedgeResponseWriter) Size() int {
+	return w.size
+}
+
+func (w *hedgeResponseWriter) Written() bool {
+	return w.size != -1
+}
+
+func (w *hedgeResponseWriter) Flush() {
+	w.WriteHeaderNow()
+}
+
+func (w *hedgeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("hedged response does not support hijacking")
+}
+
+func (w *hedgeResponseWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *hedgeResponseWriter) Pusher() http.Pusher {
+	return nil
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/middleware"
+	"veloera/model"
+	relaycommon "veloera/relay/common"
+	relayconstant "veloera/relay/constant"
+	"veloera/setting/model_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// 影子流量：主请求成功后按渠道配置的比例，把同一个请求异步发送到候选渠道。
+// 影子请求的响应写入缓冲区，不计费、不影响熔断和渠道统计，结果记录到影子日志。
+
+// 记录主请求响应的最大字节数
+const shadowCaptureMaxBytes = 1 << 20
+
+// 当前节点进行中的影子请求数
+var shadowInflight int64
+
+// shouldShadow 判断请求类型是否支持影子流量
+func shouldShadow(relayMode int) bool {
+	switch relayMode {
+	case relayconstant.RelayModeChatCompletions, relayconstant.RelayModeCompletions, relayconstant.RelayModeEmbeddings:
+		return true
+	}
+	return false
+}
+
+// prepareShadowRequest 在每次尝试前按当前渠道的影子流量配置抽样，
+// 需要对比响应时记录写回客户端的响应
+func prepareShadowRequest(c *gin.Context, relayMode int) {
+	c.Set("shadow_config", nil)
+	if !model_setting.GetShadowSettings().Enabled || !shouldShadow(relayMode) {
+		return
+	}
+	config := model.ParseChannelShadowConfig(c.GetStringMap("channel_setting"))
+	if config == nil || !config.Sample() {
+		return
+	}
+	c.Set("shadow_config", config)
+	c.Set("shadow_start_time", time.Now())
+	if config.Diff && relayMode != relayconstant.RelayModeEmbeddings {
+		if writer, ok := c.Writer.(*shadowCaptureWriter); ok {
+			writer.body.Reset()
+		} else {
+			c.Writer = &shadowCaptureWriter{ResponseWriter: c.Writer}
+		}
+	}
+}
+
+// startShadowRequest 主请求成功后发出影子请求
+func startShadowRequest(c *gin.Context, relayMode int, primaryChannelId int) {
+	value, _ := c.Get("shadow_config")
+	config, ok := value.(*model.ChannelShadowConfig)
+	if !ok || config == nil {
+		return
+	}
+	settings := model_setting.GetShadowSettings()
+	inflight := atomic.AddInt64(&shadowInflight, 1)
+	if settings.MaxInflight > 0 && inflight > int64(settings.MaxInflight) {
+		atomic.AddInt64(&shadowInflight, -1)
+		return
+	}
+	channel, err := model.GetShadowChannel(config, primaryChannelId)
+	if err != nil {
+		atomic.AddInt64(&shadowInflight, -1)
+		common.LogWarn(c, fmt.Sprintf("get shadow channel of channel #%d failed: %s", primaryChannelId, err.Error()))
+		return
+	}
+
+	var request struct {
+		Stream bool `json:"stream"`
+	}
+	_ = common.UnmarshalBodyReusable(c, &request)
+	shadowLog := &model.ShadowLog{
+		RequestId:        c.GetString(common.RequestIdKey),
+		UserId:           c.GetInt("id"),
+		ModelName:        c.GetString("original_model"),
+		PrimaryChannelId: primaryChannelId,
+		ShadowChannelId:  channel.Id,
+		IsStream:         request.Stream,
+		PrimaryLatency:   time.Since(c.GetTime("shadow_start_time")).Milliseconds(),
+	}
+	diff := false
+	if writer, ok := c.Writer.(*shadowCaptureWriter); ok && config.Diff {
+		diff = true
+		shadowLog.PrimaryContent = truncateShadowContent(extractShadowContent(writer.body.Bytes()), settings.DiffMaxLength)
+	}
+
+	// 原请求返回后上下文会被回收，在返回前复制
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(settings.TimeoutSeconds)*time.Second)
+	sc := c.Copy()
+	sc.Request = c.Request.Clone(relaycommon.WithShadowRequest(ctx))
+	writer := newHedgeResponseWriter()
+	sc.Writer = writer
+	// 避免追加到主请求的渠道列表
+	sc.Set("use_channel", append([]string(nil), c.GetStringSlice("use_channel")...))
+	middleware.SetupContextForSelectedChannel(sc, channel, c.GetString("original_model"))
+	common.LogInfo(c, fmt.Sprintf("mirroring request of channel #%d to shadow channel #%d", primaryChannelId, channel.Id))
+
+	gopool.Go(func() {
+		defer atomic.AddInt64(&shadowInflight, -1)
+		defer cancel()
+		start := time.Now()
+		// 与普通请求一样占用渠道的并发名额，不计入渠道统计
+		openaiErr := relayRequest(sc, relayMode, channel)
+		shadowLog.ShadowLatency = time.Since(start).Milliseconds()
+		if openaiErr != nil {
+			shadowLog.StatusCode = openaiErr.StatusCode
+			shadowLog.ErrorMessage = openaiErr.Error.Message
+		} else {
+			shadowLog.StatusCode = writer.Status()
+			if usage, ok := sc.Get("shadow_usage"); ok {
+				if usage, ok := usage.(*dto.Usage); ok && usage != nil {
+					shadowLog.PromptTokens = usage.PromptTokens
+					shadowLog.CompletionTokens = usage.CompletionTokens
+				}
+			}
+			if diff {
+				shadowLog.ShadowContent = truncateShadowContent(extractShadowContent(writer.body.Bytes()), settings.DiffMaxLength)
+				matched := shadowLog.ShadowContent == shadowLog.PrimaryContent
+				shadowLog.ContentMatched = &matched
+			}
+		}
+		if err := shadowLog.Insert(); err != nil {
+			common.SysError("failed to record shadow log: " + err.Error())
+		}
+	})
+}
+
+// shadowCaptureWriter 在写回客户端的同时记录主请求的响应
+type shadowCaptureWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *shadowCaptureWriter) capture(data []byte) {
+	if remaining := shadowCaptureMaxBytes - w.body.Len(); remaining > 0 {
+		if len(data) > remaining {
+			data = data[:remaining]
+		}
+		w.body.Write(data)
+	}
+}
+
+func (w *shadowCaptureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *shadowCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+type shadowResponseChunk struct {
+	Choices []struct {
+		Text    string `json:"text"`
+		Message *struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"message"`
+		Delta *struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// extractShadowContent 提取对话和补全响应中的文本，流式响应按顺序拼接各个分块
+func extractShadowContent(body []byte) string {
+	var builder strings.Builder
+	trimmed := bytes.TrimSpace(body)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		appendShadowContent(&builder, trimmed)
+		return builder.String()
+	}
+	for _, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+		data := bytes.TrimSpace(line[len("data:"):])
+		if bytes.Equal(data, []byte("[DONE]")) {
+			continue
+		}
+		appendShadowContent(&builder, data)
+	}
+	return builder.String()
+}
+
+func appendShadowContent(builder *strings.Builder, data []byte) {
+	var chunk shadowResponseChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return
+	}
+	for _, choice := range chunk.Choices {
+		builder.WriteString(choice.Text)
+		if choice.Message != nil {
+			appendShadowMessageContent(builder, choice.Message.Content)
+		}
+		if choice.Delta != nil {
+			appendShadowMessageContent(builder, choice.Delta.Content)
+		}
+	}
+}
+
+func appendShadowMessageContent(builder *strings.Builder, content json.RawMessage) {
+	if len(content) == 0 || string(content) == "null" {
+		return
+	}
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		builder.WriteString(text)
+		return
+	}
+	builder.Write(content)
+}
+
+func truncateShadowContent(content string, maxLength int) string {
+	if maxLength <= 0 {
+		return content
+	}
+	runes := []rune(content)
+	if len(runes) <= maxLength {
+		return content
+	}
+	return string(runes[:maxLength])
+}
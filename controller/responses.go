@@ -0,0 +1,158 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/model"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// https://platform.openai.com/docs/api-reference/responses
+
+func getStoredResponse(c *gin.Context) (*model.StoredResponse, bool) {
+	responseId := c.Param("id")
+	userId, tokenId := service.ResponsesStoreOwner(c)
+	storedResponse, err := model.GetStoredResponse(responseId, userId, tokenId)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			responseNotFound(c, responseId)
+		} else {
+			common.LogError(c, "get stored response error: "+err.Error())
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": dto.OpenAIError{
+					Message: "failed to get response",
+					Type:    "veloera_error",
+					Code:    "get_response_failed",
+				},
+			})
+		}
+		return nil, false
+	}
+	return storedResponse, true
+}
+
+func responseNotFound(c *gin.Context, responseId string) {
+	c.JSON(http.StatusNotFound, gin.H{
+		"error": dto.OpenAIError{
+			Message: fmt.Sprintf("Response with id '%s' not found.", responseId),
+			Type:    "invalid_request_error",
+			Param:   "response_id",
+			Code:    "response_not_found",
+		},
+	})
+}
+
+func GetResponse(c *gin.Context) {
+	storedResponse, ok := getStoredResponse(c)
+	if !ok {
+		return
+	}
+	c.Data(http.StatusOK, "application/json", storedResponse.Response)
+}
+
+func DeleteResponse(c *gin.Context) {
+	responseId := c.Param("id")
+	userId, tokenId := service.ResponsesStoreOwner(c)
+	deleted, err := model.DeleteStoredResponse(responseId, userId, tokenId)
+	if err != nil {
+		common.LogError(c, "delete stored response error: "+err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": dto.OpenAIError{
+				Message: "failed to delete response",
+				Type:    "veloera_error",
+				Code:    "delete_response_failed",
+			},
+		})
+		return
+	}
+	if !deleted {
+		responseNotFound(c, responseId)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      responseId,
+		"object":  "response.deleted",
+		"deleted": true,
+	})
+}
+
+// ListResponseInputItems 返回响应的输入项，支持 limit、order、after 和 before 分页参数
+func ListResponseInputItems(c *gin.Context) {
+	storedResponse, ok := getStoredResponse(c)
+	if !ok {
+		return
+	}
+	var items []map[string]any
+	if err := json.Unmarshal(storedResponse.Input, &items); err != nil {
+		common.LogError(c, "unmarshal stored input error: "+err.Error())
+		items = make([]map[string]any, 0)
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+	// 默认按时间倒序返回
+	if c.DefaultQuery("order", "desc") == "desc" {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	if after := c.Query("after"); after != "" {
+		for i, item := range items {
+			if item["id"] == after {
+				items = items[i+1:]
+				break
+			}
+		}
+	}
+	if before := c.Query("before"); before != "" {
+		for i, item := range items {
+			if item["id"] == before {
+				items = items[:i]
+				break
+			}
+		}
+	}
+	hasMore := len(items) > limit
+	if hasMore {
+		items = items[:limit]
+	}
+
+	var firstId, lastId any
+	if len(items) > 0 {
+		firstId = items[0]["id"]
+		lastId = items[len(items)-1]["id"]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     items,
+		"first_id": firstId,
+		"last_id":  lastId,
+		"has_more": hasMore,
+	})
+}
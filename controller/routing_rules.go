@@ -0,0 +1,107 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"veloera/service"
+	"veloera/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+type routingRuleDryRunRequest struct {
+	// 未保存的规则 JSON，为空时使用当前保存的规则
+	Rules        *string           `json:"rules"`
+	TokenId      int               `json:"token_id"`
+	TokenName    string            `json:"token_name"`
+	Group        string            `json:"group"`
+	IP           string            `json:"ip"`
+	Headers      map[string]string `json:"headers"`
+	Model        string            `json:"model"`
+	PromptTokens int               `json:"prompt_tokens"`
+	Stream       bool              `json:"stream"`
+}
+
+// DryRunRoutingRules 使用给定的请求信息试运行路由规则，返回命中的规则和路由结果
+func DryRunRoutingRules(c *gin.Context) {
+	var request routingRuleDryRunRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	raw := model_setting.GetRoutingRuleSettings().Rules
+	if request.Rules != nil {
+		raw = *request.Rules
+	}
+	rules, err := model_setting.ParseRoutingRules(raw)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	routingRequest := &service.RoutingRequest{
+		TokenId:      request.TokenId,
+		TokenName:    request.TokenName,
+		Group:        request.Group,
+		IP:           request.IP,
+		Headers:      http.Header{},
+		Model:        request.Model,
+		PromptTokens: request.PromptTokens,
+		Stream:       request.Stream,
+	}
+	for name, value := range request.Headers {
+		routingRequest.Headers.Set(name, value)
+	}
+	index, rule := service.MatchRoutingRule(c, rules, routingRequest)
+	data := gin.H{
+		"enabled": model_setting.GetRoutingRuleSettings().Enabled,
+		"matched": rule != nil,
+		"index":   index,
+		"group":   request.Group,
+		"model":   request.Model,
+	}
+	if rule != nil {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", index+1)
+		}
+		data["rule"] = name
+		data["action"] = rule.Action
+		if rule.Action.Reject == "" {
+			if rule.Action.Group != "" {
+				data["group"] = rule.Action.Group
+			}
+			if rule.Action.Model != "" {
+				data["model"] = rule.Action.Model
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    data,
+	})
+}
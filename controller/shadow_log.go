@@ -0,0 +1,83 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"veloera/common"
+	"veloera/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getShadowLogQueryParams(c *gin.Context) model.ShadowLogQueryParams {
+	params := model.ShadowLogQueryParams{
+		ModelName: c.Query("model_name"),
+	}
+	params.PrimaryChannelId, _ = strconv.Atoi(c.Query("primary_channel_id"))
+	params.ShadowChannelId, _ = strconv.Atoi(c.Query("shadow_channel_id"))
+	params.StartTimestamp, _ = strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	params.EndTimestamp, _ = strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	return params
+}
+
+func GetShadowLogs(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	logs, total, err := model.GetShadowLogs((p-1)*pageSize, pageSize, getShadowLogQueryParams(c))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": map[string]any{
+			"items":     logs,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// GetShadowLogStats 按影子渠道汇总影子请求的成功率、延迟和响应一致率
+func GetShadowLogStats(c *gin.Context) {
+	stats, err := model.GetShadowLogStats(getShadowLogQueryParams(c))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    stats,
+	})
+}
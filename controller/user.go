@@ -29,7 +29,6 @@ import (
 	"veloera/setting"
 
 	"veloera/constant"
-	"veloera/middleware"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
@@ -518,7 +517,7 @@ func GetUserModels(c *gin.Context) {
 	addedModels := make(map[string]bool) // Track added models to avoid duplicates
 
 	// Get prefix channels for the user's group
-	prefixChannels := middleware.GetPrefixChannels(user.Group)
+	prefixChannels := model.CacheGetPrefixChannels(user.Group)
 	modelPrefixMap := make(map[string][]string) // Map base model to all prefixed versions
 
 	for prefix, channels := range prefixChannels {
@@ -185,6 +185,32 @@ func (c *ClaudeRequest) ParseSystem() []ClaudeMediaMessage {
 	return make([]ClaudeMediaMessage, 0)
 }
 
+// ClaudeCountTokensRequest https://docs.anthropic.com/en/api/messages-count-tokens
+type ClaudeCountTokensRequest struct {
+	Model      string          `json:"model"`
+	System     any             `json:"system,omitempty"`
+	Messages   []ClaudeMessage `json:"messages"`
+	Tools      any             `json:"tools,omitempty"`
+	ToolChoice any             `json:"tool_choice,omitempty"`
+	Thinking   *Thinking       `json:"thinking,omitempty"`
+}
+
+type ClaudeCountTokensResponse struct {
+	InputTokens int `json:"input_tokens"`
+}
+
+// ToCountTokensRequest 去掉 count_tokens 接口不接受的生成参数
+func (c *ClaudeRequest) ToCountTokensRequest() *ClaudeCountTokensRequest {
+	return &ClaudeCountTokensRequest{
+		Model:      c.Model,
+		System:     c.System,
+		Messages:   c.Messages,
+		Tools:      c.Tools,
+		ToolChoice: c.ToolChoice,
+		Thinking:   c.Thinking,
+	}
+}
+
 type ClaudeError struct {
 	Type    string `json:"type,omitempty"`
 	Message string `json:"message,omitempty"`
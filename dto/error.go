@@ -29,6 +29,13 @@ type OpenAIErrorWithStatusCode struct {
 	LocalError bool
 }
 
+// GeminiError is the error body returned by the native Gemini endpoints
+type GeminiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
 type GeneralErrorResponse struct {
 	Error    OpenAIError `json:"error"`
 	Message  string      `json:"message"`
@@ -381,7 +381,7 @@ type OpenAIResponsesRequest struct {
 	PreviousResponseID string               `json:"previous_response_id,omitempty"`
 	Reasoning          *Reasoning           `json:"reasoning,omitempty"`
 	ServiceTier        string               `json:"service_tier,omitempty"`
-	Store              bool                 `json:"store,omitempty"`
+	Store              *bool                `json:"store,omitempty"`
 	Stream             bool                 `json:"stream,omitempty"`
 	Temperature        float64              `json:"temperature,omitempty"`
 	Text               json.RawMessage      `json:"text,omitempty"`
@@ -392,6 +392,66 @@ type OpenAIResponsesRequest struct {
 	User               string               `json:"user,omitempty"`
 }
 
+// ResponsesInputItem is one item of the responses input array: a message,
+// a function call made by the model or the output of that call.
+type ResponsesInputItem struct {
+	Type      string          `json:"type,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Role      string          `json:"role,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	Status    string          `json:"status,omitempty"`
+	CallId    string          `json:"call_id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Arguments string          `json:"arguments,omitempty"`
+	Output    json.RawMessage `json:"output,omitempty"`
+}
+
+type ResponsesInputContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageUrl string `json:"image_url,omitempty"`
+	FileId   string `json:"file_id,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// ShouldStore reports whether the response should be stored, store defaults to true
+func (r *OpenAIResponsesRequest) ShouldStore() bool {
+	return r.Store == nil || *r.Store
+}
+
+// ParseInput returns the input as items, a plain string input becomes a single user message
+func (r *OpenAIResponsesRequest) ParseInput() ([]ResponsesInputItem, error) {
+	if len(r.Input) == 0 {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(r.Input, &text); err == nil {
+		content, _ := json.Marshal(text)
+		return []ResponsesInputItem{{Type: "message", Role: "user", Content: content}}, nil
+	}
+	var items []ResponsesInputItem
+	if err := json.Unmarshal(r.Input, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ParseContent returns the message content as parts, a plain string becomes a single input_text part
+func (i *ResponsesInputItem) ParseContent() []ResponsesInputContent {
+	if len(i.Content) == 0 {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(i.Content, &text); err == nil {
+		return []ResponsesInputContent{{Type: "input_text", Text: text}}
+	}
+	var contents []ResponsesInputContent
+	_ = json.Unmarshal(i.Content, &contents)
+	return contents
+}
+
 type Reasoning struct {
 	Effort  string `json:"effort,omitempty"`
 	Summary string `json:"summary,omitempty"`
@@ -184,14 +184,16 @@ type CompletionsStreamResponse struct {
 }
 
 type Usage struct {
-	PromptTokens           int                `json:"prompt_tokens"`
-	CompletionTokens       int                `json:"completion_tokens"`
-	TotalTokens            int                `json:"total_tokens"`
-	PromptCacheHitTokens   int                `json:"prompt_cache_hit_tokens,omitempty"`
-	PromptTokensDetails    InputTokenDetails  `json:"prompt_tokens_details"`
-	CompletionTokenDetails OutputTokenDetails `json:"completion_tokens_details"`
-	InputTokens            int                `json:"input_tokens,omitempty"`
-	OutputTokens           int                `json:"output_tokens,omitempty"`
+	PromptTokens           int                 `json:"prompt_tokens"`
+	CompletionTokens       int                 `json:"completion_tokens"`
+	TotalTokens            int                 `json:"total_tokens"`
+	PromptCacheHitTokens   int                 `json:"prompt_cache_hit_tokens,omitempty"`
+	PromptTokensDetails    InputTokenDetails   `json:"prompt_tokens_details"`
+	CompletionTokenDetails OutputTokenDetails  `json:"completion_tokens_details"`
+	InputTokens            int                 `json:"input_tokens,omitempty"`
+	OutputTokens           int                 `json:"output_tokens,omitempty"`
+	InputTokensDetails     *InputTokenDetails  `json:"input_tokens_details,omitempty"`
+	OutputTokensDetails    *OutputTokenDetails `json:"output_tokens_details,omitempty"`
 }
 
 type OpenAIResponsesResponse struct {
@@ -220,15 +222,22 @@ type OpenAIResponsesResponse struct {
 }
 
 type IncompleteDetails struct {
-	Reasoning string `json:"reasoning"`
+	Reasoning string `json:"reasoning,omitempty"`
+	Reason    string `json:"reason,omitempty"`
 }
 
 type ResponsesOutput struct {
 	Type    string                   `json:"type"`
 	ID      string                   `json:"id"`
-	Status  string                   `json:"status"`
-	Role    string                   `json:"role"`
-	Content []ResponsesOutputContent `json:"content"`
+	Status  string                   `json:"status,omitempty"`
+	Role    string                   `json:"role,omitempty"`
+	Content []ResponsesOutputContent `json:"content,omitempty"`
+	// function_call
+	CallId    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	// reasoning
+	Summary []ResponsesOutputContent `json:"summary,omitempty"`
 }
 
 type ResponsesOutputContent struct {
@@ -247,11 +256,37 @@ const (
 	ResponsesOutputTypeItemDone  = "response.output_item.done"
 )
 
+const (
+	ResponsesStreamTypeCreated               = "response.created"
+	ResponsesStreamTypeInProgress            = "response.in_progress"
+	ResponsesStreamTypeCompleted             = "response.completed"
+	ResponsesStreamTypeIncomplete            = "response.incomplete"
+	ResponsesStreamTypeContentPartAdded      = "response.content_part.added"
+	ResponsesStreamTypeContentPartDone       = "response.content_part.done"
+	ResponsesStreamTypeOutputTextDelta       = "response.output_text.delta"
+	ResponsesStreamTypeOutputTextDone        = "response.output_text.done"
+	ResponsesStreamTypeReasoningPartAdded    = "response.reasoning_summary_part.added"
+	ResponsesStreamTypeReasoningPartDone     = "response.reasoning_summary_part.done"
+	ResponsesStreamTypeReasoningTextDelta    = "response.reasoning_summary_text.delta"
+	ResponsesStreamTypeReasoningTextDone     = "response.reasoning_summary_text.done"
+	ResponsesStreamTypeFunctionArgumentDelta = "response.function_call_arguments.delta"
+	ResponsesStreamTypeFunctionArgumentDone  = "response.function_call_arguments.done"
+)
+
 // ResponsesStreamResponse 用于处理 /v1/responses 流式响应
 type ResponsesStreamResponse struct {
-	Type     string                   `json:"type"`
-	Response *OpenAIResponsesResponse `json:"response"`
-	Delta    string                   `json:"delta,omitempty"`
+	Type           string                   `json:"type"`
+	SequenceNumber int                      `json:"sequence_number"`
+	Response       *OpenAIResponsesResponse `json:"response,omitempty"`
+	Delta          string                   `json:"delta,omitempty"`
+	ItemId         string                   `json:"item_id,omitempty"`
+	OutputIndex    *int                     `json:"output_index,omitempty"`
+	ContentIndex   *int                     `json:"content_index,omitempty"`
+	SummaryIndex   *int                     `json:"summary_index,omitempty"`
+	Item           *ResponsesOutput         `json:"item,omitempty"`
+	Part           *ResponsesOutputContent  `json:"part,omitempty"`
+	Text           string                   `json:"text,omitempty"`
+	Arguments      string                   `json:"arguments,omitempty"`
 }
 
 type InputTokenDetails struct {
@@ -114,6 +114,9 @@ func main() {
 		go model.SyncOptions(common.SyncFrequency)
 		go model.SyncChannelCache(common.SyncFrequency)
 	}
+	// 渠道 key 状态缓存在请求路径上使用，无论是否启用内存缓存都需要加载
+	model.InitDisabledChannelKeys()
+	go model.SyncDisabledChannelKeys(common.SyncFrequency)
 
 	// 数据看板
 	go model.UpdateQuotaData()
@@ -140,12 +143,19 @@ func main() {
 			controller.UpdateTaskBulk()
 		})
 	}
+	if common.IsMasterNode {
+		go model.CleanExpiredStoredResponses(3600)
+		go model.CleanExpiredShadowLogs(3600)
+	}
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
 		common.BatchUpdateEnabled = true
 		common.SysLog("batch update enabled with interval " + strconv.Itoa(common.BatchUpdateInterval) + "s")
 		model.InitBatchUpdater()
 	}
 
+	// 多 key 渠道的 key 使用统计
+	model.InitChannelKeyStatsUpdater()
+
 	if os.Getenv("ENABLE_PPROF") == "true" {
 		gopool.Go(func() {
 			log.Println(http.ListenAndServe("0.0.0.0:8005", nil))
@@ -183,6 +193,8 @@ func main() {
 	server.Use(sessions.Sessions("session", store))
 
 	router.SetRouter(server, buildFS, indexPage)
+	// 批处理中的请求通过网关自身的路由执行
+	service.InitBatchProcessor(server)
 	var port = os.Getenv("PORT")
 	if port == "" {
 		port = strconv.Itoa(*common.Port)
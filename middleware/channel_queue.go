@@ -0,0 +1,70 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package middleware
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+	"veloera/model"
+	"veloera/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 所有渠道的并发都已满时按配置排队，在超时前定期重新选择渠道，等待其他请求释放名额
+
+const channelQueuePollInterval = 100 * time.Millisecond
+
+// 当前节点排队中的请求数
+var channelQueueSize int64
+
+// WaitForChannel 排队等待空闲渠道，未启用排队、队列已满或超时时返回 model.ErrChannelSaturated
+func WaitForChannel(c *gin.Context, group string, modelName string, retry int) (*model.Channel, error) {
+	settings := model_setting.GetChannelConcurrencySettings()
+	if !settings.QueueEnabled || settings.QueueTimeoutMs <= 0 {
+		return nil, model.ErrChannelSaturated
+	}
+	size := atomic.AddInt64(&channelQueueSize, 1)
+	defer atomic.AddInt64(&channelQueueSize, -1)
+	if settings.QueueMaxSize > 0 && size > int64(settings.QueueMaxSize) {
+		return nil, model.ErrChannelSaturated
+	}
+
+	start := time.Now()
+	defer func() {
+		// 记录累计排队时间，用于日志
+		c.Set("channel_queue_time", c.GetInt64("channel_queue_time")+time.Since(start).Milliseconds())
+	}()
+	timeout := time.NewTimer(time.Duration(settings.QueueTimeoutMs) * time.Millisecond)
+	defer timeout.Stop()
+	ticker := time.NewTicker(channelQueuePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return nil, c.Request.Context().Err()
+		case <-timeout.C:
+			return nil, model.ErrChannelSaturated
+		case <-ticker.C:
+		}
+		channel, err := SelectChannel(c, group, modelName, retry)
+		if !errors.Is(err, model.ErrChannelSaturated) {
+			return channel, err
+		}
+	}
+}
@@ -39,51 +39,13 @@ type ModelRequest struct {
 	Model string `json:"model"`
 }
 
-// Cache for storing channels by prefix
+// Round-robin key selection state
 var (
-	prefixChannelsMutex       sync.RWMutex
-	prefixChannelsCache       = make(map[string]map[string][]*model.Channel) // group -> prefix -> channels
-	prefixChannelsCacheExpiry = make(map[string]int64)                       // group -> expiry timestamp
-
-	// Round-robin key selection state
 	channelKeysMutex sync.Mutex
 	channelKeysIndex = make(map[int]int)    // channel_id -> current key index
 	channelKeysHash  = make(map[int]string) // channel_id -> hash of keys
 )
 
-// getPrefixChannels returns a map of prefix -> channels for a given group
-// The cache is refreshed every hour
-func getPrefixChannels(group string) map[string][]*model.Channel {
-	prefixChannelsMutex.RLock()
-	expiry, ok := prefixChannelsCacheExpiry[group]
-	prefixChannelsMutex.RUnlock()
-
-	currentTime := time.Now().Unix()
-	if !ok || expiry < currentTime {
-		// Cache expired, refresh it
-		return refreshPrefixChannelsCache(group)
-	}
-
-	prefixChannelsMutex.RLock()
-	defer prefixChannelsMutex.RUnlock()
-	prefixMap, ok := prefixChannelsCache[group]
-	if !ok {
-		return make(map[string][]*model.Channel)
-	}
-
-	return prefixMap
-}
-
-// GetPrefixChannels is the exported version of getPrefixChannels for use by other packages
-func GetPrefixChannels(group string) map[string][]*model.Channel {
-	return getPrefixChannels(group)
-}
-
-// SelectChannelByPrefix is the exported version of selectChannelByPrefix for use by other packages
-func SelectChannelByPrefix(group, prefix, originalModel string) (*model.Channel, error) {
-	return selectChannelByPrefix(group, prefix, originalModel)
-}
-
 // ResetChannelKeyIndex resets the round-robin key index for a specific channel
 // This can be called when a channel's keys are updated
 func ResetChannelKeyIndex(channelId int) {
@@ -95,109 +57,53 @@ func ResetChannelKeyIndex(channelId int) {
 	delete(channelKeysHash, channelId)
 }
 
-// RefreshPrefixChannelsCache refreshes prefix cache for one or multiple groups.
-// Groups should be a comma separated string, empty entries are ignored.
-func RefreshPrefixChannelsCache(groups string) {
-	for _, g := range strings.Split(groups, ",") {
-		g = strings.TrimSpace(g)
-		if g == "" {
-			continue
+// SplitModelPrefix returns the model prefix of a prefixed model name in the group and the model name without it.
+// Prefixed model names are routed through the abilities like any other model.
+func SplitModelPrefix(group, modelName string) (string, string) {
+	modelPrefix := ""
+	for prefix := range model.CacheGetPrefixChannels(group) {
+		// Prefer the longest prefix when prefixes overlap
+		if prefix != "" && strings.HasPrefix(modelName, prefix) && len(prefix) > len(modelPrefix) {
+			modelPrefix = prefix
 		}
-		refreshPrefixChannelsCache(g)
 	}
+	return modelPrefix, strings.TrimPrefix(modelName, modelPrefix)
 }
 
-// refreshPrefixChannelsCache refreshes the prefix channels cache for a given group
-func refreshPrefixChannelsCache(group string) map[string][]*model.Channel {
-	var channels []*model.Channel
-
-	// Get channels for this group from the database
-	db := model.DB.Model(&model.Channel{}).Where("status = ?", common.ChannelStatusEnabled)
-	if group != "" {
-		var condition string
-		groupCol := "`group`"
-		if common.UsingPostgreSQL {
-			groupCol = "\"group\""
-		}
-		if common.UsingMySQL {
-			condition = fmt.Sprintf("CONCAT(',', %s, ',') LIKE '%%,%s,%%'", groupCol, group)
-		} else {
-			// sqlite, PostgreSQL
-			condition = fmt.Sprintf("(',' || %s || ',') LIKE '%%,%s,%%'", groupCol, group)
-		}
-		db = db.Where(condition)
+// getStickyChannel returns the channel bound to the session if it is still enabled and serves the model
+func getStickyChannel(c *gin.Context, stickyKey string, group string, modelName string) *model.Channel {
+	session, ok := service.GetStickySession(stickyKey)
+	if !ok {
+		return nil
 	}
-
-	db.Order("priority desc").Find(&channels)
-	prefixMap := make(map[string][]*model.Channel)
-
-	// Group channels by prefix
-	for _, channel := range channels {
-		prefix := ""
-		if channel.ModelPrefix != nil {
-			prefix = *channel.ModelPrefix
-		}
-		prefixMap[prefix] = append(prefixMap[prefix], channel)
+	channel, err := model.CacheGetChannel(session.ChannelId)
+	if err != nil || channel.Status != common.ChannelStatusEnabled || !channel.HasEnabledKey() {
+		return nil
 	}
-
-	// Store in cache
-	prefixChannelsMutex.Lock()
-	prefixChannelsCache[group] = prefixMap
-	prefixChannelsCacheExpiry[group] = time.Now().Add(1 * time.Hour).Unix()
-	prefixChannelsMutex.Unlock()
-
-	return prefixMap
-}
-
-// selectChannelByPrefix selects a channel based on the model prefix
-func selectChannelByPrefix(group, prefix, originalModel string) (*model.Channel, error) {
-	prefixMap := getPrefixChannels(group)
-
-	channels, ok := prefixMap[prefix]
-	if !ok || len(channels) == 0 {
-		return nil, fmt.Errorf("no channels found for prefix %s", prefix)
+	if !model.CacheIsChannelSatisfied(group, modelName, channel.Id) {
+		return nil
 	}
-
-	// Filter channels that support the model (without prefix)
-	var compatibleChannels []*model.Channel
-	for _, channel := range channels {
-		// Check if the channel supports the model
-		for _, model := range channel.GetModels() {
-			if model == originalModel {
-				compatibleChannels = append(compatibleChannels, channel)
-				break
-			}
-		}
+	if tag := c.GetString("routing_tag"); tag != "" && channel.GetTag() != tag {
+		return nil
 	}
-
-	if len(compatibleChannels) == 0 {
-		return nil, fmt.Errorf("no channels supporting model %s found for prefix %s", originalModel, prefix)
+	if !channel.IsInSchedule(time.Now()) {
+		return nil
 	}
-
-	// Select a random channel based on weight
-	totalWeight := 0
-	for _, channel := range compatibleChannels {
-		totalWeight += channel.GetWeight()
+	// 并发已满或上游限流冷却中时重新选择渠道
+	if len(model.FilterChannelsByConcurrency([]*model.Channel{channel})) == 0 {
+		return nil
 	}
-
-	if totalWeight <= 0 {
-		// If no weight or all zero weights, select randomly
-		return compatibleChannels[common.GetRandomInt(len(compatibleChannels))], nil
+	if channels, _ := model.FilterChannelsByRateLimit([]*model.Channel{channel}); len(channels) == 0 {
+		return nil
 	}
-
-	// Weighted random selection
-	randWeight := common.GetRandomInt(totalWeight)
-	currentWeight := 0
-
-	for _, channel := range compatibleChannels {
-		currentWeight += channel.GetWeight()
-		if randWeight < currentWeight {
-			return channel, nil
-		}
+	ok, probe := model.AcquireChannelBreaker(channel.Id, modelName)
+	if !ok {
+		return nil
 	}
-
-	// Fallback - should not happen unless there's a calculation error
-	return compatibleChannels[0], nil
+	c.Set("channel_breaker_probe", probe)
+	c.Set("sticky_channel_id", channel.Id)
+	c.Set("sticky_key_index", session.KeyIndex)
+	return channel
 }
 
 func Distribute() func(c *gin.Context) {
@@ -211,7 +117,6 @@ func Distribute() func(c *gin.Context) {
 			}
 		}
 		var channel *model.Channel
-		channelId, ok := c.Get("specific_channel_id")
 		modelRequest, shouldSelectChannel, err := getModelRequest(c)
 		if err != nil {
 			abortWithOpenAiMessage(c, http.StatusBadRequest, "Invalid request, "+err.Error())
@@ -234,23 +139,23 @@ func Distribute() func(c *gin.Context) {
 		}
 		c.Set("group", userGroup)
 
+		// 路由规则可能切换分组、改写模型或固定渠道
+		requestModel := modelRequest.Model
+		var allowed bool
+		userGroup, modelRequest.Model, allowed = applyRoutingRules(c, userGroup, modelRequest.Model)
+		if !allowed {
+			return
+		}
+		channelId, ok := c.Get("specific_channel_id")
+
 		// Check if the model has a prefix, which is used for routing
 		originalModel := modelRequest.Model
-		prefixedModel, hasPrefixedModel := c.Get("prefixed_model")
 		modelPrefix := ""
-		prefixedModelStr := ""
-		if hasPrefixedModel {
-			prefixedModelStr = prefixedModel.(string)
-			// Extract prefix from the model name if it exists
-			for prefix := range getPrefixChannels(userGroup) {
-				if prefix != "" && strings.HasPrefix(prefixedModelStr, prefix) {
-					modelPrefix = prefix
-					// Update the model name to strip the prefix for channel selection
-					modelRequest.Model = strings.TrimPrefix(prefixedModelStr, prefix)
-					break
-				}
-			}
+		if modelRequest.Model != "" {
+			// Strip the prefix for the virtual model mapping
+			modelPrefix, modelRequest.Model = SplitModelPrefix(userGroup, modelRequest.Model)
 		}
+		c.Set("model_prefix", modelPrefix)
 
 		// Apply global virtual model mapping
 		originalVirtualModel := modelRequest.Model
@@ -269,6 +174,12 @@ func Distribute() func(c *gin.Context) {
 			c.Set("virtual_model_original", originalVirtualModel)
 			c.Set("virtual_model_actual", actualModel)
 		}
+		if !ok {
+			setupModelFallbacks(c, originalModel, originalVirtualModel, modelRequest.Model)
+		}
+		// Prefixed models are routed by the prefixed name through the abilities,
+		// the prefix is stripped again for the upstream request
+		modelRequest.Model = modelPrefix + modelRequest.Model
 
 		if ok {
 			id, err := strconv.Atoi(channelId.(string))
@@ -285,6 +196,14 @@ func Distribute() func(c *gin.Context) {
 				abortWithOpenAiMessage(c, http.StatusForbidden, "该渠道已被禁用")
 				return
 			}
+			if !channel.IsInSchedule(time.Now()) {
+				abortWithOpenAiMessage(c, http.StatusForbidden, "该渠道当前不在可用时间段内")
+				return
+			}
+			if !channel.HasEnabledKey() {
+				abortWithOpenAiMessage(c, http.StatusForbidden, "该渠道的 key 均已被禁用")
+				return
+			}
 		} else {
 			// Select a channel for the user
 			// check token model mapping
@@ -298,9 +217,9 @@ func Distribute() func(c *gin.Context) {
 					tokenModelLimit = map[string]bool{}
 				}
 				if tokenModelLimit != nil {
-					// Check access against the original (prefixed) model name
-					if _, ok := tokenModelLimit[originalModel]; !ok {
-						abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问模型 "+originalModel)
+					// Check access against the model requested by the client (with prefix)
+					if _, ok := tokenModelLimit[requestModel]; !ok {
+						abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问模型 "+requestModel)
 						return
 					}
 				} else {
@@ -311,13 +230,30 @@ func Distribute() func(c *gin.Context) {
 			}
 
 			if shouldSelectChannel {
-				// If we have a model prefix, use it to select among specific channels
-				if modelPrefix != "" {
-					channel, err = selectChannelByPrefix(userGroup, modelPrefix, modelRequest.Model)
-				} else {
-					channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, modelRequest.Model, 0)
+				// Prefer the channel bound to the session for prompt cache hits
+				stickyKey := service.GetStickySessionKey(c, userGroup, modelRequest.Model)
+				c.Set("sticky_session_key", stickyKey)
+				channel = getStickyChannel(c, stickyKey, userGroup, modelRequest.Model)
+				if channel == nil {
+					channel, err = SelectChannel(c, userGroup, modelRequest.Model, 0)
+				}
+				saturated := errors.Is(err, model.ErrChannelSaturated)
+				if channel == nil {
+					// 当前模型没有可用渠道时按降级链切换模型
+					if fallbackChannel, fallbackModel, found := SelectFallbackChannel(c, userGroup); found {
+						channel, err = fallbackChannel, nil
+						modelRequest.Model = fallbackModel
+					}
+				}
+				if channel == nil && saturated {
+					// 降级模型也没有可用渠道时，排队等待当前模型的渠道释放并发名额
+					channel, err = WaitForChannel(c, userGroup, modelRequest.Model, 0)
 				}
 
+				if errors.Is(err, model.ErrChannelSaturated) {
+					abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("当前分组 %s 下对于模型 %s 的渠道并发已满，请稍后再试", userGroup, originalModel))
+					return
+				}
 				if err != nil {
 					message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", userGroup, originalModel)
 					// 如果错误，但是渠道不为空，说明是数据库一致性问题
@@ -394,6 +330,10 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 		//wss://api.openai.com/v1/realtime?model=gpt-4o-realtime-preview-2024-10-01
 		modelRequest.Model = c.Query("model")
 	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1beta/models/") {
+		// /v1beta/models/gemini-2.0-flash:generateContent
+		modelRequest.Model, _ = relayconstant.ParseGeminiModelPath(c.Request.URL.Path)
+	}
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/moderations") {
 		if modelRequest.Model == "" {
 			modelRequest.Model = "text-moderation-stable"
@@ -407,6 +347,18 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/images/generations") {
 		modelRequest.Model = common.GetStringIfEmpty(modelRequest.Model, "dall-e")
 	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/images/edits") || strings.HasPrefix(c.Request.URL.Path, "/v1/images/variations") {
+		// 图片编辑和变体请求为 multipart/form-data，从表单中读取模型
+		form, err := common.ParseMultipartFormReusable(c)
+		if err != nil {
+			return nil, false, errors.New("无效的请求, " + err.Error())
+		}
+		if models := form.Value["model"]; len(models) > 0 {
+			modelRequest.Model = models[0]
+		}
+		_ = form.RemoveAll()
+		modelRequest.Model = common.GetStringIfEmpty(modelRequest.Model, "dall-e-2")
+	}
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/audio") {
 		relayMode := relayconstant.RelayModeAudioSpeech
 		if strings.HasPrefix(c.Request.URL.Path, "/v1/audio/speech") {
@@ -445,10 +397,8 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	c.Set("param_override", channel.GetParamOverride())
 	c.Set("system_prompt", channel.GetSystemPrompt())
 
-	// Set model prefix if available
-	if channel.ModelPrefix != nil && *channel.ModelPrefix != "" {
-		c.Set("model_prefix", *channel.ModelPrefix)
-	}
+	// 只有选中的渠道有模型前缀时才去掉前缀，没有前缀的渠道可能把带斜杠的完整模型名作为模型
+	c.Set("model_prefix", channel.GetModelPrefix())
 
 	if nil != channel.OpenAIOrganization && "" != *channel.OpenAIOrganization {
 		c.Set("channel_organization", *channel.OpenAIOrganization)
@@ -457,12 +407,39 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	c.Set("model_mapping", channel.GetModelMapping())
 	c.Set("status_code_mapping", channel.GetStatusCodeMapping())
 
-	// 如果key包含逗号，使用轮询方式选择一个key
+	// 如果key包含逗号，使用轮询方式选择一个key，跳过被禁用的key
 	// 对于渠道类型41，不处理逗号分隔的多key机制
+	c.Set("channel_key_index", 0)
+	c.Set("channel_key_hash", "")
 	if channel.Type == 41 {
 		c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
-	} else if strings.Contains(channel.Key, ",") {
-		keys := strings.Split(channel.Key, ",")
+	} else if keys := model.GetChannelKeyList(channel.Key); strings.Contains(channel.Key, ",") && len(keys) > 0 {
+		// 与 key 管理接口使用相同的拆分规则，忽略空白和空行，保证 key 的序号一致
+		keyHashes := make([]string, len(keys))
+		keyEnabled := make([]bool, len(keys))
+		keyDisabled := make([]bool, len(keys))
+		for i, key := range keys {
+			keyHashes[i] = model.GetChannelKeyHash(key)
+		}
+		// 并发已满和上游限流冷却中的 key 与被禁用的 key 一样跳过
+		saturatedKeys := model.GetSaturatedChannelKeys(channel.Id, keyHashes, channel.GetConcurrencyLimits().Key)
+		coolingDownKeys, lowHeadroomKeys := model.GetChannelKeyRateLimits(channel.Id, keyHashes)
+		hasHeadroom := false
+		for i := range keys {
+			keyDisabled[i] = !model.IsChannelKeyEnabled(channel.Id, keyHashes[i])
+			keyEnabled[i] = !keyDisabled[i] && !saturatedKeys[keyHashes[i]] && !coolingDownKeys[keyHashes[i]]
+			if keyEnabled[i] && !lowHeadroomKeys[keyHashes[i]] {
+				hasHeadroom = true
+			}
+		}
+		// 存在剩余额度充足的 key 时跳过额度不足的 key
+		if hasHeadroom {
+			for i := range keys {
+				if lowHeadroomKeys[keyHashes[i]] {
+					keyEnabled[i] = false
+				}
+			}
+		}
 
 		// Get current index for this channel using round-robin
 		channelKeysMutex.Lock()
@@ -484,13 +461,35 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 			channelKeysHash[channel.Id] = currentHash
 		}
 
-		// Select key at current index
+		stickyIndex := c.GetInt("sticky_key_index")
+		if c.GetInt("sticky_channel_id") == channel.Id && stickyIndex >= 0 && stickyIndex < len(keys) && keyEnabled[stickyIndex] {
+			// Keep using the key bound to the session without advancing the round-robin index
+			index = stickyIndex
+		} else {
+			// Select the first enabled key starting from the current index,
+			// fall back to a key that is saturated or cooling down but not disabled
+			selected := false
+			for i := 0; i < len(keys); i++ {
+				if keyEnabled[(index+i)%len(keys)] {
+					index = (index + i) % len(keys)
+					selected = true
+					break
+				}
+			}
+			for i := 0; !selected && i < len(keys); i++ {
+				if !keyDisabled[(index+i)%len(keys)] {
+					index = (index + i) % len(keys)
+					selected = true
+				}
+			}
+			// Update index for next use
+			channelKeysIndex[channel.Id] = (index + 1) % len(keys)
+		}
 		selectedKey := keys[index]
-
-		// Update index for next use
-		channelKeysIndex[channel.Id] = (index + 1) % len(keys)
 		channelKeysMutex.Unlock()
 
+		c.Set("channel_key_index", index)
+		c.Set("channel_key_hash", keyHashes[index])
 		c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", strings.TrimSpace(selectedKey)))
 	} else {
 		c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
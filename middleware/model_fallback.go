@@ -0,0 +1,88 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package middleware
+
+import (
+	"fmt"
+	"veloera/common"
+	"veloera/model"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupModelFallbacks 记录请求模型的降级链，虚拟模型没有配置时使用映射后的实际模型的降级链
+func setupModelFallbacks(c *gin.Context, requestModel, virtualModel, actualModel string) {
+	fallbacks := service.GetModelFallbacks(virtualModel)
+	if len(fallbacks) == 0 && actualModel != virtualModel {
+		fallbacks = service.GetModelFallbacks(actualModel)
+	}
+	if len(fallbacks) == 0 {
+		return
+	}
+	c.Set("model_fallbacks", fallbacks)
+	c.Set("model_fallback_chain", []string{requestModel})
+}
+
+// SelectFallbackChannel 依次尝试降级链中剩余的模型，返回第一个有可用渠道的模型及其渠道。
+// 调用方需要再调用 SetupContextForSelectedChannel 设置渠道信息。
+func SelectFallbackChannel(c *gin.Context, group string) (*model.Channel, string, bool) {
+	fallbacks := c.GetStringSlice("model_fallbacks")
+	for len(fallbacks) > 0 {
+		fallbackModel := fallbacks[0]
+		fallbacks = fallbacks[1:]
+		c.Set("model_fallbacks", fallbacks)
+		if !isTokenModelAllowed(c, fallbackModel) {
+			continue
+		}
+		actualModel, err := service.GetActualModel(fallbackModel)
+		if err != nil {
+			continue
+		}
+		channel, err := SelectChannel(c, group, actualModel, 0)
+		if err != nil || channel == nil {
+			continue
+		}
+
+		chain := c.GetStringSlice("model_fallback_chain")
+		common.LogInfo(c, fmt.Sprintf("model fallback: %s -> %s", chain[len(chain)-1], fallbackModel))
+		c.Set("model_fallback_chain", append(chain, fallbackModel))
+		// 计费和日志使用实际提供服务的模型
+		c.Set("prefixed_model", fallbackModel)
+		c.Set("model_prefix", "")
+		c.Set("virtual_model_mapped", actualModel != fallbackModel)
+		c.Set("virtual_model_original", fallbackModel)
+		c.Set("virtual_model_actual", actualModel)
+		c.Set("sticky_session_key", service.GetStickySessionKey(c, group, actualModel))
+		return channel, actualModel, true
+	}
+	return nil, "", false
+}
+
+// isTokenModelAllowed 检查令牌的模型限制是否允许访问该模型
+func isTokenModelAllowed(c *gin.Context, modelName string) bool {
+	if !c.GetBool("token_model_limit_enabled") {
+		return true
+	}
+	tokenModelLimit, _ := c.Get("token_model_limit")
+	limit, ok := tokenModelLimit.(map[string]bool)
+	if !ok {
+		return false
+	}
+	_, ok = limit[modelName]
+	return ok
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"veloera/common"
+	"veloera/model"
+	"veloera/service"
+	"veloera/setting"
+	"veloera/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SelectChannel 为请求选择渠道，路由规则固定了渠道标签时只从该标签的渠道中选择。
+// 选中的渠道处于半开状态时在上下文中标记 channel_breaker_probe，记录结果或释放名额时使用
+func SelectChannel(c *gin.Context, group string, modelName string, retry int) (*model.Channel, error) {
+	channel, probe, err := model.CacheGetRandomSatisfiedChannelByTag(group, modelName, c.GetString("routing_tag"), retry)
+	c.Set("channel_breaker_probe", probe)
+	return channel, err
+}
+
+// applyRoutingRules 按路由规则处理请求，返回处理后的分组和模型，请求被拒绝时返回 false
+func applyRoutingRules(c *gin.Context, group string, modelName string) (string, string, bool) {
+	rules := model_setting.GetRoutingRules()
+	if len(rules) == 0 {
+		return group, modelName, true
+	}
+	index, rule := service.MatchRoutingRule(c, rules, service.NewRoutingRequest(c, group, modelName))
+	if rule == nil {
+		return group, modelName, true
+	}
+	ruleName := rule.Name
+	if ruleName == "" {
+		ruleName = fmt.Sprintf("#%d", index+1)
+	}
+	c.Set("routing_rule", ruleName)
+	action := rule.Action
+	if action.Reject != "" {
+		abortWithOpenAiMessage(c, http.StatusForbidden, action.Reject)
+		return group, modelName, false
+	}
+	if action.Group != "" && action.Group != group {
+		if !setting.ContainsGroupRatio(action.Group) {
+			common.LogError(c, fmt.Sprintf("routing rule %s: group %s does not exist", ruleName, action.Group))
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, "路由规则配置错误，请联系管理员")
+			return group, modelName, false
+		}
+		group = action.Group
+		c.Set("group", group)
+	}
+	if action.Model != "" && action.Model != modelName {
+		// 改写后的模型与客户端直接请求该模型一样处理
+		modelName = action.Model
+		c.Set("prefixed_model", modelName)
+	}
+	if action.RetryTimes != nil {
+		c.Set("routing_retry_times", *action.RetryTimes)
+	}
+	if action.ChannelId != 0 {
+		// 令牌已经指定渠道时以令牌为准
+		if _, ok := c.Get("specific_channel_id"); !ok {
+			c.Set("specific_channel_id", strconv.Itoa(action.ChannelId))
+		}
+	} else if action.Tag != "" {
+		c.Set("routing_tag", action.Tag)
+	}
+	common.LogInfo(c, fmt.Sprintf("routing rule matched: %s", ruleName))
+	return group, modelName, true
+}
@@ -34,38 +34,34 @@ type Ability struct {
 	Priority  *int64  `json:"priority" gorm:"bigint;default:0;index"`
 	Weight    uint    `json:"weight" gorm:"default:0;index"`
 	Tag       *string `json:"tag" gorm:"index"`
+	// 有模型前缀的渠道会额外写入带前缀的模型名，用于前缀路由
+	ModelPrefix string `json:"model_prefix" gorm:"type:varchar(64);default:''"`
 }
 
 func GetGroupModels(group string) []string {
 	var models []string
 	// Find distinct models
-	DB.Table("abilities").Where(groupCol+" = ? and enabled = ?", group, true).Distinct("model").Pluck("model", &models)
+	DB.Table("abilities").Where(groupCol+" = ? and enabled = ? and model_prefix = ?", group, true, "").Distinct("model").Pluck("model", &models)
 	return models
 }
 
 func GetEnabledModels() []string {
 	var models []string
 	// Find distinct models
-	DB.Table("abilities").Where("enabled = ?", true).Distinct("model").Pluck("model", &models)
+	DB.Table("abilities").Where("enabled = ? and model_prefix = ?", true, "").Distinct("model").Pluck("model", &models)
 	return models
 }
 
 func GetAllEnableAbilities() []Ability {
 	var abilities []Ability
-	DB.Find(&abilities, "enabled = ?", true)
+	DB.Find(&abilities, "enabled = ? and model_prefix = ?", true, "")
 	return abilities
 }
 
-func getPriority(group string, model string, retry int) (int, error) {
-	trueVal := "1"
-	if common.UsingPostgreSQL {
-		trueVal = "true"
-	}
-
+func getPriority(group string, model string, tag string, retry int) (int, error) {
 	var priorities []int
-	err := DB.Model(&Ability{}).
+	err := satisfiedAbilityQuery(DB.Model(&Ability{}), group, model, tag).
 		Select("DISTINCT(priority)").
-		Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model).
 		Order("priority DESC").              // 按优先级降序排序
 		Pluck("priority", &priorities).Error // Pluck用于将查询的结果直接扫描到一个切片中
 
@@ -90,31 +86,53 @@ func getPriority(group string, model string, retry int) (int, error) {
 	return priorityToUse, nil
 }
 
-func getChannelQuery(group string, model string, retry int) *gorm.DB {
+// satisfiedAbilityQuery 查询分组和模型下已启用的能力，tag 不为空时只查询该标签的渠道
+func satisfiedAbilityQuery(query *gorm.DB, group string, model string, tag string) *gorm.DB {
 	trueVal := "1"
 	if common.UsingPostgreSQL {
 		trueVal = "true"
 	}
-	maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
-	channelQuery := DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and priority = (?)", group, model, maxPrioritySubQuery)
+	query = query.Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
+	if tag != "" {
+		query = query.Where("tag = ?", tag)
+	}
+	return query
+}
+
+func getChannelQuery(group string, model string, tag string, retry int) *gorm.DB {
+	maxPrioritySubQuery := satisfiedAbilityQuery(DB.Model(&Ability{}), group, model, tag).Select("MAX(priority)")
+	channelQuery := satisfiedAbilityQuery(DB, group, model, tag).Where("priority = (?)", maxPrioritySubQuery)
 	if retry != 0 {
-		priority, err := getPriority(group, model, retry)
+		priority, err := getPriority(group, model, tag, retry)
 		if err != nil {
 			common.SysError(fmt.Sprintf("Get priority failed: %s", err.Error()))
 		} else {
-			channelQuery = DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and priority = ?", group, model, priority)
+			channelQuery = satisfiedAbilityQuery(DB, group, model, tag).Where("priority = ?", priority)
 		}
 	}
 
 	return channelQuery
 }
 
-func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+// IsChannelSatisfied 判断渠道是否启用了分组和模型
+func IsChannelSatisfied(group string, model string, channelId int) bool {
+	trueVal := "1"
+	if common.UsingPostgreSQL {
+		trueVal = "true"
+	}
+	var count int64
+	err := DB.Model(&Ability{}).
+		Where(groupCol+" = ? and model = ? and channel_id = ? and enabled = "+trueVal, group, model, channelId).
+		Count(&count).Error
+	return err == nil && count > 0
+}
+
+func GetRandomSatisfiedChannel(group string, model string, tag string, retry int) (*Channel, bool, error) {
 	// 调用全局模型映射服务，将虚拟模型名转换为实际模型名
 	actualModel, err := GetActualModel(model)
 	if err != nil {
 		common.SysError(fmt.Sprintf("Model mapping failed: Virtual Model=%s, Error=%s", model, err.Error()))
-		return nil, fmt.Errorf("Model mapping failed: %w", err)
+		return nil, false, fmt.Errorf("Model mapping failed: %w", err)
 	}
 
 	// 记录模型映射日志
@@ -125,7 +143,7 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	var abilities []Ability
 
 	var dbErr error = nil
-	channelQuery := getChannelQuery(group, actualModel, retry)
+	channelQuery := getChannelQuery(group, actualModel, tag, retry)
 	if common.UsingSQLite || common.UsingPostgreSQL {
 		dbErr = channelQuery.Order("weight DESC").Find(&abilities).Error
 	} else {
@@ -133,11 +151,121 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	}
 	if dbErr != nil {
 		common.SysError(fmt.Sprintf("查询渠道能力失败: group=%s, model=%s, 错误=%s", group, actualModel, dbErr.Error()))
-		return nil, dbErr
+		return nil, false, dbErr
 	}
 
-	channel := Channel{}
+	// 过滤不在可用时间段内的渠道和所有 key 都已被禁用的渠道
+	if len(abilities) > 0 {
+		channelIds := make([]int, 0, len(abilities))
+		for _, ability_ := range abilities {
+			channelIds = append(channelIds, ability_.ChannelId)
+		}
+		unscheduled := getUnscheduledChannelIdsFromDB(channelIds)
+		// 所有 key 都已被禁用的渠道同样跳过
+		for channelId := range getKeylessChannelIdsFromDB(channelIds) {
+			unscheduled[channelId] = true
+		}
+		if len(unscheduled) > 0 {
+			available := make([]Ability, 0, len(abilities))
+			for _, ability_ := range abilities {
+				if !unscheduled[ability_.ChannelId] {
+					available = append(available, ability_)
+				}
+			}
+			abilities = available
+		}
+	}
+
+	// 过滤处于熔断状态的渠道
 	if len(abilities) > 0 {
+		channelIds := make([]int, 0, len(abilities))
+		for _, ability_ := range abilities {
+			channelIds = append(channelIds, ability_.ChannelId)
+		}
+		if blocked := getBreakerBlockedChannelIds(channelIds, model); len(blocked) > 0 {
+			available := make([]Ability, 0, len(abilities))
+			for _, ability_ := range abilities {
+				if !blocked[ability_.ChannelId] {
+					available = append(available, ability_)
+				}
+			}
+			abilities = available
+		}
+	}
+
+	// 过滤并发已满的渠道
+	if len(abilities) > 0 {
+		channelIds := make([]int, 0, len(abilities))
+		for _, ability_ := range abilities {
+			channelIds = append(channelIds, ability_.ChannelId)
+		}
+		if saturated := getSaturatedChannelIdsFromDB(channelIds); len(saturated) > 0 {
+			available := make([]Ability, 0, len(abilities))
+			for _, ability_ := range abilities {
+				if !saturated[ability_.ChannelId] {
+					available = append(available, ability_)
+				}
+			}
+			if len(available) == 0 {
+				return nil, false, ErrChannelSaturated
+			}
+			abilities = available
+		}
+	}
+
+	// 过滤上游限流冷却中的渠道，剩余额度不足的渠道靠后选择
+	if len(abilities) > 0 {
+		channelIds := make([]int, 0, len(abilities))
+		for _, ability_ := range abilities {
+			channelIds = append(channelIds, ability_.ChannelId)
+		}
+		coolingDown, lowHeadroom := getRateLimitedChannelIdsFromDB(channelIds)
+		if len(coolingDown) > 0 || len(lowHeadroom) > 0 {
+			available := make([]Ability, 0, len(abilities))
+			preferred := make([]Ability, 0, len(abilities))
+			for _, ability_ := range abilities {
+				if coolingDown[ability_.ChannelId] {
+					continue
+				}
+				available = append(available, ability_)
+				if !lowHeadroom[ability_.ChannelId] {
+					preferred = append(preferred, ability_)
+				}
+			}
+			if len(available) == 0 {
+				return nil, false, ErrChannelSaturated
+			}
+			abilities = available
+			if len(preferred) > 0 {
+				abilities = preferred
+			}
+		}
+	}
+
+	// 放量中的渠道只承担一部分流量
+	if len(abilities) > 1 {
+		channelIds := make([]int, 0, len(abilities))
+		for _, ability_ := range abilities {
+			channelIds = append(channelIds, ability_.ChannelId)
+		}
+		if chosen, ramping := pickCanaryChannelId(channelIds); ramping != nil {
+			available := make([]Ability, 0, len(abilities))
+			for _, ability_ := range abilities {
+				if (chosen != 0 && ability_.ChannelId == chosen) || (chosen == 0 && !ramping[ability_.ChannelId]) {
+					available = append(available, ability_)
+				}
+			}
+			abilities = available
+		}
+	}
+
+	channel := Channel{}
+	probe := false
+	if len(abilities) == 0 {
+		common.SysError(fmt.Sprintf("没有找到可用渠道: group=%s, model=%s", group, actualModel))
+		return nil, false, errors.New("no channels available")
+	}
+	for len(abilities) > 0 {
 		// Randomly choose one
 		weightSum := uint(0)
 		for _, ability_ := range abilities {
@@ -145,33 +273,67 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		}
 		// Randomly choose one
 		weight := common.GetRandomInt(int(weightSum))
-		for _, ability_ := range abilities {
+		chosen := len(abilities) - 1
+		for i, ability_ := range abilities {
 			weight -= int(ability_.Weight) + 10
 			//log.Printf("weight: %d, ability weight: %d", weight, *ability_.Weight)
 			if weight <= 0 {
-				channel.Id = ability_.ChannelId
+				chosen = i
 				break
 			}
 		}
-	} else {
-		common.SysError(fmt.Sprintf("没有找到可用渠道: group=%s, model=%s", group, actualModel))
-		return nil, errors.New("no channels available")
+		if ok, acquired := AcquireChannelBreaker(abilities[chosen].ChannelId, model); ok {
+			channel.Id = abilities[chosen].ChannelId
+			probe = acquired
+			break
+		}
+		// 半开状态的探测名额已满，从候选中移除后重新选择
+		abilities = append(abilities[:chosen:chosen], abilities[chosen+1:]...)
+	}
+	if channel.Id == 0 {
+		return nil, false, errors.New("channel not found")
 	}
 
 	dbErr = DB.First(&channel, "id = ?", channel.Id).Error
 	if dbErr != nil {
 		common.SysError(fmt.Sprintf("查询渠道信息失败: channel_id=%d, 错误=%s", channel.Id, dbErr.Error()))
-		return nil, dbErr
+		if probe {
+			ReleaseChannelBreaker(channel.Id, model)
+		}
+		return nil, false, dbErr
 	}
 
-	return &channel, dbErr
+	return &channel, probe, nil
 }
 
-func (channel *Channel) AddAbilities() error {
+// GetRoutingModels 返回渠道可以路由的模型名，有模型前缀的渠道同时支持带前缀的模型名
+func (channel *Channel) GetRoutingModels() []string {
 	models_ := strings.Split(channel.Models, ",")
-	groups_ := strings.Split(channel.Group, ",")
-	abilities := make([]Ability, 0, len(models_))
+	return append(models_, channel.getPrefixedModels(models_)...)
+}
+
+// getPrefixedModels 返回带模型前缀的模型名，与原模型名重复的会被跳过
+func (channel *Channel) getPrefixedModels(models_ []string) []string {
+	prefix := channel.GetModelPrefix()
+	if prefix == "" {
+		return nil
+	}
+	prefixedModels := make([]string, 0, len(models_))
 	for _, model := range models_ {
+		if model == "" || common.StringsContains(models_, prefix+model) || common.StringsContains(prefixedModels, prefix+model) {
+			continue
+		}
+		prefixedModels = append(prefixedModels, prefix+model)
+	}
+	return prefixedModels
+}
+
+func (channel *Channel) getAbilities() []Ability {
+	models_ := strings.Split(channel.Models, ",")
+	prefixedModels := channel.getPrefixedModels(models_)
+	groups_ := strings.Split(channel.Group, ",")
+	abilities := make([]Ability, 0, (len(models_)+len(prefixedModels))*len(groups_))
+	for i, model := range append(models_, prefixedModels...) {
 		for _, group := range groups_ {
 			ability := Ability{
 				Group:     group,
@@ -182,9 +344,17 @@ func (channel *Channel) AddAbilities() error {
 				Weight:    uint(channel.GetWeight()),
 				Tag:       channel.Tag,
 			}
+			if i >= len(models_) {
+				ability.ModelPrefix = channel.GetModelPrefix()
+			}
 			abilities = append(abilities, ability)
 		}
 	}
+	return abilities
+}
+
+func (channel *Channel) AddAbilities() error {
+	abilities := channel.getAbilities()
 	if len(abilities) == 0 {
 		return nil
 	}
@@ -229,23 +399,7 @@ func (channel *Channel) UpdateAbilities(tx *gorm.DB) error {
 	}
 
 	// Then add new abilities
-	models_ := strings.Split(channel.Models, ",")
-	groups_ := strings.Split(channel.Group, ",")
-	abilities := make([]Ability, 0, len(models_))
-	for _, model := range models_ {
-		for _, group := range groups_ {
-			ability := Ability{
-				Group:     group,
-				Model:     model,
-				ChannelId: channel.Id,
-				Enabled:   channel.Status == common.ChannelStatusEnabled,
-				Priority:  channel.Priority,
-				Weight:    uint(channel.GetWeight()),
-				Tag:       channel.Tag,
-			}
-			abilities = append(abilities, ability)
-		}
-	}
+	abilities := channel.getAbilities()
 
 	if len(abilities) > 0 {
 		for _, chunk := range lo.Chunk(abilities, 50) {
@@ -267,6 +421,21 @@ func (channel *Channel) UpdateAbilities(tx *gorm.DB) error {
 	return nil
 }
 
+// SyncPrefixAbilities 为有模型前缀的渠道补齐带前缀的能力，兼容前缀路由迁移到能力表之前创建的渠道
+func SyncPrefixAbilities() error {
+	var channels []*Channel
+	err := DB.Where("model_prefix IS NOT NULL and model_prefix <> ?", "").Find(&channels).Error
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		if err := channel.UpdateAbilities(nil); err != nil {
+			common.SysError(fmt.Sprintf("Update abilities of channel %d failed: %s", channel.Id, err.Error()))
+		}
+	}
+	return nil
+}
+
 func UpdateAbilityStatus(channelId int, status bool) error {
 	return DB.Model(&Ability{}).Where("channel_id = ?", channelId).Select("enabled").Update("enabled", status).Error
 }
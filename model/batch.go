@@ -0,0 +1,143 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"encoding/json"
+	"veloera/common"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// Batch 由网关执行的批处理任务
+type Batch struct {
+	Id               int             `json:"id" gorm:"primaryKey"`
+	BatchId          string          `json:"batch_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId           int             `json:"user_id" gorm:"index"`
+	TokenId          int             `json:"token_id" gorm:"index"`
+	Endpoint         string          `json:"endpoint" gorm:"type:varchar(64)"`
+	InputFileId      string          `json:"input_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string          `json:"completion_window" gorm:"type:varchar(16)"`
+	Status           string          `json:"status" gorm:"type:varchar(20);index"`
+	OutputFileId     string          `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string          `json:"error_file_id" gorm:"type:varchar(64)"`
+	Errors           json.RawMessage `json:"errors" gorm:"type:json"`
+	Metadata         json.RawMessage `json:"metadata" gorm:"type:json"`
+	TotalCount       int             `json:"total_count"`
+	CompletedCount   int             `json:"completed_count"`
+	FailedCount      int             `json:"failed_count"`
+	CreatedAt        int64           `json:"created_at" gorm:"bigint;index"`
+	InProgressAt     int64           `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt        int64           `json:"expires_at" gorm:"bigint"`
+	FinalizingAt     int64           `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt      int64           `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64           `json:"failed_at" gorm:"bigint"`
+	ExpiredAt        int64           `json:"expired_at" gorm:"bigint"`
+	CancellingAt     int64           `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt      int64           `json:"cancelled_at" gorm:"bigint"`
+	HeartbeatAt      int64           `json:"heartbeat_at" gorm:"bigint"`                 // 执行中的批处理定期更新，用于发现中断的批处理
+	ClientIP         string          `json:"-" gorm:"column:client_ip;type:varchar(64)"` // 提交批处理的客户端 IP，执行请求时沿用，令牌 IP 白名单和路由规则按该 IP 匹配
+}
+
+func (batch *Batch) Insert() error {
+	if batch.CreatedAt == 0 {
+		batch.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(batch).Error
+}
+
+func (batch *Batch) Update() error {
+	return DB.Save(batch).Error
+}
+
+// UpdateProgress 只更新请求计数和心跳，避免覆盖并发写入的状态
+func (batch *Batch) UpdateProgress() error {
+	batch.HeartbeatAt = common.GetTimestamp()
+	return DB.Model(batch).Updates(map[string]any{
+		"total_count":     batch.TotalCount,
+		"completed_count": batch.CompletedCount,
+		"failed_count":    batch.FailedCount,
+		"heartbeat_at":    batch.HeartbeatAt,
+	}).Error
+}
+
+// TransitStatus 仅当状态仍为 from 时更新为 fields，返回是否更新成功
+func (batch *Batch) TransitStatus(from string, fields map[string]any) (bool, error) {
+	result := DB.Model(&Batch{}).Where("id = ? AND status = ?", batch.Id, from).Updates(fields)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	return true, DB.First(batch, batch.Id).Error
+}
+
+// MarkCancelling 将仍在进行中的批处理标记为取消中，返回是否更新成功
+func (batch *Batch) MarkCancelling() (bool, error) {
+	now := common.GetTimestamp()
+	result := DB.Model(&Batch{}).
+		Where("id = ? AND status IN ?", batch.Id, []string{BatchStatusValidating, BatchStatusInProgress}).
+		Updates(map[string]any{"status": BatchStatusCancelling, "cancelling_at": now})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		batch.Status = BatchStatusCancelling
+		batch.CancellingAt = now
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func GetBatchByBatchId(batchId string, userId int) (*Batch, error) {
+	var batch Batch
+	err := DB.Where("batch_id = ? AND user_id = ?", batchId, userId).First(&batch).Error
+	return &batch, err
+}
+
+func GetBatchStatus(id int) (string, error) {
+	var batch Batch
+	err := DB.Select("status").Where("id = ?", id).First(&batch).Error
+	return batch.Status, err
+}
+
+// GetUserBatches 按创建时间倒序分页列出用户的批处理，afterId 为上一页最后一个批处理的 id
+func GetUserBatches(userId int, afterId int, limit int) ([]*Batch, error) {
+	var batches []*Batch
+	query := DB.Where("user_id = ?", userId)
+	if afterId != 0 {
+		query = query.Where("id < ?", afterId)
+	}
+	err := query.Order("id desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// GetUnfinishedBatches 获取尚未结束的批处理，用于重启后恢复
+func GetUnfinishedBatches() ([]*Batch, error) {
+	var batches []*Batch
+	err := DB.Where("status IN ?", []string{BatchStatusValidating, BatchStatusInProgress, BatchStatusFinalizing, BatchStatusCancelling}).Find(&batches).Error
+	return batches, err
+}
@@ -25,10 +25,13 @@ import (
 	"sync"
 	"time"
 	"veloera/common"
+	"veloera/setting/model_setting"
 )
 
 var group2model2channels map[string]map[string][]*Channel
+var group2prefix2channels map[string]map[string][]*Channel
 var channelsIDM map[int]*Channel
+var tag2channels map[string][]*Channel
 var channelSyncLock sync.RWMutex
 
 func InitChannelCache() {
@@ -45,6 +48,7 @@ func InitChannelCache() {
 		groups[ability.Group] = true
 	}
 	newGroup2model2channels := make(map[string]map[string][]*Channel)
+	newGroup2prefix2channels := make(map[string]map[string][]*Channel)
 	newChannelsIDM := make(map[int]*Channel)
 	for group := range groups {
 		newGroup2model2channels[group] = make(map[string][]*Channel)
@@ -53,7 +57,14 @@ func InitChannelCache() {
 		newChannelsIDM[channel.Id] = channel
 		groups := strings.Split(channel.Group, ",")
 		for _, group := range groups {
-			models := strings.Split(channel.Models, ",")
+			if prefix := channel.GetModelPrefix(); prefix != "" {
+				if newGroup2prefix2channels[group] == nil {
+					newGroup2prefix2channels[group] = make(map[string][]*Channel)
+				}
+				newGroup2prefix2channels[group][prefix] = append(newGroup2prefix2channels[group][prefix], channel)
+			}
+			// 有模型前缀的渠道同时按带前缀的模型名索引
+			models := channel.GetRoutingModels()
 			for _, model := range models {
 				if _, ok := newGroup2model2channels[group][model]; !ok {
 					newGroup2model2channels[group][model] = make([]*Channel, 0)
@@ -72,10 +83,29 @@ func InitChannelCache() {
 			newGroup2model2channels[group][model] = channels
 		}
 	}
+	for _, prefix2channels := range newGroup2prefix2channels {
+		for _, channels := range prefix2channels {
+			sort.Slice(channels, func(i, j int) bool {
+				return channels[i].GetPriority() > channels[j].GetPriority()
+			})
+		}
+	}
+
+	// 按标签索引渠道，包括未启用的渠道，影子请求的候选渠道在接入前通常处于禁用状态
+	var disabledTaggedChannels []*Channel
+	DB.Where("status <> ? AND tag <> ''", common.ChannelStatusEnabled).Find(&disabledTaggedChannels)
+	newTag2channels := make(map[string][]*Channel)
+	for _, channel := range append(channels, disabledTaggedChannels...) {
+		if tag := channel.GetTag(); tag != "" {
+			newTag2channels[tag] = append(newTag2channels[tag], channel)
+		}
+	}
 
 	channelSyncLock.Lock()
 	group2model2channels = newGroup2model2channels
+	group2prefix2channels = newGroup2prefix2channels
 	channelsIDM = newChannelsIDM
+	tag2channels = newTag2channels
 	channelSyncLock.Unlock()
 	common.SysLog("channels synced from database")
 }
@@ -88,7 +118,15 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func CacheGetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+func CacheGetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, bool, error) {
+	return CacheGetRandomSatisfiedChannelByTag(group, model, "", retry)
+}
+
+// CacheGetRandomSatisfiedChannelByTag 只从指定标签的渠道中选择，tag 为空时不限制标签。
+// probe 表示选中的渠道处于半开状态并占用了探测名额，需要传给 RecordChannelBreakerResult 或 ReleaseChannelBreaker
+func CacheGetRandomSatisfiedChannelByTag(group string, model string, tag string, retry int) (*Channel, bool, error) {
+	// 熔断按请求的模型名统计
+	breakerModel := model
 	if strings.HasPrefix(model, "gpt-4-gizmo") {
 		model = "gpt-4-gizmo-*"
 	}
@@ -98,13 +136,23 @@ func CacheGetRandomSatisfiedChannel(group string, model string, retry int) (*Cha
 
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannel(group, model, retry)
+		return GetRandomSatisfiedChannel(group, model, tag, retry)
 	}
 	channelSyncLock.RLock()
 	defer channelSyncLock.RUnlock()
-	channels := group2model2channels[group][model]
+	channels := filterEnabledChannels(filterChannelsByTag(group2model2channels[group][model], tag))
+	// 不在可用时间段内的渠道和所有 key 都已被禁用的渠道与禁用的渠道一样不参与选择
+	channels = FilterChannelsByBreaker(FilterChannelsBySchedule(FilterChannelsByKeyStatus(channels)), breakerModel)
+	if len(channels) == 0 {
+		return nil, false, errors.New("channel not found")
+	}
+	channels = FilterChannelsByConcurrency(channels)
+	if len(channels) == 0 {
+		return nil, false, ErrChannelSaturated
+	}
+	channels, lowHeadroom := FilterChannelsByRateLimit(channels)
 	if len(channels) == 0 {
-		return nil, errors.New("channel not found")
+		return nil, false, ErrChannelSaturated
 	}
 
 	uniquePriorities := make(map[int]bool)
@@ -130,25 +178,119 @@ func CacheGetRandomSatisfiedChannel(group string, model string, retry int) (*Cha
 		}
 	}
 
+	// 剩余额度不足的渠道靠后选择
+	targetChannels = PreferChannelsWithHeadroom(targetChannels, lowHeadroom)
+
+	strategy := model_setting.GetChannelSelectionStrategy(group, breakerModel)
+	for len(targetChannels) > 0 {
+		// 放量中的渠道只承担所在优先级的一部分流量
+		channel := PickChannelByStrategy(ApplyChannelCanary(targetChannels), strategy)
+		if ok, probe := AcquireChannelBreaker(channel.Id, breakerModel); ok {
+			return channel, probe, nil
+		}
+		// 半开状态的探测名额已满，从候选中移除后重新选择
+		remaining := make([]*Channel, 0, len(targetChannels)-1)
+		for _, c := range targetChannels {
+			if c.Id != channel.Id {
+				remaining = append(remaining, c)
+			}
+		}
+		targetChannels = remaining
+	}
+	// return null if no channel is not found
+	return nil, false, errors.New("channel not found")
+}
+
+// pickWeightedChannel 按权重随机选择一个渠道
+func pickWeightedChannel(channels []*Channel) *Channel {
 	// 平滑系数
 	smoothingFactor := 10
 	// Calculate the total weight of all channels up to endIdx
 	totalWeight := 0
-	for _, channel := range targetChannels {
+	for _, channel := range channels {
 		totalWeight += channel.GetWeight() + smoothingFactor
 	}
 	// Generate a random value in the range [0, totalWeight)
 	randomWeight := rand.Intn(totalWeight)
 
 	// Find a channel based on its weight
-	for _, channel := range targetChannels {
+	for _, channel := range channels {
 		randomWeight -= channel.GetWeight() + smoothingFactor
 		if randomWeight < 0 {
-			return channel, nil
+			return channel
 		}
 	}
-	// return null if no channel is not found
-	return nil, errors.New("channel not found")
+	return channels[len(channels)-1]
+}
+
+// CacheIsChannelSatisfied 判断渠道是否启用且可用于分组和模型
+func CacheIsChannelSatisfied(group string, model string, channelId int) bool {
+	if !common.MemoryCacheEnabled {
+		return IsChannelSatisfied(group, model, channelId)
+	}
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	for _, channel := range group2model2channels[group][model] {
+		if channel.Id == channelId {
+			return channel.Status == common.ChannelStatusEnabled
+		}
+	}
+	return false
+}
+
+// filterChannelsByTag 过滤出指定标签的渠道，tag 为空时不过滤
+func filterChannelsByTag(channels []*Channel, tag string) []*Channel {
+	if tag == "" {
+		return channels
+	}
+	tagged := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.GetTag() == tag {
+			tagged = append(tagged, channel)
+		}
+	}
+	return tagged
+}
+
+// filterEnabledChannels 过滤掉缓存同步前已被禁用的渠道，使禁用立即生效
+func filterEnabledChannels(channels []*Channel) []*Channel {
+	for i, channel := range channels {
+		if channel.Status == common.ChannelStatusEnabled {
+			continue
+		}
+		enabled := make([]*Channel, 0, len(channels)-1)
+		enabled = append(enabled, channels[:i]...)
+		for _, c := range channels[i+1:] {
+			if c.Status == common.ChannelStatusEnabled {
+				enabled = append(enabled, c)
+			}
+		}
+		return enabled
+	}
+	return channels
+}
+
+// CacheGetPrefixChannels 获取分组下各模型前缀对应的已启用渠道
+func CacheGetPrefixChannels(group string) map[string][]*Channel {
+	if !common.MemoryCacheEnabled {
+		return GetPrefixChannels(group)
+	}
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	prefix2channels := make(map[string][]*Channel, len(group2prefix2channels[group]))
+	for prefix, channels := range group2prefix2channels[group] {
+		if channels = filterEnabledChannels(channels); len(channels) > 0 {
+			prefix2channels[prefix] = channels
+		}
+	}
+	return prefix2channels
+}
+
+// RefreshChannelCache 渠道新增、编辑、启用或删除后立即刷新内存缓存
+func RefreshChannelCache() {
+	if common.MemoryCacheEnabled {
+		InitChannelCache()
+	}
 }
 
 func CacheGetChannel(id int) (*Channel, error) {
@@ -165,6 +307,16 @@ func CacheGetChannel(id int) (*Channel, error) {
 	return c, nil
 }
 
+// CacheGetChannelsByTag 获取指定标签的所有渠道，包括未启用的渠道
+func CacheGetChannelsByTag(tag string) ([]*Channel, error) {
+	if !common.MemoryCacheEnabled {
+		return GetChannelsByTag(tag, false)
+	}
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	return tag2channels[tag], nil
+}
+
 func CacheUpdateChannelStatus(id int, status int) {
 	if !common.MemoryCacheEnabled {
 		return
@@ -197,6 +197,9 @@ func BatchInsertChannels(channels []Channel) error {
 		if err != nil {
 			return err
 		}
+		if channel_.Status == common.ChannelStatusEnabled || channel_.Status == common.ChannelStatusUnknown {
+			StartChannelCanary(&channel_)
+		}
 	}
 	return nil
 }
@@ -216,8 +219,17 @@ func BatchDeleteChannels(ids []int) error {
 		tx.Rollback()
 		return err
 	}
+	err = tx.Where("channel_id in (?)", ids).Delete(&ChannelKey{}).Error
+	if err != nil {
+		// 回滚事务
+		tx.Rollback()
+		return err
+	}
 	// 提交事务
 	tx.Commit()
+	for _, id := range ids {
+		FinishChannelCanary(id)
+	}
 	return err
 }
 
@@ -263,17 +275,35 @@ func (channel *Channel) Insert() error {
 		return err
 	}
 	err = channel.AddAbilities()
-	return err
+	if err != nil {
+		return err
+	}
+	// 未指定状态时使用数据库默认值，即启用
+	if channel.Status == common.ChannelStatusEnabled || channel.Status == common.ChannelStatusUnknown {
+		StartChannelCanary(channel)
+	}
+	return nil
 }
 
 func (channel *Channel) Update() error {
 	var err error
+	// 手动重新启用的渠道需要开始放量
+	reenabled := false
+	if channel.Status == common.ChannelStatusEnabled && channelCanaryEnabled() {
+		var oldStatus int
+		if DB.Model(&Channel{}).Select("status").Where("id = ?", channel.Id).Scan(&oldStatus).Error == nil {
+			reenabled = oldStatus != common.ChannelStatusEnabled
+		}
+	}
 	err = DB.Model(channel).Updates(channel).Error
 	if err != nil {
 		return err
 	}
 	DB.Model(channel).First(channel, "id = ?", channel.Id)
 	err = channel.UpdateAbilities(nil)
+	if err == nil && reenabled {
+		StartChannelCanary(channel)
+	}
 	return err
 }
 
@@ -304,7 +334,11 @@ func (channel *Channel) Delete() error {
 		return err
 	}
 	err = channel.DeleteAbilities()
-	return err
+	if err != nil {
+		return err
+	}
+	FinishChannelCanary(channel.Id)
+	return DeleteChannelKeys(channel.Id)
 }
 
 var channelStatusLock sync.Mutex
@@ -356,17 +390,35 @@ func UpdateChannelStatusById(id int, status int, reason string) bool {
 			common.SysError("failed to update channel status: " + err.Error())
 			return false
 		}
+		if status == common.ChannelStatusEnabled {
+			StartChannelCanary(channel)
+		}
+	}
+	// 启用的渠道不在内存缓存中，需要重新加载
+	if status == common.ChannelStatusEnabled {
+		RefreshChannelCache()
 	}
 	return true
 }
 
 func EnableChannelByTag(tag string) error {
+	// 重新启用的渠道需要开始放量
+	var reenabled []*Channel
+	if channelCanaryEnabled() {
+		DB.Omit("key").Where("tag = ? and status <> ?", tag, common.ChannelStatusEnabled).Find(&reenabled)
+	}
 	err := DB.Model(&Channel{}).Where("tag = ?", tag).Update("status", common.ChannelStatusEnabled).Error
 	if err != nil {
 		return err
 	}
 	err = UpdateAbilityStatusByTag(tag, true)
-	return err
+	if err != nil {
+		return err
+	}
+	for _, channel := range reenabled {
+		StartChannelCanary(channel)
+	}
+	return nil
 }
 
 func DisableChannelByTag(tag string) error {
@@ -518,6 +570,22 @@ func (channel *Channel) GetModelPrefix() string {
 	return *channel.ModelPrefix
 }
 
+// GetPrefixChannels 从数据库获取分组下各模型前缀对应的已启用渠道，按优先级降序排列
+func GetPrefixChannels(group string) map[string][]*Channel {
+	var channels []*Channel
+	DB.Where("status = ? and model_prefix IS NOT NULL and model_prefix <> ?", common.ChannelStatusEnabled, "").
+		Order("priority desc").Find(&channels)
+	prefix2channels := make(map[string][]*Channel)
+	for _, channel := range channels {
+		if !common.StringsContains(strings.Split(channel.Group, ","), group) {
+			continue
+		}
+		prefix := channel.GetModelPrefix()
+		prefix2channels[prefix] = append(prefix2channels[prefix], channel)
+	}
+	return prefix2channels
+}
+
 func (channel *Channel) GetSystemPrompt() string {
 	if channel.SystemPrompt == nil {
 		return ""
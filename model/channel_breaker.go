@@ -0,0 +1,590 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+	"veloera/common"
+	"veloera/setting/model_setting"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 渠道熔断：按 (渠道, 模型) 统计滚动窗口内的请求结果，错误率或超时率超过阈值后熔断，
+// 熔断期间选择渠道时跳过；熔断时间结束后进入半开状态，只放行有限的探测请求，探测成功后恢复。
+// 滚动窗口在每个节点内存中统计，熔断状态在启用 Redis 时由所有节点共享。
+
+const (
+	ChannelBreakerStateClosed   = "closed"
+	ChannelBreakerStateOpen     = "open"
+	ChannelBreakerStateHalfOpen = "half_open"
+)
+
+type ChannelBreakerOutcome int
+
+const (
+	ChannelBreakerSuccess ChannelBreakerOutcome = iota
+	ChannelBreakerFailure
+	ChannelBreakerTimeout
+)
+
+const (
+	channelBreakerBuckets = 10
+	// 半开探测名额的超时时间，防止探测请求异常退出后名额无法释放
+	channelBreakerProbeTTL = 5 * time.Minute
+	// 熔断状态在 Redis 中的最长保存时间
+	channelBreakerStateTTL = 24 * time.Hour
+)
+
+// ChannelBreakerState 熔断状态，关闭状态不保存
+type ChannelBreakerState struct {
+	ChannelId int    `json:"channel_id"`
+	Model     string `json:"model"`
+	State     string `json:"state"`
+	OpenedAt  int64  `json:"opened_at"`
+	// 半开状态结束的时间，即熔断开始时间加上熔断时长
+	HalfOpenAt int64  `json:"half_open_at"`
+	Reason     string `json:"reason"`
+	// 半开状态下已成功的探测次数
+	Successes int `json:"successes"`
+}
+
+func (s *ChannelBreakerState) blocked(now int64) bool {
+	return now < s.HalfOpenAt
+}
+
+type channelBreakerStore interface {
+	get(key string) *ChannelBreakerState
+	getMany(keys []string) map[string]*ChannelBreakerState
+	set(key string, state *ChannelBreakerState)
+	del(key string)
+	list() []*ChannelBreakerState
+	acquireProbe(key string, limit int) bool
+	releaseProbe(key string)
+	// recordProbeSuccess 原子地累加半开状态的探测成功次数，达到 limit 时删除熔断状态并返回 true
+	recordProbeSuccess(key string, limit int, now int64) bool
+}
+
+var (
+	channelBreakerStoreOnce sync.Once
+	channelBreakerStoreImpl channelBreakerStore
+)
+
+func getChannelBreakerStore() channelBreakerStore {
+	channelBreakerStoreOnce.Do(func() {
+		if common.RedisEnabled {
+			channelBreakerStoreImpl = &redisChannelBreakerStore{}
+		} else {
+			channelBreakerStoreImpl = newMemoryChannelBreakerStore()
+		}
+	})
+	return channelBreakerStoreImpl
+}
+
+func channelBreakerKey(channelId int, model string) string {
+	return fmt.Sprintf("%d:%s", channelId, model)
+}
+
+func channelBreakerEnabled() bool {
+	return model_setting.GetGlobalSettings().CircuitBreakerEnabled
+}
+
+// FilterChannelsByBreaker 过滤掉处于熔断状态的渠道，半开状态的渠道保留，选中后需调用 AcquireChannelBreaker
+func FilterChannelsByBreaker(channels []*Channel, model string) []*Channel {
+	if !channelBreakerEnabled() || len(channels) == 0 {
+		return channels
+	}
+	channelIds := make([]int, 0, len(channels))
+	for _, channel := range channels {
+		channelIds = append(channelIds, channel.Id)
+	}
+	blocked := getBreakerBlockedChannelIds(channelIds, model)
+	if len(blocked) == 0 {
+		return channels
+	}
+	filtered := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if !blocked[channel.Id] {
+			filtered = append(filtered, channel)
+		}
+	}
+	return filtered
+}
+
+// getBreakerBlockedChannelIds 返回处于熔断状态的渠道
+func getBreakerBlockedChannelIds(channelIds []int, model string) map[int]bool {
+	blocked := make(map[int]bool)
+	if !channelBreakerEnabled() || len(channelIds) == 0 {
+		return blocked
+	}
+	keys := make([]string, 0, len(channelIds))
+	for _, channelId := range channelIds {
+		keys = append(keys, channelBreakerKey(channelId, model))
+	}
+	now := time.Now().Unix()
+	for _, state := range getChannelBreakerStore().getMany(keys) {
+		if state.blocked(now) {
+			blocked[state.ChannelId] = true
+		}
+	}
+	return blocked
+}
+
+// AcquireChannelBreaker 在选中渠道后调用，渠道处于半开状态时占用一个探测名额，名额已满时 ok 返回 false。
+// probe 表示是否占用了探测名额，调用方需要保存下来，记录结果或释放名额时传回
+func AcquireChannelBreaker(channelId int, model string) (ok bool, probe bool) {
+	if !channelBreakerEnabled() {
+		return true, false
+	}
+	key := channelBreakerKey(channelId, model)
+	store := getChannelBreakerStore()
+	state := store.get(key)
+	if state == nil {
+		return true, false
+	}
+	if state.blocked(time.Now().Unix()) {
+		return false, false
+	}
+	if !store.acquireProbe(key, common.Max(model_setting.GetGlobalSettings().CircuitBreakerHalfOpenProbes, 1)) {
+		return false, false
+	}
+	return true, true
+}
+
+// ReleaseChannelBreaker 释放选中渠道时占用的探测名额，只能由占用了名额的请求调用，用于结果不计入统计的请求
+func ReleaseChannelBreaker(channelId int, model string) {
+	if !channelBreakerEnabled() {
+		return
+	}
+	key := channelBreakerKey(channelId, model)
+	store := getChannelBreakerStore()
+	if state := store.get(key); state != nil && !state.blocked(time.Now().Unix()) {
+		store.releaseProbe(key)
+	}
+}
+
+// RecordChannelBreakerResult 记录一次请求结果，根据结果打开或关闭熔断。
+// probe 表示请求在选择渠道时是否占用了半开探测名额，只有探测请求的结果决定半开状态的渠道是否恢复
+func RecordChannelBreakerResult(channelId int, model string, outcome ChannelBreakerOutcome, probe bool) {
+	if !channelBreakerEnabled() {
+		return
+	}
+	settings := model_setting.GetGlobalSettings()
+	key := channelBreakerKey(channelId, model)
+	store := getChannelBreakerStore()
+	now := time.Now().Unix()
+
+	if state := store.get(key); state != nil {
+		if state.blocked(now) || !probe {
+			// 熔断前发出的请求，结果不再计入
+			return
+		}
+		// 半开状态下的探测结果
+		store.releaseProbe(key)
+		if outcome != ChannelBreakerSuccess {
+			openChannelBreaker(channelId, model, "half-open probe failed")
+			return
+		}
+		if store.recordProbeSuccess(key, common.Max(settings.CircuitBreakerHalfOpenProbes, 1), now) {
+			resetChannelBreakerWindow(key)
+			common.SysLog(fmt.Sprintf("channel #%d model %s circuit breaker closed", channelId, model))
+		}
+		return
+	}
+
+	total, failures, timeouts := addChannelBreakerWindow(key, outcome, now, settings.CircuitBreakerWindowSeconds)
+	if total < common.Max(settings.CircuitBreakerMinRequests, 1) {
+		return
+	}
+	errorRate := float64(failures+timeouts) / float64(total)
+	timeoutRate := float64(timeouts) / float64(total)
+	if settings.CircuitBreakerErrorRate > 0 && errorRate >= settings.CircuitBreakerErrorRate {
+		openChannelBreaker(channelId, model, fmt.Sprintf("error rate %.0f%% (%d/%d)", errorRate*100, failures+timeouts, total))
+	} else if settings.CircuitBreakerTimeoutRate > 0 && timeoutRate >= settings.CircuitBreakerTimeoutRate {
+		openChannelBreaker(channelId, model, fmt.Sprintf("timeout rate %.0f%% (%d/%d)", timeoutRate*100, timeouts, total))
+	}
+}
+
+func openChannelBreaker(channelId int, model string, reason string) {
+	now := time.Now().Unix()
+	key := channelBreakerKey(channelId, model)
+	getChannelBreakerStore().set(key, &ChannelBreakerState{
+		ChannelId:  channelId,
+		Model:      model,
+		State:      ChannelBreakerStateOpen,
+		OpenedAt:   now,
+		HalfOpenAt: now + int64(model_setting.GetGlobalSettings().CircuitBreakerOpenSeconds),
+		Reason:     reason,
+	})
+	resetChannelBreakerWindow(key)
+	common.SysLog(fmt.Sprintf("channel #%d model %s circuit breaker opened: %s", channelId, model, reason))
+}
+
+// GetChannelBreakerStates 返回处于熔断或半开状态的 (渠道, 模型)，channelId 为 0 时返回全部
+func GetChannelBreakerStates(channelId int) []*ChannelBreakerState {
+	now := time.Now().Unix()
+	states := make([]*ChannelBreakerState, 0)
+	for _, state := range getChannelBreakerStore().list() {
+		if channelId != 0 && state.ChannelId != channelId {
+			continue
+		}
+		if !state.blocked(now) {
+			state.State = ChannelBreakerStateHalfOpen
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].ChannelId != states[j].ChannelId {
+			return states[i].ChannelId < states[j].ChannelId
+		}
+		return states[i].Model < states[j].Model
+	})
+	return states
+}
+
+// ResetChannelBreaker 手动关闭渠道的熔断，model 为空时关闭该渠道所有模型的熔断
+func ResetChannelBreaker(channelId int, model string) {
+	store := getChannelBreakerStore()
+	for _, state := range store.list() {
+		if state.ChannelId != channelId || (model != "" && state.Model != model) {
+			continue
+		}
+		key := channelBreakerKey(state.ChannelId, state.Model)
+		store.del(key)
+		resetChannelBreakerWindow(key)
+	}
+}
+
+// 滚动窗口
+
+type channelBreakerBucket struct {
+	start    int64
+	total    int
+	failures int
+	timeouts int
+}
+
+type channelBreakerWindow struct {
+	buckets [channelBreakerBuckets]channelBreakerBucket
+}
+
+var (
+	channelBreakerWindows     = make(map[string]*channelBreakerWindow)
+	channelBreakerWindowsLock sync.Mutex
+)
+
+func addChannelBreakerWindow(key string, outcome ChannelBreakerOutcome, now int64, windowSeconds int) (total int, failures int, timeouts int) {
+	bucketSize := int64(common.Max(windowSeconds/channelBreakerBuckets, 1))
+	bucketStart := now / bucketSize * bucketSize
+
+	channelBreakerWindowsLock.Lock()
+	defer channelBreakerWindowsLock.Unlock()
+	window, ok := channelBreakerWindows[key]
+	if !ok {
+		window = &channelBreakerWindow{}
+		channelBreakerWindows[key] = window
+	}
+	bucket := &window.buckets[(now/bucketSize)%channelBreakerBuckets]
+	if bucket.start != bucketStart {
+		*bucket = channelBreakerBucket{start: bucketStart}
+	}
+	bucket.total++
+	switch outcome {
+	case ChannelBreakerFailure:
+		bucket.failures++
+	case ChannelBreakerTimeout:
+		bucket.timeouts++
+	}
+
+	windowStart := bucketStart - bucketSize*(channelBreakerBuckets-1)
+	for _, b := range window.buckets {
+		if b.start >= windowStart {
+			total += b.total
+			failures += b.failures
+			timeouts += b.timeouts
+		}
+	}
+	return
+}
+
+func resetChannelBreakerWindow(key string) {
+	channelBreakerWindowsLock.Lock()
+	defer channelBreakerWindowsLock.Unlock()
+	delete(channelBreakerWindows, key)
+}
+
+// 内存存储
+
+type memoryChannelBreakerStore struct {
+	lock   sync.Mutex
+	states map[string]ChannelBreakerState
+	probes map[string]memoryChannelBreakerProbe
+}
+
+// memoryChannelBreakerProbe 与 Redis 一样在第一个名额被占用时开始计时，超时后名额全部释放
+type memoryChannelBreakerProbe struct {
+	count     int
+	expiresAt time.Time
+}
+
+func newMemoryChannelBreakerStore() *memoryChannelBreakerStore {
+	return &memoryChannelBreakerStore{
+		states: make(map[string]ChannelBreakerState),
+		probes: make(map[string]memoryChannelBreakerProbe),
+	}
+}
+
+func (m *memoryChannelBreakerStore) get(key string) *ChannelBreakerState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	state, ok := m.states[key]
+	if !ok {
+		return nil
+	}
+	return &state
+}
+
+func (m *memoryChannelBreakerStore) getMany(keys []string) map[string]*ChannelBreakerState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	result := make(map[string]*ChannelBreakerState)
+	for _, key := range keys {
+		if state, ok := m.states[key]; ok {
+			result[key] = &state
+		}
+	}
+	return result
+}
+
+func (m *memoryChannelBreakerStore) set(key string, state *ChannelBreakerState) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.states[key] = *state
+}
+
+func (m *memoryChannelBreakerStore) del(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.states, key)
+	delete(m.probes, key)
+}
+
+func (m *memoryChannelBreakerStore) list() []*ChannelBreakerState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	states := make([]*ChannelBreakerState, 0, len(m.states))
+	for _, state := range m.states {
+		state := state
+		states = append(states, &state)
+	}
+	return states
+}
+
+func (m *memoryChannelBreakerStore) acquireProbe(key string, limit int) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	probe, ok := m.probes[key]
+	if !ok || !now.Before(probe.expiresAt) {
+		probe = memoryChannelBreakerProbe{expiresAt: now.Add(channelBreakerProbeTTL)}
+	}
+	if probe.count >= limit {
+		return false
+	}
+	probe.count++
+	m.probes[key] = probe
+	return true
+}
+
+func (m *memoryChannelBreakerStore) releaseProbe(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	probe, ok := m.probes[key]
+	if !ok {
+		return
+	}
+	if probe.count <= 1 || !time.Now().Before(probe.expiresAt) {
+		delete(m.probes, key)
+		return
+	}
+	probe.count--
+	m.probes[key] = probe
+}
+
+func (m *memoryChannelBreakerStore) recordProbeSuccess(key string, limit int, now int64) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	state, ok := m.states[key]
+	if !ok || state.blocked(now) {
+		return false
+	}
+	state.Successes++
+	if state.Successes >= limit {
+		delete(m.states, key)
+		delete(m.probes, key)
+		return true
+	}
+	m.states[key] = state
+	return false
+}
+
+// Redis 存储
+
+const (
+	channelBreakerRedisPrefix      = "channel_breaker:"
+	channelBreakerProbeRedisPrefix = "channel_breaker_probe:"
+)
+
+type redisChannelBreakerStore struct{}
+
+func (r *redisChannelBreakerStore) get(key string) *ChannelBreakerState {
+	value, err := common.RedisGet(channelBreakerRedisPrefix + key)
+	if err != nil {
+		return nil
+	}
+	return decodeChannelBreakerState(value)
+}
+
+func (r *redisChannelBreakerStore) getMany(keys []string) map[string]*ChannelBreakerState {
+	result := make(map[string]*ChannelBreakerState)
+	redisKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		redisKeys = append(redisKeys, channelBreakerRedisPrefix+key)
+	}
+	values, err := common.RDB.MGet(context.Background(), redisKeys...).Result()
+	if err != nil {
+		common.SysError("failed to get channel breaker states: " + err.Error())
+		return result
+	}
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if state := decodeChannelBreakerState(str); state != nil {
+			result[keys[i]] = state
+		}
+	}
+	return result
+}
+
+func (r *redisChannelBreakerStore) set(key string, state *ChannelBreakerState) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	if err = common.RedisSet(channelBreakerRedisPrefix+key, string(data), channelBreakerStateTTL); err != nil {
+		common.SysError("failed to save channel breaker state: " + err.Error())
+	}
+}
+
+func (r *redisChannelBreakerStore) del(key string) {
+	_ = common.RDB.Del(context.Background(), channelBreakerRedisPrefix+key, channelBreakerProbeRedisPrefix+key).Err()
+}
+
+func (r *redisChannelBreakerStore) list() []*ChannelBreakerState {
+	ctx := context.Background()
+	states := make([]*ChannelBreakerState, 0)
+	iter := common.RDB.Scan(ctx, 0, channelBreakerRedisPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		value, err := common.RDB.Get(ctx, iter.Val()).Result()
+		if err != nil {
+			continue
+		}
+		if state := decodeChannelBreakerState(value); state != nil {
+			states = append(states, state)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		common.SysError("failed to list channel breaker states: " + err.Error())
+	}
+	return states
+}
+
+func (r *redisChannelBreakerStore) acquireProbe(key string, limit int) bool {
+	ctx := context.Background()
+	probeKey := channelBreakerProbeRedisPrefix + key
+	count, err := common.RDB.Incr(ctx, probeKey).Result()
+	if err != nil {
+		return false
+	}
+	if count == 1 {
+		common.RDB.Expire(ctx, probeKey, channelBreakerProbeTTL)
+	}
+	if count > int64(limit) {
+		common.RDB.Decr(ctx, probeKey)
+		return false
+	}
+	return true
+}
+
+// channelBreakerReleaseProbeScript 释放探测名额，计数归零时删除，避免删除其他节点刚占用的名额
+var channelBreakerReleaseProbeScript = redis.NewScript(`
+if redis.call('DECR', KEYS[1]) <= 0 then
+	redis.call('DEL', KEYS[1])
+end
+return 1
+`)
+
+func (r *redisChannelBreakerStore) releaseProbe(key string) {
+	if err := channelBreakerReleaseProbeScript.Run(context.Background(), common.RDB, []string{channelBreakerProbeRedisPrefix + key}).Err(); err != nil {
+		common.SysError("failed to release channel breaker probe: " + err.Error())
+	}
+}
+
+// channelBreakerProbeSuccessScript 在同一个脚本中读取、累加并写回探测成功次数，避免并发探测互相覆盖
+var channelBreakerProbeSuccessScript = redis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if not value then
+	return 0
+end
+local state = cjson.decode(value)
+if (state.half_open_at or 0) > tonumber(ARGV[2]) then
+	return 0
+end
+state.successes = (state.successes or 0) + 1
+if state.successes >= tonumber(ARGV[1]) then
+	redis.call('DEL', KEYS[1], KEYS[2])
+	return 1
+end
+redis.call('SET', KEYS[1], cjson.encode(state), 'EX', ARGV[3])
+return 0
+`)
+
+func (r *redisChannelBreakerStore) recordProbeSuccess(key string, limit int, now int64) bool {
+	closed, err := channelBreakerProbeSuccessScript.Run(context.Background(), common.RDB,
+		[]string{channelBreakerRedisPrefix + key, channelBreakerProbeRedisPrefix + key},
+		limit, now, int(channelBreakerStateTTL.Seconds())).Int()
+	if err != nil {
+		common.SysError("failed to record channel breaker probe: " + err.Error())
+		return false
+	}
+	return closed == 1
+}
+
+func decodeChannelBreakerState(value string) *ChannelBreakerState {
+	state := &ChannelBreakerState{}
+	if err := json.Unmarshal([]byte(value), state); err != nil {
+		return nil
+	}
+	return state
+}
@@ -0,0 +1,423 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/setting/model_setting"
+)
+
+// 渠道灰度放量：新增或重新启用的渠道先只承担所在优先级的一小部分流量，每个阶段结束且错误率
+// 低于阈值时按步长提高比例，直到 100% 后结束放量；阶段内错误率达到阈值时自动回退到初始比例。
+// 放量状态在启用 Redis 时由所有节点共享，渠道额外设置中 canary_ramp 为 false 时不参与放量。
+
+const (
+	// 放量状态在 Redis 中的最长保存时间，每次更新时刷新
+	channelCanaryStateTTL = 7 * 24 * time.Hour
+)
+
+// ChannelCanaryState 渠道的放量状态，放量结束后删除
+type ChannelCanaryState struct {
+	ChannelId      int   `json:"channel_id"`
+	StartedAt      int64 `json:"started_at"`
+	Stage          int   `json:"stage"`
+	StageStartedAt int64 `json:"stage_started_at"`
+	// 当前阶段的请求数和失败数
+	Requests int `json:"requests"`
+	Failures int `json:"failures"`
+	// 已回退的次数及最近一次回退的原因
+	Rollbacks      int    `json:"rollbacks"`
+	LastRollbackAt int64  `json:"last_rollback_at"`
+	Reason         string `json:"reason"`
+	// 当前阶段的流量百分比，只在查询时计算
+	Percent float64 `json:"percent"`
+}
+
+// percent 计算当前阶段占所在优先级流量的百分比
+func (s *ChannelCanaryState) percent(settings *model_setting.CanaryRampSettings) float64 {
+	percent := settings.InitialPercent + float64(s.Stage)*settings.StepPercent
+	// 比例为 0 时渠道收不到请求，放量无法推进
+	if percent < 1 {
+		percent = 1
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+type channelCanaryStore interface {
+	get(channelId int) *ChannelCanaryState
+	getMany(channelIds []int) map[int]*ChannelCanaryState
+	set(state *ChannelCanaryState)
+	del(channelId int)
+	list() []*ChannelCanaryState
+}
+
+var (
+	channelCanaryStoreOnce sync.Once
+	channelCanaryStoreImpl channelCanaryStore
+	// 同一节点内串行更新放量状态，多节点之间的并发更新可能丢失少量计数
+	channelCanaryLock sync.Mutex
+)
+
+func getChannelCanaryStore() channelCanaryStore {
+	channelCanaryStoreOnce.Do(func() {
+		if common.RedisEnabled {
+			channelCanaryStoreImpl = &redisChannelCanaryStore{}
+		} else {
+			channelCanaryStoreImpl = newMemoryChannelCanaryStore()
+		}
+	})
+	return channelCanaryStoreImpl
+}
+
+func channelCanaryEnabled() bool {
+	return model_setting.GetCanaryRampSettings().Enabled
+}
+
+// StartChannelCanary 渠道新增或重新启用时开始放量，已在放量中的渠道重新从初始比例开始
+func StartChannelCanary(channel *Channel) {
+	if !channelCanaryEnabled() || channel == nil || channel.Id == 0 {
+		return
+	}
+	setting := channel.GetSetting()
+	if _, ok := setting[constant.ChannelSettingCanaryRamp]; ok && !getSettingBool(setting, constant.ChannelSettingCanaryRamp) {
+		return
+	}
+	now := time.Now().Unix()
+	channelCanaryLock.Lock()
+	defer channelCanaryLock.Unlock()
+	getChannelCanaryStore().set(&ChannelCanaryState{
+		ChannelId:      channel.Id,
+		StartedAt:      now,
+		StageStartedAt: now,
+	})
+	common.SysLog(fmt.Sprintf("channel #%d canary ramp started at %.0f%%", channel.Id, model_setting.GetCanaryRampSettings().InitialPercent))
+}
+
+// RestartChannelCanary 手动让渠道重新开始放量
+func RestartChannelCanary(channelId int) error {
+	channel, err := GetChannelById(channelId, false)
+	if err != nil {
+		return err
+	}
+	if !channelCanaryEnabled() {
+		return fmt.Errorf("灰度放量未启用")
+	}
+	StartChannelCanary(channel)
+	return nil
+}
+
+// FinishChannelCanary 结束渠道的放量，渠道立即按权重承担全部流量
+func FinishChannelCanary(channelId int) {
+	channelCanaryLock.Lock()
+	defer channelCanaryLock.Unlock()
+	getChannelCanaryStore().del(channelId)
+}
+
+// RecordChannelCanaryResult 记录放量中渠道的一次请求结果，按错误率推进或回退放量
+func RecordChannelCanaryResult(channelId int, failed bool) {
+	if !channelCanaryEnabled() {
+		return
+	}
+	settings := model_setting.GetCanaryRampSettings()
+	store := getChannelCanaryStore()
+	channelCanaryLock.Lock()
+	defer channelCanaryLock.Unlock()
+	state := store.get(channelId)
+	if state == nil {
+		return
+	}
+	state.Requests++
+	if failed {
+		state.Failures++
+	}
+	now := time.Now().Unix()
+	if state.Requests >= common.Max(settings.MinSamples, 1) {
+		errorRate := float64(state.Failures) / float64(state.Requests)
+		if settings.ErrorRateThreshold > 0 && errorRate >= settings.ErrorRateThreshold {
+			state.Reason = fmt.Sprintf("error rate %.0f%% (%d/%d) at %.0f%%", errorRate*100, state.Failures, state.Requests, state.percent(settings))
+			state.Stage = 0
+			state.StageStartedAt = now
+			state.Requests = 0
+			state.Failures = 0
+			state.Rollbacks++
+			state.LastRollbackAt = now
+			store.set(state)
+			common.SysLog(fmt.Sprintf("channel #%d canary ramp rolled back: %s", channelId, state.Reason))
+			return
+		}
+		if now-state.StageStartedAt >= int64(settings.StepIntervalSeconds) {
+			state.Stage++
+			state.StageStartedAt = now
+			state.Requests = 0
+			state.Failures = 0
+			if state.percent(settings) >= 100 {
+				store.del(channelId)
+				common.SysLog(fmt.Sprintf("channel #%d canary ramp finished", channelId))
+				return
+			}
+			common.SysLog(fmt.Sprintf("channel #%d canary ramp advanced to %.0f%%", channelId, state.percent(settings)))
+		}
+	}
+	store.set(state)
+}
+
+// getChannelCanaryPercents 返回放量中的渠道当前的流量百分比
+func getChannelCanaryPercents(channelIds []int) map[int]float64 {
+	percents := make(map[int]float64)
+	if !channelCanaryEnabled() || len(channelIds) == 0 {
+		return percents
+	}
+	settings := model_setting.GetCanaryRampSettings()
+	for channelId, state := range getChannelCanaryStore().getMany(channelIds) {
+		percents[channelId] = state.percent(settings)
+	}
+	return percents
+}
+
+// pickCanaryChannelId 在同一优先级的渠道中按放量比例决定本次请求是否交给放量中的渠道。
+// 返回选中的放量渠道（未选中时为 0）和所有放量中的渠道；没有放量中的渠道，
+// 或者全部渠道都在放量中时返回 nil，按原有方式选择。
+func pickCanaryChannelId(channelIds []int) (int, map[int]bool) {
+	if len(channelIds) < 2 {
+		return 0, nil
+	}
+	percents := getChannelCanaryPercents(channelIds)
+	if len(percents) == 0 {
+		return 0, nil
+	}
+	ramping := make(map[int]bool, len(percents))
+	stable := false
+	for _, channelId := range channelIds {
+		if _, ok := percents[channelId]; ok {
+			ramping[channelId] = true
+		} else {
+			stable = true
+		}
+	}
+	if !stable {
+		return 0, nil
+	}
+	rampingIds := make([]int, 0, len(ramping))
+	for channelId := range ramping {
+		rampingIds = append(rampingIds, channelId)
+	}
+	sort.Ints(rampingIds)
+	random := rand.Float64() * 100
+	for _, channelId := range rampingIds {
+		random -= percents[channelId]
+		if random < 0 {
+			return channelId, ramping
+		}
+	}
+	return 0, ramping
+}
+
+// ApplyChannelCanary 按放量比例缩小同一优先级的候选渠道：选中放量中的渠道时只返回该渠道，
+// 否则去掉所有放量中的渠道，剩余渠道再按选择策略和权重选择
+func ApplyChannelCanary(channels []*Channel) []*Channel {
+	if !channelCanaryEnabled() || len(channels) < 2 {
+		return channels
+	}
+	channelIds := make([]int, 0, len(channels))
+	for _, channel := range channels {
+		channelIds = append(channelIds, channel.Id)
+	}
+	chosen, ramping := pickCanaryChannelId(channelIds)
+	if ramping == nil {
+		return channels
+	}
+	filtered := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if chosen != 0 {
+			if channel.Id == chosen {
+				return []*Channel{channel}
+			}
+		} else if !ramping[channel.Id] {
+			filtered = append(filtered, channel)
+		}
+	}
+	return filtered
+}
+
+// GetChannelCanaryStates 返回放量中的渠道，channelId 为 0 时返回全部
+func GetChannelCanaryStates(channelId int) []*ChannelCanaryState {
+	settings := model_setting.GetCanaryRampSettings()
+	states := make([]*ChannelCanaryState, 0)
+	for _, state := range getChannelCanaryStore().list() {
+		if channelId != 0 && state.ChannelId != channelId {
+			continue
+		}
+		state.Percent = state.percent(settings)
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].ChannelId < states[j].ChannelId
+	})
+	return states
+}
+
+// 内存存储
+
+type memoryChannelCanaryStore struct {
+	lock   sync.Mutex
+	states map[int]ChannelCanaryState
+}
+
+func newMemoryChannelCanaryStore() *memoryChannelCanaryStore {
+	return &memoryChannelCanaryStore{
+		states: make(map[int]ChannelCanaryState),
+	}
+}
+
+func (m *memoryChannelCanaryStore) get(channelId int) *ChannelCanaryState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	state, ok := m.states[channelId]
+	if !ok {
+		return nil
+	}
+	return &state
+}
+
+func (m *memoryChannelCanaryStore) getMany(channelIds []int) map[int]*ChannelCanaryState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	result := make(map[int]*ChannelCanaryState)
+	for _, channelId := range channelIds {
+		if state, ok := m.states[channelId]; ok {
+			result[channelId] = &state
+		}
+	}
+	return result
+}
+
+func (m *memoryChannelCanaryStore) set(state *ChannelCanaryState) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.states[state.ChannelId] = *state
+}
+
+func (m *memoryChannelCanaryStore) del(channelId int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.states, channelId)
+}
+
+func (m *memoryChannelCanaryStore) list() []*ChannelCanaryState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	states := make([]*ChannelCanaryState, 0, len(m.states))
+	for _, state := range m.states {
+		state := state
+		states = append(states, &state)
+	}
+	return states
+}
+
+// Redis 存储
+
+const channelCanaryRedisPrefix = "channel_canary:"
+
+type redisChannelCanaryStore struct{}
+
+func channelCanaryRedisKey(channelId int) string {
+	return channelCanaryRedisPrefix + strconv.Itoa(channelId)
+}
+
+func (r *redisChannelCanaryStore) get(channelId int) *ChannelCanaryState {
+	value, err := common.RedisGet(channelCanaryRedisKey(channelId))
+	if err != nil {
+		return nil
+	}
+	return decodeChannelCanaryState(value)
+}
+
+func (r *redisChannelCanaryStore) getMany(channelIds []int) map[int]*ChannelCanaryState {
+	result := make(map[int]*ChannelCanaryState)
+	redisKeys := make([]string, 0, len(channelIds))
+	for _, channelId := range channelIds {
+		redisKeys = append(redisKeys, channelCanaryRedisKey(channelId))
+	}
+	values, err := common.RDB.MGet(context.Background(), redisKeys...).Result()
+	if err != nil {
+		common.SysError("failed to get channel canary states: " + err.Error())
+		return result
+	}
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if state := decodeChannelCanaryState(str); state != nil {
+			result[channelIds[i]] = state
+		}
+	}
+	return result
+}
+
+func (r *redisChannelCanaryStore) set(state *ChannelCanaryState) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	if err = common.RedisSet(channelCanaryRedisKey(state.ChannelId), string(data), channelCanaryStateTTL); err != nil {
+		common.SysError("failed to save channel canary state: " + err.Error())
+	}
+}
+
+func (r *redisChannelCanaryStore) del(channelId int) {
+	_ = common.RDB.Del(context.Background(), channelCanaryRedisKey(channelId)).Err()
+}
+
+func (r *redisChannelCanaryStore) list() []*ChannelCanaryState {
+	ctx := context.Background()
+	states := make([]*ChannelCanaryState, 0)
+	iter := common.RDB.Scan(ctx, 0, channelCanaryRedisPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		value, err := common.RDB.Get(ctx, iter.Val()).Result()
+		if err != nil {
+			continue
+		}
+		if state := decodeChannelCanaryState(value); state != nil {
+			states = append(states, state)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		common.SysError("failed to list channel canary states: " + err.Error())
+	}
+	return states
+}
+
+func decodeChannelCanaryState(value string) *ChannelCanaryState {
+	state := &ChannelCanaryState{}
+	if err := json.Unmarshal([]byte(value), state); err != nil {
+		return nil
+	}
+	return state
+}
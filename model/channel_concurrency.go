@@ -0,0 +1,399 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"veloera/common"
+	"veloera/constant"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 渠道并发限制：渠道设置中的 max_concurrency 限制渠道同时处理的请求数，key_max_concurrency 限制多 key 渠道中每个 key 同时处理的请求数。
+// 选择渠道和 key 时跳过并发已满的渠道和 key，请求开始前再占用名额。
+// 进行中的请求数在启用 Redis 时由所有节点共享，否则只在当前节点内存中统计。
+
+// ErrChannelSaturated 分组和模型下有可用渠道，但并发都已满
+var ErrChannelSaturated = errors.New("all channels are saturated")
+
+// 并发计数在 Redis 中的超时时间，防止节点异常退出后名额无法释放
+const channelConcurrencyTTL = 10 * time.Minute
+
+// ChannelConcurrencyLimits 渠道和单个 key 的并发上限，0 表示不限制
+type ChannelConcurrencyLimits struct {
+	Channel int `json:"channel"`
+	Key     int `json:"key"`
+}
+
+func (l ChannelConcurrencyLimits) enabled() bool {
+	return l.Channel > 0 || l.Key > 0
+}
+
+// singleKey 单 key 渠道的 key 并发上限同时也是渠道的并发上限
+func (l ChannelConcurrencyLimits) singleKey() ChannelConcurrencyLimits {
+	if l.Key > 0 && (l.Channel <= 0 || l.Key < l.Channel) {
+		return ChannelConcurrencyLimits{Channel: l.Key}
+	}
+	return ChannelConcurrencyLimits{Channel: l.Channel}
+}
+
+// ParseChannelConcurrencyLimits 从渠道设置中读取并发上限
+func ParseChannelConcurrencyLimits(setting map[string]interface{}) ChannelConcurrencyLimits {
+	return ChannelConcurrencyLimits{
+		Channel: getSettingInt(setting, constant.ChannelSettingMaxConcurrency),
+		Key:     getSettingInt(setting, constant.ChannelSettingKeyMaxConcurrency),
+	}
+}
+
+func getSettingInt(setting map[string]interface{}, key string) int {
+	switch value := setting[key].(type) {
+	case float64:
+		return int(value)
+	case int:
+		return value
+	case string:
+		n, _ := strconv.Atoi(strings.TrimSpace(value))
+		return n
+	}
+	return 0
+}
+
+// GetConcurrencyLimits 获取渠道的并发上限
+func (channel *Channel) GetConcurrencyLimits() ChannelConcurrencyLimits {
+	// 大多数渠道没有设置并发上限，避免每次选择渠道时都解析设置
+	if channel.Setting == nil || !strings.Contains(*channel.Setting, "max_concurrency") {
+		return ChannelConcurrencyLimits{}
+	}
+	return ParseChannelConcurrencyLimits(channel.GetSetting())
+}
+
+// isMultiKey 与选择 key 时的规则一致，渠道类型 41 的 key 不按逗号拆分
+func (channel *Channel) isMultiKey() bool {
+	return channel.Type != common.ChannelTypeVertexAi && strings.Contains(channel.Key, ",")
+}
+
+type channelConcurrencyStore interface {
+	getMany(keys []string) map[string]int64
+	acquire(key string, limit int) bool
+	release(key string)
+}
+
+var (
+	channelConcurrencyStoreOnce sync.Once
+	channelConcurrencyStoreImpl channelConcurrencyStore
+)
+
+func getChannelConcurrencyStore() channelConcurrencyStore {
+	channelConcurrencyStoreOnce.Do(func() {
+		if common.RedisEnabled {
+			channelConcurrencyStoreImpl = &redisChannelConcurrencyStore{}
+		} else {
+			channelConcurrencyStoreImpl = newMemoryChannelConcurrencyStore()
+		}
+	})
+	return channelConcurrencyStoreImpl
+}
+
+func channelConcurrencyKey(channelId int) string {
+	return strconv.Itoa(channelId)
+}
+
+func channelKeyConcurrencyKey(channelId int, keyHash string) string {
+	return fmt.Sprintf("%d:%s", channelId, keyHash)
+}
+
+// AcquireChannelConcurrency 在请求开始前占用渠道和 key 的并发名额，名额已满时返回 false，成功时返回释放名额的函数
+func AcquireChannelConcurrency(channelId int, keyHash string, limits ChannelConcurrencyLimits) (func(), bool) {
+	if keyHash == "" {
+		limits = limits.singleKey()
+	}
+	if !limits.enabled() {
+		return func() {}, true
+	}
+	store := getChannelConcurrencyStore()
+	acquired := make([]string, 0, 2)
+	release := func() {
+		for _, key := range acquired {
+			store.release(key)
+		}
+	}
+	if limits.Channel > 0 {
+		key := channelConcurrencyKey(channelId)
+		if !store.acquire(key, limits.Channel) {
+			return nil, false
+		}
+		acquired = append(acquired, key)
+	}
+	if limits.Key > 0 {
+		key := channelKeyConcurrencyKey(channelId, keyHash)
+		if !store.acquire(key, limits.Key) {
+			release()
+			return nil, false
+		}
+		acquired = append(acquired, key)
+	}
+	var once sync.Once
+	return func() {
+		once.Do(release)
+	}, true
+}
+
+// GetSaturatedChannelKeys 返回多 key 渠道中并发已满的 key
+func GetSaturatedChannelKeys(channelId int, keyHashes []string, limit int) map[string]bool {
+	saturated := make(map[string]bool)
+	if limit <= 0 || len(keyHashes) == 0 {
+		return saturated
+	}
+	keys := make([]string, 0, len(keyHashes))
+	for _, keyHash := range keyHashes {
+		keys = append(keys, channelKeyConcurrencyKey(channelId, keyHash))
+	}
+	counts := getChannelConcurrencyStore().getMany(keys)
+	for i, keyHash := range keyHashes {
+		if counts[keys[i]] >= int64(limit) {
+			saturated[keyHash] = true
+		}
+	}
+	return saturated
+}
+
+// FilterChannelsByConcurrency 过滤掉并发已满的渠道，多 key 渠道所有可用 key 的并发都已满时也视为已满
+func FilterChannelsByConcurrency(channels []*Channel) []*Channel {
+	saturated := getSaturatedChannelIds(channels)
+	if len(saturated) == 0 {
+		return channels
+	}
+	filtered := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if !saturated[channel.Id] {
+			filtered = append(filtered, channel)
+		}
+	}
+	return filtered
+}
+
+// getSaturatedChannelIds 返回并发已满的渠道，没有设置并发上限的渠道不会查询计数
+func getSaturatedChannelIds(channels []*Channel) map[int]bool {
+	saturated := make(map[int]bool)
+	limited := make([]*Channel, 0)
+	limitsMap := make(map[int]ChannelConcurrencyLimits)
+	keyHashesMap := make(map[int][]string)
+	keys := make([]string, 0)
+	for _, channel := range channels {
+		limits := channel.GetConcurrencyLimits()
+		multiKey := channel.isMultiKey()
+		if !multiKey {
+			limits = limits.singleKey()
+		}
+		if !limits.enabled() {
+			continue
+		}
+		limited = append(limited, channel)
+		limitsMap[channel.Id] = limits
+		keys = append(keys, channelConcurrencyKey(channel.Id))
+		if multiKey && limits.Key > 0 {
+			keyHashes := make([]string, 0)
+			for _, key := range GetChannelKeyList(channel.Key) {
+				if keyHash := GetChannelKeyHash(key); IsChannelKeyEnabled(channel.Id, keyHash) {
+					keyHashes = append(keyHashes, keyHash)
+					keys = append(keys, channelKeyConcurrencyKey(channel.Id, keyHash))
+				}
+			}
+			keyHashesMap[channel.Id] = keyHashes
+		}
+	}
+	if len(limited) == 0 {
+		return saturated
+	}
+	counts := getChannelConcurrencyStore().getMany(keys)
+	for _, channel := range limited {
+		limits := limitsMap[channel.Id]
+		if limits.Channel > 0 && counts[channelConcurrencyKey(channel.Id)] >= int64(limits.Channel) {
+			saturated[channel.Id] = true
+			continue
+		}
+		keyHashes := keyHashesMap[channel.Id]
+		if limits.Key <= 0 || len(keyHashes) == 0 {
+			continue
+		}
+		allSaturated := true
+		for _, keyHash := range keyHashes {
+			if counts[channelKeyConcurrencyKey(channel.Id, keyHash)] < int64(limits.Key) {
+				allSaturated = false
+				break
+			}
+		}
+		if allSaturated {
+			saturated[channel.Id] = true
+		}
+	}
+	return saturated
+}
+
+// getSaturatedChannelIdsFromDB 未启用内存缓存时从数据库读取设置了并发上限的渠道
+func getSaturatedChannelIdsFromDB(channelIds []int) map[int]bool {
+	if len(channelIds) == 0 {
+		return map[int]bool{}
+	}
+	var channels []*Channel
+	err := DB.Select("id", "type", keyCol, "setting").
+		Where("id IN ? AND setting LIKE ?", channelIds, "%max_concurrency%").
+		Find(&channels).Error
+	if err != nil {
+		common.SysError("failed to load channel concurrency limits: " + err.Error())
+		return map[int]bool{}
+	}
+	return getSaturatedChannelIds(channels)
+}
+
+// getChannelKeyConcurrency 获取多 key 渠道中各 key 进行中的请求数，只有设置了 key 并发上限时才有计数
+func getChannelKeyConcurrency(channel *Channel, keyHashes []string) map[string]int64 {
+	keys := make([]string, 0, len(keyHashes))
+	for _, keyHash := range keyHashes {
+		keys = append(keys, channelKeyConcurrencyKey(channel.Id, keyHash))
+	}
+	counts := getChannelConcurrencyStore().getMany(keys)
+	result := make(map[string]int64, len(keyHashes))
+	for i, keyHash := range keyHashes {
+		result[keyHash] = counts[keys[i]]
+	}
+	return result
+}
+
+// 内存存储
+
+type memoryChannelConcurrencyStore struct {
+	lock   sync.Mutex
+	counts map[string]int64
+}
+
+func newMemoryChannelConcurrencyStore() *memoryChannelConcurrencyStore {
+	return &memoryChannelConcurrencyStore{
+		counts: make(map[string]int64),
+	}
+}
+
+func (m *memoryChannelConcurrencyStore) getMany(keys []string) map[string]int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	result := make(map[string]int64, len(keys))
+	for _, key := range keys {
+		if count, ok := m.counts[key]; ok {
+			result[key] = count
+		}
+	}
+	return result
+}
+
+func (m *memoryChannelConcurrencyStore) acquire(key string, limit int) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.counts[key] >= int64(limit) {
+		return false
+	}
+	m.counts[key]++
+	return true
+}
+
+func (m *memoryChannelConcurrencyStore) release(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.counts[key] > 1 {
+		m.counts[key]--
+	} else {
+		delete(m.counts, key)
+	}
+}
+
+// Redis 存储
+
+const channelConcurrencyRedisPrefix = "channel_concurrency:"
+
+type redisChannelConcurrencyStore struct{}
+
+func (r *redisChannelConcurrencyStore) getMany(keys []string) map[string]int64 {
+	result := make(map[string]int64, len(keys))
+	if len(keys) == 0 {
+		return result
+	}
+	redisKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		redisKeys = append(redisKeys, channelConcurrencyRedisPrefix+key)
+	}
+	values, err := common.RDB.MGet(context.Background(), redisKeys...).Result()
+	if err != nil {
+		common.SysError("failed to get channel concurrency: " + err.Error())
+		return result
+	}
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if count, err := strconv.ParseInt(str, 10, 64); err == nil && count > 0 {
+			result[keys[i]] = count
+		}
+	}
+	return result
+}
+
+// channelConcurrencyAcquireScript 占用名额并刷新超时时间，名额已满时撤回。
+// 每次占用都刷新超时时间，持续有请求时计数不会在名额释放前过期；计数归零时删除，
+// 所有请求都结束后超过超时时间才重新计数，避免异常退出的请求一直占用名额
+var channelConcurrencyAcquireScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+if count > tonumber(ARGV[1]) then
+	if redis.call('DECR', KEYS[1]) <= 0 then
+		redis.call('DEL', KEYS[1])
+	end
+	return 0
+end
+return 1
+`)
+
+func (r *redisChannelConcurrencyStore) acquire(key string, limit int) bool {
+	acquired, err := channelConcurrencyAcquireScript.Run(context.Background(), common.RDB,
+		[]string{channelConcurrencyRedisPrefix + key}, limit, int(channelConcurrencyTTL.Seconds())).Int()
+	if err != nil {
+		// Redis 不可用时不限制并发
+		common.SysError("failed to acquire channel concurrency: " + err.Error())
+		return true
+	}
+	return acquired == 1
+}
+
+// channelConcurrencyReleaseScript 释放名额，计数归零时删除，避免在两次调用之间删除其他节点刚占用的名额
+var channelConcurrencyReleaseScript = redis.NewScript(`
+if redis.call('DECR', KEYS[1]) <= 0 then
+	redis.call('DEL', KEYS[1])
+end
+return 1
+`)
+
+func (r *redisChannelConcurrencyStore) release(key string) {
+	if err := channelConcurrencyReleaseScript.Run(context.Background(), common.RDB, []string{channelConcurrencyRedisPrefix + key}).Err(); err != nil {
+		common.SysError("failed to release channel concurrency: " + err.Error())
+	}
+}
@@ -0,0 +1,395 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"strings"
+	"sync"
+	"time"
+	"veloera/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+)
+
+// ChannelKey 多 key 渠道中单个 key 的状态和统计，key 本身保存在渠道中，这里只按 key 的 md5 关联。
+// 只有状态变更或产生统计后才会创建记录，没有记录的 key 视为启用。
+type ChannelKey struct {
+	Id          int    `json:"id"`
+	ChannelId   int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_key_hash"`
+	KeyHash     string `json:"key_hash" gorm:"type:varchar(32);uniqueIndex:idx_channel_key_hash"`
+	Status      int    `json:"status" gorm:"default:1"`
+	Reason      string `json:"reason" gorm:"type:varchar(255)"`
+	UsedCount   int64  `json:"used_count" gorm:"bigint;default:0"`
+	ErrorCount  int64  `json:"error_count" gorm:"bigint;default:0"`
+	LastError   string `json:"last_error" gorm:"type:text"`
+	LastUsedAt  int64  `json:"last_used_at" gorm:"bigint"`
+	LastErrorAt int64  `json:"last_error_at" gorm:"bigint"`
+	UpdatedAt   int64  `json:"updated_at" gorm:"bigint"`
+}
+
+// ChannelKeyInfo 管理接口返回的 key 信息
+type ChannelKeyInfo struct {
+	ChannelKey
+	Index     int    `json:"index"`
+	MaskedKey string `json:"masked_key"`
+	// 进行中的请求数，只有设置了 key 并发上限时才统计
+	Concurrency int64 `json:"concurrency"`
+	// 根据上游限流响应头记录的剩余额度和冷却状态
+	RateLimit *ChannelRateLimitState `json:"rate_limit,omitempty"`
+}
+
+// GetChannelKeyList 拆分渠道中逗号分隔的多个 key
+func GetChannelKeyList(key string) []string {
+	keys := make([]string, 0)
+	for _, k := range strings.Split(key, ",") {
+		k = strings.TrimSpace(k)
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func GetChannelKeyHash(key string) string {
+	return common.GetMD5Hash(strings.TrimSpace(key))
+}
+
+func maskChannelKey(key string) string {
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:4] + strings.Repeat("*", 4) + key[len(key)-4:]
+}
+
+// 被禁用的 key 缓存，由后台按同步频率从数据库刷新，本节点的修改立即生效
+
+var (
+	disabledChannelKeys     = make(map[int]map[string]bool)
+	disabledChannelKeysLock sync.RWMutex
+)
+
+func InitDisabledChannelKeys() {
+	var keys []*ChannelKey
+	if err := DB.Select("channel_id", "key_hash").Where("status <> ?", common.ChannelStatusEnabled).Find(&keys).Error; err != nil {
+		common.SysError("failed to load disabled channel keys: " + err.Error())
+		return
+	}
+	newDisabledChannelKeys := make(map[int]map[string]bool)
+	for _, key := range keys {
+		if _, ok := newDisabledChannelKeys[key.ChannelId]; !ok {
+			newDisabledChannelKeys[key.ChannelId] = make(map[string]bool)
+		}
+		newDisabledChannelKeys[key.ChannelId][key.KeyHash] = true
+	}
+	disabledChannelKeysLock.Lock()
+	disabledChannelKeys = newDisabledChannelKeys
+	disabledChannelKeysLock.Unlock()
+}
+
+func SyncDisabledChannelKeys(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitDisabledChannelKeys()
+	}
+}
+
+// IsChannelKeyEnabled 判断渠道中的 key 是否可用
+func IsChannelKeyEnabled(channelId int, keyHash string) bool {
+	disabledChannelKeysLock.RLock()
+	defer disabledChannelKeysLock.RUnlock()
+	return !disabledChannelKeys[channelId][keyHash]
+}
+
+func setChannelKeyDisabledCache(channelId int, keyHash string, disabled bool) {
+	disabledChannelKeysLock.Lock()
+	defer disabledChannelKeysLock.Unlock()
+	if disabled {
+		if _, ok := disabledChannelKeys[channelId]; !ok {
+			disabledChannelKeys[channelId] = make(map[string]bool)
+		}
+		disabledChannelKeys[channelId][keyHash] = true
+	} else {
+		delete(disabledChannelKeys[channelId], keyHash)
+	}
+}
+
+// UpdateChannelKeyStatus 修改 key 的状态，返回状态是否发生变化
+func UpdateChannelKeyStatus(channelId int, keyHash string, status int, reason string) (bool, error) {
+	if status == common.ChannelStatusEnabled {
+		reason = ""
+	}
+	changed := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var key ChannelKey
+		if err := tx.Where("channel_id = ? AND key_hash = ?", channelId, keyHash).Limit(1).Find(&key).Error; err != nil {
+			return err
+		}
+		if key.Id == 0 {
+			if status == common.ChannelStatusEnabled {
+				return nil
+			}
+			changed = true
+			return tx.Create(&ChannelKey{
+				ChannelId: channelId,
+				KeyHash:   keyHash,
+				Status:    status,
+				Reason:    reason,
+				UpdatedAt: common.GetTimestamp(),
+			}).Error
+		}
+		if key.Status == status {
+			return nil
+		}
+		changed = true
+		return tx.Model(&ChannelKey{}).Where("id = ?", key.Id).Updates(map[string]interface{}{
+			"status":     status,
+			"reason":     reason,
+			"updated_at": common.GetTimestamp(),
+		}).Error
+	})
+	if err != nil {
+		return false, err
+	}
+	setChannelKeyDisabledCache(channelId, keyHash, status != common.ChannelStatusEnabled)
+	return changed, nil
+}
+
+// GetChannelKeyInfos 列出渠道中所有 key 的状态和统计
+func GetChannelKeyInfos(channel *Channel) ([]*ChannelKeyInfo, error) {
+	var records []*ChannelKey
+	if err := DB.Where("channel_id = ?", channel.Id).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	recordMap := make(map[string]*ChannelKey)
+	for _, record := range records {
+		recordMap[record.KeyHash] = record
+	}
+	keys := GetChannelKeyList(channel.Key)
+	keyHashes := make([]string, 0, len(keys))
+	for _, key := range keys {
+		keyHashes = append(keyHashes, GetChannelKeyHash(key))
+	}
+	concurrency := getChannelKeyConcurrency(channel, keyHashes)
+	infos := make([]*ChannelKeyInfo, 0, len(keys))
+	for i, key := range keys {
+		keyHash := keyHashes[i]
+		info := &ChannelKeyInfo{
+			ChannelKey: ChannelKey{
+				ChannelId: channel.Id,
+				KeyHash:   keyHash,
+				Status:    common.ChannelStatusEnabled,
+			},
+			Index:       i,
+			MaskedKey:   maskChannelKey(key),
+			Concurrency: concurrency[keyHash],
+		}
+		if channel.isMultiKey() {
+			info.RateLimit = GetChannelKeyRateLimitState(channel.Id, keyHash)
+		} else {
+			info.RateLimit = GetChannelKeyRateLimitState(channel.Id, "")
+		}
+		if record, ok := recordMap[keyHash]; ok {
+			info.ChannelKey = *record
+		}
+		// 加上尚未写入数据库的统计
+		pending := getPendingChannelKeyStats(channel.Id, keyHash)
+		info.UsedCount += pending.used
+		info.ErrorCount += pending.errors
+		if pending.lastUsedAt > info.LastUsedAt {
+			info.LastUsedAt = pending.lastUsedAt
+		}
+		if pending.lastErrorAt > info.LastErrorAt {
+			info.LastErrorAt = pending.lastErrorAt
+			info.LastError = pending.lastError
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// CountEnabledChannelKeys 统计渠道中可用的 key 数量
+func CountEnabledChannelKeys(channel *Channel) int {
+	count := 0
+	for _, key := range GetChannelKeyList(channel.Key) {
+		if IsChannelKeyEnabled(channel.Id, GetChannelKeyHash(key)) {
+			count++
+		}
+	}
+	return count
+}
+
+// HasEnabledKey 判断渠道是否还有可用的 key，单 key 渠道总是返回 true
+func (channel *Channel) HasEnabledKey() bool {
+	if !channel.isMultiKey() {
+		return true
+	}
+	disabledChannelKeysLock.RLock()
+	disabledCount := len(disabledChannelKeys[channel.Id])
+	disabledChannelKeysLock.RUnlock()
+	if disabledCount == 0 {
+		return true
+	}
+	return CountEnabledChannelKeys(channel) > 0
+}
+
+// FilterChannelsByKeyStatus 过滤掉所有 key 都已被禁用的多 key 渠道
+func FilterChannelsByKeyStatus(channels []*Channel) []*Channel {
+	filtered := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.HasEnabledKey() {
+			filtered = append(filtered, channel)
+		}
+	}
+	return filtered
+}
+
+// getKeylessChannelIdsFromDB 未启用内存缓存时从数据库读取有 key 被禁用的渠道，返回所有 key 都已被禁用的渠道
+func getKeylessChannelIdsFromDB(channelIds []int) map[int]bool {
+	keyless := map[int]bool{}
+	candidates := make([]int, 0)
+	disabledChannelKeysLock.RLock()
+	for _, channelId := range channelIds {
+		if len(disabledChannelKeys[channelId]) > 0 {
+			candidates = append(candidates, channelId)
+		}
+	}
+	disabledChannelKeysLock.RUnlock()
+	if len(candidates) == 0 {
+		return keyless
+	}
+	var channels []*Channel
+	if err := DB.Select("id", "type", keyCol).Where("id IN ?", candidates).Find(&channels).Error; err != nil {
+		common.SysError("failed to load channel keys: " + err.Error())
+		return keyless
+	}
+	for _, channel := range channels {
+		if !channel.HasEnabledKey() {
+			keyless[channel.Id] = true
+		}
+	}
+	return keyless
+}
+
+// key 的使用和错误统计先在内存中累计，定期写入数据库
+
+type channelKeyStats struct {
+	used        int64
+	errors      int64
+	lastUsedAt  int64
+	lastErrorAt int64
+	lastError   string
+}
+
+type channelKeyRef struct {
+	channelId int
+	keyHash   string
+}
+
+var (
+	pendingChannelKeyStats     = make(map[channelKeyRef]*channelKeyStats)
+	pendingChannelKeyStatsLock sync.Mutex
+)
+
+// RecordChannelKeyUsage 记录 key 的一次使用，errMessage 不为空时记为错误
+func RecordChannelKeyUsage(channelId int, keyHash string, errMessage string) {
+	now := common.GetTimestamp()
+	pendingChannelKeyStatsLock.Lock()
+	defer pendingChannelKeyStatsLock.Unlock()
+	key := channelKeyRef{channelId: channelId, keyHash: keyHash}
+	stats, ok := pendingChannelKeyStats[key]
+	if !ok {
+		stats = &channelKeyStats{}
+		pendingChannelKeyStats[key] = stats
+	}
+	stats.used++
+	stats.lastUsedAt = now
+	if errMessage != "" {
+		stats.errors++
+		stats.lastErrorAt = now
+		stats.lastError = errMessage
+	}
+}
+
+func getPendingChannelKeyStats(channelId int, keyHash string) channelKeyStats {
+	pendingChannelKeyStatsLock.Lock()
+	defer pendingChannelKeyStatsLock.Unlock()
+	if stats, ok := pendingChannelKeyStats[channelKeyRef{channelId: channelId, keyHash: keyHash}]; ok {
+		return *stats
+	}
+	return channelKeyStats{}
+}
+
+// InitChannelKeyStatsUpdater 定期将 key 的统计写入数据库
+func InitChannelKeyStatsUpdater() {
+	gopool.Go(func() {
+		for {
+			time.Sleep(time.Duration(common.Max(common.BatchUpdateInterval, 1)) * time.Second)
+			flushChannelKeyStats()
+		}
+	})
+}
+
+func flushChannelKeyStats() {
+	pendingChannelKeyStatsLock.Lock()
+	store := pendingChannelKeyStats
+	pendingChannelKeyStats = make(map[channelKeyRef]*channelKeyStats)
+	pendingChannelKeyStatsLock.Unlock()
+
+	for key, stats := range store {
+		if err := updateChannelKeyStats(key.channelId, key.keyHash, stats); err != nil {
+			common.SysError("failed to update channel key stats: " + err.Error())
+		}
+	}
+}
+
+func updateChannelKeyStats(channelId int, keyHash string, stats *channelKeyStats) error {
+	updates := map[string]interface{}{
+		"used_count":   gorm.Expr("used_count + ?", stats.used),
+		"error_count":  gorm.Expr("error_count + ?", stats.errors),
+		"last_used_at": stats.lastUsedAt,
+	}
+	if stats.lastErrorAt != 0 {
+		updates["last_error_at"] = stats.lastErrorAt
+		updates["last_error"] = stats.lastError
+	}
+	result := DB.Model(&ChannelKey{}).Where("channel_id = ? AND key_hash = ?", channelId, keyHash).Updates(updates)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	err := DB.Create(&ChannelKey{
+		ChannelId:   channelId,
+		KeyHash:     keyHash,
+		Status:      common.ChannelStatusEnabled,
+		UsedCount:   stats.used,
+		ErrorCount:  stats.errors,
+		LastError:   stats.lastError,
+		LastUsedAt:  stats.lastUsedAt,
+		LastErrorAt: stats.lastErrorAt,
+		UpdatedAt:   common.GetTimestamp(),
+	}).Error
+	if err != nil {
+		// 其他节点可能已创建记录，重新更新一次
+		return DB.Model(&ChannelKey{}).Where("channel_id = ? AND key_hash = ?", channelId, keyHash).Updates(updates).Error
+	}
+	return nil
+}
+
+// DeleteChannelKeys 删除渠道的所有 key 记录
+func DeleteChannelKeys(channelId int) error {
+	return DB.Where("channel_id = ?", channelId).Delete(&ChannelKey{}).Error
+}
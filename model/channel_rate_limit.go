@@ -0,0 +1,463 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+	"veloera/common"
+	"veloera/setting/model_setting"
+)
+
+// 上游限流：根据上游返回的限流响应头记录每个渠道和 key 的剩余额度，
+// 剩余额度不足的渠道和 key 在同优先级中靠后选择，上游返回 429 后在 retry-after 之前不再选择。
+// 状态在启用 Redis 时由所有节点共享。
+
+const (
+	// 上游没有返回额度恢复时间时，剩余额度的有效时间
+	channelRateLimitStateTTL = time.Minute
+)
+
+// ChannelRateLimitHeaders 从上游响应头解析出的限流信息，-1 或 0 表示上游没有返回
+type ChannelRateLimitHeaders struct {
+	RemainingRequests int64
+	LimitRequests     int64
+	RemainingTokens   int64
+	LimitTokens       int64
+	RequestsReset     time.Duration
+	TokensReset       time.Duration
+	RetryAfter        time.Duration
+}
+
+// HasHeadroom 是否包含剩余额度信息
+func (h *ChannelRateLimitHeaders) HasHeadroom() bool {
+	return h.RemainingRequests >= 0 || h.RemainingTokens >= 0
+}
+
+// ChannelRateLimitState 渠道或 key 的剩余额度和冷却状态，时间均为毫秒时间戳
+type ChannelRateLimitState struct {
+	ChannelId         int    `json:"channel_id"`
+	KeyHash           string `json:"key_hash,omitempty"`
+	RemainingRequests int64  `json:"remaining_requests"`
+	LimitRequests     int64  `json:"limit_requests"`
+	RemainingTokens   int64  `json:"remaining_tokens"`
+	LimitTokens       int64  `json:"limit_tokens"`
+	RequestsResetAt   int64  `json:"requests_reset_at"`
+	TokensResetAt     int64  `json:"tokens_reset_at"`
+	CooldownUntil     int64  `json:"cooldown_until"`
+	UpdatedAt         int64  `json:"updated_at"`
+}
+
+func (s *ChannelRateLimitState) coolingDown(now int64) bool {
+	return now < s.CooldownUntil
+}
+
+func (s *ChannelRateLimitState) lowHeadroom(now int64, ratio float64) bool {
+	return lowRateLimitHeadroom(s.RemainingRequests, s.LimitRequests, s.RequestsResetAt, s.UpdatedAt, now, ratio) ||
+		lowRateLimitHeadroom(s.RemainingTokens, s.LimitTokens, s.TokensResetAt, s.UpdatedAt, now, ratio)
+}
+
+func lowRateLimitHeadroom(remaining, limit, resetAt, updatedAt, now int64, ratio float64) bool {
+	if remaining < 0 {
+		return false
+	}
+	// 额度已经恢复
+	if resetAt > 0 && now >= resetAt {
+		return false
+	}
+	if resetAt == 0 && now-updatedAt >= channelRateLimitStateTTL.Milliseconds() {
+		return false
+	}
+	if remaining == 0 {
+		return true
+	}
+	return limit > 0 && float64(remaining) < float64(limit)*ratio
+}
+
+// expireAt 状态不再影响渠道选择的时间
+func (s *ChannelRateLimitState) expireAt() int64 {
+	expireAt := s.UpdatedAt + channelRateLimitStateTTL.Milliseconds()
+	for _, t := range []int64{s.RequestsResetAt, s.TokensResetAt, s.CooldownUntil} {
+		if t > expireAt {
+			expireAt = t
+		}
+	}
+	return expireAt
+}
+
+type channelRateLimitStore interface {
+	get(key string) *ChannelRateLimitState
+	getMany(keys []string) map[string]*ChannelRateLimitState
+	set(key string, state *ChannelRateLimitState)
+}
+
+var (
+	channelRateLimitStoreOnce sync.Once
+	channelRateLimitStoreImpl channelRateLimitStore
+)
+
+func getChannelRateLimitStore() channelRateLimitStore {
+	channelRateLimitStoreOnce.Do(func() {
+		if common.RedisEnabled {
+			channelRateLimitStoreImpl = &redisChannelRateLimitStore{}
+		} else {
+			channelRateLimitStoreImpl = newMemoryChannelRateLimitStore()
+		}
+	})
+	return channelRateLimitStoreImpl
+}
+
+func channelRateLimitKey(channelId int, keyHash string) string {
+	return fmt.Sprintf("%d:%s", channelId, keyHash)
+}
+
+func channelRateLimitEnabled() bool {
+	return model_setting.GetUpstreamRateLimitSettings().Enabled
+}
+
+// UpdateChannelRateLimit 根据上游响应头更新渠道或 key 的剩余额度，tooManyRequests 表示上游返回了 429，返回是否进入冷却
+func UpdateChannelRateLimit(channelId int, keyHash string, headers *ChannelRateLimitHeaders, tooManyRequests bool) bool {
+	if !channelRateLimitEnabled() {
+		return false
+	}
+	var cooldown time.Duration
+	if tooManyRequests {
+		cooldown = headers.RetryAfter
+		// 没有 retry-after 时以耗尽的额度的恢复时间为准
+		if cooldown <= 0 && headers.RemainingRequests == 0 {
+			cooldown = headers.RequestsReset
+		}
+		if cooldown <= 0 && headers.RemainingTokens == 0 {
+			cooldown = headers.TokensReset
+		}
+		// 没有任何限流头的 429 使用默认冷却时间，同样不自动禁用渠道
+		if cooldown <= 0 {
+			cooldown = time.Duration(model_setting.GetUpstreamRateLimitSettings().DefaultCooldownSeconds) * time.Second
+		}
+		if maxCooldown := time.Duration(model_setting.GetUpstreamRateLimitSettings().MaxCooldownSeconds) * time.Second; maxCooldown > 0 && cooldown > maxCooldown {
+			cooldown = maxCooldown
+		}
+	}
+	if cooldown <= 0 && !headers.HasHeadroom() {
+		return false
+	}
+
+	now := time.Now().UnixMilli()
+	key := channelRateLimitKey(channelId, keyHash)
+	store := getChannelRateLimitStore()
+	state := &ChannelRateLimitState{
+		ChannelId:         channelId,
+		KeyHash:           keyHash,
+		RemainingRequests: -1,
+		RemainingTokens:   -1,
+		UpdatedAt:         now,
+	}
+	if old := store.get(key); old != nil {
+		state.CooldownUntil = old.CooldownUntil
+		if !headers.HasHeadroom() {
+			// 429 响应可能不带剩余额度，保留之前的记录
+			state = old
+		}
+	}
+	if headers.HasHeadroom() {
+		state.RemainingRequests = headers.RemainingRequests
+		state.LimitRequests = headers.LimitRequests
+		state.RemainingTokens = headers.RemainingTokens
+		state.LimitTokens = headers.LimitTokens
+		state.RequestsResetAt = 0
+		state.TokensResetAt = 0
+		if headers.RequestsReset > 0 {
+			state.RequestsResetAt = now + headers.RequestsReset.Milliseconds()
+		}
+		if headers.TokensReset > 0 {
+			state.TokensResetAt = now + headers.TokensReset.Milliseconds()
+		}
+		state.UpdatedAt = now
+	}
+	coolingDown := false
+	if cooldown > 0 {
+		state.CooldownUntil = now + cooldown.Milliseconds()
+		coolingDown = true
+		common.SysLog(fmt.Sprintf("channel #%d is rate limited by upstream, cooling down for %s", channelId, cooldown))
+	}
+	store.set(key, state)
+	return coolingDown
+}
+
+// IsChannelRateLimitCoolingDown 判断渠道或 key 是否因为上游 429 处于冷却中
+func IsChannelRateLimitCoolingDown(channelId int, keyHash string) bool {
+	if !channelRateLimitEnabled() {
+		return false
+	}
+	state := getChannelRateLimitStore().get(channelRateLimitKey(channelId, keyHash))
+	return state != nil && state.coolingDown(time.Now().UnixMilli())
+}
+
+// GetChannelKeyRateLimits 返回多 key 渠道中处于冷却中和剩余额度不足的 key
+func GetChannelKeyRateLimits(channelId int, keyHashes []string) (coolingDown map[string]bool, lowHeadroom map[string]bool) {
+	coolingDown = make(map[string]bool)
+	lowHeadroom = make(map[string]bool)
+	if !channelRateLimitEnabled() || len(keyHashes) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(keyHashes))
+	for _, keyHash := range keyHashes {
+		keys = append(keys, channelRateLimitKey(channelId, keyHash))
+	}
+	states := getChannelRateLimitStore().getMany(keys)
+	now := time.Now().UnixMilli()
+	ratio := model_setting.GetUpstreamRateLimitSettings().LowHeadroomRatio
+	for i, keyHash := range keyHashes {
+		state, ok := states[keys[i]]
+		if !ok {
+			continue
+		}
+		if state.coolingDown(now) {
+			coolingDown[keyHash] = true
+		} else if state.lowHeadroom(now, ratio) {
+			lowHeadroom[keyHash] = true
+		}
+	}
+	return
+}
+
+// FilterChannelsByRateLimit 过滤掉处于冷却中的渠道，同时返回剩余额度不足的渠道；
+// 多 key 渠道所有可用 key 都处于冷却中或额度不足时才视为冷却中或额度不足
+func FilterChannelsByRateLimit(channels []*Channel) ([]*Channel, map[int]bool) {
+	coolingDown, lowHeadroom := getRateLimitedChannelIds(channels)
+	if len(coolingDown) == 0 {
+		return channels, lowHeadroom
+	}
+	filtered := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if !coolingDown[channel.Id] {
+			filtered = append(filtered, channel)
+		}
+	}
+	return filtered, lowHeadroom
+}
+
+// PreferChannelsWithHeadroom 同优先级中存在剩余额度充足的渠道时，只从这些渠道中选择
+func PreferChannelsWithHeadroom(channels []*Channel, lowHeadroom map[int]bool) []*Channel {
+	if len(lowHeadroom) == 0 {
+		return channels
+	}
+	preferred := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if !lowHeadroom[channel.Id] {
+			preferred = append(preferred, channel)
+		}
+	}
+	if len(preferred) == 0 {
+		return channels
+	}
+	return preferred
+}
+
+func getRateLimitedChannelIds(channels []*Channel) (map[int]bool, map[int]bool) {
+	coolingDown := make(map[int]bool)
+	lowHeadroom := make(map[int]bool)
+	if !channelRateLimitEnabled() || len(channels) == 0 {
+		return coolingDown, lowHeadroom
+	}
+	keyHashesMap := make(map[int][]string, len(channels))
+	keys := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		keyHashes := []string{""}
+		if channel.isMultiKey() {
+			keyHashes = keyHashes[:0]
+			for _, key := range GetChannelKeyList(channel.Key) {
+				if keyHash := GetChannelKeyHash(key); IsChannelKeyEnabled(channel.Id, keyHash) {
+					keyHashes = append(keyHashes, keyHash)
+				}
+			}
+		}
+		keyHashesMap[channel.Id] = keyHashes
+		for _, keyHash := range keyHashes {
+			keys = append(keys, channelRateLimitKey(channel.Id, keyHash))
+		}
+	}
+	states := getChannelRateLimitStore().getMany(keys)
+	if len(states) == 0 {
+		return coolingDown, lowHeadroom
+	}
+	now := time.Now().UnixMilli()
+	ratio := model_setting.GetUpstreamRateLimitSettings().LowHeadroomRatio
+	for _, channel := range channels {
+		keyHashes := keyHashesMap[channel.Id]
+		if len(keyHashes) == 0 {
+			continue
+		}
+		allCoolingDown, allLow := true, true
+		for _, keyHash := range keyHashes {
+			state := states[channelRateLimitKey(channel.Id, keyHash)]
+			if state == nil {
+				allCoolingDown, allLow = false, false
+				break
+			}
+			if !state.coolingDown(now) {
+				allCoolingDown = false
+				if !state.lowHeadroom(now, ratio) {
+					allLow = false
+				}
+			}
+		}
+		if allCoolingDown {
+			coolingDown[channel.Id] = true
+		} else if allLow {
+			lowHeadroom[channel.Id] = true
+		}
+	}
+	return coolingDown, lowHeadroom
+}
+
+// getRateLimitedChannelIdsFromDB 未启用内存缓存时从数据库读取渠道的 key
+func getRateLimitedChannelIdsFromDB(channelIds []int) (map[int]bool, map[int]bool) {
+	if !channelRateLimitEnabled() || len(channelIds) == 0 {
+		return map[int]bool{}, map[int]bool{}
+	}
+	var channels []*Channel
+	if err := DB.Select("id", "type", keyCol).Where("id IN ?", channelIds).Find(&channels).Error; err != nil {
+		common.SysError("failed to load channels for rate limit: " + err.Error())
+		return map[int]bool{}, map[int]bool{}
+	}
+	return getRateLimitedChannelIds(channels)
+}
+
+// GetChannelKeyRateLimitState 获取渠道或 key 的限流状态，没有记录时返回 nil
+func GetChannelKeyRateLimitState(channelId int, keyHash string) *ChannelRateLimitState {
+	state := getChannelRateLimitStore().get(channelRateLimitKey(channelId, keyHash))
+	if state == nil || time.Now().UnixMilli() >= state.expireAt() {
+		return nil
+	}
+	return state
+}
+
+// 内存存储
+
+type memoryChannelRateLimitStore struct {
+	lock   sync.Mutex
+	states map[string]ChannelRateLimitState
+}
+
+func newMemoryChannelRateLimitStore() *memoryChannelRateLimitStore {
+	return &memoryChannelRateLimitStore{
+		states: make(map[string]ChannelRateLimitState),
+	}
+}
+
+func (m *memoryChannelRateLimitStore) get(key string) *ChannelRateLimitState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.getLocked(key, time.Now().UnixMilli())
+}
+
+func (m *memoryChannelRateLimitStore) getLocked(key string, now int64) *ChannelRateLimitState {
+	state, ok := m.states[key]
+	if !ok {
+		return nil
+	}
+	if now >= state.expireAt() {
+		delete(m.states, key)
+		return nil
+	}
+	return &state
+}
+
+func (m *memoryChannelRateLimitStore) getMany(keys []string) map[string]*ChannelRateLimitState {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now().UnixMilli()
+	result := make(map[string]*ChannelRateLimitState)
+	for _, key := range keys {
+		if state := m.getLocked(key, now); state != nil {
+			result[key] = state
+		}
+	}
+	return result
+}
+
+func (m *memoryChannelRateLimitStore) set(key string, state *ChannelRateLimitState) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.states[key] = *state
+}
+
+// Redis 存储
+
+const channelRateLimitRedisPrefix = "channel_rate_limit:"
+
+type redisChannelRateLimitStore struct{}
+
+func (r *redisChannelRateLimitStore) get(key string) *ChannelRateLimitState {
+	value, err := common.RedisGet(channelRateLimitRedisPrefix + key)
+	if err != nil {
+		return nil
+	}
+	return decodeChannelRateLimitState(value)
+}
+
+func (r *redisChannelRateLimitStore) getMany(keys []string) map[string]*ChannelRateLimitState {
+	result := make(map[string]*ChannelRateLimitState)
+	if len(keys) == 0 {
+		return result
+	}
+	redisKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		redisKeys = append(redisKeys, channelRateLimitRedisPrefix+key)
+	}
+	values, err := common.RDB.MGet(context.Background(), redisKeys...).Result()
+	if err != nil {
+		common.SysError("failed to get channel rate limit states: " + err.Error())
+		return result
+	}
+	for i, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if state := decodeChannelRateLimitState(str); state != nil {
+			result[keys[i]] = state
+		}
+	}
+	return result
+}
+
+func (r *redisChannelRateLimitStore) set(key string, state *ChannelRateLimitState) {
+	ttl := time.Duration(state.expireAt()-time.Now().UnixMilli()) * time.Millisecond
+	if ttl <= 0 {
+		return
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	if err = common.RedisSet(channelRateLimitRedisPrefix+key, string(data), ttl); err != nil {
+		common.SysError("failed to save channel rate limit state: " + err.Error())
+	}
+}
+
+func decodeChannelRateLimitState(value string) *ChannelRateLimitState {
+	state := &ChannelRateLimitState{}
+	if err := json.Unmarshal([]byte(value), state); err != nil {
+		return nil
+	}
+	return state
+}
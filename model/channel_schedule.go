@@ -0,0 +1,396 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata"
+	"veloera/common"
+	"veloera/constant"
+)
+
+// 渠道可用时间段：在渠道额外设置中用类 cron 表达式（分 时 日 月 周）配置可用时间段和禁用时间段，
+// 某一分钟匹配任意可用时间段且不匹配任何禁用时间段时渠道可用。不在可用时间段内的渠道不会被选择，
+// 但不会修改渠道的状态。
+
+// 向后查找下一次可用状态变化的最长时间
+const channelScheduleLookahead = 31 * 24 * time.Hour
+
+// ScheduleWindow 一个类 cron 表达式，每个字段是可以匹配的值的位图
+type ScheduleWindow struct {
+	Expr    string
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+type scheduleField struct {
+	name string
+	min  int
+	max  int
+}
+
+var scheduleFields = []scheduleField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseScheduleWindow 解析类 cron 表达式，支持 *、a-b、*/n、a-b/n 和逗号分隔的列表，周日为 0 或 7
+func ParseScheduleWindow(expr string) (*ScheduleWindow, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(scheduleFields) {
+		return nil, fmt.Errorf("时间段 %q 需要 5 个字段（分 时 日 月 周）", expr)
+	}
+	masks := make([]uint64, len(fields))
+	for i, field := range fields {
+		mask, err := parseScheduleField(field, scheduleFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("时间段 %q: %w", expr, err)
+		}
+		masks[i] = mask
+	}
+	// 周日可以写作 7
+	if masks[4]&(1<<7) != 0 {
+		masks[4] |= 1
+	}
+	return &ScheduleWindow{
+		Expr:    expr,
+		minute:  masks[0],
+		hour:    masks[1],
+		dom:     masks[2],
+		month:   masks[3],
+		dow:     masks[4],
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}, nil
+}
+
+func parseScheduleField(field string, spec scheduleField) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("%s 的步长 %q 不合法", spec.name, part[i+1:])
+			}
+			step = n
+			part = part[:i]
+		}
+		start, end := spec.min, spec.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("%s 的值 %q 不合法", spec.name, bounds[0])
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("%s 的值 %q 不合法", spec.name, bounds[1])
+				}
+			} else if step > 1 {
+				// a/n 表示从 a 开始到最大值
+				end = spec.max
+			}
+		}
+		if start < spec.min || end > spec.max || start > end {
+			return 0, fmt.Errorf("%s 的范围 %d-%d 超出 %d-%d", spec.name, start, end, spec.min, spec.max)
+		}
+		for v := start; v <= end; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// Match 判断时间是否在时间段内，日和周都有限制时满足其一即可，与 cron 一致
+func (w *ScheduleWindow) Match(t time.Time) bool {
+	if w.minute&(1<<uint(t.Minute())) == 0 || w.hour&(1<<uint(t.Hour())) == 0 || w.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	domMatch := w.dom&(1<<uint(t.Day())) != 0
+	dowMatch := w.dow&(1<<uint(t.Weekday())) != 0
+	if w.domStar || w.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// matchHour 判断这一小时内是否可能有匹配的分钟，用于快速跳过
+func (w *ScheduleWindow) matchHour(t time.Time) bool {
+	return w.Match(time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), bits.TrailingZeros64(w.minute), 0, 0, t.Location()))
+}
+
+// ChannelSchedule 渠道的可用时间段配置
+type ChannelSchedule struct {
+	Windows   []*ScheduleWindow
+	Blackouts []*ScheduleWindow
+	Location  *time.Location
+}
+
+// ParseChannelSchedule 解析渠道额外设置中的可用时间段，未配置时返回 nil
+func ParseChannelSchedule(setting map[string]interface{}) (*ChannelSchedule, error) {
+	windows, err := parseScheduleWindows(setting[constant.ChannelSettingSchedule])
+	if err != nil {
+		return nil, err
+	}
+	blackouts, err := parseScheduleWindows(setting[constant.ChannelSettingScheduleBlackout])
+	if err != nil {
+		return nil, err
+	}
+	if len(windows) == 0 && len(blackouts) == 0 {
+		return nil, nil
+	}
+	location := time.Local
+	if name, _ := setting[constant.ChannelSettingScheduleTimezone].(string); strings.TrimSpace(name) != "" {
+		location, err = time.LoadLocation(strings.TrimSpace(name))
+		if err != nil {
+			return nil, fmt.Errorf("时区 %q 不合法", name)
+		}
+	}
+	return &ChannelSchedule{Windows: windows, Blackouts: blackouts, Location: location}, nil
+}
+
+// 时间段可以是单个表达式或表达式列表
+func parseScheduleWindows(value interface{}) ([]*ScheduleWindow, error) {
+	var exprs []string
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		exprs = []string{v}
+	case []interface{}:
+		for _, item := range v {
+			expr, ok := item.(string)
+			if !ok {
+				return nil, errors.New("时间段必须是字符串")
+			}
+			exprs = append(exprs, expr)
+		}
+	default:
+		return nil, errors.New("时间段必须是字符串或字符串列表")
+	}
+	windows := make([]*ScheduleWindow, 0, len(exprs))
+	for _, expr := range exprs {
+		if strings.TrimSpace(expr) == "" {
+			continue
+		}
+		window, err := ParseScheduleWindow(expr)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// IsAvailable 判断渠道在某个时间是否可用
+func (s *ChannelSchedule) IsAvailable(t time.Time) bool {
+	t = t.In(s.Location)
+	for _, window := range s.Blackouts {
+		if window.Match(t) {
+			return false
+		}
+	}
+	if len(s.Windows) == 0 {
+		return true
+	}
+	for _, window := range s.Windows {
+		if window.Match(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayChangeWithinHour 判断这一小时内是否可能有时间段开始或结束
+func (s *ChannelSchedule) mayChangeWithinHour(t time.Time) bool {
+	for _, window := range append(s.Windows, s.Blackouts...) {
+		if window.matchHour(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextTransition 返回可用状态下一次变化的时间，一段时间内不会变化时返回 false
+func (s *ChannelSchedule) NextTransition(now time.Time) (time.Time, bool) {
+	now = now.In(s.Location)
+	current := s.IsAvailable(now)
+	t := now.Truncate(time.Minute).Add(time.Minute)
+	end := now.Add(channelScheduleLookahead)
+	for t.Before(end) {
+		// 这一小时内没有任何时间段匹配时，状态与整点时一致，可以整小时跳过
+		if t.Minute() == 0 && !s.mayChangeWithinHour(t) {
+			if s.IsAvailable(t) != current {
+				return t, true
+			}
+			t = t.Add(time.Hour)
+			continue
+		}
+		if s.IsAvailable(t) != current {
+			return t, true
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}, false
+}
+
+// 解析后的可用时间段按渠道额外设置缓存，设置变化时重新解析
+var channelScheduleCache sync.Map // setting -> *ChannelSchedule
+
+// GetSchedule 获取渠道的可用时间段，未配置或配置不合法时返回 nil
+func (channel *Channel) GetSchedule() *ChannelSchedule {
+	// 大多数渠道没有设置可用时间段，避免每次选择渠道时都解析设置
+	if channel.Setting == nil || !strings.Contains(*channel.Setting, "schedule") {
+		return nil
+	}
+	if schedule, ok := channelScheduleCache.Load(*channel.Setting); ok {
+		return schedule.(*ChannelSchedule)
+	}
+	schedule, err := ParseChannelSchedule(channel.GetSetting())
+	if err != nil {
+		common.SysError(fmt.Sprintf("invalid schedule of channel #%d: %s", channel.Id, err.Error()))
+		schedule = nil
+	}
+	channelScheduleCache.Store(*channel.Setting, schedule)
+	return schedule
+}
+
+// IsInSchedule 判断渠道当前是否在可用时间段内，未配置时间段的渠道始终可用
+func (channel *Channel) IsInSchedule(now time.Time) bool {
+	schedule := channel.GetSchedule()
+	return schedule == nil || schedule.IsAvailable(now)
+}
+
+// ValidateChannelSchedule 校验渠道额外设置中的可用时间段
+func ValidateChannelSchedule(setting *string) error {
+	if setting == nil || !strings.Contains(*setting, "schedule") {
+		return nil
+	}
+	channel := Channel{Setting: setting}
+	_, err := ParseChannelSchedule(channel.GetSetting())
+	return err
+}
+
+// FilterChannelsBySchedule 过滤掉不在可用时间段内的渠道
+func FilterChannelsBySchedule(channels []*Channel) []*Channel {
+	now := time.Now()
+	for i, channel := range channels {
+		if channel.IsInSchedule(now) {
+			continue
+		}
+		available := make([]*Channel, 0, len(channels)-1)
+		available = append(available, channels[:i]...)
+		for _, c := range channels[i+1:] {
+			if c.IsInSchedule(now) {
+				available = append(available, c)
+			}
+		}
+		return available
+	}
+	return channels
+}
+
+// getUnscheduledChannelIdsFromDB 获取不在可用时间段内的渠道
+func getUnscheduledChannelIdsFromDB(channelIds []int) map[int]bool {
+	unscheduled := map[int]bool{}
+	if len(channelIds) == 0 {
+		return unscheduled
+	}
+	var channels []*Channel
+	err := DB.Select("id", "setting").
+		Where("id IN ? AND setting LIKE ?", channelIds, "%schedule%").
+		Find(&channels).Error
+	if err != nil {
+		common.SysError("failed to load channel schedules: " + err.Error())
+		return unscheduled
+	}
+	now := time.Now()
+	for _, channel := range channels {
+		if !channel.IsInSchedule(now) {
+			unscheduled[channel.Id] = true
+		}
+	}
+	return unscheduled
+}
+
+// ChannelScheduleState 渠道当前的可用状态和下一次变化的时间
+type ChannelScheduleState struct {
+	ChannelId      int      `json:"channel_id"`
+	Name           string   `json:"name"`
+	Status         int      `json:"status"`
+	Timezone       string   `json:"timezone"`
+	Windows        []string `json:"windows"`
+	Blackouts      []string `json:"blackouts"`
+	Available      bool     `json:"available"`
+	NextTransition int64    `json:"next_transition"` // 秒级时间戳，0 表示一段时间内不会变化
+}
+
+// GetChannelScheduleStates 获取配置了可用时间段的渠道的状态，channelId 为 0 时返回所有渠道
+func GetChannelScheduleStates(channelId int) ([]*ChannelScheduleState, error) {
+	var channels []*Channel
+	query := DB.Select("id", "name", "status", "setting").Where("setting LIKE ?", "%schedule%")
+	if channelId != 0 {
+		query = query.Where("id = ?", channelId)
+	}
+	if err := query.Order("id").Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	states := make([]*ChannelScheduleState, 0, len(channels))
+	for _, channel := range channels {
+		schedule := channel.GetSchedule()
+		if schedule == nil {
+			continue
+		}
+		state := &ChannelScheduleState{
+			ChannelId: channel.Id,
+			Name:      channel.Name,
+			Status:    channel.Status,
+			Timezone:  schedule.Location.String(),
+			Windows:   make([]string, 0, len(schedule.Windows)),
+			Blackouts: make([]string, 0, len(schedule.Blackouts)),
+			Available: schedule.IsAvailable(now),
+		}
+		for _, window := range schedule.Windows {
+			state.Windows = append(state.Windows, window.Expr)
+		}
+		for _, window := range schedule.Blackouts {
+			state.Blackouts = append(state.Blackouts, window.Expr)
+		}
+		if next, ok := schedule.NextTransition(now); ok {
+			state.NextTransition = next.Unix()
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
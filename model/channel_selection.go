@@ -0,0 +1,249 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+	"veloera/setting/model_setting"
+)
+
+// 渠道运行统计，用于按延迟、首字时间或进行中请求数选择渠道。
+// 统计只保存在当前节点内存中，多节点部署时各节点独立统计。
+
+type channelStats struct {
+	inFlight int64
+
+	lock        sync.Mutex
+	latencyMs   float64 // 请求耗时的 EWMA
+	ttftMs      float64 // 首字时间的 EWMA
+	samples     int64
+	ttftSamples int64
+	lastSample  int64
+
+	// 最近的非流式请求耗时，用于计算对冲请求的等待时间
+	recentLatency [channelLatencyWindow]int64
+	recentCount   int
+	recentNext    int
+}
+
+const channelLatencyWindow = 128
+
+// ChannelStats 渠道运行统计的快照
+type ChannelStats struct {
+	ChannelId   int     `json:"channel_id"`
+	InFlight    int64   `json:"in_flight"`
+	LatencyMs   float64 `json:"latency_ms"`
+	TTFTMs      float64 `json:"ttft_ms"`
+	Samples     int64   `json:"samples"`
+	TTFTSamples int64   `json:"ttft_samples"`
+	LastSample  int64   `json:"last_sample"`
+}
+
+var channelStatsMap sync.Map // channel_id -> *channelStats
+
+func getChannelStats(channelId int) *channelStats {
+	if stats, ok := channelStatsMap.Load(channelId); ok {
+		return stats.(*channelStats)
+	}
+	stats, _ := channelStatsMap.LoadOrStore(channelId, &channelStats{})
+	return stats.(*channelStats)
+}
+
+// IncreaseChannelInFlight 渠道开始处理一个请求
+func IncreaseChannelInFlight(channelId int) {
+	atomic.AddInt64(&getChannelStats(channelId).inFlight, 1)
+}
+
+// DecreaseChannelInFlight 渠道处理完一个请求
+func DecreaseChannelInFlight(channelId int) {
+	atomic.AddInt64(&getChannelStats(channelId).inFlight, -1)
+}
+
+// RecordChannelLatency 记录一次成功请求的耗时和首字时间，ttft 小于等于 0 表示没有首字时间
+func RecordChannelLatency(channelId int, latency time.Duration, ttft time.Duration) {
+	if latency <= 0 {
+		return
+	}
+	alpha := model_setting.GetChannelSelectionSettings().EwmaAlpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.3
+	}
+	stats := getChannelStats(channelId)
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	latencyMs := float64(latency.Milliseconds())
+	if stats.samples == 0 {
+		stats.latencyMs = latencyMs
+	} else {
+		stats.latencyMs = alpha*latencyMs + (1-alpha)*stats.latencyMs
+	}
+	stats.samples++
+	if ttft > 0 {
+		ttftMs := float64(ttft.Milliseconds())
+		if stats.ttftSamples == 0 {
+			stats.ttftMs = ttftMs
+		} else {
+			stats.ttftMs = alpha*ttftMs + (1-alpha)*stats.ttftMs
+		}
+		stats.ttftSamples++
+	} else {
+		stats.recentLatency[stats.recentNext] = latency.Milliseconds()
+		stats.recentNext = (stats.recentNext + 1) % channelLatencyWindow
+		if stats.recentCount < channelLatencyWindow {
+			stats.recentCount++
+		}
+	}
+	stats.lastSample = time.Now().Unix()
+}
+
+// GetChannelStats 获取渠道运行统计
+func GetChannelStats(channelId int) ChannelStats {
+	stats := getChannelStats(channelId)
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	return ChannelStats{
+		ChannelId:   channelId,
+		InFlight:    atomic.LoadInt64(&stats.inFlight),
+		LatencyMs:   stats.latencyMs,
+		TTFTMs:      stats.ttftMs,
+		Samples:     stats.samples,
+		TTFTSamples: stats.ttftSamples,
+		LastSample:  stats.lastSample,
+	}
+}
+
+// GetChannelLatencyPercentile 获取渠道最近非流式请求耗时的分位数，样本数不足时返回 false
+func GetChannelLatencyPercentile(channelId int, percentile float64, minSamples int) (time.Duration, bool) {
+	stats := getChannelStats(channelId)
+	stats.lock.Lock()
+	samples := make([]int64, stats.recentCount)
+	copy(samples, stats.recentLatency[:stats.recentCount])
+	stats.lock.Unlock()
+	if len(samples) == 0 || len(samples) < minSamples {
+		return 0, false
+	}
+	if percentile <= 0 || percentile > 100 {
+		percentile = 95
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+	index := int(math.Ceil(percentile/100*float64(len(samples)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return time.Duration(samples[index]) * time.Millisecond, true
+}
+
+// GetAllChannelStats 获取当前节点所有渠道的运行统计
+func GetAllChannelStats() []ChannelStats {
+	result := make([]ChannelStats, 0)
+	channelStatsMap.Range(func(key, value any) bool {
+		result = append(result, GetChannelStats(key.(int)))
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ChannelId < result[j].ChannelId
+	})
+	return result
+}
+
+// PickChannelByStrategy 按策略从同一优先级的渠道中选择一个
+func PickChannelByStrategy(channels []*Channel, strategy string) *Channel {
+	if len(channels) == 1 {
+		return channels[0]
+	}
+	switch strategy {
+	case model_setting.ChannelSelectionLatency:
+		return pickChannelByLatency(channels, false)
+	case model_setting.ChannelSelectionTTFT:
+		return pickChannelByLatency(channels, true)
+	case model_setting.ChannelSelectionLeastInFlight:
+		return pickLeastInFlightChannel(channels)
+	}
+	return pickWeightedChannel(channels)
+}
+
+// pickChannelByLatency 按 (权重 + 平滑系数) / 延迟 加权随机选择，没有样本的渠道使用其余渠道的平均延迟
+func pickChannelByLatency(channels []*Channel, ttft bool) *Channel {
+	latencies := make([]float64, len(channels))
+	known := 0
+	sum := 0.0
+	for i, channel := range channels {
+		stats := GetChannelStats(channel.Id)
+		if ttft && stats.TTFTSamples > 0 {
+			latencies[i] = stats.TTFTMs
+		} else if !ttft && stats.Samples > 0 {
+			latencies[i] = stats.LatencyMs
+		} else {
+			continue
+		}
+		// 避免极小的延迟占据全部流量
+		if latencies[i] < 1 {
+			latencies[i] = 1
+		}
+		known++
+		sum += latencies[i]
+	}
+	if known == 0 {
+		return pickWeightedChannel(channels)
+	}
+	average := sum / float64(known)
+
+	// 平滑系数，与按权重随机保持一致
+	smoothingFactor := 10
+	scores := make([]float64, len(channels))
+	totalScore := 0.0
+	for i, channel := range channels {
+		latency := latencies[i]
+		if latency == 0 {
+			latency = average
+		}
+		scores[i] = float64(channel.GetWeight()+smoothingFactor) / latency
+		totalScore += scores[i]
+	}
+	randomScore := rand.Float64() * totalScore
+	for i, channel := range channels {
+		randomScore -= scores[i]
+		if randomScore < 0 {
+			return channel
+		}
+	}
+	return channels[len(channels)-1]
+}
+
+// pickLeastInFlightChannel 选择进行中请求最少的渠道，数量相同时按权重随机
+func pickLeastInFlightChannel(channels []*Channel) *Channel {
+	var candidates []*Channel
+	minInFlight := int64(-1)
+	for _, channel := range channels {
+		inFlight := atomic.LoadInt64(&getChannelStats(channel.Id).inFlight)
+		if minInFlight < 0 || inFlight < minInFlight {
+			minInFlight = inFlight
+			candidates = candidates[:0]
+		}
+		if inFlight == minInFlight {
+			candidates = append(candidates, channel)
+		}
+	}
+	return pickWeightedChannel(candidates)
+}
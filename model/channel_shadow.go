@@ -0,0 +1,232 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/setting/model_setting"
+
+	"gorm.io/gorm"
+)
+
+// 影子流量：按比例把主请求成功的请求异步镜像到候选渠道，结果只记录到影子日志，不写回客户端也不计费
+
+// ChannelShadowConfig 渠道的影子流量配置，目标为候选渠道或某个标签下的渠道
+type ChannelShadowConfig struct {
+	ChannelId int
+	Tag       string
+	Ratio     float64 // 百分比
+	Diff      bool
+}
+
+// ParseChannelShadowConfig 解析渠道额外设置中的影子流量配置，未配置时返回 nil
+func ParseChannelShadowConfig(setting map[string]interface{}) *ChannelShadowConfig {
+	tag, _ := setting[constant.ChannelSettingShadowTag].(string)
+	config := &ChannelShadowConfig{
+		ChannelId: getSettingInt(setting, constant.ChannelSettingShadowChannelId),
+		Tag:       strings.TrimSpace(tag),
+		Ratio:     getSettingFloat(setting, constant.ChannelSettingShadowRatio),
+		Diff:      getSettingBool(setting, constant.ChannelSettingShadowDiff),
+	}
+	if config.Ratio <= 0 || (config.ChannelId == 0 && config.Tag == "") {
+		return nil
+	}
+	return config
+}
+
+// Sample 按镜像比例抽样
+func (config *ChannelShadowConfig) Sample() bool {
+	return rand.Float64()*100 < config.Ratio
+}
+
+func getSettingFloat(setting map[string]interface{}, key string) float64 {
+	switch value := setting[key].(type) {
+	case float64:
+		return value
+	case int:
+		return float64(value)
+	case string:
+		n, _ := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		return n
+	}
+	return 0
+}
+
+func getSettingBool(setting map[string]interface{}, key string) bool {
+	switch value := setting[key].(type) {
+	case bool:
+		return value
+	case string:
+		b, _ := strconv.ParseBool(strings.TrimSpace(value))
+		return b
+	}
+	return false
+}
+
+// GetShadowChannel 获取影子请求的目标渠道，不会选中主请求的渠道。
+// 候选渠道在接入前通常处于禁用状态，因此不要求渠道已启用。
+func GetShadowChannel(config *ChannelShadowConfig, primaryChannelId int) (*Channel, error) {
+	if config.ChannelId != 0 {
+		if config.ChannelId == primaryChannelId {
+			return nil, errors.New("shadow channel is the primary channel")
+		}
+		if channel, err := CacheGetChannel(config.ChannelId); err == nil {
+			return channel, nil
+		}
+		return GetChannelById(config.ChannelId, true)
+	}
+	channels, err := CacheGetChannelsByTag(config.Tag)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.Id != primaryChannelId {
+			candidates = append(candidates, channel)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no shadow channel with tag %s", config.Tag)
+	}
+	return candidates[rand.Intn(len(candidates))], nil
+}
+
+// ShadowLog 影子请求的结果
+type ShadowLog struct {
+	Id               int    `json:"id" gorm:"primaryKey"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index"`
+	RequestId        string `json:"request_id" gorm:"type:varchar(64)"`
+	UserId           int    `json:"user_id" gorm:"index"`
+	ModelName        string `json:"model_name" gorm:"type:varchar(255);index"`
+	PrimaryChannelId int    `json:"primary_channel_id" gorm:"index"`
+	ShadowChannelId  int    `json:"shadow_channel_id" gorm:"index"`
+	IsStream         bool   `json:"is_stream"`
+	PrimaryLatency   int64  `json:"primary_latency"` // 毫秒
+	ShadowLatency    int64  `json:"shadow_latency"`  // 毫秒
+	StatusCode       int    `json:"status_code"`     // 影子请求的状态码
+	ErrorMessage     string `json:"error_message" gorm:"type:text"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	// 开启响应对比时记录双方的响应内容
+	PrimaryContent string `json:"primary_content" gorm:"type:text"`
+	ShadowContent  string `json:"shadow_content" gorm:"type:text"`
+	ContentMatched *bool  `json:"content_matched"`
+}
+
+func (log *ShadowLog) Insert() error {
+	if log.CreatedAt == 0 {
+		log.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(log).Error
+}
+
+type ShadowLogQueryParams struct {
+	PrimaryChannelId int
+	ShadowChannelId  int
+	ModelName        string
+	StartTimestamp   int64
+	EndTimestamp     int64
+}
+
+func (params ShadowLogQueryParams) apply() *gorm.DB {
+	query := DB.Model(&ShadowLog{})
+	if params.PrimaryChannelId != 0 {
+		query = query.Where("primary_channel_id = ?", params.PrimaryChannelId)
+	}
+	if params.ShadowChannelId != 0 {
+		query = query.Where("shadow_channel_id = ?", params.ShadowChannelId)
+	}
+	if params.ModelName != "" {
+		query = query.Where("model_name = ?", params.ModelName)
+	}
+	if params.StartTimestamp != 0 {
+		query = query.Where("created_at >= ?", params.StartTimestamp)
+	}
+	if params.EndTimestamp != 0 {
+		query = query.Where("created_at <= ?", params.EndTimestamp)
+	}
+	return query
+}
+
+func GetShadowLogs(startIdx int, num int, params ShadowLogQueryParams) ([]*ShadowLog, int64, error) {
+	var logs []*ShadowLog
+	var total int64
+	query := params.apply()
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err := query.Order("id desc").Limit(num).Offset(startIdx).Find(&logs).Error
+	return logs, total, err
+}
+
+// ShadowLogStat 按影子渠道汇总的影子请求结果
+type ShadowLogStat struct {
+	ShadowChannelId   int     `json:"shadow_channel_id"`
+	Count             int64   `json:"count"`
+	SuccessCount      int64   `json:"success_count"`
+	MatchedCount      int64   `json:"matched_count"`
+	AvgPrimaryLatency float64 `json:"avg_primary_latency"`
+	AvgShadowLatency  float64 `json:"avg_shadow_latency"`
+	PromptTokens      int64   `json:"prompt_tokens"`
+	CompletionTokens  int64   `json:"completion_tokens"`
+}
+
+func GetShadowLogStats(params ShadowLogQueryParams) ([]*ShadowLogStat, error) {
+	var stats []*ShadowLogStat
+	trueVal := "1"
+	if common.UsingPostgreSQL {
+		trueVal = "true"
+	}
+	err := params.apply().
+		Select("shadow_channel_id, count(*) as count, " +
+			"sum(case when status_code = 200 then 1 else 0 end) as success_count, " +
+			"sum(case when content_matched = " + trueVal + " then 1 else 0 end) as matched_count, " +
+			"avg(primary_latency) as avg_primary_latency, avg(shadow_latency) as avg_shadow_latency, " +
+			"sum(prompt_tokens) as prompt_tokens, sum(completion_tokens) as completion_tokens").
+		Group("shadow_channel_id").
+		Scan(&stats).Error
+	return stats, err
+}
+
+func DeleteExpiredShadowLogs() (int64, error) {
+	retentionDays := model_setting.GetShadowSettings().RetentionDays
+	if retentionDays <= 0 {
+		return 0, nil
+	}
+	result := DB.Where("created_at < ?", time.Now().AddDate(0, 0, -retentionDays).Unix()).Delete(&ShadowLog{})
+	return result.RowsAffected, result.Error
+}
+
+// CleanExpiredShadowLogs 定期清理超过保留天数的影子日志
+func CleanExpiredShadowLogs(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		count, err := DeleteExpiredShadowLogs()
+		if err != nil {
+			common.SysError("failed to clean expired shadow logs: " + err.Error())
+		} else if count > 0 {
+			common.SysLog(fmt.Sprintf("cleaned %d expired shadow logs", count))
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"veloera/common"
+)
+
+// File 通过 Files API 上传或由批处理生成的文件，内容保存在 common.FileStoragePath 目录下
+type File struct {
+	Id          int    `json:"id" gorm:"primaryKey"`
+	FileId      string `json:"file_id" gorm:"type:varchar(64);uniqueIndex"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	Filename    string `json:"filename" gorm:"type:varchar(255)"`
+	Purpose     string `json:"purpose" gorm:"type:varchar(32);index"`
+	Bytes       int64  `json:"bytes"`
+	StoragePath string `json:"-" gorm:"type:varchar(255)"` // 相对于 FileStoragePath 的路径
+	CreatedAt   int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+func (file *File) Insert() error {
+	if file.CreatedAt == 0 {
+		file.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(file).Error
+}
+
+func (file *File) Delete() error {
+	return DB.Delete(file).Error
+}
+
+func GetFileByFileId(fileId string, userId int) (*File, error) {
+	var file File
+	err := DB.Where("file_id = ? AND user_id = ?", fileId, userId).First(&file).Error
+	return &file, err
+}
+
+// GetUserFiles 按创建时间分页列出用户的文件，afterId 为上一页最后一个文件的 id
+func GetUserFiles(userId int, purpose string, afterId int, limit int, asc bool) ([]*File, error) {
+	var files []*File
+	query := DB.Where("user_id = ?", userId)
+	if purpose != "" {
+		query = query.Where("purpose = ?", purpose)
+	}
+	order := "id desc"
+	if asc {
+		order = "id asc"
+		if afterId != 0 {
+			query = query.Where("id > ?", afterId)
+		}
+	} else if afterId != 0 {
+		query = query.Where("id < ?", afterId)
+	}
+	err := query.Order(order).Limit(limit).Find(&files).Error
+	return files, err
+}
@@ -20,6 +20,7 @@ package model
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 
 	"veloera/common"
@@ -96,6 +97,12 @@ func GetGlobalModelMapping() *GlobalModelMapping {
 		copy(itemsCopy, v)
 		configCopy.Mapping[k] = itemsCopy
 	}
+	if len(globalModelMapping.Fallbacks) > 0 {
+		configCopy.Fallbacks = make(map[string][]string, len(globalModelMapping.Fallbacks))
+		for k, v := range globalModelMapping.Fallbacks {
+			configCopy.Fallbacks[k] = append([]string(nil), v...)
+		}
+	}
 
 	return configCopy
 }
@@ -221,3 +228,18 @@ func GetAllVirtualModels() []string {
 
 	return virtualModels
 }
+
+// GetModelFallbacks 获取模型的降级链，没有配置时返回空
+func GetModelFallbacks(modelName string) []string {
+	ModelMappingMutex.RLock()
+	defer ModelMappingMutex.RUnlock()
+
+	if globalModelMapping == nil || len(globalModelMapping.Fallbacks[modelName]) == 0 {
+		return nil
+	}
+	fallbacks := make([]string, 0, len(globalModelMapping.Fallbacks[modelName]))
+	for _, fallback := range globalModelMapping.Fallbacks[modelName] {
+		fallbacks = append(fallbacks, strings.TrimSpace(fallback))
+	}
+	return fallbacks
+}
@@ -33,7 +33,8 @@ type ModelMappingItem struct {
 
 // GlobalModelMapping 全局模型映射配置
 type GlobalModelMapping struct {
-	Mapping map[string][]ModelMappingItem `json:"mapping"` // 虚拟模型名 -> 实际模型映射列表
+	Mapping   map[string][]ModelMappingItem `json:"mapping"`             // 虚拟模型名 -> 实际模型映射列表
+	Fallbacks map[string][]string           `json:"fallbacks,omitempty"` // 虚拟或实际模型名 -> 按顺序尝试的降级模型
 }
 
 // RoundRobinCounter 轮询计数器
@@ -177,6 +178,23 @@ func ValidateModelMapping(mapping *GlobalModelMapping) error {
 			modelSet[trimmedModel] = true
 		}
 	}
-	
+
+	for sourceModel, fallbacks := range mapping.Fallbacks {
+		if strings.TrimSpace(sourceModel) == "" {
+			return fmt.Errorf("降级链的模型名不能为空")
+		}
+		modelSet := map[string]bool{sourceModel: true}
+		for i, fallback := range fallbacks {
+			trimmedModel := strings.TrimSpace(fallback)
+			if trimmedModel == "" {
+				return fmt.Errorf("模型 '%s' 的第 %d 个降级模型名不能为空", sourceModel, i+1)
+			}
+			if modelSet[trimmedModel] {
+				return fmt.Errorf("模型 '%s' 的降级链中存在重复的模型名 '%s'", sourceModel, trimmedModel)
+			}
+			modelSet[trimmedModel] = true
+		}
+	}
+
 	return nil
 }
\ No newline at end of file
@@ -272,6 +272,11 @@ func migrateDB() error {
 		&Setup{},
 		&Message{},
 		&UserMessage{},
+		&StoredResponse{},
+		&File{},
+		&Batch{},
+		&ChannelKey{},
+		&ShadowLog{},
 	}
 
 	for _, model := range modelsToMigrate {
@@ -286,6 +291,9 @@ func migrateDB() error {
 		}
 	}
 
+	if err := SyncPrefixAbilities(); err != nil {
+		common.SysError("failed to sync prefix abilities: " + err.Error())
+	}
 	common.SysLog("database migrated")
 	return nil
 }
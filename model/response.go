@@ -0,0 +1,96 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"veloera/common"
+	"veloera/setting/model_setting"
+)
+
+// StoredResponse 网关侧存储的 Responses API 响应，用于 previous_response_id 续写
+type StoredResponse struct {
+	Id                 int             `json:"id" gorm:"primaryKey"`
+	ResponseId         string          `json:"response_id" gorm:"type:varchar(100);uniqueIndex"`
+	UserId             int             `json:"user_id" gorm:"index"`
+	TokenId            int             `json:"token_id" gorm:"index"`
+	Model              string          `json:"model" gorm:"type:varchar(255)"`
+	PreviousResponseId string          `json:"previous_response_id" gorm:"type:varchar(100)"`
+	Input              json.RawMessage `json:"input" gorm:"type:json"`    // 本次请求的输入项（不含历史）
+	Response           json.RawMessage `json:"response" gorm:"type:json"` // 完整的 response 对象
+	CreatedAt          int64           `json:"created_at" gorm:"bigint;index"`
+}
+
+func (response *StoredResponse) Insert() error {
+	if response.CreatedAt == 0 {
+		response.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(response).Error
+}
+
+func storedResponseRetentionStart() int64 {
+	retentionDays := model_setting.GetResponsesSettings().RetentionDays
+	if retentionDays <= 0 {
+		return 0
+	}
+	return time.Now().AddDate(0, 0, -retentionDays).Unix()
+}
+
+// GetStoredResponse 获取存储的响应，tokenId 为 0 时不按令牌过滤
+func GetStoredResponse(responseId string, userId int, tokenId int) (*StoredResponse, error) {
+	var response StoredResponse
+	query := DB.Where("response_id = ? AND user_id = ? AND created_at >= ?", responseId, userId, storedResponseRetentionStart())
+	if tokenId != 0 {
+		query = query.Where("token_id = ?", tokenId)
+	}
+	err := query.First(&response).Error
+	return &response, err
+}
+
+// DeleteStoredResponse 删除存储的响应，返回是否删除了记录
+func DeleteStoredResponse(responseId string, userId int, tokenId int) (bool, error) {
+	query := DB.Where("response_id = ? AND user_id = ?", responseId, userId)
+	if tokenId != 0 {
+		query = query.Where("token_id = ?", tokenId)
+	}
+	result := query.Delete(&StoredResponse{})
+	return result.RowsAffected > 0, result.Error
+}
+
+func DeleteExpiredStoredResponses() (int64, error) {
+	retentionStart := storedResponseRetentionStart()
+	if retentionStart == 0 {
+		return 0, nil
+	}
+	result := DB.Where("created_at < ?", retentionStart).Delete(&StoredResponse{})
+	return result.RowsAffected, result.Error
+}
+
+// CleanExpiredStoredResponses 定期清理超过保留天数的响应
+func CleanExpiredStoredResponses(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		count, err := DeleteExpiredStoredResponses()
+		if err != nil {
+			common.SysError("failed to clean expired stored responses: " + err.Error())
+		} else if count > 0 {
+			common.SysLog(fmt.Sprintf("cleaned %d expired stored responses", count))
+		}
+	}
+}
@@ -110,6 +110,15 @@ func DoWssRequest(a Adaptor, c *gin.Context, info *common.RelayInfo, requestBody
 	return targetConn, nil
 }
 
+// DoHttpRequest 发送已构造好的请求，用于需要对完整请求签名的渠道
+func DoHttpRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http.Response, error) {
+	resp, err := doRequest(c, req, info)
+	if err != nil {
+		return nil, fmt.Errorf("do request failed: %w", err)
+	}
+	return resp, nil
+}
+
 func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http.Response, error) {
 	var client *http.Client
 	var err error
@@ -121,6 +130,10 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	} else {
 		client = service.GetHttpClient()
 	}
+	// 对冲请求中落败的一方会被取消，影子请求有单独的超时
+	if common.GetHedgeAttempt(c) != nil || common.IsShadowRequest(c) {
+		req = req.WithContext(c.Request.Context())
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -128,6 +141,7 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
+	service.RecordUpstreamRateLimit(c, info.ChannelId, resp)
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
 	return resp, nil
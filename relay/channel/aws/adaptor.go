@@ -22,13 +22,18 @@ import (
 	"io"
 	"net/http"
 	"veloera/dto"
+	"veloera/relay/channel"
 	"veloera/relay/channel/claude"
 	relaycommon "veloera/relay/common"
+	"veloera/service"
 	"veloera/setting/model_setting"
 )
 
 const (
 	RequestModeMessage = 1
+	// 非 Anthropic 模型使用 Converse API
+	RequestModeConverse  = 2
+	RequestModeEmbedding = 3
 )
 
 type Adaptor struct {
@@ -38,9 +43,37 @@ type Adaptor struct {
 func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (any, error) {
 	c.Set("request_model", request.Model)
 	c.Set("converted_request", request)
+	awsModelId, err := a.resolveModelID(c, info, request.Model)
+	if err != nil {
+		return nil, err
+	}
+	if isAwsConverseModel(awsModelId) {
+		return a.convertConverseRequest(c, request)
+	}
 	return request, nil
 }
 
+// resolveModelID 解析实际请求的模型 ID 并保存到上下文
+func (a *Adaptor) resolveModelID(c *gin.Context, info *relaycommon.RelayInfo, requestModel string) (string, error) {
+	_, _, region, err := parseAwsSecret(info.ApiKey)
+	if err != nil {
+		return "", err
+	}
+	awsModelId := awsRequestModelID(info, requestModel, region)
+	c.Set("aws_model_id", awsModelId)
+	return awsModelId, nil
+}
+
+func (a *Adaptor) convertConverseRequest(c *gin.Context, request *dto.ClaudeRequest) (any, error) {
+	converseReq, err := requestClaude2Converse(request)
+	if err != nil {
+		return nil, err
+	}
+	a.RequestMode = RequestModeConverse
+	c.Set("converse_request", converseReq)
+	return converseReq, nil
+}
+
 func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
 	//TODO implement me
 	return nil, errors.New("not implemented")
@@ -75,8 +108,26 @@ func (a *Adaptor) ConvertOpenAIRequest(c *gin.Context, info *relaycommon.RelayIn
 	if err != nil {
 		return nil, err
 	}
+	info.StructuredOutput = service.GetStructuredOutputSchema(request)
 	c.Set("request_model", claudeReq.Model)
 	c.Set("converted_request", claudeReq)
+	awsModelId, err := a.resolveModelID(c, info, claudeReq.Model)
+	if err != nil {
+		return nil, err
+	}
+	if isAwsConverseModel(awsModelId) {
+		// Claude 的默认 max_tokens 可能超过其他模型的上限，只使用请求中指定的值
+		claudeReq.MaxTokens = request.MaxTokens
+		if request.MaxCompletionTokens > 0 {
+			claudeReq.MaxTokens = request.MaxCompletionTokens
+		}
+		toolChoice, withTools := claudeToolChoiceFromOpenAI(request.ToolChoice)
+		claudeReq.ToolChoice = toolChoice
+		if !withTools {
+			claudeReq.Tools = nil
+		}
+		return a.convertConverseRequest(c, claudeReq)
+	}
 	return claudeReq, err
 }
 
@@ -85,20 +136,44 @@ func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dt
 }
 
 func (a *Adaptor) ConvertEmbeddingRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.EmbeddingRequest) (any, error) {
-	//TODO implement me
-	return nil, errors.New("not implemented")
+	awsModelId, err := a.resolveModelID(c, info, info.UpstreamModelName)
+	if err != nil {
+		return nil, err
+	}
+	requests, err := requestOpenAI2AwsEmbedding(awsModelId, request)
+	if err != nil {
+		return nil, err
+	}
+	a.RequestMode = RequestModeEmbedding
+	c.Set("converted_request", requests)
+	return requests, nil
 }
 
 func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
-	// TODO implement me
-	return nil, errors.New("not implemented")
+	return channel.ConvertResponsesRequestByOpenAI(a, c, info, request)
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	// Anthropic 模型和向量模型在 DoResponse 中通过 SDK 请求
+	if a.RequestMode == RequestModeConverse {
+		return a.doConverseRequest(c, info)
+	}
 	return nil, nil
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *dto.OpenAIErrorWithStatusCode) {
+	switch a.RequestMode {
+	case RequestModeConverse:
+		if info.IsStream {
+			err, usage = converseStreamHandler(c, resp, info)
+		} else {
+			err, usage = converseHandler(c, resp, info)
+		}
+		return
+	case RequestModeEmbedding:
+		err, usage = awsEmbeddingHandler(c, info)
+		return
+	}
 	if info.IsStream {
 		err, usage = awsStreamHandler(c, resp, info, a.RequestMode)
 	} else {
@@ -107,10 +182,16 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 	return
 }
 
+func (a *Adaptor) CountClaudeTokens(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (int, error) {
+	return awsCountTokens(c, info, request)
+}
+
 func (a *Adaptor) GetModelList() (models []string) {
 	for n := range awsModelIDMap {
 		models = append(models, n)
 	}
+	models = append(models, awsConverseModelIds...)
+	models = append(models, awsEmbeddingModelIds...)
 
 	return
 }
@@ -31,6 +31,39 @@ var awsModelIDMap = map[string]string{
 	"claude-opus-4-20250514":     "anthropic.claude-opus-4-20250514-v1:0",
 }
 
+// awsConverseModelIds 通过 Converse API 调用的非 Anthropic 模型，请求的模型名即为 Bedrock 模型 ID
+var awsConverseModelIds = []string{
+	"amazon.nova-micro-v1:0",
+	"amazon.nova-lite-v1:0",
+	"amazon.nova-pro-v1:0",
+	"amazon.nova-premier-v1:0",
+	"meta.llama3-8b-instruct-v1:0",
+	"meta.llama3-70b-instruct-v1:0",
+	"meta.llama3-1-8b-instruct-v1:0",
+	"meta.llama3-1-70b-instruct-v1:0",
+	"meta.llama3-1-405b-instruct-v1:0",
+	"meta.llama3-2-11b-instruct-v1:0",
+	"meta.llama3-2-90b-instruct-v1:0",
+	"meta.llama3-3-70b-instruct-v1:0",
+	"meta.llama4-scout-17b-instruct-v1:0",
+	"meta.llama4-maverick-17b-instruct-v1:0",
+	"mistral.mistral-large-2402-v1:0",
+	"mistral.mistral-large-2407-v1:0",
+	"mistral.mistral-small-2402-v1:0",
+	"mistral.pixtral-large-2502-v1:0",
+	"cohere.command-r-v1:0",
+	"cohere.command-r-plus-v1:0",
+	"deepseek.r1-v1:0",
+}
+
+// awsEmbeddingModelIds 支持的向量模型
+var awsEmbeddingModelIds = []string{
+	"amazon.titan-embed-text-v1",
+	"amazon.titan-embed-text-v2:0",
+	"cohere.embed-english-v3",
+	"cohere.embed-multilingual-v3",
+}
+
 var awsModelCanCrossRegionMap = map[string]map[string]bool{
 	"anthropic.claude-3-sonnet-20240229-v1:0": {
 		"us": true,
@@ -70,6 +103,46 @@ var awsModelCanCrossRegionMap = map[string]map[string]bool{
 	"anthropic.claude-opus-4-20250514-v1:0": {
 		"us": true,
 	},
+	"amazon.nova-micro-v1:0": {
+		"us": true,
+		"eu": true,
+		"ap": true,
+	},
+	"amazon.nova-lite-v1:0": {
+		"us": true,
+		"eu": true,
+		"ap": true,
+	},
+	"amazon.nova-pro-v1:0": {
+		"us": true,
+		"eu": true,
+		"ap": true,
+	},
+	"amazon.nova-premier-v1:0": {
+		"us": true,
+	},
+	"meta.llama3-2-11b-instruct-v1:0": {
+		"us": true,
+	},
+	"meta.llama3-2-90b-instruct-v1:0": {
+		"us": true,
+	},
+	"meta.llama3-3-70b-instruct-v1:0": {
+		"us": true,
+	},
+	"meta.llama4-scout-17b-instruct-v1:0": {
+		"us": true,
+	},
+	"meta.llama4-maverick-17b-instruct-v1:0": {
+		"us": true,
+	},
+	"mistral.pixtral-large-2502-v1:0": {
+		"us": true,
+		"eu": true,
+	},
+	"deepseek.r1-v1:0": {
+		"us": true,
+	},
 }
 
 var awsRegionCrossModelPrefixMap = map[string]string{
@@ -0,0 +1,572 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package aws
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/relay/channel"
+	"veloera/relay/channel/claude"
+	relaycommon "veloera/relay/common"
+	"veloera/relay/helper"
+	"veloera/service"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// 非 Anthropic 模型通过 Converse / ConverseStream API 调用。请求先统一转换为 Claude 格式，
+// 再转换为 Converse 格式；响应转换回 Claude 格式后复用 Claude 的响应处理，同时支持 OpenAI 和 Claude 两种客户端格式。
+// 当前依赖的 SDK 版本不包含 Converse，这里直接发送 SigV4 签名的 HTTP 请求。
+
+// isAwsConverseModel Anthropic 模型继续使用 InvokeModel，其余模型使用 Converse
+func isAwsConverseModel(awsModelId string) bool {
+	return !strings.Contains(awsModelId, "anthropic.")
+}
+
+func awsConverseURL(info *relaycommon.RelayInfo, awsModelId string, region string, stream bool) string {
+	baseURL := info.BaseUrl
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", region)
+	}
+	action := "converse"
+	if stream {
+		action = "converse-stream"
+	}
+	return fmt.Sprintf("%s/model/%s/%s", strings.TrimSuffix(baseURL, "/"), url.PathEscape(awsModelId), action)
+}
+
+// doAwsSignedRequest 发送 SigV4 签名的请求，使用渠道的代理设置
+func doAwsSignedRequest(c *gin.Context, info *relaycommon.RelayInfo, url string, body []byte, accept string) (*http.Response, error) {
+	ak, sk, region, err := parseAwsSecret(info.ApiKey)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("new request failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	payloadHash := sha256.Sum256(body)
+	credentials := aws.Credentials{AccessKeyID: ak, SecretAccessKey: sk}
+	err = v4.NewSigner().SignHTTP(c.Request.Context(), credentials, req, hex.EncodeToString(payloadHash[:]), "bedrock", region, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("sign request failed: %w", err)
+	}
+	return channel.DoHttpRequest(c, req, info)
+}
+
+func (a *Adaptor) doConverseRequest(c *gin.Context, info *relaycommon.RelayInfo) (*http.Response, error) {
+	converseReq_, ok := c.Get("converse_request")
+	if !ok {
+		return nil, errors.New("request not found")
+	}
+	body, err := json.Marshal(converseReq_)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal request")
+	}
+	_, _, region, err := parseAwsSecret(info.ApiKey)
+	if err != nil {
+		return nil, err
+	}
+	accept := "application/json"
+	if info.IsStream {
+		accept = "application/vnd.amazon.eventstream"
+	}
+	return doAwsSignedRequest(c, info, awsConverseURL(info, c.GetString("aws_model_id"), region, info.IsStream), body, accept)
+}
+
+// claudeToolChoiceFromOpenAI 把 OpenAI 的 tool_choice 转换为 Claude 格式，none 返回 false 表示不发送工具
+func claudeToolChoiceFromOpenAI(toolChoice any) (any, bool) {
+	switch choice := toolChoice.(type) {
+	case string:
+		switch choice {
+		case "none":
+			return nil, false
+		case "required":
+			return map[string]any{"type": "any"}, true
+		case "auto":
+			return map[string]any{"type": "auto"}, true
+		}
+	case map[string]any:
+		if function, ok := choice["function"].(map[string]any); ok {
+			if name, ok := function["name"].(string); ok && name != "" {
+				return map[string]any{"type": "tool", "name": name}, true
+			}
+		}
+	}
+	return nil, true
+}
+
+// requestClaude2Converse 把 Claude 格式的请求转换为 Converse 请求
+func requestClaude2Converse(claudeReq *dto.ClaudeRequest) (*ConverseRequest, error) {
+	converseReq := &ConverseRequest{
+		Messages: make([]ConverseMessage, 0, len(claudeReq.Messages)),
+	}
+
+	if claudeReq.IsStringSystem() {
+		if system := claudeReq.GetStringSystem(); system != "" {
+			converseReq.System = append(converseReq.System, ConverseContentBlock{Text: common.GetPointer(system)})
+		}
+	} else {
+		for _, block := range claudeReq.ParseSystem() {
+			if block.Type == "text" && block.GetText() != "" {
+				converseReq.System = append(converseReq.System, ConverseContentBlock{Text: common.GetPointer(block.GetText())})
+			}
+		}
+	}
+
+	for _, message := range claudeReq.Messages {
+		converseMessage := ConverseMessage{Role: message.Role}
+		if message.IsStringContent() {
+			converseMessage.Content = []ConverseContentBlock{{Text: common.GetPointer(message.GetStringContent())}}
+		} else {
+			blocks, err := message.ParseContent()
+			if err != nil {
+				return nil, err
+			}
+			for _, block := range blocks {
+				converseBlock, err := contentClaude2Converse(block)
+				if err != nil {
+					return nil, err
+				}
+				if converseBlock != nil {
+					converseMessage.Content = append(converseMessage.Content, *converseBlock)
+				}
+			}
+		}
+		if len(converseMessage.Content) == 0 {
+			continue
+		}
+		converseReq.Messages = append(converseReq.Messages, converseMessage)
+	}
+
+	inferenceConfig := &ConverseInferenceConfig{
+		MaxTokens:     claudeReq.MaxTokens,
+		Temperature:   claudeReq.Temperature,
+		StopSequences: claudeReq.StopSequences,
+	}
+	if claudeReq.TopP > 0 {
+		inferenceConfig.TopP = common.GetPointer(claudeReq.TopP)
+	}
+	if inferenceConfig.MaxTokens > 0 || inferenceConfig.Temperature != nil || inferenceConfig.TopP != nil || len(inferenceConfig.StopSequences) > 0 {
+		converseReq.InferenceConfig = inferenceConfig
+	}
+
+	var tools []dto.Tool
+	if claudeReq.Tools != nil {
+		toolsData, _ := json.Marshal(claudeReq.Tools)
+		if err := json.Unmarshal(toolsData, &tools); err != nil {
+			return nil, fmt.Errorf("invalid tools: %w", err)
+		}
+	}
+	if len(tools) > 0 {
+		toolConfig := &ConverseToolConfig{}
+		for _, tool := range tools {
+			converseTool := ConverseTool{ToolSpec: ConverseToolSpec{Name: tool.Name, Description: tool.Description}}
+			if tool.InputSchema == nil {
+				converseTool.ToolSpec.InputSchema.Json = map[string]any{"type": "object", "properties": map[string]any{}}
+			} else {
+				// 转换 OpenAI 工具时可能产生值为 null 的字段，Converse 会拒绝
+				schema := make(map[string]any, len(tool.InputSchema))
+				for key, value := range tool.InputSchema {
+					if value != nil {
+						schema[key] = value
+					}
+				}
+				converseTool.ToolSpec.InputSchema.Json = schema
+			}
+			toolConfig.Tools = append(toolConfig.Tools, converseTool)
+		}
+		if choice, ok := claudeReq.ToolChoice.(map[string]any); ok {
+			switch choice["type"] {
+			case "auto":
+				toolConfig.ToolChoice = map[string]any{"auto": map[string]any{}}
+			case "any":
+				toolConfig.ToolChoice = map[string]any{"any": map[string]any{}}
+			case "tool":
+				toolConfig.ToolChoice = map[string]any{"tool": map[string]any{"name": choice["name"]}}
+			case "none":
+				toolConfig = nil
+			}
+		}
+		converseReq.ToolConfig = toolConfig
+	}
+
+	if claudeReq.Thinking != nil {
+		converseReq.AdditionalModelRequestFields = map[string]any{"thinking": claudeReq.Thinking}
+	}
+	return converseReq, nil
+}
+
+func contentClaude2Converse(block dto.ClaudeMediaMessage) (*ConverseContentBlock, error) {
+	switch block.Type {
+	case "text":
+		if block.GetText() == "" {
+			return nil, nil
+		}
+		return &ConverseContentBlock{Text: common.GetPointer(block.GetText())}, nil
+	case "image":
+		if block.Source == nil || block.Source.Type != "base64" {
+			return nil, errors.New("only base64 image source is supported")
+		}
+		data, _ := block.Source.Data.(string)
+		image := &ConverseImageBlock{Format: strings.TrimPrefix(block.Source.MediaType, "image/")}
+		if image.Format == "jpg" {
+			image.Format = "jpeg"
+		}
+		image.Source.Bytes = data
+		return &ConverseContentBlock{Image: image}, nil
+	case "tool_use":
+		input := block.Input
+		if input == nil {
+			input = map[string]any{}
+		}
+		return &ConverseContentBlock{ToolUse: &ConverseToolUseBlock{ToolUseId: block.Id, Name: block.Name, Input: input}}, nil
+	case "tool_result":
+		result := &ConverseToolResultBlock{ToolUseId: block.ToolUseId}
+		if block.IsStringContent() {
+			result.Content = append(result.Content, ConverseContentBlock{Text: common.GetPointer(block.GetStringContent())})
+		} else {
+			for _, content := range block.ParseMediaContent() {
+				converseBlock, err := contentClaude2Converse(content)
+				if err != nil {
+					return nil, err
+				}
+				if converseBlock != nil && (converseBlock.Text != nil || converseBlock.Image != nil) {
+					result.Content = append(result.Content, *converseBlock)
+				}
+			}
+			// OpenAI 格式的工具结果可能是 {type: text, text: ...} 数组以外的内容
+			if len(result.Content) == 0 && len(block.Content) > 0 {
+				result.Content = append(result.Content, ConverseContentBlock{Text: common.GetPointer(string(block.Content))})
+			}
+		}
+		if len(result.Content) == 0 {
+			result.Content = append(result.Content, ConverseContentBlock{Text: common.GetPointer("")})
+		}
+		return &ConverseContentBlock{ToolResult: result}, nil
+	}
+	// 历史消息中的 thinking 等内容不发送给上游
+	return nil, nil
+}
+
+func stopReasonConverse2Claude(reason string) string {
+	switch reason {
+	case "guardrail_intervened", "content_filtered":
+		return "content_filter"
+	}
+	return reason
+}
+
+// responseConverse2Claude 把 Converse 响应转换为 Claude 格式
+func responseConverse2Claude(converseResp *ConverseResponse, id string, model string) *dto.ClaudeResponse {
+	claudeResp := &dto.ClaudeResponse{
+		Id:         id,
+		Type:       "message",
+		Role:       "assistant",
+		Model:      model,
+		StopReason: stopReasonConverse2Claude(converseResp.StopReason),
+		Content:    make([]dto.ClaudeMediaMessage, 0, len(converseResp.Output.Message.Content)),
+		Usage: &dto.ClaudeUsage{
+			InputTokens:              converseResp.Usage.InputTokens,
+			OutputTokens:             converseResp.Usage.OutputTokens,
+			CacheReadInputTokens:     converseResp.Usage.CacheReadInputTokens,
+			CacheCreationInputTokens: converseResp.Usage.CacheWriteInputTokens,
+		},
+	}
+	for _, block := range converseResp.Output.Message.Content {
+		switch {
+		case block.Text != nil:
+			claudeResp.Content = append(claudeResp.Content, dto.ClaudeMediaMessage{Type: "text", Text: block.Text})
+		case block.ToolUse != nil:
+			claudeResp.Content = append(claudeResp.Content, dto.ClaudeMediaMessage{
+				Type:  "tool_use",
+				Id:    block.ToolUse.ToolUseId,
+				Name:  block.ToolUse.Name,
+				Input: block.ToolUse.Input,
+			})
+		case block.ReasoningContent != nil && block.ReasoningContent.ReasoningText != nil:
+			claudeResp.Content = append(claudeResp.Content, dto.ClaudeMediaMessage{
+				Type:      "thinking",
+				Thinking:  block.ReasoningContent.ReasoningText.Text,
+				Signature: block.ReasoningContent.ReasoningText.Signature,
+			})
+		}
+	}
+	return claudeResp
+}
+
+func converseHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	_ = resp.Body.Close()
+	var converseResp ConverseResponse
+	if err = json.Unmarshal(responseBody, &converseResp); err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+
+	claudeInfo := &claude.ClaudeResponseInfo{
+		ResponseId:   fmt.Sprintf("chatcmpl-%s", common.GetUUID()),
+		Created:      common.GetTimestamp(),
+		Model:        info.UpstreamModelName,
+		ResponseText: strings.Builder{},
+		Usage:        &dto.Usage{},
+	}
+	claudeResp := responseConverse2Claude(&converseResp, claudeInfo.ResponseId, info.UpstreamModelName)
+	data, err := json.Marshal(claudeResp)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	if handleErr := claude.HandleClaudeResponseData(c, info, claudeInfo, data, RequestModeMessage); handleErr != nil {
+		return handleErr, nil
+	}
+	return nil, claudeInfo.Usage
+}
+
+// converseStreamState 把 ConverseStream 事件转换为 Claude 流式事件
+type converseStreamState struct {
+	c          *gin.Context
+	info       *relaycommon.RelayInfo
+	claudeInfo *claude.ClaudeResponseInfo
+	// 已发送 content_block_start 的内容块，文本和推理内容块在 Converse 中没有开始事件
+	started    map[int]bool
+	stopReason string
+	finished   bool
+}
+
+func (s *converseStreamState) send(claudeResp *dto.ClaudeResponse) *dto.OpenAIErrorWithStatusCode {
+	data, err := json.Marshal(claudeResp)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError)
+	}
+	return claude.HandleStreamResponseData(s.c, s.info, s.claudeInfo, string(data), RequestModeMessage)
+}
+
+func (s *converseStreamState) startBlock(index int, block *dto.ClaudeMediaMessage) *dto.OpenAIErrorWithStatusCode {
+	if s.started[index] {
+		return nil
+	}
+	s.started[index] = true
+	claudeResp := &dto.ClaudeResponse{Type: "content_block_start", ContentBlock: block}
+	claudeResp.SetIndex(index)
+	return s.send(claudeResp)
+}
+
+func (s *converseStreamState) handleEvent(eventType string, event *ConverseStreamEvent) *dto.OpenAIErrorWithStatusCode {
+	switch eventType {
+	case "messageStart":
+		return s.send(&dto.ClaudeResponse{
+			Type: "message_start",
+			Message: &dto.ClaudeMediaMessage{
+				Id:    s.claudeInfo.ResponseId,
+				Type:  "message",
+				Role:  "assistant",
+				Model: s.info.UpstreamModelName,
+				Usage: &dto.ClaudeUsage{},
+			},
+		})
+	case "contentBlockStart":
+		if event.Start != nil && event.Start.ToolUse != nil {
+			return s.startBlock(event.ContentBlockIndex, &dto.ClaudeMediaMessage{
+				Type:  "tool_use",
+				Id:    event.Start.ToolUse.ToolUseId,
+				Name:  event.Start.ToolUse.Name,
+				Input: map[string]any{},
+			})
+		}
+	case "contentBlockDelta":
+		if event.Delta == nil {
+			return nil
+		}
+		delta := &dto.ClaudeMediaMessage{}
+		switch {
+		case event.Delta.Text != nil:
+			if err := s.startBlock(event.ContentBlockIndex, &dto.ClaudeMediaMessage{Type: "text", Text: common.GetPointer("")}); err != nil {
+				return err
+			}
+			delta.Type = "text_delta"
+			delta.Text = event.Delta.Text
+		case event.Delta.ToolUse != nil:
+			delta.Type = "input_json_delta"
+			delta.PartialJson = common.GetPointer(event.Delta.ToolUse.Input)
+		case event.Delta.ReasoningContent != nil:
+			if err := s.startBlock(event.ContentBlockIndex, &dto.ClaudeMediaMessage{Type: "thinking"}); err != nil {
+				return err
+			}
+			if event.Delta.ReasoningContent.Text != nil {
+				delta.Type = "thinking_delta"
+				delta.Thinking = *event.Delta.ReasoningContent.Text
+			} else if event.Delta.ReasoningContent.Signature != nil {
+				delta.Type = "signature_delta"
+				delta.Signature = *event.Delta.ReasoningContent.Signature
+			} else {
+				return nil
+			}
+		default:
+			return nil
+		}
+		claudeResp := &dto.ClaudeResponse{Type: "content_block_delta", Delta: delta}
+		claudeResp.SetIndex(event.ContentBlockIndex)
+		return s.send(claudeResp)
+	case "contentBlockStop":
+		if !s.started[event.ContentBlockIndex] {
+			return nil
+		}
+		claudeResp := &dto.ClaudeResponse{Type: "content_block_stop"}
+		claudeResp.SetIndex(event.ContentBlockIndex)
+		return s.send(claudeResp)
+	case "messageStop":
+		s.stopReason = stopReasonConverse2Claude(event.StopReason)
+	case "metadata":
+		// 用量在 messageStop 之后的 metadata 事件中返回
+		return s.finish(event.Usage)
+	}
+	return nil
+}
+
+// finish 发送 message_delta 和 message_stop
+func (s *converseStreamState) finish(usage *ConverseUsage) *dto.OpenAIErrorWithStatusCode {
+	if s.finished {
+		return nil
+	}
+	s.finished = true
+	claudeUsage := &dto.ClaudeUsage{}
+	if usage != nil {
+		claudeUsage.InputTokens = usage.InputTokens
+		claudeUsage.OutputTokens = usage.OutputTokens
+		claudeUsage.CacheReadInputTokens = usage.CacheReadInputTokens
+		claudeUsage.CacheCreationInputTokens = usage.CacheWriteInputTokens
+	}
+	stopReason := s.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	if err := s.send(&dto.ClaudeResponse{
+		Type:  "message_delta",
+		Delta: &dto.ClaudeMediaMessage{StopReason: common.GetPointer(stopReason)},
+		Usage: claudeUsage,
+	}); err != nil {
+		return err
+	}
+	if usage != nil {
+		s.claudeInfo.Usage.PromptTokensDetails.CachedTokens = usage.CacheReadInputTokens
+		s.claudeInfo.Usage.PromptTokensDetails.CachedCreationTokens = usage.CacheWriteInputTokens
+	}
+	return s.send(&dto.ClaudeResponse{Type: "message_stop"})
+}
+
+func converseStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	defer resp.Body.Close()
+	helper.SetEventStreamHeaders(c)
+
+	state := &converseStreamState{
+		c:    c,
+		info: info,
+		claudeInfo: &claude.ClaudeResponseInfo{
+			ResponseId:   fmt.Sprintf("chatcmpl-%s", common.GetUUID()),
+			Created:      common.GetTimestamp(),
+			Model:        info.UpstreamModelName,
+			ResponseText: strings.Builder{},
+			Usage:        &dto.Usage{},
+		},
+		started: make(map[int]bool),
+	}
+
+	decoder := eventstream.NewDecoder()
+	var payloadBuf []byte
+	for {
+		message, err := decoder.Decode(resp.Body, payloadBuf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return service.OpenAIErrorWrapper(err, "decode_stream_failed", http.StatusInternalServerError), nil
+		}
+		payloadBuf = message.Payload[:0]
+		info.SetFirstResponseTime()
+
+		var event ConverseStreamEvent
+		if len(message.Payload) > 0 {
+			if err = json.Unmarshal(message.Payload, &event); err != nil {
+				return service.OpenAIErrorWrapper(err, "unmarshal_stream_event_failed", http.StatusInternalServerError), nil
+			}
+		}
+		if messageType := headerString(message.Headers, ":message-type"); messageType == "exception" || messageType == "error" {
+			exceptionType := headerString(message.Headers, ":exception-type")
+			if exceptionType == "" {
+				exceptionType = headerString(message.Headers, ":error-code")
+			}
+			return &dto.OpenAIErrorWithStatusCode{
+				StatusCode: awsExceptionStatusCode(exceptionType),
+				Error: dto.OpenAIError{
+					Message: event.Message,
+					Type:    exceptionType,
+					Code:    exceptionType,
+				},
+			}, nil
+		}
+		if respErr := state.handleEvent(headerString(message.Headers, ":event-type"), &event); respErr != nil {
+			return respErr, nil
+		}
+	}
+	if respErr := state.finish(nil); respErr != nil {
+		return respErr, nil
+	}
+
+	claude.HandleStreamFinalResponse(c, info, state.claudeInfo, RequestModeMessage)
+	return nil, state.claudeInfo.Usage
+}
+
+func headerString(headers eventstream.Headers, name string) string {
+	value := headers.Get(name)
+	if value == nil {
+		return ""
+	}
+	return value.String()
+}
+
+// awsExceptionStatusCode 流式响应中的异常对应的 HTTP 状态码
+func awsExceptionStatusCode(exceptionType string) int {
+	switch exceptionType {
+	case "throttlingException":
+		return http.StatusTooManyRequests
+	case "validationException":
+		return http.StatusBadRequest
+	case "serviceUnavailableException":
+		return http.StatusServiceUnavailable
+	case "modelStreamErrorException", "modelTimeoutException":
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
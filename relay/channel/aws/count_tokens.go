@@ -0,0 +1,115 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package aws
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"veloera/dto"
+	relaycommon "veloera/relay/common"
+	"veloera/service"
+	"veloera/setting/model_setting"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// https://docs.aws.amazon.com/bedrock/latest/APIReference/API_runtime_CountTokens.html
+type awsCountTokensRequest struct {
+	Input struct {
+		InvokeModel struct {
+			// base64 编码的 InvokeModel 请求体
+			Body []byte `json:"body"`
+		} `json:"invokeModel"`
+	} `json:"input"`
+}
+
+type awsCountTokensResponse struct {
+	InputTokens int `json:"inputTokens"`
+}
+
+// awsCountTokens Bedrock SDK 的当前版本没有 CountTokens，这里直接签名并请求 REST 接口
+func awsCountTokens(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (int, error) {
+	ak, sk, region, err := parseAwsSecret(info.ApiKey)
+	if err != nil {
+		return 0, err
+	}
+
+	awsModelId, _ := awsModelID(info, request.Model)
+
+	// count_tokens 请求中没有 max_tokens，但 InvokeModel 请求体要求该字段
+	invokeRequest := copyRequest(request)
+	if invokeRequest.MaxTokens == 0 {
+		invokeRequest.MaxTokens = uint(model_setting.GetClaudeSettings().GetDefaultMaxTokens(request.Model))
+	}
+	countRequest := awsCountTokensRequest{}
+	countRequest.Input.InvokeModel.Body, err = json.Marshal(invokeRequest)
+	if err != nil {
+		return 0, errors.Wrap(err, "marshal invoke body")
+	}
+	jsonData, err := json.Marshal(countRequest)
+	if err != nil {
+		return 0, errors.Wrap(err, "marshal request")
+	}
+
+	baseUrl := info.BaseUrl
+	if baseUrl == "" {
+		baseUrl = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", region)
+	}
+	fullRequestURL := fmt.Sprintf("%s/model/%s/count-tokens", strings.TrimSuffix(baseUrl, "/"), url.PathEscape(awsModelId))
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, fullRequestURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return 0, errors.Wrap(err, "new request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	payloadHash := sha256.Sum256(jsonData)
+	credentials := aws.Credentials{AccessKeyID: ak, SecretAccessKey: sk}
+	err = v4.NewSigner().SignHTTP(c.Request.Context(), credentials, req, hex.EncodeToString(payloadHash[:]), "bedrock", region, time.Now())
+	if err != nil {
+		return 0, errors.Wrap(err, "sign request")
+	}
+
+	resp, err := service.GetHttpClient().Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "do request")
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, errors.Wrap(err, "read response body")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("count tokens failed with status %d: %s", resp.StatusCode, string(responseBody))
+	}
+	var countResponse awsCountTokensResponse
+	if err = json.Unmarshal(responseBody, &countResponse); err != nil {
+		return 0, errors.Wrap(err, "unmarshal response")
+	}
+	return countResponse.InputTokens, nil
+}
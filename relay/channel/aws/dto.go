@@ -50,3 +50,138 @@ func copyRequest(req *dto.ClaudeRequest) *AwsClaudeRequest {
 		Thinking:         req.Thinking,
 	}
 }
+
+// Converse API
+// https://docs.aws.amazon.com/bedrock/latest/APIReference/API_runtime_Converse.html
+
+type ConverseRequest struct {
+	Messages                     []ConverseMessage        `json:"messages"`
+	System                       []ConverseContentBlock   `json:"system,omitempty"`
+	InferenceConfig              *ConverseInferenceConfig `json:"inferenceConfig,omitempty"`
+	ToolConfig                   *ConverseToolConfig      `json:"toolConfig,omitempty"`
+	AdditionalModelRequestFields map[string]any           `json:"additionalModelRequestFields,omitempty"`
+}
+
+type ConverseMessage struct {
+	Role    string                 `json:"role"`
+	Content []ConverseContentBlock `json:"content"`
+}
+
+type ConverseContentBlock struct {
+	Text             *string                   `json:"text,omitempty"`
+	Image            *ConverseImageBlock       `json:"image,omitempty"`
+	ToolUse          *ConverseToolUseBlock     `json:"toolUse,omitempty"`
+	ToolResult       *ConverseToolResultBlock  `json:"toolResult,omitempty"`
+	ReasoningContent *ConverseReasoningContent `json:"reasoningContent,omitempty"`
+}
+
+type ConverseImageBlock struct {
+	Format string `json:"format"`
+	Source struct {
+		Bytes string `json:"bytes"`
+	} `json:"source"`
+}
+
+type ConverseToolUseBlock struct {
+	ToolUseId string `json:"toolUseId"`
+	Name      string `json:"name"`
+	Input     any    `json:"input"`
+}
+
+type ConverseToolResultBlock struct {
+	ToolUseId string                 `json:"toolUseId"`
+	Content   []ConverseContentBlock `json:"content"`
+	Status    string                 `json:"status,omitempty"`
+}
+
+type ConverseReasoningContent struct {
+	ReasoningText *struct {
+		Text      string `json:"text"`
+		Signature string `json:"signature,omitempty"`
+	} `json:"reasoningText,omitempty"`
+}
+
+type ConverseInferenceConfig struct {
+	MaxTokens     uint     `json:"maxTokens,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"topP,omitempty"`
+	StopSequences []string `json:"stopSequences,omitempty"`
+}
+
+type ConverseToolConfig struct {
+	Tools      []ConverseTool `json:"tools"`
+	ToolChoice map[string]any `json:"toolChoice,omitempty"`
+}
+
+type ConverseTool struct {
+	ToolSpec ConverseToolSpec `json:"toolSpec"`
+}
+
+type ConverseToolSpec struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema struct {
+		Json any `json:"json"`
+	} `json:"inputSchema"`
+}
+
+type ConverseUsage struct {
+	InputTokens           int `json:"inputTokens"`
+	OutputTokens          int `json:"outputTokens"`
+	TotalTokens           int `json:"totalTokens"`
+	CacheReadInputTokens  int `json:"cacheReadInputTokens"`
+	CacheWriteInputTokens int `json:"cacheWriteInputTokens"`
+}
+
+type ConverseResponse struct {
+	Output struct {
+		Message ConverseMessage `json:"message"`
+	} `json:"output"`
+	StopReason string        `json:"stopReason"`
+	Usage      ConverseUsage `json:"usage"`
+}
+
+// ConverseStreamEvent ConverseStream 的事件内容，事件类型在消息头 :event-type 中
+type ConverseStreamEvent struct {
+	Role              string `json:"role,omitempty"`
+	ContentBlockIndex int    `json:"contentBlockIndex"`
+	Start             *struct {
+		ToolUse *ConverseToolUseBlock `json:"toolUse,omitempty"`
+	} `json:"start,omitempty"`
+	Delta *struct {
+		Text    *string `json:"text,omitempty"`
+		ToolUse *struct {
+			Input string `json:"input"`
+		} `json:"toolUse,omitempty"`
+		ReasoningContent *struct {
+			Text      *string `json:"text,omitempty"`
+			Signature *string `json:"signature,omitempty"`
+		} `json:"reasoningContent,omitempty"`
+	} `json:"delta,omitempty"`
+	StopReason string         `json:"stopReason,omitempty"`
+	Usage      *ConverseUsage `json:"usage,omitempty"`
+	Message    string         `json:"message,omitempty"`
+}
+
+// Embedding
+
+type AwsTitanEmbeddingRequest struct {
+	InputText  string `json:"inputText"`
+	Dimensions int    `json:"dimensions,omitempty"`
+	Normalize  *bool  `json:"normalize,omitempty"`
+}
+
+type AwsTitanEmbeddingResponse struct {
+	Embedding           []float64 `json:"embedding"`
+	InputTextTokenCount int       `json:"inputTextTokenCount"`
+}
+
+type AwsCohereEmbeddingRequest struct {
+	Texts     []string `json:"texts"`
+	InputType string   `json:"input_type"`
+	Truncate  string   `json:"truncate,omitempty"`
+}
+
+type AwsCohereEmbeddingResponse struct {
+	Embeddings [][]float64 `json:"embeddings"`
+}
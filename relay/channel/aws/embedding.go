@@ -0,0 +1,153 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package aws
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"veloera/dto"
+	relaycommon "veloera/relay/common"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+)
+
+// Cohere 向量模型每次请求最多 96 条文本
+const awsCohereEmbeddingBatchSize = 96
+
+func isAwsTitanEmbeddingModel(awsModelId string) bool {
+	return strings.Contains(awsModelId, "amazon.titan-embed")
+}
+
+func isAwsCohereEmbeddingModel(awsModelId string) bool {
+	return strings.Contains(awsModelId, "cohere.embed")
+}
+
+// requestOpenAI2AwsEmbedding 转换向量请求，Titan 每次只接受一条文本，需要逐条请求
+func requestOpenAI2AwsEmbedding(awsModelId string, request dto.EmbeddingRequest) ([]any, error) {
+	input := request.ParseInput()
+	if len(input) == 0 {
+		return nil, errors.New("input is empty")
+	}
+	requests := make([]any, 0)
+	switch {
+	case isAwsTitanEmbeddingModel(awsModelId):
+		for _, text := range input {
+			titanReq := &AwsTitanEmbeddingRequest{InputText: text}
+			// v1 不支持 dimensions 和 normalize
+			if !strings.Contains(awsModelId, "titan-embed-text-v1") {
+				titanReq.Dimensions = request.Dimensions
+				titanReq.Normalize = aws.Bool(true)
+			}
+			requests = append(requests, titanReq)
+		}
+	case isAwsCohereEmbeddingModel(awsModelId):
+		for start := 0; start < len(input); start += awsCohereEmbeddingBatchSize {
+			end := start + awsCohereEmbeddingBatchSize
+			if end > len(input) {
+				end = len(input)
+			}
+			requests = append(requests, &AwsCohereEmbeddingRequest{
+				Texts:     input[start:end],
+				InputType: "search_document",
+			})
+		}
+	default:
+		return nil, errors.Errorf("embedding model %s is not supported", awsModelId)
+	}
+	return requests, nil
+}
+
+func awsEmbeddingHandler(c *gin.Context, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	awsCli, err := newAwsClient(c, info)
+	if err != nil {
+		return wrapErr(errors.Wrap(err, "newAwsClient")), nil
+	}
+	awsModelId := c.GetString("aws_model_id")
+	requests_, ok := c.Get("converted_request")
+	if !ok {
+		return wrapErr(errors.New("request not found")), nil
+	}
+	requests := requests_.([]any)
+
+	embeddingResp := dto.OpenAIEmbeddingResponse{
+		Object: "list",
+		Data:   make([]dto.OpenAIEmbeddingResponseItem, 0),
+		Model:  info.UpstreamModelName,
+	}
+	promptTokens := 0
+	for _, request := range requests {
+		body, err := json.Marshal(request)
+		if err != nil {
+			return wrapErr(errors.Wrap(err, "marshal request")), nil
+		}
+		awsResp, err := awsCli.InvokeModel(c.Request.Context(), &bedrockruntime.InvokeModelInput{
+			ModelId:     aws.String(awsModelId),
+			Accept:      aws.String("application/json"),
+			ContentType: aws.String("application/json"),
+			Body:        body,
+		})
+		if err != nil {
+			return wrapErr(errors.Wrap(err, "InvokeModel")), nil
+		}
+		if isAwsTitanEmbeddingModel(awsModelId) {
+			var titanResp AwsTitanEmbeddingResponse
+			if err = json.Unmarshal(awsResp.Body, &titanResp); err != nil {
+				return wrapErr(errors.Wrap(err, "unmarshal response")), nil
+			}
+			embeddingResp.Data = append(embeddingResp.Data, dto.OpenAIEmbeddingResponseItem{
+				Object:    "embedding",
+				Index:     len(embeddingResp.Data),
+				Embedding: titanResp.Embedding,
+			})
+			promptTokens += titanResp.InputTextTokenCount
+		} else {
+			var cohereResp AwsCohereEmbeddingResponse
+			if err = json.Unmarshal(awsResp.Body, &cohereResp); err != nil {
+				return wrapErr(errors.Wrap(err, "unmarshal response")), nil
+			}
+			for _, embedding := range cohereResp.Embeddings {
+				embeddingResp.Data = append(embeddingResp.Data, dto.OpenAIEmbeddingResponseItem{
+					Object:    "embedding",
+					Index:     len(embeddingResp.Data),
+					Embedding: embedding,
+				})
+			}
+		}
+	}
+	// Cohere 不返回用量，使用本地估算的 token 数
+	if promptTokens == 0 {
+		promptTokens = info.PromptTokens
+	}
+	usage := &dto.Usage{
+		PromptTokens: promptTokens,
+		TotalTokens:  promptTokens,
+	}
+	embeddingResp.Usage = *usage
+
+	jsonResponse, err := json.Marshal(embeddingResp)
+	if err != nil {
+		return wrapErr(errors.Wrap(err, "marshal response")), nil
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(http.StatusOK)
+	_, _ = c.Writer.Write(jsonResponse)
+	return nil, usage
+}
@@ -24,24 +24,32 @@ import (
 	"net/http"
 	"strings"
 	"veloera/common"
+	"veloera/constant"
 	"veloera/dto"
 	"veloera/relay/channel/claude"
 	relaycommon "veloera/relay/common"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
 )
 
-func newAwsClient(c *gin.Context, info *relaycommon.RelayInfo) (*bedrockruntime.Client, error) {
-	awsSecret := strings.Split(info.ApiKey, "|")
+// parseAwsSecret 渠道密钥格式为 AccessKey|SecretKey|Region
+func parseAwsSecret(apiKey string) (ak string, sk string, region string, err error) {
+	awsSecret := strings.Split(apiKey, "|")
 	if len(awsSecret) != 3 {
-		return nil, errors.New("invalid aws secret key")
+		return "", "", "", errors.New("invalid aws secret key")
+	}
+	return awsSecret[0], awsSecret[1], awsSecret[2], nil
+}
+
+func newAwsClient(c *gin.Context, info *relaycommon.RelayInfo) (*bedrockruntime.Client, error) {
+	ak, sk, region, err := parseAwsSecret(info.ApiKey)
+	if err != nil {
+		return nil, err
 	}
-	ak := awsSecret[0]
-	sk := awsSecret[1]
-	region := awsSecret[2]
 
 	options := bedrockruntime.Options{
 		Region:      region,
@@ -58,8 +66,14 @@ func newAwsClient(c *gin.Context, info *relaycommon.RelayInfo) (*bedrockruntime.
 }
 
 func wrapErr(err error) *dto.OpenAIErrorWithStatusCode {
+	statusCode := http.StatusInternalServerError
+	// 保留上游返回的状态码，便于按 429 等状态码重试和冷却
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() > 0 {
+		statusCode = respErr.HTTPStatusCode()
+	}
 	return &dto.OpenAIErrorWithStatusCode{
-		StatusCode: http.StatusInternalServerError,
+		StatusCode: statusCode,
 		Error: dto.OpenAIError{
 			Message: fmt.Sprintf("%s", err.Error()),
 		},
@@ -88,12 +102,31 @@ func awsModelCrossRegion(awsModelId, awsRegionPrefix string) string {
 	return modelPrefix + "." + awsModelId
 }
 
-func awsModelID(requestModel string) (string, error) {
+// awsModelID 渠道额外设置 aws_model_ids 中配置的模型 ID、推理配置文件 ID 或 ARN 优先
+func awsModelID(info *relaycommon.RelayInfo, requestModel string) (string, bool) {
+	if modelIds, ok := info.ChannelSetting[constant.ChannelSettingAwsModelIds].(map[string]interface{}); ok {
+		if awsModelID, ok := modelIds[requestModel].(string); ok && awsModelID != "" {
+			return awsModelID, true
+		}
+	}
 	if awsModelID, ok := awsModelIDMap[requestModel]; ok {
-		return awsModelID, nil
+		return awsModelID, false
 	}
 
-	return requestModel, nil
+	return requestModel, false
+}
+
+// awsRequestModelID 获取实际请求的模型 ID，未单独配置的模型在支持跨区域推理时加上区域前缀
+func awsRequestModelID(info *relaycommon.RelayInfo, requestModel string, region string) string {
+	awsModelId, configured := awsModelID(info, requestModel)
+	if configured {
+		return awsModelId
+	}
+	awsRegionPrefix := awsRegionPrefix(region)
+	if awsModelCanCrossRegion(awsModelId, awsRegionPrefix) {
+		awsModelId = awsModelCrossRegion(awsModelId, awsRegionPrefix)
+	}
+	return awsModelId
 }
 
 func awsHandler(c *gin.Context, info *relaycommon.RelayInfo, requestMode int) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
@@ -102,16 +135,7 @@ func awsHandler(c *gin.Context, info *relaycommon.RelayInfo, requestMode int) (*
 		return wrapErr(errors.Wrap(err, "newAwsClient")), nil
 	}
 
-	awsModelId, err := awsModelID(c.GetString("request_model"))
-	if err != nil {
-		return wrapErr(errors.Wrap(err, "awsModelID")), nil
-	}
-
-	awsRegionPrefix := awsRegionPrefix(awsCli.Options().Region)
-	canCrossRegion := awsModelCanCrossRegion(awsModelId, awsRegionPrefix)
-	if canCrossRegion {
-		awsModelId = awsModelCrossRegion(awsModelId, awsRegionPrefix)
-	}
+	awsModelId := awsRequestModelID(info, c.GetString("request_model"), awsCli.Options().Region)
 
 	awsReq := &bedrockruntime.InvokeModelInput{
 		ModelId:     aws.String(awsModelId),
@@ -153,16 +177,7 @@ func awsStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.Rel
 		return wrapErr(errors.Wrap(err, "newAwsClient")), nil
 	}
 
-	awsModelId, err := awsModelID(c.GetString("request_model"))
-	if err != nil {
-		return wrapErr(errors.Wrap(err, "awsModelID")), nil
-	}
-
-	awsRegionPrefix := awsRegionPrefix(awsCli.Options().Region)
-	canCrossRegion := awsModelCanCrossRegion(awsModelId, awsRegionPrefix)
-	if canCrossRegion {
-		awsModelId = awsModelCrossRegion(awsModelId, awsRegionPrefix)
-	}
+	awsModelId := awsRequestModelID(info, c.GetString("request_model"), awsCli.Options().Region)
 
 	awsReq := &bedrockruntime.InvokeModelWithResponseStreamInput{
 		ModelId:     aws.String(awsModelId),
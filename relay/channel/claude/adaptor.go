@@ -25,6 +25,7 @@ import (
 	"veloera/dto"
 	"veloera/relay/channel"
 	relaycommon "veloera/relay/common"
+	"veloera/service"
 	"veloera/setting/model_setting"
 )
 
@@ -74,6 +75,7 @@ func (a *Adaptor) ConvertOpenAIRequest(c *gin.Context, info *relaycommon.RelayIn
 	if request == nil {
 		return nil, errors.New("request is nil")
 	}
+	info.StructuredOutput = service.GetStructuredOutputSchema(request)
 	return RequestOpenAI2ClaudeMessage(*request)
 }
 
@@ -87,8 +89,7 @@ func (a *Adaptor) ConvertEmbeddingRequest(c *gin.Context, info *relaycommon.Rela
 }
 
 func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
-	// TODO implement me
-	return nil, errors.New("not implemented")
+	return channel.ConvertResponsesRequestByOpenAI(a, c, info, request)
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
@@ -104,6 +105,11 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 	return
 }
 
+func (a *Adaptor) CountClaudeTokens(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (int, error) {
+	fullRequestURL := fmt.Sprintf("%s/v1/messages/count_tokens", info.BaseUrl)
+	return channel.DoClaudeCountTokensRequest(a, c, info, fullRequestURL, request.ToCountTokensRequest())
+}
+
 func (a *Adaptor) GetModelList() []string {
 	return ModelList
 }
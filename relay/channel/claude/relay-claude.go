@@ -307,9 +307,43 @@ func RequestOpenAI2ClaudeMessage(textRequest dto.GeneralOpenAIRequest) (*dto.Cla
 	}
 	claudeRequest.Prompt = ""
 	claudeRequest.Messages = claudeMessages
+	if schema := service.GetStructuredOutputSchema(&textRequest); schema != nil {
+		applyStructuredOutput(&claudeRequest, schema, len(claudeTools) > 0)
+	}
 	return &claudeRequest, nil
 }
 
+// applyStructuredOutput 模拟 response_format json_schema：强制调用一个以 schema 为参数的工具，响应时再把工具参数还原为消息内容。
+// 开启思考时不允许强制调用工具，schema 不是对象时不能作为工具参数，这两种情况改为写入系统提示词
+func applyStructuredOutput(claudeRequest *dto.ClaudeRequest, schema *dto.FormatJsonSchema, hasTools bool) {
+	if claudeRequest.Thinking != nil || !service.IsObjectSchema(schema.Schema) {
+		instruction := service.StructuredOutputInstruction(schema)
+		if system, ok := claudeRequest.System.(string); ok && system != "" {
+			claudeRequest.System = system + "\n\n" + instruction
+		} else {
+			claudeRequest.System = instruction
+		}
+		return
+	}
+	description := schema.Description
+	if description == "" {
+		description = "Respond with the final answer as structured output."
+	}
+	tool := dto.Tool{
+		Name:        service.StructuredOutputToolName,
+		Description: description,
+		InputSchema: schema.Schema.(map[string]any),
+	}
+	claudeTools, _ := claudeRequest.Tools.([]dto.Tool)
+	claudeRequest.Tools = append(claudeTools, tool)
+	if hasTools {
+		// 客户端自己的工具仍然可以被调用
+		claudeRequest.ToolChoice = map[string]any{"type": "any"}
+	} else {
+		claudeRequest.ToolChoice = map[string]any{"type": "tool", "name": service.StructuredOutputToolName}
+	}
+}
+
 func StreamResponseClaude2OpenAI(reqMode int, claudeResponse *dto.ClaudeResponse) *dto.ChatCompletionsStreamResponse {
 	var response dto.ChatCompletionsStreamResponse
 	response.Object = "chat.completion.chunk"
@@ -403,11 +437,18 @@ func ResponseClaude2OpenAI(reqMode int, claudeResponse *dto.ClaudeResponse) *dto
 	}
 	tools := make([]dto.ToolCallResponse, 0)
 	thinkingContent := ""
+	structuredOutput := false
 	fullTextResponse.Id = claudeResponse.Id
 	for _, message := range claudeResponse.Content {
 		switch message.Type {
 		case "tool_use":
 			args, _ := json.Marshal(message.Input)
+			if message.Name == service.StructuredOutputToolName {
+				// 模拟 json_schema 的工具参数即为输出内容
+				responseText = string(args)
+				structuredOutput = true
+				continue
+			}
 			tools = append(tools, dto.ToolCallResponse{
 				ID:   message.Id,
 				Type: "function", // compatible with other OpenAI derivative applications
@@ -430,6 +471,9 @@ func ResponseClaude2OpenAI(reqMode int, claudeResponse *dto.ClaudeResponse) *dto
 		},
 		FinishReason: stopReasonClaude2OpenAI(claudeResponse.StopReason),
 	}
+	if structuredOutput && len(tools) == 0 {
+		choice.FinishReason = "stop"
+	}
 	choice.SetStringContent(responseText)
 	if len(responseThinking) > 0 {
 		choice.ReasoningContent = responseThinking
@@ -450,6 +494,49 @@ type ClaudeResponseInfo struct {
 	Model        string
 	ResponseText strings.Builder
 	Usage        *dto.Usage
+	// 模拟 json_schema 的工具调用所在的内容块，以及是否还有其他工具调用
+	structuredOutputIndex *int
+	hasToolCalls          bool
+}
+
+// unwrapStructuredOutputChunk 把模拟 json_schema 的工具调用增量转换为文本增量
+func unwrapStructuredOutputChunk(claudeResponse *dto.ClaudeResponse, response *dto.ChatCompletionsStreamResponse, claudeInfo *ClaudeResponseInfo) {
+	if response == nil || len(response.Choices) == 0 {
+		return
+	}
+	choice := &response.Choices[0]
+	switch claudeResponse.Type {
+	case "content_block_start":
+		if claudeResponse.ContentBlock == nil || claudeResponse.ContentBlock.Type != "tool_use" {
+			return
+		}
+		if claudeResponse.ContentBlock.Name != service.StructuredOutputToolName {
+			claudeInfo.hasToolCalls = true
+			return
+		}
+		index := 0
+		if claudeResponse.Index != nil {
+			index = *claudeResponse.Index
+		}
+		claudeInfo.structuredOutputIndex = &index
+		choice.Delta.ToolCalls = nil
+		choice.Delta.SetContentString("")
+	case "content_block_delta":
+		if claudeInfo.structuredOutputIndex == nil || claudeResponse.Index == nil || *claudeResponse.Index != *claudeInfo.structuredOutputIndex {
+			return
+		}
+		if claudeResponse.Delta == nil || claudeResponse.Delta.PartialJson == nil {
+			return
+		}
+		choice.Delta.ToolCalls = nil
+		choice.Delta.SetContentString(*claudeResponse.Delta.PartialJson)
+		claudeInfo.ResponseText.WriteString(*claudeResponse.Delta.PartialJson)
+	case "message_delta":
+		if claudeInfo.structuredOutputIndex != nil && !claudeInfo.hasToolCalls && choice.FinishReason != nil {
+			finishReason := "stop"
+			choice.FinishReason = &finishReason
+		}
+	}
 }
 
 func FormatClaudeResponseInfo(requestMode int, claudeResponse *dto.ClaudeResponse, oaiResponse *dto.ChatCompletionsStreamResponse, claudeInfo *ClaudeResponseInfo) bool {
@@ -518,6 +605,7 @@ func HandleStreamResponseData(c *gin.Context, info *relaycommon.RelayInfo, claud
 		helper.ClaudeChunkData(c, claudeResponse, data)
 	} else if info.RelayFormat == relaycommon.RelayFormatOpenAI {
 		response := StreamResponseClaude2OpenAI(requestMode, &claudeResponse)
+		unwrapStructuredOutputChunk(&claudeResponse, response, claudeInfo)
 
 		if !FormatClaudeResponseInfo(requestMode, &claudeResponse, response, claudeInfo) {
 			return nil
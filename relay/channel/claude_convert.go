@@ -0,0 +1,45 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package channel
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"veloera/dto"
+	"veloera/relay/common"
+	"veloera/service"
+)
+
+// ConvertClaudeRequestByOpenAI converts a Claude Messages request to chat completions
+// and hands it to the adaptor's OpenAI conversion, for adaptors without native Claude support.
+// The relay converts the OpenAI format response written by the adaptor back to Claude format.
+func ConvertClaudeRequestByOpenAI(a Adaptor, c *gin.Context, info *common.RelayInfo, request *dto.ClaudeRequest) (any, error) {
+	if request == nil {
+		return nil, errors.New("request is nil")
+	}
+	openAIRequest, err := service.ClaudeToOpenAIRequest(*request, info)
+	if err != nil {
+		return nil, err
+	}
+	info.SetClaudeOverOpenAI()
+	if openAIRequest.Stream && info.SupportStreamOptions {
+		openAIRequest.StreamOptions = &dto.StreamOptions{
+			IncludeUsage: true,
+		}
+	}
+	return a.ConvertOpenAIRequest(c, info, openAIRequest)
+}
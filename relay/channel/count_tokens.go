@@ -0,0 +1,69 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package channel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"veloera/dto"
+	relaycommon "veloera/relay/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClaudeTokenCounter 由能够从上游获取 Claude 精确 token 数的适配器实现
+type ClaudeTokenCounter interface {
+	CountClaudeTokens(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (int, error)
+}
+
+// DoClaudeCountTokensRequest 请求上游的 count_tokens 接口，返回 input_tokens
+func DoClaudeCountTokensRequest(a Adaptor, c *gin.Context, info *relaycommon.RelayInfo, fullRequestURL string, request any) (int, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return 0, fmt.Errorf("marshal count tokens request failed: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, fullRequestURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return 0, fmt.Errorf("new request failed: %w", err)
+	}
+	err = a.SetupRequestHeader(c, &req.Header, info)
+	if err != nil {
+		return 0, fmt.Errorf("setup request header failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := doRequest(c, req, info)
+	if err != nil {
+		return 0, fmt.Errorf("do request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("read response body failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("count tokens failed with status %d: %s", resp.StatusCode, string(responseBody))
+	}
+	var countResponse dto.ClaudeCountTokensResponse
+	if err = json.Unmarshal(responseBody, &countResponse); err != nil {
+		return 0, fmt.Errorf("unmarshal count tokens response failed: %w", err)
+	}
+	return countResponse.InputTokens, nil
+}
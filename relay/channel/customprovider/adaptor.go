@@ -0,0 +1,127 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package customprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"veloera/dto"
+	"veloera/relay/channel"
+	relaycommon "veloera/relay/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Adaptor struct {
+	config    *Config
+	configErr error
+}
+
+func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
+	a.config, a.configErr = GetConfig(info)
+}
+
+func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
+	if a.configErr != nil {
+		return "", a.configErr
+	}
+	return a.config.RequestURL(info)
+}
+
+func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Header, info *relaycommon.RelayInfo) error {
+	if a.configErr != nil {
+		return a.configErr
+	}
+	channel.SetupApiRequestHeader(info, c, req)
+	if a.config.Auth.Type == AuthTypeHeader {
+		value := info.ApiKey
+		if a.config.Auth.Scheme != "" {
+			value = a.config.Auth.Scheme + " " + value
+		}
+		req.Set(a.config.Auth.Header, value)
+	}
+	for k, v := range a.config.Headers {
+		req.Set(k, a.config.renderTemplate(v, info))
+	}
+	return nil
+}
+
+func (a *Adaptor) ConvertOpenAIRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest) (any, error) {
+	if request == nil {
+		return nil, errors.New("request is nil")
+	}
+	if a.configErr != nil {
+		return nil, a.configErr
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	body := make(map[string]any)
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	a.config.TransformRequest(body)
+	return body, nil
+}
+
+func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (any, error) {
+	return channel.ConvertClaudeRequestByOpenAI(a, c, info, request)
+}
+
+func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
+	return channel.ConvertResponsesRequestByOpenAI(a, c, info, request)
+}
+
+func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
+	return nil, errors.New("not supported by custom provider")
+}
+
+func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
+	return nil, errors.New("not supported by custom provider")
+}
+
+func (a *Adaptor) ConvertEmbeddingRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.EmbeddingRequest) (any, error) {
+	return nil, errors.New("not supported by custom provider")
+}
+
+func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dto.RerankRequest) (any, error) {
+	return nil, errors.New("not supported by custom provider")
+}
+
+func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	return channel.DoApiRequest(a, c, info, requestBody)
+}
+
+func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *dto.OpenAIErrorWithStatusCode) {
+	if info.IsStream {
+		err, usage = customProviderStreamHandler(c, resp, info, a.config)
+	} else {
+		err, usage = customProviderHandler(c, resp, info, a.config)
+	}
+	return
+}
+
+func (a *Adaptor) GetModelList() []string {
+	return ModelList
+}
+
+func (a *Adaptor) GetChannelName() string {
+	return ChannelName
+}
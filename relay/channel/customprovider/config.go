@@ -0,0 +1,451 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package customprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"veloera/constant"
+	relaycommon "veloera/relay/common"
+)
+
+const (
+	AuthTypeHeader = "header"
+	AuthTypeQuery  = "query"
+	AuthTypeNone   = "none"
+)
+
+// Config 自定义供应商渠道的声明式配置，保存在渠道设置的 custom_provider 字段中
+type Config struct {
+	URL       string            `json:"url"`
+	StreamURL string            `json:"stream_url,omitempty"`
+	Auth      AuthConfig        `json:"auth"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Request   RequestConfig     `json:"request"`
+	Response  ResponseConfig    `json:"response"`
+	Stream    StreamConfig      `json:"stream"`
+
+	response compiledResponse
+	stream   compiledResponse
+}
+
+type AuthConfig struct {
+	Type   string `json:"type,omitempty"`   // header（默认）、query 或 none
+	Header string `json:"header,omitempty"` // 默认 Authorization
+	Scheme string `json:"scheme,omitempty"` // 未指定 header 时默认为 Bearer
+	Query  string `json:"query,omitempty"`  // query 方式的参数名，默认 key
+}
+
+// RequestConfig 对 OpenAI 格式请求体的改写，按 defaults、rename、remove、override 的顺序执行
+type RequestConfig struct {
+	Defaults map[string]any    `json:"defaults,omitempty"`
+	Remove   []string          `json:"remove,omitempty"`
+	Rename   map[string]string `json:"rename,omitempty"`
+	Override map[string]any    `json:"override,omitempty"`
+}
+
+// ResponseConfig 各字段为 JSONPath，如 $.choices[0].message.content
+type ResponseConfig struct {
+	Id               string `json:"id,omitempty"`
+	Content          string `json:"content,omitempty"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+	FinishReason     string `json:"finish_reason,omitempty"`
+	PromptTokens     string `json:"prompt_tokens,omitempty"`
+	CompletionTokens string `json:"completion_tokens,omitempty"`
+	Error            string `json:"error,omitempty"`
+}
+
+type StreamConfig struct {
+	ResponseConfig
+	Done string `json:"done,omitempty"` // 结束标记，默认 [DONE]
+}
+
+type compiledResponse struct {
+	id               jsonPath
+	content          jsonPath
+	reasoningContent jsonPath
+	finishReason     jsonPath
+	promptTokens     jsonPath
+	completionTokens jsonPath
+	error            jsonPath
+}
+
+var defaultResponse = ResponseConfig{
+	Content:          "$.choices[0].message.content",
+	ReasoningContent: "$.choices[0].message.reasoning_content",
+	FinishReason:     "$.choices[0].finish_reason",
+	PromptTokens:     "$.usage.prompt_tokens",
+	CompletionTokens: "$.usage.completion_tokens",
+	Error:            "$.error",
+}
+
+var defaultStream = ResponseConfig{
+	Content:          "$.choices[0].delta.content",
+	ReasoningContent: "$.choices[0].delta.reasoning_content",
+	FinishReason:     "$.choices[0].finish_reason",
+	PromptTokens:     "$.usage.prompt_tokens",
+	CompletionTokens: "$.usage.completion_tokens",
+	Error:            "$.error",
+}
+
+// GetConfig 从渠道设置中读取并校验自定义供应商配置
+func GetConfig(info *relaycommon.RelayInfo) (*Config, error) {
+	raw, ok := info.ChannelSetting[constant.ChannelSettingCustomProvider]
+	if !ok || raw == nil {
+		return nil, errors.New("custom_provider is not configured in channel setting")
+	}
+	var data []byte
+	if s, ok := raw.(string); ok {
+		data = []byte(s)
+	} else {
+		var err error
+		data, err = json.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig 解析配置，补全默认值并预编译所有 JSONPath
+func ParseConfig(data []byte) (*Config, error) {
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid custom_provider config: %w", err)
+	}
+	if err := cfg.init(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (cfg *Config) init() error {
+	if cfg.URL == "" {
+		return errors.New("custom_provider.url is required")
+	}
+	for _, u := range []string{cfg.URL, cfg.StreamURL} {
+		if u == "" || strings.HasPrefix(u, "{base_url}") {
+			continue
+		}
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return fmt.Errorf("custom_provider url %q must start with {base_url} or http(s)://", u)
+		}
+	}
+	switch cfg.Auth.Type {
+	case "":
+		cfg.Auth.Type = AuthTypeHeader
+	case AuthTypeHeader, AuthTypeQuery, AuthTypeNone:
+	default:
+		return fmt.Errorf("custom_provider.auth.type %q is not one of header, query, none", cfg.Auth.Type)
+	}
+	if cfg.Auth.Type == AuthTypeHeader && cfg.Auth.Header == "" {
+		cfg.Auth.Header = "Authorization"
+		if cfg.Auth.Scheme == "" {
+			cfg.Auth.Scheme = "Bearer"
+		}
+	}
+	if cfg.Auth.Type == AuthTypeQuery && cfg.Auth.Query == "" {
+		cfg.Auth.Query = "key"
+	}
+	if cfg.Stream.Done == "" {
+		cfg.Stream.Done = "[DONE]"
+	}
+
+	for _, p := range cfg.Request.Remove {
+		if _, err := parseJSONPath(p); err != nil {
+			return fmt.Errorf("custom_provider.request.remove: %w", err)
+		}
+	}
+	for from, to := range cfg.Request.Rename {
+		if _, err := parseJSONPath(from); err != nil {
+			return fmt.Errorf("custom_provider.request.rename: %w", err)
+		}
+		if _, err := parseJSONPath(to); err != nil {
+			return fmt.Errorf("custom_provider.request.rename: %w", err)
+		}
+	}
+	for _, m := range []map[string]any{cfg.Request.Defaults, cfg.Request.Override} {
+		for p := range m {
+			if _, err := parseJSONPath(p); err != nil {
+				return fmt.Errorf("custom_provider.request: %w", err)
+			}
+		}
+	}
+
+	var err error
+	if cfg.response, err = compileResponse(cfg.Response, defaultResponse); err != nil {
+		return fmt.Errorf("custom_provider.response: %w", err)
+	}
+	if cfg.stream, err = compileResponse(cfg.Stream.ResponseConfig, defaultStream); err != nil {
+		return fmt.Errorf("custom_provider.stream: %w", err)
+	}
+	return nil
+}
+
+func compileResponse(rc ResponseConfig, def ResponseConfig) (compiledResponse, error) {
+	var out compiledResponse
+	fields := []struct {
+		name   string
+		path   string
+		def    string
+		target *jsonPath
+	}{
+		{"id", rc.Id, def.Id, &out.id},
+		{"content", rc.Content, def.Content, &out.content},
+		{"reasoning_content", rc.ReasoningContent, def.ReasoningContent, &out.reasoningContent},
+		{"finish_reason", rc.FinishReason, def.FinishReason, &out.finishReason},
+		{"prompt_tokens", rc.PromptTokens, def.PromptTokens, &out.promptTokens},
+		{"completion_tokens", rc.CompletionTokens, def.CompletionTokens, &out.completionTokens},
+		{"error", rc.Error, def.Error, &out.error},
+	}
+	for _, f := range fields {
+		p := f.path
+		if p == "" {
+			p = f.def
+		}
+		if p == "" || p == "-" {
+			// "-" 表示显式关闭该字段的提取
+			continue
+		}
+		compiled, err := parseJSONPath(p)
+		if err != nil {
+			return out, fmt.Errorf("%s: %w", f.name, err)
+		}
+		*f.target = compiled
+	}
+	return out, nil
+}
+
+func (cfg *Config) renderTemplate(tpl string, info *relaycommon.RelayInfo) string {
+	return strings.NewReplacer(
+		"{base_url}", strings.TrimSuffix(info.BaseUrl, "/"),
+		"{model}", info.UpstreamModelName,
+		"{api_version}", info.ApiVersion,
+		"{api_key}", info.ApiKey,
+	).Replace(tpl)
+}
+
+// RequestURL 渲染请求地址，query 鉴权时附加 key 参数
+func (cfg *Config) RequestURL(info *relaycommon.RelayInfo) (string, error) {
+	tpl := cfg.URL
+	if info.IsStream && cfg.StreamURL != "" {
+		tpl = cfg.StreamURL
+	}
+	fullURL := cfg.renderTemplate(tpl, info)
+	if cfg.Auth.Type != AuthTypeQuery {
+		return fullURL, nil
+	}
+	u, err := url.Parse(fullURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid custom_provider url: %w", err)
+	}
+	q := u.Query()
+	q.Set(cfg.Auth.Query, info.ApiKey)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// TransformRequest 按配置改写 OpenAI 格式请求体
+func (cfg *Config) TransformRequest(body map[string]any) {
+	for p, v := range cfg.Request.Defaults {
+		path, _ := parseJSONPath(p)
+		if _, ok := path.get(body); !ok {
+			path.set(body, v)
+		}
+	}
+	for from, to := range cfg.Request.Rename {
+		fromPath, _ := parseJSONPath(from)
+		toPath, _ := parseJSONPath(to)
+		if v, ok := fromPath.get(body); ok {
+			fromPath.remove(body)
+			toPath.set(body, v)
+		}
+	}
+	for _, p := range cfg.Request.Remove {
+		path, _ := parseJSONPath(p)
+		path.remove(body)
+	}
+	for p, v := range cfg.Request.Override {
+		path, _ := parseJSONPath(p)
+		path.set(body, v)
+	}
+}
+
+// jsonPath 支持 JSONPath 的一个子集：$.a.b[0].c，开头的 $. 可省略
+type jsonPath []pathSegment
+
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+func parseJSONPath(p string) (jsonPath, error) {
+	s := strings.TrimSpace(p)
+	s = strings.TrimPrefix(s, "$")
+	s = strings.TrimPrefix(s, ".")
+	if s == "" {
+		return nil, fmt.Errorf("empty path %q", p)
+	}
+	var path jsonPath
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			if s == "" || s[0] == '.' || s[0] == '[' {
+				return nil, fmt.Errorf("invalid path %q", p)
+			}
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed bracket in path %q", p)
+			}
+			idx, err := strconv.Atoi(s[1:end])
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("invalid index in path %q", p)
+			}
+			path = append(path, pathSegment{index: idx, isIndex: true})
+			s = s[end+1:]
+		default:
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			path = append(path, pathSegment{key: s[:end]})
+			s = s[end:]
+		}
+	}
+	return path, nil
+}
+
+func (p jsonPath) get(v any) (any, bool) {
+	if len(p) == 0 {
+		return nil, false
+	}
+	cur := v
+	for _, seg := range p {
+		if seg.isIndex {
+			arr, ok := cur.([]any)
+			if !ok || seg.index >= len(arr) {
+				return nil, false
+			}
+			cur = arr[seg.index]
+		} else {
+			obj, ok := cur.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if cur, ok = obj[seg.key]; !ok {
+				return nil, false
+			}
+		}
+	}
+	return cur, true
+}
+
+// set 写入值，缺失的对象层级会自动创建，数组下标必须已存在
+func (p jsonPath) set(root map[string]any, value any) bool {
+	var cur any = root
+	for i, seg := range p {
+		last := i == len(p)-1
+		if seg.isIndex {
+			arr, ok := cur.([]any)
+			if !ok || seg.index >= len(arr) {
+				return false
+			}
+			if last {
+				arr[seg.index] = value
+				return true
+			}
+			cur = arr[seg.index]
+			continue
+		}
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return false
+		}
+		if last {
+			obj[seg.key] = value
+			return true
+		}
+		next, ok := obj[seg.key]
+		if !ok || next == nil {
+			if p[i+1].isIndex {
+				return false
+			}
+			next = map[string]any{}
+			obj[seg.key] = next
+		}
+		cur = next
+	}
+	return false
+}
+
+func (p jsonPath) remove(root map[string]any) {
+	if len(p) == 0 || p[len(p)-1].isIndex {
+		return
+	}
+	var parent any = root
+	if len(p) > 1 {
+		var ok bool
+		if parent, ok = p[:len(p)-1].get(root); !ok {
+			return
+		}
+	}
+	if obj, ok := parent.(map[string]any); ok {
+		delete(obj, p[len(p)-1].key)
+	}
+}
+
+func (p jsonPath) getString(v any) (string, bool) {
+	value, ok := p.get(v)
+	if !ok || value == nil {
+		return "", false
+	}
+	switch val := value.(type) {
+	case string:
+		return val, true
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(val), true
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+func (p jsonPath) getInt(v any) (int, bool) {
+	value, ok := p.get(v)
+	if !ok {
+		return 0, false
+	}
+	switch val := value.(type) {
+	case float64:
+		return int(val), true
+	case string:
+		n, err := strconv.Atoi(val)
+		return n, err == nil
+	}
+	return 0, false
+}
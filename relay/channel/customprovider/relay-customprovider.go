@@ -0,0 +1,251 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package customprovider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/relay/channel/openai"
+	relaycommon "veloera/relay/common"
+	"veloera/relay/helper"
+	"veloera/service"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// upstreamError 按 error 路径提取上游错误，值为空时视为没有错误
+func (r compiledResponse) upstreamError(payload any) *dto.OpenAIError {
+	value, ok := r.error.get(payload)
+	if !ok || value == nil || value == false || value == "" {
+		return nil
+	}
+	openaiErr := &dto.OpenAIError{
+		Type: "upstream_error",
+	}
+	switch v := value.(type) {
+	case string:
+		openaiErr.Message = v
+	case map[string]any:
+		if msg, ok := v["message"].(string); ok {
+			openaiErr.Message = msg
+		}
+		if typ, ok := v["type"].(string); ok && typ != "" {
+			openaiErr.Type = typ
+		}
+		openaiErr.Code = v["code"]
+	}
+	if openaiErr.Message == "" {
+		data, _ := json.Marshal(value)
+		openaiErr.Message = string(data)
+	}
+	return openaiErr
+}
+
+func (r compiledResponse) usage(payload any) (*dto.Usage, bool) {
+	promptTokens, hasPrompt := r.promptTokens.getInt(payload)
+	completionTokens, hasCompletion := r.completionTokens.getInt(payload)
+	if !hasPrompt && !hasCompletion {
+		return nil, false
+	}
+	return &dto.Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}, true
+}
+
+// ConvertResponse 把上游的非流式响应按配置转换为 OpenAI 格式
+func (cfg *Config) ConvertResponse(body []byte, id string, model string) (*dto.OpenAITextResponse, *dto.OpenAIError, error) {
+	var payload any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, nil, err
+	}
+	if openaiErr := cfg.response.upstreamError(payload); openaiErr != nil {
+		return nil, openaiErr, nil
+	}
+	if v, ok := cfg.response.id.getString(payload); ok && v != "" {
+		id = v
+	}
+	message := dto.Message{
+		Role: "assistant",
+	}
+	content, _ := cfg.response.content.getString(payload)
+	message.SetStringContent(content)
+	message.ReasoningContent, _ = cfg.response.reasoningContent.getString(payload)
+	finishReason, ok := cfg.response.finishReason.getString(payload)
+	if !ok || finishReason == "" {
+		finishReason = "stop"
+	}
+	textResponse := &dto.OpenAITextResponse{
+		Id:      id,
+		Model:   model,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Choices: []dto.OpenAITextResponseChoice{
+			{
+				Index:        0,
+				Message:      message,
+				FinishReason: finishReason,
+			},
+		},
+	}
+	if usage, ok := cfg.response.usage(payload); ok {
+		textResponse.Usage = *usage
+	}
+	return textResponse, nil, nil
+}
+
+// ConvertStreamChunk 把上游的一个流式数据块按配置转换为 OpenAI 格式，没有可用内容时返回 nil
+func (cfg *Config) ConvertStreamChunk(data []byte, id string, createdAt int64, model string) (*dto.ChatCompletionsStreamResponse, *dto.OpenAIError, error) {
+	var payload any
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, nil, err
+	}
+	if openaiErr := cfg.stream.upstreamError(payload); openaiErr != nil {
+		return nil, openaiErr, nil
+	}
+	if v, ok := cfg.stream.id.getString(payload); ok && v != "" {
+		id = v
+	}
+	chunk := &dto.ChatCompletionsStreamResponse{
+		Id:      id,
+		Object:  "chat.completion.chunk",
+		Created: createdAt,
+		Model:   model,
+		Choices: []dto.ChatCompletionsStreamResponseChoice{},
+	}
+	choice := dto.ChatCompletionsStreamResponseChoice{}
+	hasChoice := false
+	if content, ok := cfg.stream.content.getString(payload); ok && content != "" {
+		choice.Delta.SetContentString(content)
+		hasChoice = true
+	}
+	if reasoning, ok := cfg.stream.reasoningContent.getString(payload); ok && reasoning != "" {
+		choice.Delta.SetReasoningContent(reasoning)
+		hasChoice = true
+	}
+	if finishReason, ok := cfg.stream.finishReason.getString(payload); ok && finishReason != "" {
+		choice.FinishReason = &finishReason
+		hasChoice = true
+	}
+	if hasChoice {
+		chunk.Choices = append(chunk.Choices, choice)
+	}
+	usage, hasUsage := cfg.stream.usage(payload)
+	if hasUsage {
+		chunk.Usage = usage
+	}
+	if !hasChoice && !hasUsage {
+		return nil, nil, nil
+	}
+	return chunk, nil, nil
+}
+
+func customProviderHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, cfg *Config) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	_ = resp.Body.Close()
+	textResponse, upstreamErr, err := cfg.ConvertResponse(responseBody, helper.GetResponseID(c), info.UpstreamModelName)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	if upstreamErr != nil {
+		return &dto.OpenAIErrorWithStatusCode{
+			Error:      *upstreamErr,
+			StatusCode: resp.StatusCode,
+		}, nil
+	}
+	data, err := json.Marshal(textResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	// 响应体已被改写，原有的长度和编码头不再适用
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.Header.Del("Content-Length")
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Set("Content-Type", "application/json")
+	return openai.OpenaiHandler(c, resp, info)
+}
+
+func customProviderStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, cfg *Config) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	upstream := resp.Body
+	reader, writer := io.Pipe()
+	resp.Body = reader
+	gopool.Go(func() {
+		err := translateStream(c, upstream, writer, info, cfg)
+		_ = upstream.Close()
+		_ = writer.CloseWithError(err)
+	})
+	return openai.OaiStreamHandler(c, resp, info)
+}
+
+// translateStream 读取上游的 SSE 或 NDJSON 流，转换为 OpenAI 格式的 SSE 写入 w
+func translateStream(c *gin.Context, upstream io.Reader, w io.Writer, info *relaycommon.RelayInfo, cfg *Config) error {
+	scanner := bufio.NewScanner(upstream)
+	scanner.Buffer(make([]byte, helper.InitialScannerBufferSize), helper.MaxScannerBufferSize)
+	responseId := helper.GetResponseID(c)
+	createdAt := time.Now().Unix()
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var payload string
+		if strings.HasPrefix(line, "data:") {
+			payload = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		} else if strings.HasPrefix(line, "{") {
+			payload = line
+		} else {
+			continue
+		}
+		if payload == cfg.Stream.Done {
+			break
+		}
+		chunk, upstreamErr, err := cfg.ConvertStreamChunk([]byte(payload), responseId, createdAt, info.UpstreamModelName)
+		if err != nil {
+			common.LogError(c, "error unmarshalling custom provider stream chunk: "+err.Error())
+			continue
+		}
+		if upstreamErr != nil {
+			common.LogError(c, "custom provider stream error: "+upstreamErr.Message)
+			break
+		}
+		if chunk == nil {
+			continue
+		}
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n", data); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "data: [DONE]\n")
+	return err
+}
@@ -17,15 +17,19 @@
 package gemini
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"veloera/common"
 	"veloera/dto"
 	"veloera/relay/channel"
 	relaycommon "veloera/relay/common"
+	relayconstant "veloera/relay/constant"
 	"veloera/service"
 	"veloera/setting/model_setting"
 
@@ -35,50 +39,93 @@ import (
 )
 
 type Adaptor struct {
+	ResponseFormat string
+	// 多条输入的向量请求使用 batchEmbedContents
+	batchEmbedding bool
 }
 
-func (a *Adaptor) ConvertClaudeRequest(*gin.Context, *relaycommon.RelayInfo, *dto.ClaudeRequest) (any, error) {
-	//TODO implement me
-	panic("implement me")
-	return nil, nil
+func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (any, error) {
+	return channel.ConvertClaudeRequestByOpenAI(a, c, info, request)
 }
 
 func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
-	//TODO implement me
-	return nil, errors.New("not implemented")
+	a.ResponseFormat = request.ResponseFormat
+	geminiRequest, err := BuildGeminiAudioRequest(c, info, request)
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := json.Marshal(geminiRequest)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling object: %w", err)
+	}
+	return bytes.NewReader(jsonData), nil
 }
 
 func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
+	if info.RelayMode != relayconstant.RelayModeImagesGenerations {
+		return nil, errors.New("image edits and variations are not supported")
+	}
 	if !strings.HasPrefix(info.UpstreamModelName, "imagen") {
 		return nil, errors.New("not supported model for image generation")
 	}
+	return BuildImagenRequest(request), nil
+}
 
-	// convert size to aspect ratio
-	aspectRatio := "1:1" // default aspect ratio
-	switch request.Size {
-	case "1024x1024":
-		aspectRatio = "1:1"
-	case "1024x1792":
-		aspectRatio = "9:16"
-	case "1792x1024":
-		aspectRatio = "16:9"
-	}
+// imagenAspectRatios Imagen 支持的宽高比
+var imagenAspectRatios = []string{"1:1", "3:4", "4:3", "9:16", "16:9"}
 
-	// build gemini imagen request
-	geminiRequest := GeminiImageRequest{
+// imagenMaxSampleCount Imagen 单次请求最多生成的图片数量
+const imagenMaxSampleCount = 4
+
+// BuildImagenRequest 构造 Imagen predict 请求，AI Studio 和 Vertex AI 共用
+func BuildImagenRequest(request dto.ImageRequest) *GeminiImageRequest {
+	sampleCount := request.N
+	if sampleCount < 1 {
+		sampleCount = 1
+	} else if sampleCount > imagenMaxSampleCount {
+		sampleCount = imagenMaxSampleCount
+	}
+	return &GeminiImageRequest{
 		Instances: []GeminiImageInstance{
 			{
 				Prompt: request.Prompt,
 			},
 		},
 		Parameters: GeminiImageParameters{
-			SampleCount:      request.N,
-			AspectRatio:      aspectRatio,
+			SampleCount:      sampleCount,
+			AspectRatio:      imagenAspectRatio(request.Size),
 			PersonGeneration: "allow_adult", // default allow adult
 		},
 	}
+}
 
-	return geminiRequest, nil
+// imagenAspectRatio 将 OpenAI 的 size（如 1792x1024）或宽高比（如 16:9）映射为最接近的 Imagen 宽高比
+func imagenAspectRatio(size string) string {
+	sep := "x"
+	if strings.Contains(size, ":") {
+		sep = ":"
+	}
+	parts := strings.Split(strings.ToLower(size), sep)
+	if len(parts) != 2 {
+		return "1:1"
+	}
+	width, errW := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	height, errH := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if errW != nil || errH != nil || width <= 0 || height <= 0 {
+		return "1:1"
+	}
+	target := math.Log(width / height)
+	best := "1:1"
+	bestDiff := math.Inf(1)
+	for _, ratio := range imagenAspectRatios {
+		var w, h float64
+		_, _ = fmt.Sscanf(ratio, "%f:%f", &w, &h)
+		if diff := math.Abs(math.Log(w/h) - target); diff < bestDiff {
+			best = ratio
+			bestDiff = diff
+		}
+	}
+	return best
 }
 
 func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
@@ -97,6 +144,22 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 
 	version := model_setting.GetGeminiVersionSetting(info.UpstreamModelName)
 
+	if info.RelayMode == relayconstant.RelayModeRealtime {
+		// Gemini Live 使用 websocket 连接
+		baseUrl := strings.Replace(info.BaseUrl, "https://", "wss://", 1)
+		baseUrl = strings.Replace(baseUrl, "http://", "ws://", 1)
+		return fmt.Sprintf("%s/ws/google.ai.generativelanguage.%s.GenerativeService.BidiGenerateContent", baseUrl, version), nil
+	}
+
+	if info.RelayFormat == relaycommon.RelayFormatGemini {
+		switch info.RelayMode {
+		case relayconstant.RelayModeGeminiCountTokens:
+			return fmt.Sprintf("%s/%s/models/%s:countTokens", info.BaseUrl, version, info.UpstreamModelName), nil
+		case relayconstant.RelayModeEmbeddings:
+			return fmt.Sprintf("%s/%s/models/%s:embedContent", info.BaseUrl, version, info.UpstreamModelName), nil
+		}
+	}
+
 	if strings.HasPrefix(info.UpstreamModelName, "imagen") {
 		return fmt.Sprintf("%s/%s/models/%s:predict", info.BaseUrl, version, info.UpstreamModelName), nil
 	}
@@ -104,6 +167,9 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 	if strings.HasPrefix(info.UpstreamModelName, "text-embedding") ||
 		strings.HasPrefix(info.UpstreamModelName, "embedding") ||
 		strings.HasPrefix(info.UpstreamModelName, "gemini-embedding") {
+		if a.batchEmbedding {
+			return fmt.Sprintf("%s/%s/models/%s:batchEmbedContents", info.BaseUrl, version, info.UpstreamModelName), nil
+		}
 		return fmt.Sprintf("%s/%s/models/%s:embedContent", info.BaseUrl, version, info.UpstreamModelName), nil
 	}
 
@@ -116,6 +182,9 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 
 func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Header, info *relaycommon.RelayInfo) error {
 	channel.SetupApiRequestHeader(info, c, req)
+	if IsAudioRelayMode(info.RelayMode) {
+		req.Set("Content-Type", "application/json")
+	}
 	req.Set("x-goog-api-key", info.ApiKey)
 	return nil
 }
@@ -147,40 +216,64 @@ func (a *Adaptor) ConvertEmbeddingRequest(c *gin.Context, info *relaycommon.Rela
 		return nil, errors.New("input is empty")
 	}
 
-	// only process the first input
-	geminiRequest := GeminiEmbeddingRequest{
-		Content: GeminiChatContent{
-			Parts: []GeminiPart{
-				{
-					Text: inputs[0],
-				},
-			},
-		},
-	}
-
+	dimensions := 0
 	// set specific parameters for different models
 	// https://ai.google.dev/api/embeddings?hl=zh-cn#method:-models.embedcontent
-	switch info.UpstreamModelName {
-	case "text-embedding-004":
+	if info.UpstreamModelName == "text-embedding-004" || strings.HasPrefix(info.UpstreamModelName, "gemini-embedding") {
 		// except embedding-001 supports setting `OutputDimensionality`
-		if request.Dimensions > 0 {
-			geminiRequest.OutputDimensionality = request.Dimensions
+		dimensions = request.Dimensions
+	}
+
+	geminiRequests := make([]GeminiEmbeddingRequest, 0, len(inputs))
+	for _, input := range inputs {
+		geminiRequest := GeminiEmbeddingRequest{
+			Content: GeminiChatContent{
+				Parts: []GeminiPart{
+					{
+						Text: input,
+					},
+				},
+			},
+			OutputDimensionality: dimensions,
+		}
+		if len(inputs) > 1 {
+			geminiRequest.Model = "models/" + info.UpstreamModelName
 		}
+		geminiRequests = append(geminiRequests, geminiRequest)
+	}
+	if len(geminiRequests) == 1 {
+		return geminiRequests[0], nil
 	}
 
-	return geminiRequest, nil
+	a.batchEmbedding = true
+	return GeminiBatchEmbeddingRequest{Requests: geminiRequests}, nil
 }
 
 func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
-	// TODO implement me
-	return nil, errors.New("not implemented")
+	return channel.ConvertResponsesRequestByOpenAI(a, c, info, request)
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
+	if info.RelayMode == relayconstant.RelayModeRealtime {
+		return channel.DoWssRequest(a, c, info, requestBody)
+	}
 	return channel.DoApiRequest(a, c, info, requestBody)
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *dto.OpenAIErrorWithStatusCode) {
+	if info.RelayFormat == relaycommon.RelayFormatGemini {
+		return GeminiNativeResponseHandler(c, resp, info)
+	}
+
+	if info.RelayMode == relayconstant.RelayModeRealtime {
+		err, usage = GeminiRealtimeHandler(c, info)
+		return
+	}
+
+	if IsAudioRelayMode(info.RelayMode) {
+		return GeminiAudioHandler(c, resp, info, a.ResponseFormat)
+	}
+
 	if strings.HasPrefix(info.UpstreamModelName, "imagen") {
 		return GeminiImageHandler(c, resp, info)
 	}
@@ -34,6 +34,9 @@ var ModelList = []string{
 	"gemini-2.0-flash-thinking-exp",
 	"gemini-2.5-pro-exp-03-25",
 	"gemini-2.5-pro-preview-03-25",
+	// live models
+	"gemini-2.0-flash-live-001",
+	"gemini-live-2.5-flash-preview",
 	// imagen models
 	"imagen-3.0-generate-002",
 	// embedding models
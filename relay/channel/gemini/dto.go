@@ -16,14 +16,52 @@
 // along with this program. If not, see <https://www.gnu.org/licenses/>.
 package gemini
 
+import "encoding/json"
+
 type GeminiChatRequest struct {
 	Contents           []GeminiChatContent        `json:"contents"`
 	SafetySettings     []GeminiChatSafetySettings `json:"safety_settings,omitempty"`
 	GenerationConfig   GeminiChatGenerationConfig `json:"generation_config,omitempty"`
 	Tools              []GeminiChatTool           `json:"tools,omitempty"`
+	ToolConfig         *GeminiToolConfig          `json:"toolConfig,omitempty"`
 	SystemInstructions *GeminiChatContent         `json:"system_instruction,omitempty"`
 }
 
+// UnmarshalJSON accepts both the snake_case fields and the camelCase fields
+// sent by the official Gemini SDKs.
+func (r *GeminiChatRequest) UnmarshalJSON(data []byte) error {
+	type Alias GeminiChatRequest
+	var aux struct {
+		Alias
+		SafetySettingsCamel    []GeminiChatSafetySettings  `json:"safetySettings"`
+		GenerationConfigCamel  *GeminiChatGenerationConfig `json:"generationConfig"`
+		SystemInstructionCamel *GeminiChatContent          `json:"systemInstruction"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*r = GeminiChatRequest(aux.Alias)
+	if len(aux.SafetySettingsCamel) > 0 {
+		r.SafetySettings = aux.SafetySettingsCamel
+	}
+	if aux.GenerationConfigCamel != nil {
+		r.GenerationConfig = *aux.GenerationConfigCamel
+	}
+	if aux.SystemInstructionCamel != nil {
+		r.SystemInstructions = aux.SystemInstructionCamel
+	}
+	return nil
+}
+
+type GeminiToolConfig struct {
+	FunctionCallingConfig *GeminiFunctionCallingConfig `json:"functionCallingConfig,omitempty"`
+}
+
+type GeminiFunctionCallingConfig struct {
+	Mode                 string   `json:"mode,omitempty"`
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
 type GeminiThinkingConfig struct {
 	IncludeThoughts bool `json:"includeThoughts,omitempty"`
 	ThinkingBudget  *int `json:"thinkingBudget,omitempty"`
@@ -48,6 +86,24 @@ type GeminiFunctionResponseContent struct {
 	Content any    `json:"content"`
 }
 
+// UnmarshalJSON keeps the arbitrary response object sent by Gemini clients,
+// which is not limited to the name/content shape used for OpenAI tool messages.
+func (r *GeminiFunctionResponseContent) UnmarshalJSON(data []byte) error {
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if name, ok := raw["name"].(string); ok {
+		r.Name = name
+	}
+	if content, ok := raw["content"]; ok && len(raw) <= 2 {
+		r.Content = content
+	} else {
+		r.Content = raw
+	}
+	return nil
+}
+
 type FunctionResponse struct {
 	Name     string                        `json:"name"`
 	Response GeminiFunctionResponseContent `json:"response"`
@@ -130,6 +186,7 @@ type GeminiChatResponse struct {
 	Candidates     []GeminiChatCandidate    `json:"candidates"`
 	PromptFeedback GeminiChatPromptFeedback `json:"promptFeedback"`
 	UsageMetadata  GeminiUsageMetadata      `json:"usageMetadata"`
+	ModelVersion   string                   `json:"modelVersion,omitempty"`
 }
 
 type GeminiUsageMetadata struct {
@@ -168,6 +225,7 @@ type GeminiImagePrediction struct {
 
 // Embedding related structs
 type GeminiEmbeddingRequest struct {
+	Model                string            `json:"model,omitempty"`
 	Content              GeminiChatContent `json:"content"`
 	TaskType             string            `json:"taskType,omitempty"`
 	Title                string            `json:"title,omitempty"`
@@ -176,8 +234,128 @@ type GeminiEmbeddingRequest struct {
 
 type GeminiEmbeddingResponse struct {
 	Embedding ContentEmbedding `json:"embedding"`
+	// batchEmbedContents 的返回结果
+	Embeddings []ContentEmbedding `json:"embeddings,omitempty"`
+}
+
+type GeminiBatchEmbeddingRequest struct {
+	Requests []GeminiEmbeddingRequest `json:"requests"`
 }
 
 type ContentEmbedding struct {
 	Values []float64 `json:"values"`
 }
+
+type GeminiCountTokensResponse struct {
+	TotalTokens int `json:"totalTokens"`
+}
+
+// Gemini Live (BidiGenerateContent) related structs
+// https://ai.google.dev/api/live
+type GeminiLiveClientMessage struct {
+	Setup         *GeminiLiveSetup         `json:"setup,omitempty"`
+	ClientContent *GeminiLiveClientContent `json:"clientContent,omitempty"`
+	RealtimeInput *GeminiLiveRealtimeInput `json:"realtimeInput,omitempty"`
+	ToolResponse  *GeminiLiveToolResponse  `json:"toolResponse,omitempty"`
+}
+
+type GeminiLiveSetup struct {
+	Model                    string                         `json:"model"`
+	GenerationConfig         *GeminiLiveGenerationConfig    `json:"generationConfig,omitempty"`
+	SystemInstruction        *GeminiChatContent             `json:"systemInstruction,omitempty"`
+	Tools                    []GeminiChatTool               `json:"tools,omitempty"`
+	RealtimeInputConfig      *GeminiLiveRealtimeInputConfig `json:"realtimeInputConfig,omitempty"`
+	InputAudioTranscription  *struct{}                      `json:"inputAudioTranscription,omitempty"`
+	OutputAudioTranscription *struct{}                      `json:"outputAudioTranscription,omitempty"`
+}
+
+type GeminiLiveGenerationConfig struct {
+	ResponseModalities []string                `json:"responseModalities,omitempty"`
+	Temperature        *float64                `json:"temperature,omitempty"`
+	SpeechConfig       *GeminiLiveSpeechConfig `json:"speechConfig,omitempty"`
+}
+
+type GeminiLiveSpeechConfig struct {
+	VoiceConfig GeminiLiveVoiceConfig `json:"voiceConfig"`
+}
+
+type GeminiLiveVoiceConfig struct {
+	PrebuiltVoiceConfig GeminiLivePrebuiltVoiceConfig `json:"prebuiltVoiceConfig"`
+}
+
+type GeminiLivePrebuiltVoiceConfig struct {
+	VoiceName string `json:"voiceName"`
+}
+
+type GeminiLiveRealtimeInputConfig struct {
+	AutomaticActivityDetection GeminiLiveActivityDetection `json:"automaticActivityDetection"`
+}
+
+type GeminiLiveActivityDetection struct {
+	Disabled bool `json:"disabled"`
+}
+
+type GeminiLiveClientContent struct {
+	Turns        []GeminiChatContent `json:"turns,omitempty"`
+	TurnComplete bool                `json:"turnComplete"`
+}
+
+type GeminiLiveRealtimeInput struct {
+	Audio         *GeminiInlineData `json:"audio,omitempty"`
+	ActivityStart *struct{}         `json:"activityStart,omitempty"`
+	ActivityEnd   *struct{}         `json:"activityEnd,omitempty"`
+}
+
+type GeminiLiveToolResponse struct {
+	FunctionResponses []GeminiLiveFunctionResponse `json:"functionResponses"`
+}
+
+type GeminiLiveFunctionResponse struct {
+	Id       string `json:"id,omitempty"`
+	Name     string `json:"name"`
+	Response any    `json:"response"`
+}
+
+type GeminiLiveServerMessage struct {
+	SetupComplete *struct{}                `json:"setupComplete,omitempty"`
+	ServerContent *GeminiLiveServerContent `json:"serverContent,omitempty"`
+	ToolCall      *GeminiLiveToolCall      `json:"toolCall,omitempty"`
+	UsageMetadata *GeminiLiveUsageMetadata `json:"usageMetadata,omitempty"`
+}
+
+type GeminiLiveServerContent struct {
+	ModelTurn           *GeminiChatContent       `json:"modelTurn,omitempty"`
+	TurnComplete        bool                     `json:"turnComplete,omitempty"`
+	Interrupted         bool                     `json:"interrupted,omitempty"`
+	InputTranscription  *GeminiLiveTranscription `json:"inputTranscription,omitempty"`
+	OutputTranscription *GeminiLiveTranscription `json:"outputTranscription,omitempty"`
+}
+
+type GeminiLiveTranscription struct {
+	Text string `json:"text"`
+}
+
+type GeminiLiveToolCall struct {
+	FunctionCalls []GeminiLiveFunctionCall `json:"functionCalls"`
+}
+
+type GeminiLiveFunctionCall struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Args any    `json:"args"`
+}
+
+type GeminiLiveUsageMetadata struct {
+	PromptTokenCount        int                        `json:"promptTokenCount"`
+	CachedContentTokenCount int                        `json:"cachedContentTokenCount"`
+	ResponseTokenCount      int                        `json:"responseTokenCount"`
+	ThoughtsTokenCount      int                        `json:"thoughtsTokenCount"`
+	TotalTokenCount         int                        `json:"totalTokenCount"`
+	PromptTokensDetails     []GeminiModalityTokenCount `json:"promptTokensDetails"`
+	ResponseTokensDetails   []GeminiModalityTokenCount `json:"responseTokensDetails"`
+}
+
+type GeminiModalityTokenCount struct {
+	Modality   string `json:"modality"`
+	TokenCount int    `json:"tokenCount"`
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package gemini
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"veloera/dto"
+	relaycommon "veloera/relay/common"
+	relayconstant "veloera/relay/constant"
+	"veloera/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 通过多模态音频输入实现的 Whisper 兼容响应格式，srt/vtt 需要时间轴，Gemini 无法可靠提供
+var supportedAudioResponseFormats = map[string]bool{
+	"json":         true,
+	"text":         true,
+	"verbose_json": true,
+}
+
+var audioMimeTypes = map[string]string{
+	".mp3":  "audio/mp3",
+	".mpga": "audio/mp3",
+	".mpeg": "audio/mp3",
+	".wav":  "audio/wav",
+	".aiff": "audio/aiff",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".flac": "audio/flac",
+	".m4a":  "audio/mp4",
+	".mp4":  "audio/mp4",
+	".webm": "audio/webm",
+}
+
+func IsAudioRelayMode(relayMode int) bool {
+	return relayMode == relayconstant.RelayModeAudioTranscription || relayMode == relayconstant.RelayModeAudioTranslation
+}
+
+// BuildGeminiAudioRequest 将 /v1/audio/transcriptions 和 /v1/audio/translations 的表单请求
+// 转换为携带内联音频的 generateContent 请求
+func BuildGeminiAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (*GeminiChatRequest, error) {
+	if !IsAudioRelayMode(info.RelayMode) {
+		return nil, errors.New("text to speech is not supported")
+	}
+	if !supportedAudioResponseFormats[request.ResponseFormat] {
+		return nil, fmt.Errorf("response_format %s is not supported, use json, text or verbose_json", request.ResponseFormat)
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		return nil, errors.New("file is required")
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, errors.New("read file failed")
+	}
+
+	instruction := "Generate a verbatim transcript of the speech in this audio. Output only the transcript text, without any commentary, labels or timestamps."
+	if info.RelayMode == relayconstant.RelayModeAudioTranslation {
+		instruction = "Translate the speech in this audio into English. Output only the English translation, without any commentary, labels or timestamps."
+	} else if language := c.Request.FormValue("language"); language != "" {
+		instruction += fmt.Sprintf(" The spoken language is %s.", language)
+	}
+	if prompt := c.Request.FormValue("prompt"); prompt != "" {
+		instruction += "\nUse the following text as context for spelling and style: " + prompt
+	}
+
+	geminiRequest := &GeminiChatRequest{
+		Contents: []GeminiChatContent{
+			{
+				Role: "user",
+				Parts: []GeminiPart{
+					{
+						InlineData: &GeminiInlineData{
+							MimeType: audioMimeType(header.Filename, header.Header.Get("Content-Type")),
+							Data:     base64.StdEncoding.EncodeToString(data),
+						},
+					},
+					{
+						Text: instruction,
+					},
+				},
+			},
+		},
+	}
+	for _, category := range SafetySettingList {
+		geminiRequest.SafetySettings = append(geminiRequest.SafetySettings, GeminiChatSafetySettings{
+			Category:  category,
+			Threshold: model_setting.GetGeminiSafetySetting(category),
+		})
+	}
+	if temperature, err := strconv.ParseFloat(c.Request.FormValue("temperature"), 64); err == nil {
+		geminiRequest.GenerationConfig.Temperature = &temperature
+	}
+	return geminiRequest, nil
+}
+
+func audioMimeType(filename string, contentType string) string {
+	if strings.HasPrefix(contentType, "audio/") {
+		return contentType
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	if mimeType, ok := audioMimeTypes[ext]; ok {
+		return mimeType
+	}
+	if mimeType := mime.TypeByExtension(ext); mimeType != "" {
+		return mimeType
+	}
+	return "audio/mp3"
+}
+
+// GeminiAudioHandler 将 generateContent 的结果转换为 Whisper 格式响应，按 usageMetadata 计费
+func GeminiAudioHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, responseFormat string) (usage any, err *dto.OpenAIErrorWithStatusCode) {
+	geminiResponse, errResp := parseGeminiChatResponse(resp)
+	if errResp != nil {
+		return nil, errResp
+	}
+
+	var text strings.Builder
+	for _, part := range geminiResponse.Candidates[0].Content.Parts {
+		if part.Thought {
+			continue
+		}
+		text.WriteString(part.Text)
+	}
+	transcript := strings.TrimSpace(text.String())
+
+	var body []byte
+	contentType := "application/json"
+	switch responseFormat {
+	case "text":
+		body = []byte(transcript)
+		contentType = "text/plain; charset=utf-8"
+	case "verbose_json":
+		task := "transcribe"
+		if info.RelayMode == relayconstant.RelayModeAudioTranslation {
+			task = "translate"
+		}
+		body, _ = json.Marshal(dto.WhisperVerboseJSONResponse{
+			Task:     task,
+			Language: c.Request.FormValue("language"),
+			Text:     transcript,
+		})
+	default:
+		body, _ = json.Marshal(dto.AudioResponse{Text: transcript})
+	}
+
+	c.Writer.Header().Set("Content-Type", contentType)
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, _ = c.Writer.Write(body)
+
+	return buildGeminiUsage(geminiResponse), nil
+}
@@ -0,0 +1,595 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package gemini
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"veloera/common"
+	"veloera/dto"
+	relaycommon "veloera/relay/common"
+	relayconstant "veloera/relay/constant"
+	"veloera/relay/helper"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GeminiNativeResponseHandler relays the upstream response of a native Gemini
+// request (RelayFormatGemini) back to the client unchanged and extracts usage.
+func GeminiNativeResponseHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *dto.OpenAIErrorWithStatusCode) {
+	switch info.RelayMode {
+	case relayconstant.RelayModeGeminiCountTokens, relayconstant.RelayModeEmbeddings:
+		return geminiPassthroughHandler(c, resp, info)
+	}
+	if info.IsStream {
+		err, usage = GeminiNativeStreamHandler(c, resp, info)
+	} else {
+		err, usage = GeminiNativeHandler(c, resp, info)
+	}
+	return
+}
+
+func GeminiNativeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	usage := &dto.Usage{}
+	helper.StreamScannerHandler(c, resp, info, func(data string) bool {
+		var geminiResponse GeminiChatResponse
+		err := common.DecodeJsonStr(data, &geminiResponse)
+		if err != nil {
+			common.LogError(c, "error unmarshalling stream response: "+err.Error())
+			return false
+		}
+		if geminiResponse.UsageMetadata.TotalTokenCount != 0 {
+			usage = buildGeminiUsage(&geminiResponse)
+		}
+		err = helper.StringData(c, data)
+		if err != nil {
+			common.LogError(c, err.Error())
+		}
+		return true
+	})
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = info.PromptTokens
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return nil, usage
+}
+
+func GeminiNativeHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var geminiResponse GeminiChatResponse
+	err = json.Unmarshal(responseBody, &geminiResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	usage := buildGeminiUsage(&geminiResponse)
+	if usage.TotalTokens == 0 {
+		usage.PromptTokens = info.PromptTokens
+		usage.TotalTokens = info.PromptTokens
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, _ = c.Writer.Write(responseBody)
+	return nil, usage
+}
+
+// geminiPassthroughHandler writes countTokens and embedContent responses unchanged,
+// both are billed by the locally counted prompt tokens.
+func geminiPassthroughHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *dto.OpenAIErrorWithStatusCode) {
+	responseBody, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		return nil, service.OpenAIErrorWrapper(readErr, "read_response_body_failed", http.StatusInternalServerError)
+	}
+	_ = resp.Body.Close()
+
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, _ = c.Writer.Write(responseBody)
+
+	usage = &dto.Usage{
+		PromptTokens: info.PromptTokens,
+		TotalTokens:  info.PromptTokens,
+	}
+	return usage, nil
+}
+
+// IsGeminiNativeChannel reports whether the channel accepts Gemini requests as is
+func IsGeminiNativeChannel(info *relaycommon.RelayInfo) bool {
+	switch info.ChannelType {
+	case common.ChannelTypeGemini:
+		return true
+	case common.ChannelTypeVertexAi:
+		return info.RelayMode == relayconstant.RelayModeChatCompletions &&
+			strings.HasPrefix(info.UpstreamModelName, "gemini")
+	}
+	return false
+}
+
+type geminiFunctionDeclaration struct {
+	Name                 string `json:"name"`
+	Description          string `json:"description,omitempty"`
+	Parameters           any    `json:"parameters,omitempty"`
+	ParametersJsonSchema any    `json:"parametersJsonSchema,omitempty"`
+}
+
+// GeminiToOpenAIRequest converts a native Gemini generateContent request into a
+// chat completions request for channels that do not speak Gemini.
+func GeminiToOpenAIRequest(geminiRequest *GeminiChatRequest, info *relaycommon.RelayInfo) (*dto.GeneralOpenAIRequest, error) {
+	config := geminiRequest.GenerationConfig
+	openAIRequest := &dto.GeneralOpenAIRequest{
+		Model:       info.UpstreamModelName,
+		Stream:      info.IsStream,
+		Temperature: config.Temperature,
+		TopP:        config.TopP,
+		TopK:        int(config.TopK),
+		MaxTokens:   config.MaxOutputTokens,
+		Seed:        float64(config.Seed),
+	}
+	if config.CandidateCount > 1 {
+		openAIRequest.N = config.CandidateCount
+	}
+	if len(config.StopSequences) > 0 {
+		openAIRequest.Stop = config.StopSequences
+	}
+	if config.ResponseMimeType == "application/json" {
+		if config.ResponseSchema != nil {
+			openAIRequest.ResponseFormat = &dto.ResponseFormat{
+				Type: "json_schema",
+				JsonSchema: &dto.FormatJsonSchema{
+					Name:   "response",
+					Schema: schemaGemini2OpenAI(config.ResponseSchema),
+				},
+			}
+		} else {
+			openAIRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+		}
+	}
+
+	// Convert tools
+	for _, tool := range geminiRequest.Tools {
+		if tool.FunctionDeclarations == nil {
+			continue
+		}
+		declarations, err := common.Any2Type[[]geminiFunctionDeclaration](tool.FunctionDeclarations)
+		if err != nil {
+			return nil, fmt.Errorf("invalid function declarations: %w", err)
+		}
+		for _, declaration := range declarations {
+			parameters := declaration.ParametersJsonSchema
+			if parameters == nil {
+				parameters = schemaGemini2OpenAI(declaration.Parameters)
+			}
+			openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCallRequest{
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:        declaration.Name,
+					Description: declaration.Description,
+					Parameters:  parameters,
+				},
+			})
+		}
+	}
+	if len(openAIRequest.Tools) > 0 && geminiRequest.ToolConfig != nil && geminiRequest.ToolConfig.FunctionCallingConfig != nil {
+		openAIRequest.ToolChoice = toolChoiceGemini2OpenAI(geminiRequest.ToolConfig.FunctionCallingConfig)
+	}
+
+	// Convert messages
+	openAIMessages := make([]dto.Message, 0, len(geminiRequest.Contents)+1)
+	if geminiRequest.SystemInstructions != nil {
+		systemTexts := make([]string, 0, len(geminiRequest.SystemInstructions.Parts))
+		for _, part := range geminiRequest.SystemInstructions.Parts {
+			if part.Text != "" {
+				systemTexts = append(systemTexts, part.Text)
+			}
+		}
+		if len(systemTexts) > 0 {
+			systemMessage := dto.Message{Role: "system"}
+			systemMessage.SetStringContent(strings.Join(systemTexts, "\n"))
+			openAIMessages = append(openAIMessages, systemMessage)
+		}
+	}
+
+	// gemini 的 functionResponse 按名称对应 functionCall，这里按顺序分配 tool_call_id
+	pendingToolCallIds := make(map[string][]string)
+	for _, content := range geminiRequest.Contents {
+		role := "user"
+		if content.Role == "model" {
+			role = "assistant"
+		}
+		mediaContents := make([]dto.MediaContent, 0, len(content.Parts))
+		toolCalls := make([]dto.ToolCallRequest, 0)
+		textOnly := true
+		for _, part := range content.Parts {
+			switch {
+			case part.Thought:
+				continue
+			case part.FunctionCall != nil:
+				id := fmt.Sprintf("call_%s", common.GetUUID())
+				pendingToolCallIds[part.FunctionCall.FunctionName] = append(pendingToolCallIds[part.FunctionCall.FunctionName], id)
+				arguments, _ := json.Marshal(part.FunctionCall.Arguments)
+				toolCalls = append(toolCalls, dto.ToolCallRequest{
+					ID:   id,
+					Type: "function",
+					Function: dto.FunctionRequest{
+						Name:      part.FunctionCall.FunctionName,
+						Arguments: string(arguments),
+					},
+				})
+			case part.FunctionResponse != nil:
+				name := part.FunctionResponse.Name
+				var id string
+				if ids := pendingToolCallIds[name]; len(ids) > 0 {
+					id = ids[0]
+					pendingToolCallIds[name] = ids[1:]
+				} else {
+					id = fmt.Sprintf("call_%s", common.GetUUID())
+				}
+				toolMessage := dto.Message{
+					Role:       "tool",
+					Name:       &name,
+					ToolCallId: id,
+				}
+				if text, ok := part.FunctionResponse.Response.Content.(string); ok {
+					toolMessage.SetStringContent(text)
+				} else {
+					responseJson, _ := json.Marshal(part.FunctionResponse.Response.Content)
+					toolMessage.SetStringContent(string(responseJson))
+				}
+				openAIMessages = append(openAIMessages, toolMessage)
+			case part.InlineData != nil:
+				textOnly = false
+				mediaContents = append(mediaContents, inlineDataGemini2OpenAI(part.InlineData))
+			case part.FileData != nil:
+				textOnly = false
+				if strings.HasPrefix(part.FileData.MimeType, "image/") || part.FileData.MimeType == "" {
+					mediaContents = append(mediaContents, dto.MediaContent{
+						Type:     dto.ContentTypeImageURL,
+						ImageUrl: &dto.MessageImageUrl{Url: part.FileData.FileUri},
+					})
+				} else {
+					mediaContents = append(mediaContents, dto.MediaContent{
+						Type: dto.ContentTypeFile,
+						File: &dto.MessageFile{FileData: part.FileData.FileUri},
+					})
+				}
+			case part.ExecutableCode != nil:
+				mediaContents = append(mediaContents, dto.MediaContent{
+					Type: dto.ContentTypeText,
+					Text: fmt.Sprintf("```%s\n%s\n```", strings.ToLower(part.ExecutableCode.Language), part.ExecutableCode.Code),
+				})
+			case part.CodeExecutionResult != nil:
+				mediaContents = append(mediaContents, dto.MediaContent{
+					Type: dto.ContentTypeText,
+					Text: part.CodeExecutionResult.Output,
+				})
+			case part.Text != "":
+				mediaContents = append(mediaContents, dto.MediaContent{
+					Type: dto.ContentTypeText,
+					Text: part.Text,
+				})
+			}
+		}
+		if len(mediaContents) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+		openAIMessage := dto.Message{Role: role}
+		if textOnly {
+			texts := make([]string, 0, len(mediaContents))
+			for _, mediaContent := range mediaContents {
+				texts = append(texts, mediaContent.Text)
+			}
+			if len(texts) > 0 {
+				openAIMessage.SetStringContent(strings.Join(texts, ""))
+			} else {
+				openAIMessage.SetNullContent()
+			}
+		} else {
+			openAIMessage.SetMediaContent(mediaContents)
+		}
+		if len(toolCalls) > 0 {
+			openAIMessage.SetToolCalls(toolCalls)
+		}
+		openAIMessages = append(openAIMessages, openAIMessage)
+	}
+	openAIRequest.Messages = openAIMessages
+	return openAIRequest, nil
+}
+
+func inlineDataGemini2OpenAI(inlineData *GeminiInlineData) dto.MediaContent {
+	switch {
+	case strings.HasPrefix(inlineData.MimeType, "image/"):
+		return dto.MediaContent{
+			Type:     dto.ContentTypeImageURL,
+			ImageUrl: &dto.MessageImageUrl{Url: fmt.Sprintf("data:%s;base64,%s", inlineData.MimeType, inlineData.Data)},
+		}
+	case strings.HasPrefix(inlineData.MimeType, "audio/"):
+		return dto.MediaContent{
+			Type: dto.ContentTypeInputAudio,
+			InputAudio: &dto.MessageInputAudio{
+				Data:   inlineData.Data,
+				Format: strings.TrimPrefix(inlineData.MimeType, "audio/"),
+			},
+		}
+	default:
+		return dto.MediaContent{
+			Type: dto.ContentTypeFile,
+			File: &dto.MessageFile{FileData: fmt.Sprintf("data:%s;base64,%s", inlineData.MimeType, inlineData.Data)},
+		}
+	}
+}
+
+func toolChoiceGemini2OpenAI(config *GeminiFunctionCallingConfig) any {
+	switch strings.ToUpper(config.Mode) {
+	case "NONE":
+		return "none"
+	case "ANY":
+		if len(config.AllowedFunctionNames) == 1 {
+			return map[string]any{
+				"type": "function",
+				"function": map[string]any{
+					"name": config.AllowedFunctionNames[0],
+				},
+			}
+		}
+		return "required"
+	default:
+		return "auto"
+	}
+}
+
+// schemaGemini2OpenAI lowercases the OpenAPI style type names (OBJECT, STRING...)
+// used by Gemini so the schema is valid JSON Schema for OpenAI compatible upstreams.
+func schemaGemini2OpenAI(schema any) any {
+	switch v := schema.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, value := range v {
+			if key == "type" {
+				if typeName, ok := value.(string); ok {
+					result[key] = strings.ToLower(typeName)
+					continue
+				}
+			}
+			result[key] = schemaGemini2OpenAI(value)
+		}
+		return result
+	case []any:
+		result := make([]any, len(v))
+		for i, value := range v {
+			result[i] = schemaGemini2OpenAI(value)
+		}
+		return result
+	default:
+		return schema
+	}
+}
+
+func finishReasonOpenAI2Gemini(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+func usageOpenAI2Gemini(usage *dto.Usage) GeminiUsageMetadata {
+	if usage == nil {
+		return GeminiUsageMetadata{}
+	}
+	reasoningTokens := usage.CompletionTokenDetails.ReasoningTokens
+	return GeminiUsageMetadata{
+		PromptTokenCount:     usage.PromptTokens,
+		CandidatesTokenCount: usage.CompletionTokens - reasoningTokens,
+		TotalTokenCount:      usage.PromptTokens + usage.CompletionTokens,
+		ThoughtsTokenCount:   reasoningTokens,
+	}
+}
+
+func functionCallOpenAI2Gemini(name string, arguments string) *FunctionCall {
+	var args any
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil || args == nil {
+		args = map[string]any{}
+	}
+	return &FunctionCall{
+		FunctionName: name,
+		Arguments:    args,
+	}
+}
+
+// ResponseOpenAI2Gemini converts a chat completions response into a Gemini
+// generateContent response.
+func ResponseOpenAI2Gemini(openAIResponse *dto.OpenAITextResponse, usage *dto.Usage) *GeminiChatResponse {
+	geminiResponse := &GeminiChatResponse{
+		Candidates:   make([]GeminiChatCandidate, 0, len(openAIResponse.Choices)),
+		ModelVersion: openAIResponse.Model,
+	}
+	for _, choice := range openAIResponse.Choices {
+		parts := make([]GeminiPart, 0)
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			parts = append(parts, GeminiPart{Text: reasoning, Thought: true})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			parts = append(parts, GeminiPart{Text: text})
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			parts = append(parts, GeminiPart{
+				FunctionCall: functionCallOpenAI2Gemini(toolCall.Function.Name, toolCall.Function.Arguments),
+			})
+		}
+		finishReason := finishReasonOpenAI2Gemini(choice.FinishReason)
+		geminiResponse.Candidates = append(geminiResponse.Candidates, GeminiChatCandidate{
+			Content: GeminiChatContent{
+				Role:  "model",
+				Parts: parts,
+			},
+			FinishReason: &finishReason,
+			Index:        int64(choice.Index),
+		})
+	}
+	if usage == nil {
+		usage = &openAIResponse.Usage
+	}
+	geminiResponse.UsageMetadata = usageOpenAI2Gemini(usage)
+	return geminiResponse
+}
+
+// OpenAI2GeminiStreamConverter converts chat completions chunks into Gemini
+// streamGenerateContent chunks. Text is forwarded as it arrives, tool call
+// deltas are accumulated and sent as complete function calls when the stream ends.
+type OpenAI2GeminiStreamConverter struct {
+	Model        string
+	FinishReason string
+	Usage        *dto.Usage
+	toolCalls    map[int]*dto.ToolCallResponse
+}
+
+func NewOpenAI2GeminiStreamConverter(model string) *OpenAI2GeminiStreamConverter {
+	return &OpenAI2GeminiStreamConverter{
+		Model:     model,
+		toolCalls: make(map[int]*dto.ToolCallResponse),
+	}
+}
+
+// Convert returns the Gemini chunk for an OpenAI chunk, nil if there is nothing to send yet
+func (s *OpenAI2GeminiStreamConverter) Convert(streamResponse *dto.ChatCompletionsStreamResponse) *GeminiChatResponse {
+	if streamResponse.Model != "" {
+		s.Model = streamResponse.Model
+	}
+	if streamResponse.Usage != nil && service.ValidUsage(streamResponse.Usage) {
+		s.Usage = streamResponse.Usage
+	}
+	if len(streamResponse.Choices) == 0 {
+		return nil
+	}
+	choice := streamResponse.Choices[0]
+	if choice.FinishReason != nil && *choice.FinishReason != "" {
+		s.FinishReason = *choice.FinishReason
+	}
+	parts := make([]GeminiPart, 0)
+	if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+		parts = append(parts, GeminiPart{Text: reasoning, Thought: true})
+	}
+	if text := choice.Delta.GetContentString(); text != "" {
+		parts = append(parts, GeminiPart{Text: text})
+	}
+	for i, toolCall := range choice.Delta.ToolCalls {
+		index := i
+		if toolCall.Index != nil {
+			index = *toolCall.Index
+		}
+		if existing, ok := s.toolCalls[index]; ok {
+			existing.Function.Arguments += toolCall.Function.Arguments
+			if toolCall.Function.Name != "" {
+				existing.Function.Name = toolCall.Function.Name
+			}
+		} else {
+			toolCallCopy := toolCall
+			s.toolCalls[index] = &toolCallCopy
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return &GeminiChatResponse{
+		Candidates: []GeminiChatCandidate{
+			{
+				Content: GeminiChatContent{
+					Role:  "model",
+					Parts: parts,
+				},
+			},
+		},
+		ModelVersion: s.Model,
+	}
+}
+
+// Finish returns the last chunk carrying the accumulated function calls, the finish reason and usage
+func (s *OpenAI2GeminiStreamConverter) Finish(usage *dto.Usage) *GeminiChatResponse {
+	if usage != nil && service.ValidUsage(usage) {
+		s.Usage = usage
+	}
+	indexes := make([]int, 0, len(s.toolCalls))
+	for index := range s.toolCalls {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	parts := make([]GeminiPart, 0, len(indexes))
+	for _, index := range indexes {
+		toolCall := s.toolCalls[index]
+		parts = append(parts, GeminiPart{
+			FunctionCall: functionCallOpenAI2Gemini(toolCall.Function.Name, toolCall.Function.Arguments),
+		})
+	}
+	finishReason := finishReasonOpenAI2Gemini(s.FinishReason)
+	return &GeminiChatResponse{
+		Candidates: []GeminiChatCandidate{
+			{
+				Content: GeminiChatContent{
+					Role:  "model",
+					Parts: parts,
+				},
+				FinishReason: &finishReason,
+			},
+		},
+		UsageMetadata: usageOpenAI2Gemini(s.Usage),
+		ModelVersion:  s.Model,
+	}
+}
+
+// GeminiEmbeddingToOpenAI converts a native embedContent request into an OpenAI embedding request
+func GeminiEmbeddingToOpenAI(embeddingRequest *GeminiEmbeddingRequest, info *relaycommon.RelayInfo) *dto.EmbeddingRequest {
+	texts := make([]string, 0, len(embeddingRequest.Content.Parts))
+	for _, part := range embeddingRequest.Content.Parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return &dto.EmbeddingRequest{
+		Model:      info.UpstreamModelName,
+		Input:      strings.Join(texts, "\n"),
+		Dimensions: embeddingRequest.OutputDimensionality,
+	}
+}
+
+// EmbeddingResponseOpenAI2Gemini converts an OpenAI embedding response into an embedContent response
+func EmbeddingResponseOpenAI2Gemini(embeddingResponse *dto.OpenAIEmbeddingResponse) *GeminiEmbeddingResponse {
+	geminiResponse := &GeminiEmbeddingResponse{
+		Embedding: ContentEmbedding{Values: make([]float64, 0)},
+	}
+	if len(embeddingResponse.Data) > 0 {
+		geminiResponse.Embedding.Values = embeddingResponse.Data[0].Embedding
+	}
+	return geminiResponse
+}
@@ -0,0 +1,750 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package gemini
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/relay/channel/openai"
+	relaycommon "veloera/relay/common"
+	"veloera/relay/helper"
+	"veloera/service"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// OpenAI 实时接口的 pcm16 为 24kHz 单声道，Gemini Live 输出同样为 24kHz
+const geminiLiveInputAudioMimeType = "audio/pcm;rate=24000"
+
+var geminiLiveVoices = []string{"Puck", "Charon", "Kore", "Fenrir", "Aoede", "Leda", "Orus", "Zephyr"}
+
+type realtimeSessionEvent struct {
+	EventId string `json:"event_id"`
+	Type    string `json:"type"`
+	Session any    `json:"session"`
+}
+
+type realtimeResponse struct {
+	Id     string             `json:"id"`
+	Object string             `json:"object"`
+	Status string             `json:"status"`
+	Output []dto.RealtimeItem `json:"output"`
+	Usage  *dto.RealtimeUsage `json:"usage,omitempty"`
+}
+
+type realtimeResponseEvent struct {
+	EventId  string           `json:"event_id"`
+	Type     string           `json:"type"`
+	Response realtimeResponse `json:"response"`
+}
+
+type realtimeItemEvent struct {
+	EventId     string           `json:"event_id"`
+	Type        string           `json:"type"`
+	ResponseId  string           `json:"response_id,omitempty"`
+	OutputIndex int              `json:"output_index"`
+	Item        dto.RealtimeItem `json:"item"`
+}
+
+type realtimeContentEvent struct {
+	EventId      string `json:"event_id"`
+	Type         string `json:"type"`
+	ResponseId   string `json:"response_id,omitempty"`
+	ItemId       string `json:"item_id"`
+	OutputIndex  int    `json:"output_index"`
+	ContentIndex int    `json:"content_index"`
+	Delta        string `json:"delta,omitempty"`
+	Text         string `json:"text,omitempty"`
+	Transcript   string `json:"transcript,omitempty"`
+}
+
+type realtimeFunctionCallEvent struct {
+	EventId     string `json:"event_id"`
+	Type        string `json:"type"`
+	ResponseId  string `json:"response_id"`
+	ItemId      string `json:"item_id"`
+	OutputIndex int    `json:"output_index"`
+	CallId      string `json:"call_id"`
+	Name        string `json:"name"`
+	Arguments   string `json:"arguments"`
+}
+
+type realtimeBufferEvent struct {
+	EventId string `json:"event_id"`
+	Type    string `json:"type"`
+	ItemId  string `json:"item_id,omitempty"`
+}
+
+// geminiRealtimeSession 在 OpenAI 实时事件和 Gemini Live 消息之间转换，
+// mu 保护会话状态以及对客户端连接的写入
+type geminiRealtimeSession struct {
+	c    *gin.Context
+	info *relaycommon.RelayInfo
+
+	mu             sync.Mutex
+	setupSent      bool
+	manualActivity bool
+	activityActive bool
+	audioOutput    bool
+	session        *dto.RealtimeSession
+	pendingTurns   []GeminiChatContent
+	functionNames  map[string]string
+
+	responseId      string
+	outputItems     []dto.RealtimeItem
+	messageItemId   string
+	messageIndex    int
+	text            strings.Builder
+	transcript      strings.Builder
+	inputTranscript strings.Builder
+	lastUsage       *dto.RealtimeUsage
+
+	usageReported bool
+	localUsage    *dto.RealtimeUsage
+	sumUsage      *dto.RealtimeUsage
+}
+
+// GeminiRealtimeHandler 将 /v1/realtime 的 OpenAI 实时协议转换为 Gemini Live 协议，
+// 优先按 Gemini 返回的 usageMetadata 计费，未返回时使用本地估算的用量
+func GeminiRealtimeHandler(c *gin.Context, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.RealtimeUsage) {
+	if info == nil || info.ClientWs == nil || info.TargetWs == nil {
+		return service.OpenAIErrorWrapper(fmt.Errorf("invalid websocket connection"), "invalid_connection", http.StatusBadRequest), nil
+	}
+	info.IsStream = true
+	s := &geminiRealtimeSession{
+		c:             c,
+		info:          info,
+		audioOutput:   true,
+		functionNames: make(map[string]string),
+		localUsage:    &dto.RealtimeUsage{},
+		sumUsage:      &dto.RealtimeUsage{},
+	}
+	if err := s.sendSessionEvent(dto.RealtimeEventTypeSessionCreated); err != nil {
+		return service.OpenAIErrorWrapper(err, "write_client_failed", http.StatusInternalServerError), nil
+	}
+
+	clientClosed := make(chan struct{})
+	targetClosed := make(chan struct{})
+	errChan := make(chan error, 2)
+
+	gopool.Go(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errChan <- fmt.Errorf("panic in client reader: %v", r)
+			}
+		}()
+		for {
+			_, message, err := info.ClientWs.ReadMessage()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					errChan <- fmt.Errorf("error reading from client: %v", err)
+				}
+				close(clientClosed)
+				return
+			}
+			if err = s.handleClientMessage(message); err != nil {
+				errChan <- err
+				return
+			}
+		}
+	})
+
+	gopool.Go(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errChan <- fmt.Errorf("panic in target reader: %v", r)
+			}
+		}()
+		for {
+			_, message, err := info.TargetWs.ReadMessage()
+			if err != nil {
+				var closeErr *websocket.CloseError
+				if errors.As(err, &closeErr) && closeErr.Code != websocket.CloseNormalClosure && closeErr.Text != "" {
+					// Gemini 通过关闭帧返回错误原因
+					s.mu.Lock()
+					s.sendError(closeErr.Text)
+					s.mu.Unlock()
+				}
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					errChan <- fmt.Errorf("error reading from target: %v", err)
+				}
+				close(targetClosed)
+				return
+			}
+			info.SetFirstResponseTime()
+			if err = s.handleServerMessage(message); err != nil {
+				errChan <- err
+				return
+			}
+		}
+	})
+
+	select {
+	case <-clientClosed:
+	case <-targetClosed:
+	case err := <-errChan:
+		common.LogError(c, "realtime error: "+err.Error())
+	case <-c.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// 上游未返回用量时按本地估算计费
+	if !s.usageReported && s.localUsage.TotalTokens != 0 {
+		_ = openai.PreConsumeRealtimeUsage(c, info, s.localUsage, s.sumUsage)
+	}
+	return nil, s.sumUsage
+}
+
+func (s *geminiRealtimeSession) handleClientMessage(message []byte) error {
+	event := &dto.RealtimeEvent{}
+	if err := json.Unmarshal(message, event); err != nil {
+		return fmt.Errorf("error unmarshalling message: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.setupSent {
+		if err := s.sendSetup(event, message); err != nil {
+			s.sendError(err.Error())
+			return err
+		}
+		if event.Type == dto.RealtimeEventTypeSessionUpdate {
+			return nil
+		}
+	}
+
+	switch event.Type {
+	case dto.RealtimeEventTypeSessionUpdate:
+		// Gemini Live 只能在建立连接时配置会话
+		common.LogWarn(s.c, "gemini live does not support updating session after setup, ignored")
+		return s.sendSessionEvent(dto.RealtimeEventTypeSessionUpdated)
+	case dto.RealtimeEventInputAudioBufferAppend:
+		audioTokens, err := service.CountAudioTokenInput(event.Audio, s.info.InputAudioFormat)
+		if err != nil {
+			return fmt.Errorf("error counting audio token: %v", err)
+		}
+		s.addLocalUsage(0, audioTokens, true)
+		if s.manualActivity && !s.activityActive {
+			s.activityActive = true
+			if err = s.sendTarget(GeminiLiveClientMessage{RealtimeInput: &GeminiLiveRealtimeInput{ActivityStart: &struct{}{}}}); err != nil {
+				return err
+			}
+		}
+		return s.sendTarget(GeminiLiveClientMessage{RealtimeInput: &GeminiLiveRealtimeInput{
+			Audio: &GeminiInlineData{MimeType: geminiLiveInputAudioMimeType, Data: event.Audio},
+		}})
+	case "input_audio_buffer.commit":
+		if s.manualActivity && s.activityActive {
+			s.activityActive = false
+			if err := s.sendTarget(GeminiLiveClientMessage{RealtimeInput: &GeminiLiveRealtimeInput{ActivityEnd: &struct{}{}}}); err != nil {
+				return err
+			}
+		}
+		return s.sendClient(realtimeBufferEvent{EventId: s.newId("event"), Type: "input_audio_buffer.committed", ItemId: s.newId("item")})
+	case "input_audio_buffer.clear":
+		return s.sendClient(realtimeBufferEvent{EventId: s.newId("event"), Type: "input_audio_buffer.cleared"})
+	case dto.RealtimeEventTypeConversationCreate:
+		if event.Item == nil {
+			return nil
+		}
+		if err := s.convertConversationItem(event.Item); err != nil {
+			return err
+		}
+		item := *event.Item
+		if item.Id == "" {
+			item.Id = s.newId("item")
+		}
+		return s.sendClient(realtimeItemEvent{EventId: s.newId("event"), Type: dto.RealtimeEventConversationItemCreated, Item: item})
+	case dto.RealtimeEventTypeResponseCreate:
+		// 音频输入由 Gemini 自动触发回复，只有通过 conversation.item.create 添加的内容需要提交
+		if len(s.pendingTurns) == 0 {
+			return nil
+		}
+		turns := s.pendingTurns
+		s.pendingTurns = nil
+		return s.sendTarget(GeminiLiveClientMessage{ClientContent: &GeminiLiveClientContent{Turns: turns, TurnComplete: true}})
+	}
+	return nil
+}
+
+// sendSetup 发送 Gemini Live 的 setup 消息，首个事件为 session.update 时使用其中的会话配置
+func (s *geminiRealtimeSession) sendSetup(event *dto.RealtimeEvent, message []byte) error {
+	s.setupSent = true
+	setup := &GeminiLiveSetup{
+		Model:                    "models/" + s.info.UpstreamModelName,
+		GenerationConfig:         &GeminiLiveGenerationConfig{ResponseModalities: []string{"AUDIO"}},
+		OutputAudioTranscription: &struct{}{},
+	}
+	session := event.Session
+	if event.Type != dto.RealtimeEventTypeSessionUpdate || session == nil {
+		return s.sendTarget(GeminiLiveClientMessage{Setup: setup})
+	}
+	s.session = session
+
+	if session.InputAudioFormat != "" && session.InputAudioFormat != "pcm16" {
+		return fmt.Errorf("input_audio_format %s is not supported, use pcm16", session.InputAudioFormat)
+	}
+	if session.OutputAudioFormat != "" && session.OutputAudioFormat != "pcm16" {
+		return fmt.Errorf("output_audio_format %s is not supported, use pcm16", session.OutputAudioFormat)
+	}
+	if len(session.Modalities) > 0 && !common.StringsContains(session.Modalities, "audio") {
+		s.audioOutput = false
+		setup.GenerationConfig.ResponseModalities = []string{"TEXT"}
+		setup.OutputAudioTranscription = nil
+	}
+	if session.Instructions != "" {
+		setup.SystemInstruction = &GeminiChatContent{Parts: []GeminiPart{{Text: session.Instructions}}}
+	}
+	for _, voice := range geminiLiveVoices {
+		if strings.EqualFold(voice, session.Voice) {
+			setup.GenerationConfig.SpeechConfig = &GeminiLiveSpeechConfig{
+				VoiceConfig: GeminiLiveVoiceConfig{PrebuiltVoiceConfig: GeminiLivePrebuiltVoiceConfig{VoiceName: voice}},
+			}
+		}
+	}
+	if session.Temperature > 0 {
+		temperature := session.Temperature
+		setup.GenerationConfig.Temperature = &temperature
+	}
+	if session.InputAudioTranscription.Model != "" {
+		setup.InputAudioTranscription = &struct{}{}
+	}
+	if len(session.Tools) > 0 {
+		s.info.RealtimeTools = session.Tools
+		functions := make([]dto.FunctionRequest, 0, len(session.Tools))
+		for _, tool := range session.Tools {
+			functions = append(functions, dto.FunctionRequest{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  cleanFunctionParameters(tool.Parameters),
+			})
+		}
+		setup.Tools = []GeminiChatTool{{FunctionDeclarations: functions}}
+	}
+	// turn_detection 显式设为 null 时关闭服务端语音检测，由 input_audio_buffer.commit 结束一轮输入
+	var raw struct {
+		Session map[string]json.RawMessage `json:"session"`
+	}
+	if err := json.Unmarshal(message, &raw); err == nil {
+		if value, ok := raw.Session["turn_detection"]; ok && string(value) == "null" {
+			s.manualActivity = true
+			setup.RealtimeInputConfig = &GeminiLiveRealtimeInputConfig{
+				AutomaticActivityDetection: GeminiLiveActivityDetection{Disabled: true},
+			}
+		}
+	}
+
+	if session.Instructions != "" {
+		textTokens, err := service.CountTextToken(session.Instructions, s.info.UpstreamModelName)
+		if err != nil {
+			return fmt.Errorf("error counting text token: %v", err)
+		}
+		s.addLocalUsage(textTokens, 0, true)
+	}
+	return s.sendTarget(GeminiLiveClientMessage{Setup: setup})
+}
+
+func (s *geminiRealtimeSession) convertConversationItem(item *dto.RealtimeItem) error {
+	switch item.Type {
+	case "message":
+		role := "user"
+		if item.Role == "assistant" {
+			role = "model"
+		}
+		content := GeminiChatContent{Role: role}
+		for _, part := range item.Content {
+			switch part.Type {
+			case "input_text", "text":
+				content.Parts = append(content.Parts, GeminiPart{Text: part.Text})
+				textTokens, err := service.CountTextToken(part.Text, s.info.UpstreamModelName)
+				if err != nil {
+					return fmt.Errorf("error counting text token: %v", err)
+				}
+				s.addLocalUsage(textTokens, 0, true)
+			case "input_audio":
+				content.Parts = append(content.Parts, GeminiPart{
+					InlineData: &GeminiInlineData{MimeType: geminiLiveInputAudioMimeType, Data: part.Audio},
+				})
+				audioTokens, err := service.CountAudioTokenInput(part.Audio, s.info.InputAudioFormat)
+				if err != nil {
+					return fmt.Errorf("error counting audio token: %v", err)
+				}
+				s.addLocalUsage(0, audioTokens, true)
+			}
+		}
+		if len(content.Parts) > 0 {
+			s.pendingTurns = append(s.pendingTurns, content)
+		}
+	case "function_call_output":
+		var response any = map[string]any{"output": item.Output}
+		var output map[string]any
+		if err := json.Unmarshal([]byte(item.Output), &output); err == nil {
+			response = output
+		}
+		return s.sendTarget(GeminiLiveClientMessage{ToolResponse: &GeminiLiveToolResponse{
+			FunctionResponses: []GeminiLiveFunctionResponse{
+				{Id: item.CallId, Name: s.functionNames[item.CallId], Response: response},
+			},
+		}})
+	}
+	return nil
+}
+
+func (s *geminiRealtimeSession) handleServerMessage(message []byte) error {
+	var serverMessage GeminiLiveServerMessage
+	if err := json.Unmarshal(message, &serverMessage); err != nil {
+		return fmt.Errorf("error unmarshalling message: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if serverMessage.SetupComplete != nil && s.session != nil {
+		if err := s.sendSessionEvent(dto.RealtimeEventTypeSessionUpdated); err != nil {
+			return err
+		}
+	}
+	if serverMessage.UsageMetadata != nil {
+		if err := s.consumeUsage(serverMessage.UsageMetadata); err != nil {
+			return fmt.Errorf("error consume usage: %v", err)
+		}
+	}
+	if content := serverMessage.ServerContent; content != nil {
+		if content.InputTranscription != nil {
+			s.inputTranscript.WriteString(content.InputTranscription.Text)
+		}
+		if content.ModelTurn != nil {
+			for _, part := range content.ModelTurn.Parts {
+				if part.Thought {
+					continue
+				}
+				if part.Text != "" {
+					if err := s.sendContentDelta("response.text.delta", part.Text); err != nil {
+						return err
+					}
+					s.text.WriteString(part.Text)
+				}
+				if part.InlineData != nil && strings.HasPrefix(part.InlineData.MimeType, "audio/") {
+					audioTokens, err := service.CountAudioTokenOutput(part.InlineData.Data, s.info.OutputAudioFormat)
+					if err != nil {
+						return fmt.Errorf("error counting audio token: %v", err)
+					}
+					s.addLocalUsage(0, audioTokens, false)
+					if err = s.sendContentDelta(dto.RealtimeEventResponseAudioDelta, part.InlineData.Data); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if content.OutputTranscription != nil && content.OutputTranscription.Text != "" {
+			if err := s.sendContentDelta(dto.RealtimeEventResponseAudioTranscriptionDelta, content.OutputTranscription.Text); err != nil {
+				return err
+			}
+			s.transcript.WriteString(content.OutputTranscription.Text)
+		}
+		if content.Interrupted {
+			// 用户打断时通知客户端停止播放
+			if err := s.sendClient(realtimeBufferEvent{EventId: s.newId("event"), Type: "input_audio_buffer.speech_started"}); err != nil {
+				return err
+			}
+			return s.finishResponse("cancelled")
+		}
+		if content.TurnComplete {
+			return s.finishResponse("completed")
+		}
+	}
+	if serverMessage.ToolCall != nil {
+		for _, call := range serverMessage.ToolCall.FunctionCalls {
+			if err := s.sendFunctionCall(call); err != nil {
+				return err
+			}
+		}
+		// Gemini 在收到 toolResponse 后继续生成，对应 OpenAI 中新的一轮回复
+		return s.finishResponse("completed")
+	}
+	return nil
+}
+
+func (s *geminiRealtimeSession) consumeUsage(metadata *GeminiLiveUsageMetadata) error {
+	usage := &dto.RealtimeUsage{
+		TotalTokens:  metadata.TotalTokenCount,
+		InputTokens:  metadata.PromptTokenCount,
+		OutputTokens: metadata.ResponseTokenCount + metadata.ThoughtsTokenCount,
+	}
+	usage.InputTokenDetails.CachedTokens = metadata.CachedContentTokenCount
+	for _, detail := range metadata.PromptTokensDetails {
+		if detail.Modality == "AUDIO" {
+			usage.InputTokenDetails.AudioTokens += detail.TokenCount
+		} else {
+			usage.InputTokenDetails.TextTokens += detail.TokenCount
+		}
+	}
+	for _, detail := range metadata.ResponseTokensDetails {
+		if detail.Modality == "AUDIO" {
+			usage.OutputTokenDetails.AudioTokens += detail.TokenCount
+		} else {
+			usage.OutputTokenDetails.TextTokens += detail.TokenCount
+		}
+	}
+	// 未返回分模态明细时按会话的输出模态归类
+	if len(metadata.PromptTokensDetails) == 0 {
+		usage.InputTokenDetails.TextTokens = usage.InputTokens
+	}
+	if len(metadata.ResponseTokensDetails) == 0 {
+		if s.audioOutput {
+			usage.OutputTokenDetails.AudioTokens = metadata.ResponseTokenCount
+		} else {
+			usage.OutputTokenDetails.TextTokens = metadata.ResponseTokenCount
+		}
+	}
+	usage.OutputTokenDetails.TextTokens += metadata.ThoughtsTokenCount
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+	}
+	s.usageReported = true
+	s.lastUsage = usage
+	return openai.PreConsumeRealtimeUsage(s.c, s.info, usage, s.sumUsage)
+}
+
+func (s *geminiRealtimeSession) addLocalUsage(textTokens int, audioTokens int, input bool) {
+	s.localUsage.TotalTokens += textTokens + audioTokens
+	if input {
+		s.localUsage.InputTokens += textTokens + audioTokens
+		s.localUsage.InputTokenDetails.TextTokens += textTokens
+		s.localUsage.InputTokenDetails.AudioTokens += audioTokens
+	} else {
+		s.localUsage.OutputTokens += textTokens + audioTokens
+		s.localUsage.OutputTokenDetails.TextTokens += textTokens
+		s.localUsage.OutputTokenDetails.AudioTokens += audioTokens
+	}
+}
+
+// ensureResponse 在收到一轮回复的首个内容时发送 response.created
+func (s *geminiRealtimeSession) ensureResponse() error {
+	if s.responseId != "" {
+		return nil
+	}
+	s.responseId = s.newId("resp")
+	return s.sendClient(realtimeResponseEvent{
+		EventId: s.newId("event"),
+		Type:    "response.created",
+		Response: realtimeResponse{
+			Id:     s.responseId,
+			Object: "realtime.response",
+			Status: "in_progress",
+			Output: []dto.RealtimeItem{},
+		},
+	})
+}
+
+func (s *geminiRealtimeSession) ensureMessageItem() error {
+	if err := s.ensureResponse(); err != nil {
+		return err
+	}
+	if s.messageItemId != "" {
+		return nil
+	}
+	s.messageItemId = s.newId("item")
+	s.messageIndex = len(s.outputItems)
+	item := dto.RealtimeItem{Id: s.messageItemId, Type: "message", Status: "in_progress", Role: "assistant"}
+	s.outputItems = append(s.outputItems, item)
+	return s.sendClient(realtimeItemEvent{
+		EventId:     s.newId("event"),
+		Type:        "response.output_item.added",
+		ResponseId:  s.responseId,
+		OutputIndex: s.messageIndex,
+		Item:        item,
+	})
+}
+
+func (s *geminiRealtimeSession) sendContentDelta(eventType string, delta string) error {
+	if err := s.ensureMessageItem(); err != nil {
+		return err
+	}
+	return s.sendClient(s.contentEvent(eventType, delta))
+}
+
+func (s *geminiRealtimeSession) contentEvent(eventType string, delta string) realtimeContentEvent {
+	return realtimeContentEvent{
+		EventId:     s.newId("event"),
+		Type:        eventType,
+		ResponseId:  s.responseId,
+		ItemId:      s.messageItemId,
+		OutputIndex: s.messageIndex,
+		Delta:       delta,
+	}
+}
+
+func (s *geminiRealtimeSession) sendFunctionCall(call GeminiLiveFunctionCall) error {
+	if err := s.ensureResponse(); err != nil {
+		return err
+	}
+	arguments, err := json.Marshal(call.Args)
+	if err != nil {
+		return fmt.Errorf("error marshalling function call arguments: %v", err)
+	}
+	callId := call.Id
+	if callId == "" {
+		callId = s.newId("call")
+	}
+	s.functionNames[callId] = call.Name
+	name := call.Name
+	item := dto.RealtimeItem{
+		Id:        s.newId("item"),
+		Type:      "function_call",
+		Status:    "completed",
+		Name:      &name,
+		CallId:    callId,
+		Arguments: string(arguments),
+	}
+	outputIndex := len(s.outputItems)
+	s.outputItems = append(s.outputItems, item)
+	if err = s.sendClient(realtimeItemEvent{EventId: s.newId("event"), Type: "response.output_item.added", ResponseId: s.responseId, OutputIndex: outputIndex, Item: item}); err != nil {
+		return err
+	}
+	if err = s.sendClient(realtimeFunctionCallEvent{
+		EventId:     s.newId("event"),
+		Type:        dto.RealtimeEventResponseFunctionCallArgumentsDone,
+		ResponseId:  s.responseId,
+		ItemId:      item.Id,
+		OutputIndex: outputIndex,
+		CallId:      callId,
+		Name:        call.Name,
+		Arguments:   item.Arguments,
+	}); err != nil {
+		return err
+	}
+	return s.sendClient(realtimeItemEvent{EventId: s.newId("event"), Type: "response.output_item.done", ResponseId: s.responseId, OutputIndex: outputIndex, Item: item})
+}
+
+// finishResponse 发送一轮回复的结束事件并重置回复状态
+func (s *geminiRealtimeSession) finishResponse(status string) error {
+	if s.inputTranscript.Len() > 0 {
+		if err := s.sendClient(realtimeContentEvent{
+			EventId:    s.newId("event"),
+			Type:       "conversation.item.input_audio_transcription.completed",
+			ItemId:     s.newId("item"),
+			Transcript: s.inputTranscript.String(),
+		}); err != nil {
+			return err
+		}
+		s.inputTranscript.Reset()
+	}
+	if s.responseId == "" {
+		return nil
+	}
+
+	if s.messageItemId != "" {
+		item := &s.outputItems[s.messageIndex]
+		item.Status = status
+		if s.text.Len() > 0 {
+			done := s.contentEvent("response.text.done", "")
+			done.Text = s.text.String()
+			if err := s.sendClient(done); err != nil {
+				return err
+			}
+			item.Content = append(item.Content, dto.RealtimeContent{Type: "text", Text: s.text.String()})
+		}
+		if s.audioOutput {
+			if s.transcript.Len() > 0 {
+				done := s.contentEvent("response.audio_transcript.done", "")
+				done.Transcript = s.transcript.String()
+				if err := s.sendClient(done); err != nil {
+					return err
+				}
+			}
+			if err := s.sendClient(s.contentEvent("response.audio.done", "")); err != nil {
+				return err
+			}
+			item.Content = append(item.Content, dto.RealtimeContent{Type: "audio", Transcript: s.transcript.String()})
+		}
+		if err := s.sendClient(realtimeItemEvent{EventId: s.newId("event"), Type: "response.output_item.done", ResponseId: s.responseId, OutputIndex: s.messageIndex, Item: *item}); err != nil {
+			return err
+		}
+	}
+
+	err := s.sendClient(realtimeResponseEvent{
+		EventId: s.newId("event"),
+		Type:    dto.RealtimeEventTypeResponseDone,
+		Response: realtimeResponse{
+			Id:     s.responseId,
+			Object: "realtime.response",
+			Status: status,
+			Output: s.outputItems,
+			Usage:  s.lastUsage,
+		},
+	})
+	s.responseId = ""
+	s.messageItemId = ""
+	s.outputItems = nil
+	s.text.Reset()
+	s.transcript.Reset()
+	s.lastUsage = nil
+	return err
+}
+
+func (s *geminiRealtimeSession) sendSessionEvent(eventType string) error {
+	session := map[string]any{
+		"id":                  s.newId("sess"),
+		"object":              "realtime.session",
+		"model":               s.info.OriginModelName,
+		"modalities":          []string{"text", "audio"},
+		"input_audio_format":  "pcm16",
+		"output_audio_format": "pcm16",
+	}
+	if s.session != nil {
+		session["modalities"] = s.session.Modalities
+		session["instructions"] = s.session.Instructions
+		session["voice"] = s.session.Voice
+		session["turn_detection"] = s.session.TurnDetection
+		session["tools"] = s.session.Tools
+		session["temperature"] = s.session.Temperature
+	}
+	return s.sendClient(realtimeSessionEvent{EventId: s.newId("event"), Type: eventType, Session: session})
+}
+
+func (s *geminiRealtimeSession) sendError(message string) {
+	helper.WssError(s.c, s.info.ClientWs, dto.OpenAIError{Message: message, Type: "gemini_error"})
+}
+
+func (s *geminiRealtimeSession) sendClient(event any) error {
+	if err := helper.WssObject(s.c, s.info.ClientWs, event); err != nil {
+		return fmt.Errorf("error writing to client: %v", err)
+	}
+	return nil
+}
+
+func (s *geminiRealtimeSession) sendTarget(message GeminiLiveClientMessage) error {
+	if err := helper.WssObject(s.c, s.info.TargetWs, message); err != nil {
+		return fmt.Errorf("error writing to target: %v", err)
+	}
+	return nil
+}
+
+func (s *geminiRealtimeSession) newId(prefix string) string {
+	return prefix + "_" + common.GetRandomString(20)
+}
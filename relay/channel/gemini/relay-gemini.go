@@ -1090,17 +1090,23 @@ func GeminiEmbeddingHandler(c *gin.Context, resp *http.Response, info *relaycomm
 		return nil, service.OpenAIErrorWrapper(jsonErr, "unmarshal_response_body_failed", http.StatusInternalServerError)
 	}
 
+	embeddings := geminiResponse.Embeddings
+	if len(embeddings) == 0 {
+		embeddings = []ContentEmbedding{geminiResponse.Embedding}
+	}
+
 	// convert to openai format response
 	openAIResponse := dto.OpenAIEmbeddingResponse{
 		Object: "list",
-		Data: []dto.OpenAIEmbeddingResponseItem{
-			{
-				Object:    "embedding",
-				Embedding: geminiResponse.Embedding.Values,
-				Index:     0,
-			},
-		},
-		Model: info.UpstreamModelName,
+		Data:   make([]dto.OpenAIEmbeddingResponseItem, 0, len(embeddings)),
+		Model:  info.UpstreamModelName,
+	}
+	for i, embedding := range embeddings {
+		openAIResponse.Data = append(openAIResponse.Data, dto.OpenAIEmbeddingResponseItem{
+			Object:    "embedding",
+			Embedding: embedding.Values,
+			Index:     i,
+		})
 	}
 
 	// calculate usage
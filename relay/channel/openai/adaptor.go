@@ -24,6 +24,7 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"strings"
 	"veloera/common"
 	constant2 "veloera/constant"
@@ -50,8 +51,9 @@ type Adaptor struct {
 }
 
 func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (any, error) {
-	if !strings.Contains(request.Model, "claude") {
-		return nil, fmt.Errorf("you are using openai channel type with path /v1/messages, only claude model supported convert, but got %s", request.Model)
+	// azure 与自定义渠道的请求地址依赖 chat completions 路径，走通用转换流程
+	if info.ChannelType == common.ChannelTypeAzure || info.ChannelType == common.ChannelTypeCustom {
+		return channel.ConvertClaudeRequestByOpenAI(a, c, info, request)
 	}
 	aiRequest, err := service.ClaudeToOpenAIRequest(*request, info)
 	if err != nil {
@@ -122,7 +124,7 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 		// https://github.com/songquanpeng/veloera/issues/67
 		requestURL = fmt.Sprintf("/openai/deployments/%s/%s", model_, task)
 		if info.RelayMode == constant.RelayModeRealtime {
-			requestURL = fmt.Sprintf("/openai/realtime?deployment=%s&api-version=%s", model_, apiVersion)
+			requestURL = azureRealtimeRequestURL(info, model_)
 		}
 		return relaycommon.GetFullRequestURL(info.BaseUrl, requestURL, info.ChannelType), nil
 	case common.ChannelTypeMiniMax:
@@ -136,6 +138,22 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 	}
 }
 
+// azureRealtimeRequestURL 构造 Azure 实时接口地址，渠道设置 azure_realtime_api_version 优先，
+// 其次为渠道的 API 版本，都未设置时使用实时接口的默认版本；版本为 v1 时使用 GA 版接口
+func azureRealtimeRequestURL(info *relaycommon.RelayInfo, deployment string) string {
+	apiVersion := info.ApiVersion
+	if v, ok := info.ChannelSetting[constant2.ChannelSettingAzureRealtimeApiVersion].(string); ok && v != "" {
+		apiVersion = v
+	}
+	if apiVersion == "" {
+		apiVersion = constant2.AzureRealtimeDefaultAPIVersion
+	}
+	if apiVersion == "v1" {
+		return fmt.Sprintf("/openai/v1/realtime?model=%s", url.QueryEscape(deployment))
+	}
+	return fmt.Sprintf("/openai/realtime?api-version=%s&deployment=%s", url.QueryEscape(apiVersion), url.QueryEscape(deployment))
+}
+
 func (a *Adaptor) SetupRequestHeader(c *gin.Context, header *http.Header, info *relaycommon.RelayInfo) error {
 	channel.SetupApiRequestHeader(info, c, header)
 	if info.ChannelType == common.ChannelTypeAzure {
@@ -267,7 +285,65 @@ func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInf
 }
 
 func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
-	return request, nil
+	switch info.RelayMode {
+	case constant.RelayModeImagesEdits, constant.RelayModeImagesVariations:
+		return convertImageFormRequest(c, request)
+	default:
+		return request, nil
+	}
+}
+
+// convertImageFormRequest 使用原请求的分界线重新构造 multipart 请求体，仅替换模型名称，
+// 这样上游请求可以沿用客户端的 Content-Type
+func convertImageFormRequest(c *gin.Context, request dto.ImageRequest) (io.Reader, error) {
+	form := c.Request.MultipartForm
+	if form == nil {
+		return nil, errors.New("multipart form is required")
+	}
+	var requestBody bytes.Buffer
+	writer := multipart.NewWriter(&requestBody)
+	if err := writer.SetBoundary(common.GetMultipartBoundary(c)); err != nil {
+		return nil, fmt.Errorf("set multipart boundary failed: %w", err)
+	}
+
+	writer.WriteField("model", request.Model)
+	for key, values := range form.Value {
+		if key == "model" {
+			continue
+		}
+		for _, value := range values {
+			writer.WriteField(key, value)
+		}
+	}
+
+	// 添加图片和遮罩文件
+	for key, headers := range form.File {
+		for _, header := range headers {
+			if err := copyFormFile(writer, key, header); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// 关闭 multipart 编写器以设置分界线
+	writer.Close()
+	return &requestBody, nil
+}
+
+func copyFormFile(writer *multipart.Writer, key string, header *multipart.FileHeader) error {
+	file, err := header.Open()
+	if err != nil {
+		return fmt.Errorf("open form file failed: %w", err)
+	}
+	defer file.Close()
+	part, err := writer.CreatePart(header.Header)
+	if err != nil {
+		return errors.New("create form file failed")
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return errors.New("copy file failed")
+	}
+	return nil
 }
 
 func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
@@ -305,7 +381,7 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 		fallthrough
 	case constant.RelayModeAudioTranscription:
 		err, usage = OpenaiSTTHandler(c, resp, info, a.ResponseFormat)
-	case constant.RelayModeImagesGenerations:
+	case constant.RelayModeImagesGenerations, constant.RelayModeImagesEdits, constant.RelayModeImagesVariations:
 		err, usage = OpenaiTTSHandler(c, resp, info)
 	case constant.RelayModeRerank:
 		err, usage = common_handler.RerankHandler(c, info, resp)
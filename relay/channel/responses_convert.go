@@ -0,0 +1,41 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package channel
+
+import (
+	"github.com/gin-gonic/gin"
+	"veloera/dto"
+	"veloera/relay/common"
+	"veloera/service"
+)
+
+// ConvertResponsesRequestByOpenAI converts a Responses API request to chat completions
+// and hands it to the adaptor's OpenAI conversion, for adaptors without native Responses support.
+// The relay synthesizes the response.* events from the OpenAI format response written by the adaptor.
+func ConvertResponsesRequestByOpenAI(a Adaptor, c *gin.Context, info *common.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
+	openAIRequest, err := service.ResponsesToOpenAIRequest(request, info)
+	if err != nil {
+		return nil, err
+	}
+	info.SetResponsesOverOpenAI()
+	if openAIRequest.Stream && info.SupportStreamOptions {
+		openAIRequest.StreamOptions = &dto.StreamOptions{
+			IncludeUsage: true,
+		}
+	}
+	return a.ConvertOpenAIRequest(c, info, openAIRequest)
+}
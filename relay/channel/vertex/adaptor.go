@@ -17,6 +17,7 @@
 package vertex
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -30,12 +31,22 @@ import (
 	"veloera/relay/channel/gemini"
 	"veloera/relay/channel/openai"
 	relaycommon "veloera/relay/common"
+	relayconstant "veloera/relay/constant"
+	"veloera/service"
 )
 
 const (
-	RequestModeClaude = 1
-	RequestModeGemini = 2
-	RequestModeLlama  = 3
+	RequestModeClaude    = 1
+	RequestModeGemini    = 2
+	RequestModeLlama     = 3
+	RequestModeEmbedding = 4
+	RequestModeImagen    = 5
+)
+
+// Vertex AI 向量模型单次请求的最大输入条数，gemini-embedding 系列仅支持单条输入
+const (
+	maxEmbeddingInstances       = 250
+	maxGeminiEmbeddingInstances = 1
 )
 
 var claudeModelMap = map[string]string{
@@ -54,9 +65,13 @@ const anthropicVersion = "vertex-2023-10-16"
 type Adaptor struct {
 	RequestMode        int
 	AccountCredentials Credentials
+	ResponseFormat     string
 }
 
 func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (any, error) {
+	if a.RequestMode != RequestModeClaude {
+		return channel.ConvertClaudeRequestByOpenAI(a, c, info, request)
+	}
 	if v, ok := claudeModelMap[info.UpstreamModelName]; ok {
 		c.Set("request_model", v)
 	} else {
@@ -67,18 +82,38 @@ func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayIn
 }
 
 func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
-	//TODO implement me
-	return nil, errors.New("not implemented")
+	if a.RequestMode != RequestModeGemini {
+		return nil, errors.New("audio is only supported for gemini models")
+	}
+	a.ResponseFormat = request.ResponseFormat
+	geminiRequest, err := gemini.BuildGeminiAudioRequest(c, info, request)
+	if err != nil {
+		return nil, err
+	}
+	jsonData, err := json.Marshal(geminiRequest)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling object: %w", err)
+	}
+	return bytes.NewReader(jsonData), nil
 }
 
 func (a *Adaptor) ConvertImageRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.ImageRequest) (any, error) {
-	//TODO implement me
-	return nil, errors.New("not implemented")
+	if a.RequestMode != RequestModeImagen {
+		return nil, errors.New("not supported model for image generation")
+	}
+	if info.RelayMode != relayconstant.RelayModeImagesGenerations {
+		return nil, errors.New("image edits and variations are not supported")
+	}
+	return gemini.BuildImagenRequest(request), nil
 }
 
 func (a *Adaptor) Init(info *relaycommon.RelayInfo) {
 	if strings.HasPrefix(info.UpstreamModelName, "claude") {
 		a.RequestMode = RequestModeClaude
+	} else if isEmbeddingModel(info.UpstreamModelName) {
+		a.RequestMode = RequestModeEmbedding
+	} else if strings.HasPrefix(info.UpstreamModelName, "imagen") {
+		a.RequestMode = RequestModeImagen
 	} else if strings.HasPrefix(info.UpstreamModelName, "gemini") {
 		a.RequestMode = RequestModeGemini
 	} else if strings.Contains(info.UpstreamModelName, "llama") {
@@ -94,8 +129,10 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 	region := GetModelRegion(info.ApiVersion, info.OriginModelName)
 	a.AccountCredentials = *adc
 	suffix := ""
-	if a.RequestMode == RequestModeGemini {
-		if info.IsStream {
+	if a.RequestMode == RequestModeGemini || a.RequestMode == RequestModeEmbedding || a.RequestMode == RequestModeImagen {
+		if a.RequestMode != RequestModeGemini {
+			suffix = "predict"
+		} else if info.IsStream {
 			suffix = "streamGenerateContent?alt=sse"
 		} else {
 			suffix = "generateContent"
@@ -148,6 +185,9 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 
 func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Header, info *relaycommon.RelayInfo) error {
 	channel.SetupApiRequestHeader(info, c, req)
+	if gemini.IsAudioRelayMode(info.RelayMode) {
+		req.Set("Content-Type", "application/json")
+	}
 	accessToken, err := getAccessToken(a, info)
 	if err != nil {
 		return err
@@ -165,6 +205,7 @@ func (a *Adaptor) ConvertOpenAIRequest(c *gin.Context, info *relaycommon.RelayIn
 		if err != nil {
 			return nil, err
 		}
+		info.StructuredOutput = service.GetStructuredOutputSchema(request)
 		vertexClaudeReq := copyRequest(claudeReq, anthropicVersion)
 		c.Set("request_model", claudeReq.Model)
 		info.UpstreamModelName = claudeReq.Model
@@ -187,13 +228,45 @@ func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dt
 }
 
 func (a *Adaptor) ConvertEmbeddingRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.EmbeddingRequest) (any, error) {
-	//TODO implement me
-	return nil, errors.New("not implemented")
+	if a.RequestMode != RequestModeEmbedding {
+		return nil, errors.New("not supported model for embedding")
+	}
+	if request.Input == nil {
+		return nil, errors.New("input is required")
+	}
+	inputs := request.ParseInput()
+	if len(inputs) == 0 {
+		return nil, errors.New("input is empty")
+	}
+	maxInstances := maxEmbeddingInstances
+	if strings.HasPrefix(info.UpstreamModelName, "gemini-embedding") {
+		maxInstances = maxGeminiEmbeddingInstances
+	}
+	if len(inputs) > maxInstances {
+		return nil, fmt.Errorf("model %s accepts at most %d inputs per request", info.UpstreamModelName, maxInstances)
+	}
+
+	vertexRequest := VertexEmbeddingRequest{
+		Instances: make([]VertexEmbeddingInstance, 0, len(inputs)),
+		Parameters: VertexEmbeddingParameters{
+			AutoTruncate:         true,
+			OutputDimensionality: request.Dimensions,
+		},
+	}
+	for _, input := range inputs {
+		vertexRequest.Instances = append(vertexRequest.Instances, VertexEmbeddingInstance{Content: input})
+	}
+	return vertexRequest, nil
+}
+
+func isEmbeddingModel(model string) bool {
+	return strings.HasPrefix(model, "text-embedding") ||
+		strings.HasPrefix(model, "text-multilingual-embedding") ||
+		strings.HasPrefix(model, "gemini-embedding")
 }
 
 func (a *Adaptor) ConvertOpenAIResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.OpenAIResponsesRequest) (any, error) {
-	// TODO implement me
-	return nil, errors.New("not implemented")
+	return channel.ConvertResponsesRequestByOpenAI(a, c, info, request)
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody io.Reader) (any, error) {
@@ -201,6 +274,19 @@ func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, request
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *dto.OpenAIErrorWithStatusCode) {
+	if a.RequestMode == RequestModeGemini && info.RelayFormat == relaycommon.RelayFormatGemini {
+		return gemini.GeminiNativeResponseHandler(c, resp, info)
+	}
+	switch a.RequestMode {
+	case RequestModeEmbedding:
+		err, usage = vertexEmbeddingHandler(c, resp, info)
+		return
+	case RequestModeImagen:
+		return gemini.GeminiImageHandler(c, resp, info)
+	}
+	if a.RequestMode == RequestModeGemini && gemini.IsAudioRelayMode(info.RelayMode) {
+		return gemini.GeminiAudioHandler(c, resp, info, a.ResponseFormat)
+	}
 	if info.IsStream {
 		switch a.RequestMode {
 		case RequestModeClaude:
@@ -223,6 +309,30 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycom
 	return
 }
 
+// CountClaudeTokens 调用 Vertex AI 上 Anthropic 的 count-tokens 接口
+func (a *Adaptor) CountClaudeTokens(c *gin.Context, info *relaycommon.RelayInfo, request *dto.ClaudeRequest) (int, error) {
+	if a.RequestMode != RequestModeClaude {
+		return 0, errors.New("count tokens is only supported for claude models")
+	}
+	adc := &Credentials{}
+	if err := json.Unmarshal([]byte(info.ApiKey), adc); err != nil {
+		return 0, fmt.Errorf("failed to decode credentials file: %w", err)
+	}
+	a.AccountCredentials = *adc
+	region := GetModelRegion(info.ApiVersion, info.OriginModelName)
+	fullRequestURL := fmt.Sprintf(
+		"https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/anthropic/models/count-tokens:rawPredict",
+		region,
+		adc.ProjectID,
+		region,
+	)
+	countRequest := request.ToCountTokensRequest()
+	if v, ok := claudeModelMap[info.UpstreamModelName]; ok {
+		countRequest.Model = v
+	}
+	return channel.DoClaudeCountTokensRequest(a, c, info, fullRequestURL, countRequest)
+}
+
 func (a *Adaptor) GetModelList() []string {
 	var modelList []string
 	for i, s := range ModelList {
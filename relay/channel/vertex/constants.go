@@ -26,6 +26,14 @@ var ModelList = []string{
 	//"gemini-1.5-pro-001", "gemini-1.5-flash-001", "gemini-pro", "gemini-pro-vision",
 
 	"meta/llama3-405b-instruct-maas",
+
+	// embedding models
+	"text-embedding-005",
+	"text-multilingual-embedding-002",
+	"gemini-embedding-001",
+	// imagen models
+	"imagen-3.0-generate-002",
+	"imagen-3.0-fast-generate-001",
 }
 
 var ChannelName = "vertex-ai"
@@ -51,3 +51,38 @@ func copyRequest(req *dto.ClaudeRequest, version string) *VertexAIClaudeRequest
 		Thinking:         req.Thinking,
 	}
 }
+
+// VertexEmbeddingRequest text-embedding / gemini-embedding 模型的 predict 请求
+// https://cloud.google.com/vertex-ai/generative-ai/docs/model-reference/text-embeddings-api
+type VertexEmbeddingRequest struct {
+	Instances  []VertexEmbeddingInstance `json:"instances"`
+	Parameters VertexEmbeddingParameters `json:"parameters"`
+}
+
+type VertexEmbeddingInstance struct {
+	Content  string `json:"content"`
+	TaskType string `json:"task_type,omitempty"`
+}
+
+type VertexEmbeddingParameters struct {
+	AutoTruncate         bool `json:"autoTruncate"`
+	OutputDimensionality int  `json:"outputDimensionality,omitempty"`
+}
+
+type VertexEmbeddingResponse struct {
+	Predictions []VertexEmbeddingPrediction `json:"predictions"`
+}
+
+type VertexEmbeddingPrediction struct {
+	Embeddings VertexEmbedding `json:"embeddings"`
+}
+
+type VertexEmbedding struct {
+	Values     []float64                 `json:"values"`
+	Statistics VertexEmbeddingStatistics `json:"statistics"`
+}
+
+type VertexEmbeddingStatistics struct {
+	TokenCount float64 `json:"token_count"`
+	Truncated  bool    `json:"truncated"`
+}
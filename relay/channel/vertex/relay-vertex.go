@@ -16,7 +16,17 @@
 // along with this program. If not, see <https://www.gnu.org/licenses/>.
 package vertex
 
-import "veloera/common"
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"veloera/common"
+	"veloera/dto"
+	relaycommon "veloera/relay/common"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+)
 
 func GetModelRegion(other string, localModelName string) string {
 	// if other is json string
@@ -30,3 +40,50 @@ func GetModelRegion(other string, localModelName string) string {
 	}
 	return other
 }
+
+// vertexEmbeddingHandler 将 predict 返回的向量转换为 OpenAI 格式，按上游统计的 token 数计费
+func vertexEmbeddingHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	_ = resp.Body.Close()
+
+	var vertexResponse VertexEmbeddingResponse
+	if err = json.Unmarshal(responseBody, &vertexResponse); err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+
+	openAIResponse := dto.OpenAIEmbeddingResponse{
+		Object: "list",
+		Data:   make([]dto.OpenAIEmbeddingResponseItem, 0, len(vertexResponse.Predictions)),
+		Model:  info.UpstreamModelName,
+	}
+	promptTokens := 0
+	for i, prediction := range vertexResponse.Predictions {
+		openAIResponse.Data = append(openAIResponse.Data, dto.OpenAIEmbeddingResponseItem{
+			Object:    "embedding",
+			Embedding: prediction.Embeddings.Values,
+			Index:     i,
+		})
+		promptTokens += int(prediction.Embeddings.Statistics.TokenCount)
+	}
+	// 上游未返回统计信息时使用本地估算的 token 数
+	if promptTokens == 0 {
+		promptTokens = info.PromptTokens
+	}
+	usage := &dto.Usage{
+		PromptTokens: promptTokens,
+		TotalTokens:  promptTokens,
+	}
+	openAIResponse.Usage = *usage
+
+	jsonResponse, err := json.Marshal(openAIResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, _ = c.Writer.Write(jsonResponse)
+	return nil, usage
+}
@@ -27,7 +27,9 @@ import (
 	"strings"
 	"veloera/common"
 	"veloera/dto"
+	"veloera/relay/channel"
 	relaycommon "veloera/relay/common"
+	relayconstant "veloera/relay/constant"
 	"veloera/relay/helper"
 	"veloera/service"
 	"veloera/setting/model_setting"
@@ -72,6 +74,9 @@ func prependClaudeSystemPromptIfNeeded(c *gin.Context, textRequest *dto.ClaudeRe
 func ClaudeHelper(c *gin.Context) (claudeError *dto.ClaudeErrorWithStatusCode) {
 
 	relayInfo := relaycommon.GenRelayInfoClaude(c)
+	if relayInfo.RelayMode == relayconstant.RelayModeClaudeCountTokens {
+		return claudeCountTokensHelper(c, relayInfo)
+	}
 
 	// get & validate textRequest 获取并验证文本请求
 	textRequest, err := getAndValidateClaudeRequest(c)
@@ -111,7 +116,7 @@ func ClaudeHelper(c *gin.Context) (claudeError *dto.ClaudeErrorWithStatusCode) {
 		return service.OpenAIErrorToClaudeError(openaiErr)
 	}
 	defer func() {
-		if openaiErr != nil {
+		if claudeError != nil {
 			returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
 		}
 	}()
@@ -180,14 +185,32 @@ func ClaudeHelper(c *gin.Context) (claudeError *dto.ClaudeErrorWithStatusCode) {
 		}
 	}
 
+	// 适配器不支持原生 Claude 格式时，将其输出的 OpenAI 格式响应转换回 Claude 格式
+	var claudeWriter *claudeResponseWriter
+	if relayInfo.ClaudeConvertInfo.OverOpenAI {
+		claudeWriter = newClaudeResponseWriter(c, relayInfo)
+	}
 	usage, openaiErr := adaptor.DoResponse(c, httpResp, relayInfo)
 	//log.Printf("usage: %v", usage)
 	if openaiErr != nil {
+		if claudeWriter != nil {
+			claudeWriter.abort()
+		}
 		// reset status code 重置状态码
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return service.OpenAIErrorToClaudeError(openaiErr)
 	}
-	service.PostClaudeConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
+	textUsage, _ := usage.(*dto.Usage)
+	if claudeWriter != nil {
+		claudeWriter.finish(textUsage)
+	}
+	if textUsage == nil {
+		textUsage = &dto.Usage{
+			PromptTokens: relayInfo.PromptTokens,
+			TotalTokens:  relayInfo.PromptTokens,
+		}
+	}
+	service.PostClaudeConsumeQuota(c, relayInfo, textUsage, preConsumedQuota, userQuota, priceData, "")
 	return nil
 }
 
@@ -201,3 +224,46 @@ func getClaudePromptTokens(textRequest *dto.ClaudeRequest, info *relaycommon.Rel
 	info.PromptTokens = promptTokens
 	return promptTokens, err
 }
+
+// claudeCountTokensHelper 在本地计算 count_tokens，开启转发后由支持的上游渠道
+// （Anthropic、Bedrock、Vertex）返回精确值，失败时回退到本地计算。不消耗额度
+func claudeCountTokensHelper(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dto.ClaudeErrorWithStatusCode {
+	textRequest := &dto.ClaudeRequest{}
+	err := common.UnmarshalBodyReusable(c, textRequest)
+	if err != nil {
+		return service.ClaudeErrorWrapperLocal(err, "invalid_claude_request", http.StatusBadRequest)
+	}
+	if len(textRequest.Messages) == 0 {
+		return service.ClaudeErrorWrapperLocal(errors.New("field messages is required"), "invalid_claude_request", http.StatusBadRequest)
+	}
+
+	prependClaudeSystemPromptIfNeeded(c, textRequest)
+
+	err = helper.ModelMappedHelper(c, relayInfo)
+	if err != nil {
+		return service.ClaudeErrorWrapperLocal(err, "model_mapped_error", http.StatusInternalServerError)
+	}
+	textRequest.Model = relayInfo.UpstreamModelName
+
+	if model_setting.GetClaudeSettings().CountTokensForwardEnabled {
+		adaptor := GetAdaptor(relayInfo.ApiType)
+		if counter, ok := adaptor.(channel.ClaudeTokenCounter); ok {
+			adaptor.Init(relayInfo)
+			inputTokens, err := counter.CountClaudeTokens(c, relayInfo, textRequest)
+			if err == nil {
+				c.JSON(http.StatusOK, dto.ClaudeCountTokensResponse{InputTokens: inputTokens})
+				c.Set("response_written", true)
+				return nil
+			}
+			common.LogWarn(c, fmt.Sprintf("forward count_tokens failed, fallback to local count: %s", err.Error()))
+		}
+	}
+
+	inputTokens, err := service.CountTokenClaudeRequest(*textRequest, relayInfo.UpstreamModelName)
+	if err != nil {
+		return service.ClaudeErrorWrapperLocal(err, "count_token_messages_failed", http.StatusInternalServerError)
+	}
+	c.JSON(http.StatusOK, dto.ClaudeCountTokensResponse{InputTokens: inputTokens})
+	c.Set("response_written", true)
+	return nil
+}
@@ -0,0 +1,190 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"veloera/common"
+	"veloera/dto"
+	relaycommon "veloera/relay/common"
+	"veloera/relay/helper"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// claudeResponseWriter converts the OpenAI chat completions response written by an
+// adaptor into Claude Messages format. Stream chunks are converted as they arrive,
+// a non-stream body is buffered and converted once the adaptor is done.
+type claudeResponseWriter struct {
+	gin.ResponseWriter
+	c          *gin.Context
+	info       *relaycommon.RelayInfo
+	buffer     bytes.Buffer
+	statusCode int
+	started    bool
+}
+
+func newClaudeResponseWriter(c *gin.Context, info *relaycommon.RelayInfo) *claudeResponseWriter {
+	w := &claudeResponseWriter{
+		ResponseWriter: c.Writer,
+		c:              c,
+		info:           info,
+		statusCode:     http.StatusOK,
+	}
+	c.Writer = w
+	return w
+}
+
+func (w *claudeResponseWriter) WriteHeader(code int) {
+	if w.info.IsStream {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.statusCode = code
+}
+
+func (w *claudeResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *claudeResponseWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if !w.info.IsStream {
+		return len(data), nil
+	}
+	for {
+		idx := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+		line := string(w.buffer.Next(idx + 1))
+		w.handleStreamLine(strings.TrimRight(line, "\r\n"))
+	}
+	return len(data), nil
+}
+
+func (w *claudeResponseWriter) Flush() {
+	if w.info.IsStream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *claudeResponseWriter) handleStreamLine(line string) {
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "" || data == "[DONE]" {
+		return
+	}
+	var streamResponse dto.ChatCompletionsStreamResponse
+	if err := common.DecodeJsonStr(data, &streamResponse); err != nil {
+		common.SysError("error unmarshalling stream response: " + err.Error())
+		return
+	}
+	if service.ValidUsage(streamResponse.Usage) {
+		w.info.ClaudeConvertInfo.Usage = streamResponse.Usage
+	}
+	w.start(streamResponse.Id, streamResponse.Model)
+	w.info.SendResponseCount++
+	w.send(service.StreamResponseOpenAI2Claude(&streamResponse, w.info))
+}
+
+// start sends message_start before the first converted chunk
+func (w *claudeResponseWriter) start(id string, model string) {
+	if w.started {
+		return
+	}
+	w.started = true
+	if id == "" {
+		id = helper.GetResponseID(w.c)
+	}
+	if model == "" {
+		model = w.info.UpstreamModelName
+	}
+	w.info.SendResponseCount = 1
+	w.send(service.StreamResponseOpenAI2Claude(&dto.ChatCompletionsStreamResponse{
+		Id:    id,
+		Model: model,
+	}, w.info))
+}
+
+func (w *claudeResponseWriter) send(claudeResponses []*dto.ClaudeResponse) {
+	for _, resp := range claudeResponses {
+		jsonData, err := json.Marshal(resp)
+		if err != nil {
+			common.SysError("error marshalling claude response: " + err.Error())
+			continue
+		}
+		_, _ = w.ResponseWriter.WriteString(fmt.Sprintf("event: %s\ndata: %s\n\n", resp.Type, jsonData))
+	}
+	w.ResponseWriter.Flush()
+}
+
+// finish writes the remaining Claude events (or the converted non-stream body)
+// and restores the original writer. usage is the usage returned by the adaptor.
+func (w *claudeResponseWriter) finish(usage *dto.Usage) {
+	w.c.Writer = w.ResponseWriter
+	if w.info.IsStream {
+		if w.buffer.Len() > 0 {
+			w.handleStreamLine(strings.TrimSpace(w.buffer.String()))
+			w.buffer.Reset()
+		}
+		w.start("", "")
+		if service.ValidUsage(usage) {
+			w.info.ClaudeConvertInfo.Usage = usage
+		}
+		w.info.ClaudeConvertInfo.Done = true
+		w.info.SendResponseCount++
+		w.send(service.StreamResponseOpenAI2Claude(&dto.ChatCompletionsStreamResponse{}, w.info))
+		return
+	}
+
+	responseBody := w.buffer.Bytes()
+	var openAIResponse dto.OpenAITextResponse
+	if err := common.DecodeJson(responseBody, &openAIResponse); err == nil && w.statusCode == http.StatusOK {
+		claudeResponse := service.ResponseOpenAI2Claude(&openAIResponse, w.info)
+		if claudeResponse.Id == "" {
+			claudeResponse.Id = helper.GetResponseID(w.c)
+		}
+		if claudeResponse.Model == "" {
+			claudeResponse.Model = w.info.UpstreamModelName
+		}
+		if service.ValidUsage(usage) {
+			claudeResponse.Usage.InputTokens = usage.PromptTokens
+			claudeResponse.Usage.OutputTokens = usage.CompletionTokens
+			claudeResponse.Usage.CacheReadInputTokens = usage.PromptTokensDetails.CachedTokens
+		}
+		if jsonData, err := json.Marshal(claudeResponse); err == nil {
+			responseBody = jsonData
+		}
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	_, _ = w.ResponseWriter.Write(responseBody)
+}
+
+// abort restores the original writer without writing anything, used on adaptor errors
+func (w *claudeResponseWriter) abort() {
+	w.c.Writer = w.ResponseWriter
+}
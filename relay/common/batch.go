@@ -0,0 +1,40 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package common
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+type batchIdContextKey struct{}
+
+// WithBatchId marks a request as part of a batch. The id is carried in the request
+// context instead of a header so that clients can't claim the batch discount.
+func WithBatchId(ctx context.Context, batchId string) context.Context {
+	return context.WithValue(ctx, batchIdContextKey{}, batchId)
+}
+
+// GetBatchId returns the batch id of a request executed by the batch processor
+func GetBatchId(c *gin.Context) string {
+	if c.Request == nil {
+		return ""
+	}
+	batchId, _ := c.Request.Context().Value(batchIdContextKey{}).(string)
+	return batchId
+}
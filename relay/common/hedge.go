@@ -0,0 +1,94 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package common
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	HedgeRolePrimary = "primary"
+	HedgeRoleHedge   = "hedge"
+)
+
+// HedgeLostErrorCode is the error code returned by the attempt that lost the
+// hedge. It is not an upstream failure and must not be recorded against the channel.
+const HedgeLostErrorCode = "hedge_lost"
+
+// HedgeState is shared by the attempts of a hedged request. Only the attempt
+// that claims the result first is billed and logged.
+type HedgeState struct {
+	PrimaryChannelId int
+	HedgeChannelId   int
+	claimed          int32
+	launched         int32
+}
+
+// Claim reports whether the caller won the hedge
+func (s *HedgeState) Claim() bool {
+	return atomic.CompareAndSwapInt32(&s.claimed, 0, 1)
+}
+
+// Claimed reports whether one of the attempts has already won
+func (s *HedgeState) Claimed() bool {
+	return atomic.LoadInt32(&s.claimed) == 1
+}
+
+// MarkLaunched records that the second request was sent
+func (s *HedgeState) MarkLaunched(hedgeChannelId int) {
+	s.HedgeChannelId = hedgeChannelId
+	atomic.StoreInt32(&s.launched, 1)
+}
+
+func (s *HedgeState) Launched() bool {
+	return atomic.LoadInt32(&s.launched) == 1
+}
+
+// HedgeAttempt is one of the concurrent requests of a hedged request
+type HedgeAttempt struct {
+	State *HedgeState
+	Role  string
+}
+
+type hedgeAttemptContextKey struct{}
+
+// WithHedgeAttempt marks a request as an attempt of a hedged request
+func WithHedgeAttempt(ctx context.Context, attempt *HedgeAttempt) context.Context {
+	return context.WithValue(ctx, hedgeAttemptContextKey{}, attempt)
+}
+
+// GetHedgeAttempt returns the hedge attempt of a request, or nil if the request is not hedged
+func GetHedgeAttempt(c *gin.Context) *HedgeAttempt {
+	if c.Request == nil {
+		return nil
+	}
+	attempt, _ := c.Request.Context().Value(hedgeAttemptContextKey{}).(*HedgeAttempt)
+	return attempt
+}
+
+// ClaimHedgeResult must be called before billing. It returns false if another
+// attempt of the same hedged request has already won.
+func ClaimHedgeResult(c *gin.Context) bool {
+	attempt := GetHedgeAttempt(c)
+	if attempt == nil {
+		return true
+	}
+	return attempt.State.Claim()
+}
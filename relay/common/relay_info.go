@@ -23,6 +23,7 @@ import (
 	"veloera/constant"
 	"veloera/dto"
 	relayconstant "veloera/relay/constant"
+	"veloera/setting/model_setting"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -47,11 +48,15 @@ type ClaudeConvertInfo struct {
 	Usage            *dto.Usage
 	FinishReason     string
 	Done             bool
+	// OverOpenAI 表示请求已转换为 chat completions 格式交由适配器处理，响应需转换回 Claude 格式
+	OverOpenAI bool
+	ToolCallId string
 }
 
 const (
 	RelayFormatOpenAI = "openai"
 	RelayFormatClaude = "claude"
+	RelayFormatGemini = "gemini"
 )
 
 type RerankerInfo struct {
@@ -102,6 +107,13 @@ type RelayInfo struct {
 	UserEmail            string
 	UserQuota            int
 	RelayFormat          string
+	// ResponsesOverOpenAI 表示 responses 请求已转换为 chat completions 格式交由适配器处理
+	ResponsesOverOpenAI bool
+	// StructuredOutput 模拟 json_schema 输出时请求的 schema，非流式响应会按其校验
+	StructuredOutput *dto.FormatJsonSchema
+	// BatchId 批处理执行的请求所属的批处理，BatchRatio 为其计费倍率
+	BatchId              string
+	BatchRatio           float64
 	SendResponseCount    int
 	ChannelCreateTime    int64
 	PromptMessages       interface{}            // 保存请求的消息内容
@@ -143,6 +155,15 @@ func GenRelayInfoClaude(c *gin.Context) *RelayInfo {
 	return info
 }
 
+func GenRelayInfoGemini(c *gin.Context) *RelayInfo {
+	info := GenRelayInfo(c)
+	info.RelayFormat = RelayFormatGemini
+	info.ShouldIncludeUsage = false
+	_, action := relayconstant.ParseGeminiModelPath(c.Request.URL.Path)
+	info.IsStream = action == relayconstant.GeminiActionStreamGenerateContent
+	return info
+}
+
 func GenRelayInfoRerank(c *gin.Context, req *dto.RerankRequest) *RelayInfo {
 	info := GenRelayInfo(c)
 	info.RelayMode = relayconstant.RelayModeRerank
@@ -186,6 +207,10 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 
 	// Handle virtual model mapping for display and upstream names
 	upstreamModelName := originalModel
+	// Prefixed models are routed by the prefixed name, strip the prefix for the upstream
+	if modelPrefix := c.GetString("model_prefix"); modelPrefix != "" {
+		upstreamModelName = strings.TrimPrefix(c.GetString("original_model"), modelPrefix)
+	}
 	isVirtualModelMapped := false
 	
 	if virtualModelMapped && virtualModelOriginal != "" && virtualModelActual != "" {
@@ -247,13 +272,55 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 	if streamSupportedChannels[info.ChannelType] {
 		info.SupportStreamOptions = true
 	}
+	if batchId := GetBatchId(c); batchId != "" {
+		info.BatchId = batchId
+		info.BatchRatio = model_setting.GetBatchSettings().DiscountRatio
+	}
 	// responses 模式不支持 StreamOptions
 	if relayconstant.RelayModeResponses == info.RelayMode {
 		info.SupportStreamOptions = false
 	}
+	c.Set(constant.ContextKeyRelayInfo, info)
 	return info
 }
 
+// SetClaudeOverOpenAI switches a Claude Messages relay to the chat completions
+// pipeline, the adaptor then writes OpenAI format and the relay converts it back.
+func (info *RelayInfo) SetClaudeOverOpenAI() {
+	info.RelayFormat = RelayFormatOpenAI
+	info.RelayMode = relayconstant.RelayModeChatCompletions
+	info.RequestURLPath = "/v1/chat/completions"
+	info.ShouldIncludeUsage = true
+	if info.ClaudeConvertInfo == nil {
+		info.ClaudeConvertInfo = &ClaudeConvertInfo{
+			LastMessagesType: LastMessageTypeNone,
+		}
+	}
+	info.ClaudeConvertInfo.OverOpenAI = true
+}
+
+// SetGeminiOverOpenAI switches a Gemini native relay to the OpenAI pipeline for
+// channels that do not speak Gemini, the relay converts the response back.
+func (info *RelayInfo) SetGeminiOverOpenAI() {
+	info.RelayFormat = RelayFormatOpenAI
+	if info.RelayMode == relayconstant.RelayModeEmbeddings {
+		info.RequestURLPath = "/v1/embeddings"
+		return
+	}
+	info.RelayMode = relayconstant.RelayModeChatCompletions
+	info.RequestURLPath = "/v1/chat/completions"
+	info.ShouldIncludeUsage = true
+}
+
+// SetResponsesOverOpenAI switches a responses relay to the chat completions
+// pipeline, the relay synthesizes the responses output from the chat response.
+func (info *RelayInfo) SetResponsesOverOpenAI() {
+	info.RelayMode = relayconstant.RelayModeChatCompletions
+	info.RequestURLPath = "/v1/chat/completions"
+	info.ShouldIncludeUsage = true
+	info.ResponsesOverOpenAI = true
+}
+
 func (info *RelayInfo) SetPromptTokens(promptTokens int) {
 	info.PromptTokens = promptTokens
 }
@@ -0,0 +1,40 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package common
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+type shadowRequestContextKey struct{}
+
+// WithShadowRequest marks a request as a mirrored shadow request. Shadow
+// requests are never billed and their responses are not sent to the client.
+func WithShadowRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, shadowRequestContextKey{}, true)
+}
+
+// IsShadowRequest reports whether the request is a shadow request
+func IsShadowRequest(c *gin.Context) bool {
+	if c.Request == nil {
+		return false
+	}
+	shadow, _ := c.Request.Context().Value(shadowRequestContextKey{}).(bool)
+	return shadow
+}
@@ -58,12 +58,28 @@ const (
 	RelayModeResponses
 
 	RelayModeRealtime
+
+	RelayModeGeminiCountTokens
+
+	RelayModeImagesEdits
+	RelayModeImagesVariations
+
+	RelayModeClaudeCountTokens
+)
+
+const (
+	GeminiActionGenerateContent       = "generateContent"
+	GeminiActionStreamGenerateContent = "streamGenerateContent"
+	GeminiActionCountTokens           = "countTokens"
+	GeminiActionEmbedContent          = "embedContent"
 )
 
 func Path2RelayMode(path string) int {
 	relayMode := RelayModeUnknown
 	if strings.HasPrefix(path, "/v1/chat/completions") || strings.HasPrefix(path, "/pg/chat/completions") {
 		relayMode = RelayModeChatCompletions
+	} else if strings.HasPrefix(path, "/v1/messages/count_tokens") {
+		relayMode = RelayModeClaudeCountTokens
 	} else if strings.HasPrefix(path, "/v1/completions") {
 		relayMode = RelayModeCompletions
 	} else if strings.HasPrefix(path, "/v1/embeddings") {
@@ -74,6 +90,10 @@ func Path2RelayMode(path string) int {
 		relayMode = RelayModeModerations
 	} else if strings.HasPrefix(path, "/v1/images/generations") {
 		relayMode = RelayModeImagesGenerations
+	} else if strings.HasPrefix(path, "/v1/images/edits") {
+		relayMode = RelayModeImagesEdits
+	} else if strings.HasPrefix(path, "/v1/images/variations") {
+		relayMode = RelayModeImagesVariations
 	} else if strings.HasPrefix(path, "/v1/edits") {
 		relayMode = RelayModeEdits
 	} else if strings.HasPrefix(path, "/v1/responses") {
@@ -88,6 +108,32 @@ func Path2RelayMode(path string) int {
 		relayMode = RelayModeRerank
 	} else if strings.HasPrefix(path, "/v1/realtime") {
 		relayMode = RelayModeRealtime
+	} else if strings.HasPrefix(path, "/v1beta/models/") {
+		relayMode = Path2RelayModeGemini(path)
+	}
+	return relayMode
+}
+
+// ParseGeminiModelPath splits /v1beta/models/{model}:{action} into model and action
+func ParseGeminiModelPath(path string) (string, string) {
+	path = strings.TrimPrefix(path, "/v1beta/models/")
+	idx := strings.LastIndex(path, ":")
+	if idx < 0 {
+		return path, ""
+	}
+	return path[:idx], path[idx+1:]
+}
+
+func Path2RelayModeGemini(path string) int {
+	relayMode := RelayModeUnknown
+	_, action := ParseGeminiModelPath(path)
+	switch action {
+	case GeminiActionGenerateContent, GeminiActionStreamGenerateContent:
+		relayMode = RelayModeChatCompletions
+	case GeminiActionEmbedContent:
+		relayMode = RelayModeEmbeddings
+	case GeminiActionCountTokens:
+		relayMode = RelayModeGeminiCountTokens
 	}
 	return relayMode
 }
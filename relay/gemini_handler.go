@@ -0,0 +1,324 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/relay/channel"
+	"veloera/relay/channel/gemini"
+	relaycommon "veloera/relay/common"
+	relayconstant "veloera/relay/constant"
+	"veloera/relay/helper"
+	"veloera/service"
+	"veloera/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+type geminiCountTokensRequest struct {
+	Contents               []gemini.GeminiChatContent `json:"contents"`
+	GenerateContentRequest *gemini.GeminiChatRequest  `json:"generateContentRequest"`
+}
+
+// GeminiHelper serves the native Gemini endpoints /v1beta/models/{model}:{action}.
+// Gemini channels receive the request as is, other channels go through the
+// chat completions (or embeddings) pipeline and the response is converted back.
+func GeminiHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
+	relayInfo := relaycommon.GenRelayInfoGemini(c)
+	switch relayInfo.RelayMode {
+	case relayconstant.RelayModeChatCompletions:
+		return geminiChatHelper(c, relayInfo)
+	case relayconstant.RelayModeEmbeddings:
+		return geminiEmbeddingHelper(c, relayInfo)
+	case relayconstant.RelayModeGeminiCountTokens:
+		return geminiCountTokensHelper(c, relayInfo)
+	}
+	_, action := relayconstant.ParseGeminiModelPath(c.Request.URL.Path)
+	return service.OpenAIErrorWrapperLocal(fmt.Errorf("unsupported action: %s", action), "invalid_gemini_request", http.StatusNotFound)
+}
+
+func geminiChatHelper(c *gin.Context, relayInfo *relaycommon.RelayInfo) (openaiErr *dto.OpenAIErrorWithStatusCode) {
+	geminiRequest := &gemini.GeminiChatRequest{}
+	err := common.UnmarshalBodyReusable(c, geminiRequest)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "invalid_gemini_request", http.StatusBadRequest)
+	}
+	if len(geminiRequest.Contents) == 0 {
+		return service.OpenAIErrorWrapperLocal(errors.New("field contents is required"), "invalid_gemini_request", http.StatusBadRequest)
+	}
+
+	err = helper.ModelMappedHelper(c, relayInfo)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "model_mapped_error", http.StatusInternalServerError)
+	}
+
+	textRequest, err := gemini.GeminiToOpenAIRequest(geminiRequest, relayInfo)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "invalid_gemini_request", http.StatusBadRequest)
+	}
+
+	tokenGroup := c.GetString("token_group")
+	if setting.ShouldCheckPromptSensitiveWithGroup(tokenGroup) {
+		words, err := checkRequestSensitive(textRequest, relayInfo)
+		if err != nil {
+			common.LogWarn(c, fmt.Sprintf("user sensitive words detected: %s", strings.Join(words, ", ")))
+			return service.OpenAIErrorWrapperLocal(err, "sensitive_words_detected", http.StatusBadRequest)
+		}
+	}
+
+	// 获取 promptTokens，如果上下文中已经存在，则直接使用
+	var promptTokens int
+	if value, exists := c.Get("prompt_tokens"); exists {
+		promptTokens = value.(int)
+		relayInfo.PromptTokens = promptTokens
+	} else {
+		promptTokens, err = getPromptTokens(textRequest, relayInfo)
+		if err != nil {
+			return service.OpenAIErrorWrapper(err, "count_token_messages_failed", http.StatusInternalServerError)
+		}
+		c.Set("prompt_tokens", promptTokens)
+	}
+
+	priceData, err := helper.ModelPriceHelper(c, relayInfo, promptTokens, int(textRequest.MaxTokens))
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "model_price_error", http.StatusInternalServerError)
+	}
+
+	// pre-consume quota 预消耗配额
+	preConsumedQuota, userQuota, openaiErr := preConsumeQuota(c, priceData.ShouldPreConsumedQuota, relayInfo)
+	if openaiErr != nil {
+		return openaiErr
+	}
+	defer func() {
+		if openaiErr != nil {
+			returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
+		}
+	}()
+
+	adaptor := GetAdaptor(relayInfo.ApiType)
+	if adaptor == nil {
+		return service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(relayInfo)
+
+	var requestBody io.Reader
+	overOpenAI := !gemini.IsGeminiNativeChannel(relayInfo)
+	if overOpenAI {
+		prependSystemPromptIfNeeded(c, textRequest, relayInfo)
+		relayInfo.SetGeminiOverOpenAI()
+		if textRequest.Stream && relayInfo.SupportStreamOptions {
+			textRequest.StreamOptions = &dto.StreamOptions{
+				IncludeUsage: true,
+			}
+		}
+		convertedRequest, err := adaptor.ConvertOpenAIRequest(c, relayInfo, textRequest)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
+		}
+		jsonData, err := json.Marshal(convertedRequest)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
+		}
+		if common.DebugEnabled {
+			println("requestBody: ", string(jsonData))
+		}
+		requestBody = bytes.NewBuffer(jsonData)
+	} else {
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "get_request_body_failed", http.StatusInternalServerError)
+		}
+		requestBody = bytes.NewBuffer(body)
+	}
+
+	usage, openaiErr := doGeminiRequest(c, relayInfo, adaptor, requestBody, overOpenAI)
+	if openaiErr != nil {
+		return openaiErr
+	}
+	postConsumeQuota(c, relayInfo, usage, preConsumedQuota, userQuota, priceData, "")
+	return nil
+}
+
+func geminiEmbeddingHelper(c *gin.Context, relayInfo *relaycommon.RelayInfo) (openaiErr *dto.OpenAIErrorWithStatusCode) {
+	geminiRequest := &gemini.GeminiEmbeddingRequest{}
+	err := common.UnmarshalBodyReusable(c, geminiRequest)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "invalid_gemini_request", http.StatusBadRequest)
+	}
+
+	err = helper.ModelMappedHelper(c, relayInfo)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "model_mapped_error", http.StatusInternalServerError)
+	}
+
+	embeddingRequest := gemini.GeminiEmbeddingToOpenAI(geminiRequest, relayInfo)
+	promptTokens := getEmbeddingPromptToken(*embeddingRequest)
+	relayInfo.PromptTokens = promptTokens
+
+	priceData, err := helper.ModelPriceHelper(c, relayInfo, promptTokens, 0)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "model_price_error", http.StatusInternalServerError)
+	}
+	// pre-consume quota 预消耗配额
+	preConsumedQuota, userQuota, openaiErr := preConsumeQuota(c, priceData.ShouldPreConsumedQuota, relayInfo)
+	if openaiErr != nil {
+		return openaiErr
+	}
+	defer func() {
+		if openaiErr != nil {
+			returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
+		}
+	}()
+
+	adaptor := GetAdaptor(relayInfo.ApiType)
+	if adaptor == nil {
+		return service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(relayInfo)
+
+	var requestBody io.Reader
+	overOpenAI := relayInfo.ChannelType != common.ChannelTypeGemini
+	if overOpenAI {
+		relayInfo.SetGeminiOverOpenAI()
+		convertedRequest, err := adaptor.ConvertEmbeddingRequest(c, relayInfo, *embeddingRequest)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
+		}
+		jsonData, err := json.Marshal(convertedRequest)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
+		}
+		requestBody = bytes.NewBuffer(jsonData)
+	} else {
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "get_request_body_failed", http.StatusInternalServerError)
+		}
+		requestBody = bytes.NewBuffer(body)
+	}
+
+	usage, openaiErr := doGeminiRequest(c, relayInfo, adaptor, requestBody, overOpenAI)
+	if openaiErr != nil {
+		return openaiErr
+	}
+	postConsumeQuota(c, relayInfo, usage, preConsumedQuota, userQuota, priceData, "")
+	return nil
+}
+
+// geminiCountTokensHelper forwards countTokens to Gemini channels and counts
+// locally for every other channel. It never consumes quota.
+func geminiCountTokensHelper(c *gin.Context, relayInfo *relaycommon.RelayInfo) *dto.OpenAIErrorWithStatusCode {
+	countRequest := &geminiCountTokensRequest{}
+	err := common.UnmarshalBodyReusable(c, countRequest)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "invalid_gemini_request", http.StatusBadRequest)
+	}
+
+	err = helper.ModelMappedHelper(c, relayInfo)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "model_mapped_error", http.StatusInternalServerError)
+	}
+
+	if relayInfo.ChannelType == common.ChannelTypeGemini {
+		adaptor := GetAdaptor(relayInfo.ApiType)
+		if adaptor == nil {
+			return service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
+		}
+		adaptor.Init(relayInfo)
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "get_request_body_failed", http.StatusInternalServerError)
+		}
+		_, openaiErr := doGeminiRequest(c, relayInfo, adaptor, bytes.NewBuffer(body), false)
+		return openaiErr
+	}
+
+	geminiRequest := countRequest.GenerateContentRequest
+	if geminiRequest == nil {
+		geminiRequest = &gemini.GeminiChatRequest{Contents: countRequest.Contents}
+	}
+	relayInfo.RelayMode = relayconstant.RelayModeChatCompletions
+	textRequest, err := gemini.GeminiToOpenAIRequest(geminiRequest, relayInfo)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "invalid_gemini_request", http.StatusBadRequest)
+	}
+	promptTokens, err := service.CountTokenChatRequest(relayInfo, *textRequest)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "count_token_messages_failed", http.StatusInternalServerError)
+	}
+	c.JSON(http.StatusOK, gemini.GeminiCountTokensResponse{
+		TotalTokens: promptTokens,
+	})
+	c.Set("response_written", true)
+	return nil
+}
+
+// doGeminiRequest sends the prepared request and writes the response in Gemini
+// format, converting it when the adaptor answers in OpenAI format.
+func doGeminiRequest(c *gin.Context, relayInfo *relaycommon.RelayInfo, adaptor channel.Adaptor, requestBody io.Reader, overOpenAI bool) (*dto.Usage, *dto.OpenAIErrorWithStatusCode) {
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	if err != nil {
+		return nil, service.OpenAIErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+
+	var httpResp *http.Response
+	if resp != nil {
+		httpResp = resp.(*http.Response)
+		relayInfo.IsStream = relayInfo.IsStream || strings.HasPrefix(httpResp.Header.Get("Content-Type"), "text/event-stream")
+		if httpResp.StatusCode != http.StatusOK {
+			openaiErr := service.RelayErrorHandler(httpResp, false)
+			// reset status code 重置状态码
+			service.ResetStatusCode(openaiErr, statusCodeMappingStr)
+			return nil, openaiErr
+		}
+	}
+
+	var geminiWriter *geminiResponseWriter
+	if overOpenAI {
+		geminiWriter = newGeminiResponseWriter(c, relayInfo)
+	}
+	usage, openaiErr := adaptor.DoResponse(c, httpResp, relayInfo)
+	if openaiErr != nil {
+		if geminiWriter != nil {
+			geminiWriter.abort()
+		}
+		// reset status code 重置状态码
+		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
+		return nil, openaiErr
+	}
+	textUsage, _ := usage.(*dto.Usage)
+	if geminiWriter != nil {
+		geminiWriter.finish(textUsage)
+	}
+	if textUsage == nil {
+		textUsage = &dto.Usage{
+			PromptTokens: relayInfo.PromptTokens,
+			TotalTokens:  relayInfo.PromptTokens,
+		}
+	}
+	c.Set("response_written", true)
+	return textUsage, nil
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/relay/channel/gemini"
+	relaycommon "veloera/relay/common"
+	relayconstant "veloera/relay/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// geminiResponseWriter converts the OpenAI response written by an adaptor into
+// the native Gemini format, the counterpart of claudeResponseWriter.
+type geminiResponseWriter struct {
+	gin.ResponseWriter
+	c          *gin.Context
+	info       *relaycommon.RelayInfo
+	buffer     bytes.Buffer
+	statusCode int
+	converter  *gemini.OpenAI2GeminiStreamConverter
+}
+
+func newGeminiResponseWriter(c *gin.Context, info *relaycommon.RelayInfo) *geminiResponseWriter {
+	w := &geminiResponseWriter{
+		ResponseWriter: c.Writer,
+		c:              c,
+		info:           info,
+		statusCode:     http.StatusOK,
+		converter:      gemini.NewOpenAI2GeminiStreamConverter(info.UpstreamModelName),
+	}
+	c.Writer = w
+	return w
+}
+
+func (w *geminiResponseWriter) WriteHeader(code int) {
+	if w.info.IsStream {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.statusCode = code
+}
+
+func (w *geminiResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *geminiResponseWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if !w.info.IsStream {
+		return len(data), nil
+	}
+	for {
+		idx := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+		line := string(w.buffer.Next(idx + 1))
+		w.handleStreamLine(strings.TrimRight(line, "\r\n"))
+	}
+	return len(data), nil
+}
+
+func (w *geminiResponseWriter) Flush() {
+	if w.info.IsStream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *geminiResponseWriter) handleStreamLine(line string) {
+	if strings.HasPrefix(line, ":") {
+		// keep the ping comments so the client connection stays alive
+		_, _ = w.ResponseWriter.WriteString(line + "\n\n")
+		w.ResponseWriter.Flush()
+		return
+	}
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "" || data == "[DONE]" {
+		return
+	}
+	var streamResponse dto.ChatCompletionsStreamResponse
+	if err := common.DecodeJsonStr(data, &streamResponse); err != nil {
+		common.SysError("error unmarshalling stream response: " + err.Error())
+		return
+	}
+	if geminiResponse := w.converter.Convert(&streamResponse); geminiResponse != nil {
+		w.send(geminiResponse)
+	}
+}
+
+func (w *geminiResponseWriter) send(geminiResponse *gemini.GeminiChatResponse) {
+	jsonData, err := json.Marshal(geminiResponse)
+	if err != nil {
+		common.SysError("error marshalling gemini response: " + err.Error())
+		return
+	}
+	_, _ = w.ResponseWriter.WriteString(fmt.Sprintf("data: %s\n\n", jsonData))
+	w.ResponseWriter.Flush()
+}
+
+// finish writes the last Gemini chunk (or the converted non-stream body) and
+// restores the original writer. usage is the usage returned by the adaptor.
+func (w *geminiResponseWriter) finish(usage *dto.Usage) {
+	w.c.Writer = w.ResponseWriter
+	if w.info.IsStream {
+		if w.buffer.Len() > 0 {
+			w.handleStreamLine(strings.TrimSpace(w.buffer.String()))
+			w.buffer.Reset()
+		}
+		w.send(w.converter.Finish(usage))
+		return
+	}
+
+	responseBody := w.buffer.Bytes()
+	if w.statusCode == http.StatusOK {
+		var converted any
+		if w.info.RelayMode == relayconstant.RelayModeEmbeddings {
+			var embeddingResponse dto.OpenAIEmbeddingResponse
+			if err := common.DecodeJson(responseBody, &embeddingResponse); err == nil {
+				converted = gemini.EmbeddingResponseOpenAI2Gemini(&embeddingResponse)
+			}
+		} else {
+			var openAIResponse dto.OpenAITextResponse
+			if err := common.DecodeJson(responseBody, &openAIResponse); err == nil {
+				converted = gemini.ResponseOpenAI2Gemini(&openAIResponse, usage)
+			}
+		}
+		if converted != nil {
+			if jsonData, err := json.Marshal(converted); err == nil {
+				responseBody = jsonData
+			}
+		}
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	_, _ = w.ResponseWriter.Write(responseBody)
+}
+
+// abort restores the original writer without writing anything, used on adaptor errors
+func (w *geminiResponseWriter) abort() {
+	w.c.Writer = w.ResponseWriter
+}
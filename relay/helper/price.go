@@ -33,13 +33,14 @@ type PriceData struct {
 	CompletionRatio        float64
 	CacheRatio             float64
 	GroupRatio             float64
+	BatchRatio             float64
 	UsePrice               bool
 	CacheCreationRatio     float64
 	ShouldPreConsumedQuota int
 }
 
 func (p PriceData) ToSetting() string {
-	return fmt.Sprintf("ModelPrice: %f, ModelRatio: %f, CompletionRatio: %f, CacheRatio: %f, GroupRatio: %f, UsePrice: %t, CacheCreationRatio: %f, ShouldPreConsumedQuota: %d", p.ModelPrice, p.ModelRatio, p.CompletionRatio, p.CacheRatio, p.GroupRatio, p.UsePrice, p.CacheCreationRatio, p.ShouldPreConsumedQuota)
+	return fmt.Sprintf("ModelPrice: %f, ModelRatio: %f, CompletionRatio: %f, CacheRatio: %f, GroupRatio: %f, BatchRatio: %f, UsePrice: %t, CacheCreationRatio: %f, ShouldPreConsumedQuota: %d", p.ModelPrice, p.ModelRatio, p.CompletionRatio, p.CacheRatio, p.GroupRatio, p.BatchRatio, p.UsePrice, p.CacheCreationRatio, p.ShouldPreConsumedQuota)
 }
 
 func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens int, completionTokens int) (PriceData, error) {
@@ -59,6 +60,11 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 
 	modelPrice, usePrice := operation_setting.GetModelPriceWithFallback(modelNameForPrice, false)
 	groupRatio := setting.GetGroupRatio(info.Group)
+	batchRatio := 1.0
+	if info.BatchId != "" {
+		// 批处理请求按折扣倍率计费，作为独立倍率参与计算
+		batchRatio = info.BatchRatio
+	}
 	var preConsumedQuota int
 	var modelRatio float64
 	var completionRatio float64
@@ -86,10 +92,10 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		completionRatio = operation_setting.GetCompletionRatioWithFallback(modelNameForRatio)
 		cacheRatio, _ = operation_setting.GetCacheRatio(modelNameForRatio)
 		cacheCreationRatio, _ = operation_setting.GetCreateCacheRatio(modelNameForRatio)
-		ratio := modelRatio * groupRatio
+		ratio := modelRatio * groupRatio * batchRatio
 		preConsumedQuota = int(float64(preConsumedTokens) * ratio)
 	} else {
-		preConsumedQuota = int(modelPrice * common.QuotaPerUnit * groupRatio)
+		preConsumedQuota = int(modelPrice * common.QuotaPerUnit * groupRatio * batchRatio)
 	}
 
 	priceData := PriceData{
@@ -97,6 +103,7 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		ModelRatio:             modelRatio,
 		CompletionRatio:        completionRatio,
 		GroupRatio:             groupRatio,
+		BatchRatio:             batchRatio,
 		UsePrice:               usePrice,
 		CacheRatio:             cacheRatio,
 		CacheCreationRatio:     cacheCreationRatio,
@@ -22,12 +22,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"strconv"
 	"strings"
 	"veloera/common"
 	"veloera/dto"
 	"veloera/model"
 	relaycommon "veloera/relay/common"
+	relayconstant "veloera/relay/constant"
 	"veloera/relay/helper"
 	"veloera/service"
 	"veloera/setting"
@@ -35,15 +38,62 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func getAndValidImageRequest(c *gin.Context, info *relaycommon.RelayInfo) (*dto.ImageRequest, error) {
-	imageRequest := &dto.ImageRequest{}
-	err := common.UnmarshalBodyReusable(c, imageRequest)
+// getImageRequestFromForm 从 multipart 表单中读取图片编辑和变体请求的参数，
+// 解析得到的表单会设置到 c.Request.MultipartForm 上供适配器重新构造请求
+func getImageRequestFromForm(c *gin.Context, info *relaycommon.RelayInfo) (*dto.ImageRequest, error) {
+	form, err := common.ParseMultipartFormReusable(c)
 	if err != nil {
 		return nil, err
 	}
-	if imageRequest.Prompt == "" {
+	c.Request.MultipartForm = form
+	imageRequest := &dto.ImageRequest{
+		Model:          getFormValue(form, "model"),
+		Prompt:         getFormValue(form, "prompt"),
+		Size:           getFormValue(form, "size"),
+		Quality:        getFormValue(form, "quality"),
+		ResponseFormat: getFormValue(form, "response_format"),
+		User:           getFormValue(form, "user"),
+	}
+	if n := getFormValue(form, "n"); n != "" {
+		imageRequest.N, err = strconv.Atoi(n)
+		if err != nil || imageRequest.N < 1 {
+			return nil, errors.New("n must be a positive integer")
+		}
+	}
+	if len(form.File["image"]) == 0 && len(form.File["image[]"]) == 0 {
+		return nil, errors.New("image is required")
+	}
+	if info.RelayMode == relayconstant.RelayModeImagesEdits && imageRequest.Prompt == "" {
 		return nil, errors.New("prompt is required")
 	}
+	return imageRequest, nil
+}
+
+func getFormValue(form *multipart.Form, key string) string {
+	if values := form.Value[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func getAndValidImageRequest(c *gin.Context, info *relaycommon.RelayInfo) (*dto.ImageRequest, error) {
+	var imageRequest *dto.ImageRequest
+	if info.RelayMode == relayconstant.RelayModeImagesEdits || info.RelayMode == relayconstant.RelayModeImagesVariations {
+		var err error
+		imageRequest, err = getImageRequestFromForm(c, info)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		imageRequest = &dto.ImageRequest{}
+		err := common.UnmarshalBodyReusable(c, imageRequest)
+		if err != nil {
+			return nil, err
+		}
+		if imageRequest.Prompt == "" {
+			return nil, errors.New("prompt is required")
+		}
+	}
 	if strings.Contains(imageRequest.Size, "×") {
 		return nil, errors.New("size an unexpected error occurred in the parameter, please use 'x' instead of the multiplication sign '×'")
 	}
@@ -78,7 +128,7 @@ func getAndValidImageRequest(c *gin.Context, info *relaycommon.RelayInfo) (*dto.
 	//	return service.OpenAIErrorWrapper(errors.New("n must be between 1 and 10"), "invalid_field_value", http.StatusBadRequest)
 	//}
 	tokenGroup := c.GetString("token_group")
-	if setting.ShouldCheckPromptSensitiveWithGroup(tokenGroup) {
+	if imageRequest.Prompt != "" && setting.ShouldCheckPromptSensitiveWithGroup(tokenGroup) {
 		words, err := service.CheckSensitiveInput(imageRequest.Prompt)
 		if err != nil {
 			common.LogWarn(c, fmt.Sprintf("user sensitive words detected: %s", strings.Join(words, ",")))
@@ -92,6 +142,9 @@ func ImageHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 	relayInfo := relaycommon.GenRelayInfo(c)
 
 	imageRequest, err := getAndValidImageRequest(c, relayInfo)
+	if c.Request.MultipartForm != nil {
+		defer c.Request.MultipartForm.RemoveAll()
+	}
 	if err != nil {
 		common.LogError(c, fmt.Sprintf("getAndValidImageRequest failed: %s", err.Error()))
 		return service.OpenAIErrorWrapper(err, "invalid_image_request", http.StatusBadRequest)
@@ -137,7 +190,7 @@ func ImageHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 	}
 
 	priceData.ModelPrice *= sizeRatio * qualityRatio * float64(imageRequest.N)
-	quota := int(priceData.ModelPrice * priceData.GroupRatio * common.QuotaPerUnit)
+	quota := int(priceData.ModelPrice * priceData.GroupRatio * priceData.BatchRatio * common.QuotaPerUnit)
 
 	if userQuota-quota < 0 {
 		return service.OpenAIErrorWrapperLocal(fmt.Errorf("image pre-consumed quota failed, user quota: %s, need quota: %s", common.FormatQuota(userQuota), common.FormatQuota(quota)), "insufficient_user_quota", http.StatusForbidden)
@@ -156,11 +209,16 @@ func ImageHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 		return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
 	}
 
-	jsonData, err := json.Marshal(convertedRequest)
-	if err != nil {
-		return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
+	if reader, ok := convertedRequest.(io.Reader); ok {
+		// 图片编辑和变体请求由适配器构造 multipart 请求体
+		requestBody = reader
+	} else {
+		jsonData, err := json.Marshal(convertedRequest)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
+		}
+		requestBody = bytes.NewBuffer(jsonData)
 	}
-	requestBody = bytes.NewBuffer(jsonData)
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
 
@@ -28,6 +28,7 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"veloera/common"
+	"veloera/constant"
 	"veloera/dto"
 	relaycommon "veloera/relay/common"
 	"veloera/relay/helper"
@@ -72,6 +73,13 @@ func ResponsesHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 		return openaiErr
 	}
 
+	// Expand the conversation history of previous_response_id
+	storeInput := req.Input
+	upstreamReq, openaiErr := handlePreviousResponse(c, req)
+	if openaiErr != nil {
+		return openaiErr
+	}
+
 	// Handle model mapping and token counting
 	openaiErr = handleModelAndTokens(c, relayInfo, req)
 	if openaiErr != nil {
@@ -92,13 +100,14 @@ func ResponsesHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 	}()
 
 	// Prepare and send request
-	httpResp, openaiErr := prepareAndSendRequest(c, relayInfo, req)
+	upstreamReq.Model = req.Model
+	httpResp, openaiErr := prepareAndSendRequest(c, relayInfo, upstreamReq)
 	if openaiErr != nil {
 		return openaiErr
 	}
 
 	// Process response and handle quota consumption
-	usage, openaiErr := processResponse(c, httpResp, relayInfo)
+	usage, openaiErr := processResponse(c, httpResp, relayInfo, req)
 	if openaiErr != nil {
 		return openaiErr
 	}
@@ -106,9 +115,49 @@ func ResponsesHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 	// Post-consume quota
 	postProcessQuota(c, relayInfo, usage, preConsumedQuota, userQuota, priceData)
 
+	// Store the response for later retrieval and previous_response_id chaining
+	storeResponse(c, req, storeInput)
+
 	return nil
 }
 
+// handlePreviousResponse expands the stored history into the input when gateway storage is enabled,
+// the returned request is the one sent upstream, without the previous_response_id the upstream doesn't know
+func handlePreviousResponse(c *gin.Context, req *dto.OpenAIResponsesRequest) (*dto.OpenAIResponsesRequest, *dto.OpenAIErrorWithStatusCode) {
+	upstreamReq := req
+	if req.PreviousResponseID == "" || !model_setting.GetResponsesSettings().StoreEnabled {
+		return upstreamReq, nil
+	}
+	err := service.ExpandPreviousResponse(c, req)
+	if err != nil {
+		if errors.Is(err, service.ErrPreviousResponseNotFound) {
+			return nil, service.OpenAIErrorWrapperLocal(err, "previous_response_not_found", http.StatusBadRequest)
+		}
+		return nil, service.OpenAIErrorWrapperLocal(err, "expand_previous_response_failed", http.StatusInternalServerError)
+	}
+	upstreamReq = &dto.OpenAIResponsesRequest{}
+	*upstreamReq = *req
+	upstreamReq.PreviousResponseID = ""
+	return upstreamReq, nil
+}
+
+func storeResponse(c *gin.Context, req *dto.OpenAIResponsesRequest, input json.RawMessage) {
+	if !model_setting.GetResponsesSettings().StoreEnabled || !req.ShouldStore() {
+		return
+	}
+	value, exists := c.Get(constant.ContextKeyResponsesResponse)
+	if !exists {
+		return
+	}
+	response, ok := value.(*dto.OpenAIResponsesResponse)
+	if !ok || response == nil {
+		return
+	}
+	if err := service.StoreResponse(c, req, input, response); err != nil {
+		common.LogError(c, fmt.Sprintf("store response error: %s", err.Error()))
+	}
+}
+
 func validateAndPrepareRequest(c *gin.Context, relayInfo *relaycommon.RelayInfo) (*dto.OpenAIResponsesRequest, *dto.OpenAIErrorWithStatusCode) {
 	req, err := getAndValidateResponsesRequest(c, relayInfo)
 	if err != nil {
@@ -247,17 +296,37 @@ func applyParamOverride(jsonData []byte, paramOverride map[string]interface{}) (
 	return json.Marshal(reqMap)
 }
 
-func processResponse(c *gin.Context, httpResp *http.Response, relayInfo *relaycommon.RelayInfo) (*dto.Usage, *dto.OpenAIErrorWithStatusCode) {
+func processResponse(c *gin.Context, httpResp *http.Response, relayInfo *relaycommon.RelayInfo, req *dto.OpenAIResponsesRequest) (*dto.Usage, *dto.OpenAIErrorWithStatusCode) {
 	adaptor := GetAdaptor(relayInfo.ApiType)
+	adaptor.Init(relayInfo)
+
+	// 适配器不支持原生 Responses 格式时，将其输出的 OpenAI 格式响应转换为 Responses 格式
+	var responsesWriter *responsesResponseWriter
+	if relayInfo.ResponsesOverOpenAI {
+		responsesWriter = newResponsesResponseWriter(c, relayInfo, req)
+	}
 	usage, openaiErr := adaptor.DoResponse(c, httpResp, relayInfo)
 
 	if openaiErr != nil {
+		if responsesWriter != nil {
+			responsesWriter.abort()
+		}
 		statusCodeMappingStr := c.GetString("status_code_mapping")
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return nil, openaiErr
 	}
 
-	return usage.(*dto.Usage), nil
+	textUsage, _ := usage.(*dto.Usage)
+	if responsesWriter != nil {
+		responsesWriter.finish(textUsage)
+	}
+	if textUsage == nil {
+		textUsage = &dto.Usage{
+			PromptTokens: relayInfo.PromptTokens,
+			TotalTokens:  relayInfo.PromptTokens,
+		}
+	}
+	return textUsage, nil
 }
 
 func postProcessQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData) {
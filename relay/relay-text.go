@@ -318,7 +318,11 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 			c.Set("response_written", true)
 		}
 	} else {
-		usage, openaiErr = adaptor.DoResponse(c, httpResp, relayInfo)
+		if shouldValidateStructuredOutput(relayInfo) {
+			usage, openaiErr = doStructuredOutputResponse(c, adaptor, relayInfo, textRequest, httpResp)
+		} else {
+			usage, openaiErr = adaptor.DoResponse(c, httpResp, relayInfo)
+		}
 		// 对于非流式响应，如果没有错误则标记响应已写入
 		if openaiErr == nil {
 			c.Set("response_written", true)
@@ -333,6 +337,20 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		return openaiErr
 	}
 
+	// 影子请求只记录用量，不计费
+	if relaycommon.IsShadowRequest(c) {
+		if pseudoStream && stopHeartbeat != nil {
+			stopHeartbeat()
+		}
+		c.Set("shadow_usage", usage)
+		return nil
+	}
+
+	// 对冲请求只对胜出的一方计费
+	if !relaycommon.ClaimHedgeResult(c) {
+		return service.OpenAIErrorWrapperLocal(errors.New("hedged request lost"), relaycommon.HedgeLostErrorCode, http.StatusInternalServerError)
+	}
+
 	if strings.HasPrefix(relayInfo.OriginModelName, "gpt-4o-audio") {
 		service.PostAudioConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
 	} else {
@@ -382,6 +400,10 @@ func checkRequestSensitive(textRequest *dto.GeneralOpenAIRequest, info *relaycom
 
 // 预扣费并返回用户剩余配额
 func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) (int, int, *dto.OpenAIErrorWithStatusCode) {
+	// 影子请求不计费
+	if relaycommon.IsShadowRequest(c) {
+		return 0, 0, nil
+	}
 	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
 	if err != nil {
 		return 0, 0, service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
@@ -458,6 +480,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	cacheRatio := priceData.CacheRatio
 	modelRatio := priceData.ModelRatio
 	groupRatio := priceData.GroupRatio
+	batchRatio := priceData.BatchRatio
 	modelPrice := priceData.ModelPrice
 
 	// Convert values to decimal for precise calculation
@@ -468,10 +491,11 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	dCacheRatio := decimal.NewFromFloat(cacheRatio)
 	dModelRatio := decimal.NewFromFloat(modelRatio)
 	dGroupRatio := decimal.NewFromFloat(groupRatio)
+	dBatchRatio := decimal.NewFromFloat(batchRatio)
 	dModelPrice := decimal.NewFromFloat(modelPrice)
 	dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
 
-	ratio := dModelRatio.Mul(dGroupRatio)
+	ratio := dModelRatio.Mul(dGroupRatio).Mul(dBatchRatio)
 
 	var quotaCalculateDecimal decimal.Decimal
 	if !priceData.UsePrice {
@@ -486,7 +510,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 			quotaCalculateDecimal = decimal.NewFromInt(1)
 		}
 	} else {
-		quotaCalculateDecimal = dModelPrice.Mul(dQuotaPerUnit).Mul(dGroupRatio)
+		quotaCalculateDecimal = dModelPrice.Mul(dQuotaPerUnit).Mul(dGroupRatio).Mul(dBatchRatio)
 	}
 
 	quota := int(quotaCalculateDecimal.Round(0).IntPart())
@@ -498,6 +522,9 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	} else {
 		logContent = fmt.Sprintf("模型价格 %.2f，分组倍率 %.2f", modelPrice, groupRatio)
 	}
+	if relayInfo.BatchId != "" {
+		logContent += fmt.Sprintf("，批处理倍率 %.2f", batchRatio)
+	}
 
 	// record all the consume log even if quota is 0
 	if totalTokens == 0 {
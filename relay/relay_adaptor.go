@@ -26,6 +26,7 @@ import (
 	"veloera/relay/channel/claude"
 	"veloera/relay/channel/cloudflare"
 	"veloera/relay/channel/cohere"
+	"veloera/relay/channel/customprovider"
 	"veloera/relay/channel/deepseek"
 	"veloera/relay/channel/dify"
 	"veloera/relay/channel/gemini"
@@ -103,6 +104,8 @@ func GetAdaptor(apiType int) channel.Adaptor {
 		return &openai.Adaptor{}
 	case constant.APITypeXai:
 		return &xai.Adaptor{}
+	case constant.APITypeCustomProvider:
+		return &customprovider.Adaptor{}
 	}
 	return nil
 }
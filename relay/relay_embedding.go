@@ -19,6 +19,7 @@ package relay
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"veloera/common"
@@ -128,6 +129,15 @@ func EmbeddingHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return openaiErr
 	}
+	// 影子请求只记录用量，不计费
+	if relaycommon.IsShadowRequest(c) {
+		c.Set("shadow_usage", usage)
+		return nil
+	}
+	// 对冲请求只对胜出的一方计费
+	if !relaycommon.ClaimHedgeResult(c) {
+		return service.OpenAIErrorWrapperLocal(errors.New("hedged request lost"), relaycommon.HedgeLostErrorCode, http.StatusInternalServerError)
+	}
 	// 标记响应已写入，用于空回复检测
 	c.Set("response_written", true)
 	postConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
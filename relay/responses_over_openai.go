@@ -0,0 +1,178 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"veloera/common"
+	"veloera/constant"
+	"veloera/dto"
+	relaycommon "veloera/relay/common"
+	"veloera/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responsesResponseWriter converts the OpenAI chat completions response written by an
+// adaptor into the Responses API format, synthesizing the response.* events when streaming.
+type responsesResponseWriter struct {
+	gin.ResponseWriter
+	c          *gin.Context
+	info       *relaycommon.RelayInfo
+	request    *dto.OpenAIResponsesRequest
+	buffer     bytes.Buffer
+	statusCode int
+	started    bool
+	converter  *service.ResponsesStreamConverter
+	// response is the final response object, available after finish
+	response *dto.OpenAIResponsesResponse
+}
+
+func newResponsesResponseWriter(c *gin.Context, info *relaycommon.RelayInfo, request *dto.OpenAIResponsesRequest) *responsesResponseWriter {
+	w := &responsesResponseWriter{
+		ResponseWriter: c.Writer,
+		c:              c,
+		info:           info,
+		request:        request,
+		statusCode:     http.StatusOK,
+		converter:      service.NewResponsesStreamConverter(request, info),
+	}
+	c.Writer = w
+	return w
+}
+
+func (w *responsesResponseWriter) WriteHeader(code int) {
+	if w.info.IsStream {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.statusCode = code
+}
+
+func (w *responsesResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *responsesResponseWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if !w.info.IsStream {
+		return len(data), nil
+	}
+	for {
+		idx := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+		line := string(w.buffer.Next(idx + 1))
+		w.handleStreamLine(strings.TrimRight(line, "\r\n"))
+	}
+	return len(data), nil
+}
+
+func (w *responsesResponseWriter) Flush() {
+	if w.info.IsStream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *responsesResponseWriter) handleStreamLine(line string) {
+	if strings.HasPrefix(line, ":") {
+		// keep the ping comments so the client connection stays alive
+		_, _ = w.ResponseWriter.WriteString(line + "\n\n")
+		w.ResponseWriter.Flush()
+		return
+	}
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "" || data == "[DONE]" {
+		return
+	}
+	var streamResponse dto.ChatCompletionsStreamResponse
+	if err := common.DecodeJsonStr(data, &streamResponse); err != nil {
+		common.SysError("error unmarshalling stream response: " + err.Error())
+		return
+	}
+	w.start()
+	w.send(w.converter.Convert(&streamResponse))
+}
+
+func (w *responsesResponseWriter) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	w.send(w.converter.Start())
+}
+
+func (w *responsesResponseWriter) send(events []dto.ResponsesStreamResponse) {
+	if len(events) == 0 {
+		return
+	}
+	for _, event := range events {
+		jsonData, err := json.Marshal(event)
+		if err != nil {
+			common.SysError("error marshalling responses event: " + err.Error())
+			continue
+		}
+		_, _ = w.ResponseWriter.WriteString(fmt.Sprintf("event: %s\ndata: %s\n\n", event.Type, jsonData))
+	}
+	w.ResponseWriter.Flush()
+}
+
+// finish writes the closing events (or the converted non-stream body) and
+// restores the original writer. usage is the usage returned by the adaptor.
+func (w *responsesResponseWriter) finish(usage *dto.Usage) {
+	w.c.Writer = w.ResponseWriter
+	if w.info.IsStream {
+		if w.buffer.Len() > 0 {
+			w.handleStreamLine(strings.TrimSpace(w.buffer.String()))
+			w.buffer.Reset()
+		}
+		w.start()
+		w.send(w.converter.Finish(usage))
+		w.response = w.converter.Response()
+		w.c.Set(constant.ContextKeyResponsesResponse, w.response)
+		return
+	}
+
+	responseBody := w.buffer.Bytes()
+	if w.statusCode == http.StatusOK {
+		var openAIResponse dto.OpenAITextResponse
+		if err := common.DecodeJson(responseBody, &openAIResponse); err == nil {
+			w.response = service.ResponseOpenAI2Responses(&openAIResponse, w.request, w.info, usage)
+			w.c.Set(constant.ContextKeyResponsesResponse, w.response)
+			if jsonData, err := json.Marshal(w.response); err == nil {
+				responseBody = jsonData
+			}
+		}
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	_, _ = w.ResponseWriter.Write(responseBody)
+}
+
+// abort restores the original writer without writing anything, used on adaptor errors
+func (w *responsesResponseWriter) abort() {
+	w.c.Writer = w.ResponseWriter
+}
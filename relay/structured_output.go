@@ -0,0 +1,211 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/relay/channel"
+	relaycommon "veloera/relay/common"
+	relayconstant "veloera/relay/constant"
+	"veloera/service"
+	"veloera/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// structuredOutputWriter 缓存模拟 json_schema 的非流式响应，校验（和修复）之后再写回客户端
+type structuredOutputWriter struct {
+	gin.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func newStructuredOutputWriter(writer gin.ResponseWriter) *structuredOutputWriter {
+	return &structuredOutputWriter{
+		ResponseWriter: writer,
+		status:         http.StatusOK,
+	}
+}
+
+func (w *structuredOutputWriter) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *structuredOutputWriter) WriteHeaderNow() {}
+
+func (w *structuredOutputWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *structuredOutputWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *structuredOutputWriter) Status() int {
+	return w.status
+}
+
+func (w *structuredOutputWriter) Written() bool {
+	return w.body.Len() > 0
+}
+
+func (w *structuredOutputWriter) flush(body []byte) {
+	// 响应体可能已被改写
+	w.ResponseWriter.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(body)
+}
+
+// shouldValidateStructuredOutput 只有模拟 json_schema 的非流式对话请求需要在返回前校验输出
+func shouldValidateStructuredOutput(info *relaycommon.RelayInfo) bool {
+	return info.StructuredOutput != nil && !info.IsStream &&
+		info.RelayMode == relayconstant.RelayModeChatCompletions &&
+		info.RelayFormat == relaycommon.RelayFormatOpenAI
+}
+
+// doStructuredOutputResponse 处理响应并按 schema 校验输出，开启修复时对不符合的输出再请求一次
+func doStructuredOutputResponse(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, textRequest *dto.GeneralOpenAIRequest, resp *http.Response) (any, *dto.OpenAIErrorWithStatusCode) {
+	writer := newStructuredOutputWriter(c.Writer)
+	c.Writer = writer
+	usage, openaiErr := adaptor.DoResponse(c, resp, info)
+	c.Writer = writer.ResponseWriter
+	if openaiErr != nil {
+		return nil, openaiErr
+	}
+	body, content, validateErr := checkStructuredOutput(writer.body.Bytes(), info.StructuredOutput)
+	if validateErr == nil {
+		writer.flush(body)
+		return usage, nil
+	}
+	if !model_setting.GetStructuredOutputSettings().RepairEnabled {
+		common.LogWarn(c, "structured output does not match schema: "+validateErr.Error())
+		writer.flush(body)
+		return usage, nil
+	}
+
+	common.LogInfo(c, "structured output does not match schema, retrying: "+validateErr.Error())
+	repairUsage, repairBody, err := repairStructuredOutput(c, adaptor, info, textRequest, content, validateErr)
+	if err != nil {
+		// 修复失败时返回第一次的输出
+		common.LogWarn(c, "structured output repair failed: "+err.Error())
+		writer.flush(body)
+		return usage, nil
+	}
+	if u, ok := usage.(*dto.Usage); ok && repairUsage != nil {
+		repairUsage.PromptTokens += u.PromptTokens
+		repairUsage.CompletionTokens += u.CompletionTokens
+		repairUsage.TotalTokens += u.TotalTokens
+	}
+	writer.flush(repairBody)
+	return repairUsage, nil
+}
+
+// repairStructuredOutput 把不符合 schema 的输出和错误反馈给模型，重新请求一次
+func repairStructuredOutput(c *gin.Context, adaptor channel.Adaptor, info *relaycommon.RelayInfo, textRequest *dto.GeneralOpenAIRequest, content string, validateErr error) (*dto.Usage, []byte, error) {
+	repairRequest := *textRequest
+	repairRequest.Messages = append(append([]dto.Message{}, textRequest.Messages...), service.StructuredOutputRepairMessages(content, validateErr)...)
+	convertedRequest, err := adaptor.ConvertOpenAIRequest(c, info, &repairRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	jsonData, err := json.Marshal(convertedRequest)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(info.ParamOverride) > 0 {
+		reqMap := make(map[string]interface{})
+		if err = json.Unmarshal(jsonData, &reqMap); err != nil {
+			return nil, nil, err
+		}
+		for key, value := range info.ParamOverride {
+			reqMap[key] = value
+		}
+		if jsonData, err = json.Marshal(reqMap); err != nil {
+			return nil, nil, err
+		}
+	}
+	resp, err := adaptor.DoRequest(c, info, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, nil, err
+	}
+	httpResp, ok := resp.(*http.Response)
+	if !ok || httpResp == nil {
+		return nil, nil, errors.New("invalid response")
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, httpResp.Body)
+		_ = httpResp.Body.Close()
+		return nil, nil, fmt.Errorf("status code %d", httpResp.StatusCode)
+	}
+
+	writer := newStructuredOutputWriter(c.Writer)
+	c.Writer = writer
+	usage, openaiErr := adaptor.DoResponse(c, httpResp, info)
+	c.Writer = writer.ResponseWriter
+	if openaiErr != nil {
+		return nil, nil, errors.New(openaiErr.Error.Message)
+	}
+	repairUsage, ok := usage.(*dto.Usage)
+	if !ok {
+		return nil, nil, errors.New("invalid usage")
+	}
+	body, _, validateErr := checkStructuredOutput(writer.body.Bytes(), info.StructuredOutput)
+	if validateErr != nil {
+		common.LogWarn(c, "repaired structured output still does not match schema: "+validateErr.Error())
+	}
+	return repairUsage, body, nil
+}
+
+// checkStructuredOutput 校验 OpenAI 格式响应中的输出，并去掉输出外层的代码块。
+// 返回可能被改写的响应体、输出内容和校验错误，响应无法解析时不做校验
+func checkStructuredOutput(body []byte, schema *dto.FormatJsonSchema) ([]byte, string, error) {
+	var response map[string]any
+	if err := json.Unmarshal(body, &response); err != nil {
+		return body, "", nil
+	}
+	choices, _ := response["choices"].([]any)
+	if len(choices) == 0 {
+		return body, "", nil
+	}
+	choice, _ := choices[0].(map[string]any)
+	message, _ := choice["message"].(map[string]any)
+	if toolCalls, _ := message["tool_calls"].([]any); len(toolCalls) > 0 {
+		// 调用了客户端自己的工具
+		return body, "", nil
+	}
+	content, ok := message["content"].(string)
+	if !ok {
+		return body, "", nil
+	}
+	output := service.ExtractStructuredOutputJSON(content)
+	if output != content {
+		message["content"] = output
+		if data, err := json.Marshal(response); err == nil {
+			body = data
+		}
+	}
+	return body, output, service.ValidateStructuredOutput(output, schema)
+}
@@ -99,6 +99,7 @@ func SetApiRouter(router *gin.Engine) {
 			optionRoute.PUT("/", controller.UpdateOption)
 			optionRoute.POST("/rest_model_ratio", controller.ResetModelRatio)
 			optionRoute.POST("/validate_fallback_pricing", controller.ValidateFallbackPricing)
+			optionRoute.POST("/routing_rules/dry_run", controller.DryRunRoutingRules)
 		}
 		channelRoute := apiRouter.Group("/channel")
 		channelRoute.Use(middleware.AdminAuth())
@@ -107,6 +108,16 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ChannelListModels)
 			channelRoute.GET("/models_enabled", controller.EnabledListModels)
+			channelRoute.GET("/breaker", controller.GetChannelBreakers)
+			channelRoute.DELETE("/breaker/:id", controller.ResetChannelBreaker)
+			channelRoute.GET("/canary", controller.GetChannelCanaries)
+			channelRoute.POST("/canary/:id", controller.RestartChannelCanary)
+			channelRoute.DELETE("/canary/:id", controller.FinishChannelCanary)
+			channelRoute.GET("/stats", controller.GetChannelStats)
+			channelRoute.GET("/schedule", controller.GetChannelSchedules)
+			channelRoute.GET("/keys/:id", controller.GetChannelKeys)
+			channelRoute.POST("/keys/:id/enable", controller.EnableChannelKey)
+			channelRoute.POST("/keys/:id/disable", controller.DisableChannelKey)
 			channelRoute.GET("/:id", controller.GetChannel)
 			channelRoute.GET("/test", controller.TestAllChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
@@ -123,6 +134,7 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.POST("/fix", controller.FixChannelsAbilities)
 			channelRoute.GET("/fetch_models/:id", controller.FetchUpstreamModels)
 			channelRoute.POST("/fetch_models", controller.FetchModels)
+			channelRoute.POST("/custom_provider/validate", controller.ValidateCustomProvider)
 			channelRoute.POST("/batch/tag", controller.BatchSetChannelTag)
 		}
 		tokenRoute := apiRouter.Group("/token")
@@ -149,6 +161,12 @@ func SetApiRouter(router *gin.Engine) {
 			redemptionRoute.PUT("/", controller.UpdateRedemption)
 			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
 		}
+		shadowLogRoute := apiRouter.Group("/shadow_log")
+		shadowLogRoute.Use(middleware.AdminAuth())
+		{
+			shadowLogRoute.GET("/", controller.GetShadowLogs)
+			shadowLogRoute.GET("/stat", controller.GetShadowLogStats)
+		}
 		logRoute := apiRouter.Group("/log")
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
@@ -0,0 +1,591 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/model"
+	relaycommon "veloera/relay/common"
+	"veloera/setting/model_setting"
+
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+const (
+	BatchCompletionWindow = "24h"
+
+	batchHeartbeatInterval = 5 * time.Second
+	// 心跳超过该时长未更新且不在本节点执行的批处理视为已中断
+	batchStaleTimeout = int64(10 * 60)
+	// 校验输入文件时最多记录的错误数
+	maxBatchValidationErrors = 100
+)
+
+// BatchEndpoints 批处理支持的接口
+var BatchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/v1/responses":        true,
+}
+
+// batchRelayHandler 执行批处理中每个请求的 HTTP 处理器，即网关自身的路由，
+// 这样每个请求都会经过与普通请求相同的鉴权、分发、重试和计费流程。
+var batchRelayHandler http.Handler
+
+// 本节点正在执行的批处理，batch.Id -> context.CancelFunc
+var runningBatches sync.Map
+
+var batchWorkers = newBatchWorkerLimiter()
+
+// InitBatchProcessor 设置执行批处理请求的处理器，需要在路由注册完成后调用
+func InitBatchProcessor(handler http.Handler) {
+	batchRelayHandler = handler
+}
+
+// batchWorkerLimiter 限制所有批处理同时执行的请求数，上限随配置实时生效
+type batchWorkerLimiter struct {
+	mu      sync.Mutex
+	running int
+	// 有名额释放时关闭并替换，唤醒所有等待者
+	released chan struct{}
+}
+
+func newBatchWorkerLimiter() *batchWorkerLimiter {
+	return &batchWorkerLimiter{released: make(chan struct{})}
+}
+
+// acquire 等待空闲名额，ctx 结束时返回错误
+func (l *batchWorkerLimiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.running < common.Max(model_setting.GetBatchSettings().WorkerCount, 1) {
+			l.running++
+			l.mu.Unlock()
+			return nil
+		}
+		released := l.released
+		l.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *batchWorkerLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+func IsBatchFinished(status string) bool {
+	switch status {
+	case model.BatchStatusCompleted, model.BatchStatusFailed, model.BatchStatusExpired, model.BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// StartBatch 在后台执行批处理
+func StartBatch(batch *model.Batch) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Unix(batch.ExpiresAt, 0))
+	runningBatches.Store(batch.Id, cancel)
+	gopool.Go(func() {
+		defer runningBatches.Delete(batch.Id)
+		defer cancel()
+		runner := &batchRunner{batch: batch, ctx: ctx, cancel: cancel}
+		runner.run()
+	})
+}
+
+// CancelBatch 取消批处理，已发出的请求会继续执行完成
+func CancelBatch(batch *model.Batch) error {
+	if _, err := batch.MarkCancelling(); err != nil {
+		return err
+	}
+	if cancel, ok := runningBatches.Load(batch.Id); ok {
+		cancel.(context.CancelFunc)()
+	}
+	// 在其他节点执行的批处理会在下一次心跳时发现取消
+	return nil
+}
+
+// CheckStaleBatch 将执行中断（例如服务重启）的批处理标记为结束，并保留已写入的结果
+func CheckStaleBatch(batch *model.Batch) {
+	if IsBatchFinished(batch.Status) {
+		return
+	}
+	if _, ok := runningBatches.Load(batch.Id); ok {
+		return
+	}
+	if common.GetTimestamp()-batch.HeartbeatAt < batchStaleTimeout {
+		return
+	}
+	runner := &batchRunner{batch: batch}
+	runner.openExistingOutputs()
+	if batch.Status == model.BatchStatusCancelling {
+		runner.finalize(model.BatchStatusCancelled)
+		return
+	}
+	runner.finalizeWithErrors(model.BatchStatusFailed, []dto.BatchError{{
+		Code:    "batch_interrupted",
+		Message: "The batch was interrupted before all requests were processed.",
+	}})
+}
+
+func BatchToOpenAI(batch *model.Batch) dto.OpenAIBatch {
+	optional := func(v int64) *int64 {
+		if v == 0 {
+			return nil
+		}
+		return &v
+	}
+	openAIBatch := dto.OpenAIBatch{
+		Id:               batch.BatchId,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		InputFileId:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		CreatedAt:        batch.CreatedAt,
+		InProgressAt:     optional(batch.InProgressAt),
+		ExpiresAt:        optional(batch.ExpiresAt),
+		FinalizingAt:     optional(batch.FinalizingAt),
+		CompletedAt:      optional(batch.CompletedAt),
+		FailedAt:         optional(batch.FailedAt),
+		ExpiredAt:        optional(batch.ExpiredAt),
+		CancellingAt:     optional(batch.CancellingAt),
+		CancelledAt:      optional(batch.CancelledAt),
+		RequestCounts: dto.BatchRequestCounts{
+			Total:     batch.TotalCount,
+			Completed: batch.CompletedCount,
+			Failed:    batch.FailedCount,
+		},
+		Metadata: batch.Metadata,
+	}
+	// 结果文件在批处理结束后才可用
+	if IsBatchFinished(batch.Status) {
+		if batch.OutputFileId != "" {
+			openAIBatch.OutputFileId = &batch.OutputFileId
+		}
+		if batch.ErrorFileId != "" {
+			openAIBatch.ErrorFileId = &batch.ErrorFileId
+		}
+	}
+	if len(batch.Errors) > 0 {
+		var batchErrors dto.BatchErrors
+		if err := json.Unmarshal(batch.Errors, &batchErrors); err == nil {
+			openAIBatch.Errors = &batchErrors
+		}
+	}
+	return openAIBatch
+}
+
+type batchOutput struct {
+	file  *model.File
+	f     *os.File
+	lines int
+}
+
+type batchRunner struct {
+	batch    *model.Batch
+	ctx      context.Context
+	cancel   context.CancelFunc
+	tokenKey string
+
+	mu          sync.Mutex
+	output      *batchOutput
+	errorOutput *batchOutput
+}
+
+func (r *batchRunner) run() {
+	requests, batchErrors, err := r.loadRequests()
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to load batch %s input: %s", r.batch.BatchId, err.Error()))
+		batchErrors = []dto.BatchError{{Code: "invalid_file", Message: "Failed to read the input file."}}
+	}
+	if len(batchErrors) > 0 {
+		r.finalizeWithErrors(model.BatchStatusFailed, batchErrors)
+		return
+	}
+	token, err := model.GetTokenById(r.batch.TokenId)
+	if err != nil {
+		r.finalizeWithErrors(model.BatchStatusFailed, []dto.BatchError{{Code: "token_not_found", Message: "The token that created this batch no longer exists."}})
+		return
+	}
+	r.tokenKey = token.Key
+
+	if err = r.createOutputs(); err != nil {
+		common.SysError(fmt.Sprintf("failed to create batch %s output: %s", r.batch.BatchId, err.Error()))
+		r.finalizeWithErrors(model.BatchStatusFailed, []dto.BatchError{{Code: "internal_error", Message: "Failed to create the output file."}})
+		return
+	}
+	now := common.GetTimestamp()
+	started, err := r.batch.TransitStatus(model.BatchStatusValidating, map[string]any{
+		"status":         model.BatchStatusInProgress,
+		"in_progress_at": now,
+		"heartbeat_at":   now,
+		"total_count":    len(requests),
+		"output_file_id": r.output.file.FileId,
+		"error_file_id":  r.errorOutput.file.FileId,
+	})
+	if err != nil || !started {
+		// 校验期间被取消
+		r.finalize(model.BatchStatusCancelled)
+		return
+	}
+
+	heartbeatDone := make(chan struct{})
+	gopool.Go(func() {
+		r.heartbeat(heartbeatDone)
+	})
+
+	var wg sync.WaitGroup
+	for _, request := range requests {
+		if r.ctx.Err() != nil {
+			break
+		}
+		if batchWorkers.acquire(r.ctx) != nil {
+			break
+		}
+		wg.Add(1)
+		request := request
+		gopool.Go(func() {
+			defer wg.Done()
+			defer batchWorkers.release()
+			r.execute(request)
+		})
+	}
+	wg.Wait()
+	close(heartbeatDone)
+
+	switch {
+	case errors.Is(r.ctx.Err(), context.DeadlineExceeded):
+		r.finalize(model.BatchStatusExpired)
+	case errors.Is(r.ctx.Err(), context.Canceled):
+		r.finalize(model.BatchStatusCancelled)
+	default:
+		r.finalize(model.BatchStatusCompleted)
+	}
+}
+
+// loadRequests 读取并校验输入文件，任何一行无效都会使整个批处理失败
+func (r *batchRunner) loadRequests() ([]dto.BatchRequestLine, []dto.BatchError, error) {
+	inputFile, err := model.GetFileByFileId(r.batch.InputFileId, r.batch.UserId)
+	if err != nil {
+		return nil, []dto.BatchError{{Code: "file_not_found", Message: "The input file does not exist.", Param: "input_file_id"}}, nil
+	}
+	f, err := os.Open(FileAbsPath(inputFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	settings := model_setting.GetBatchSettings()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), common.Max(settings.MaxFileSizeMB, 1)<<20)
+
+	requests := make([]dto.BatchRequestLine, 0)
+	batchErrors := make([]dto.BatchError, 0)
+	customIds := make(map[string]bool)
+	addError := func(line int, code string, message string) {
+		if len(batchErrors) < maxBatchValidationErrors {
+			batchErrors = append(batchErrors, dto.BatchError{Code: code, Message: message, Line: line})
+		}
+	}
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var request dto.BatchRequestLine
+		if err := json.Unmarshal(data, &request); err != nil {
+			addError(lineNumber, "invalid_json_line", "This line is not parseable as valid JSON.")
+			continue
+		}
+		switch {
+		case request.CustomId == "":
+			addError(lineNumber, "missing_required_parameter", "The custom_id parameter is required.")
+		case customIds[request.CustomId]:
+			addError(lineNumber, "duplicate_custom_id", fmt.Sprintf("The custom_id '%s' is duplicated.", request.CustomId))
+		case request.Method != http.MethodPost:
+			addError(lineNumber, "invalid_method", "Only POST requests are supported.")
+		case request.Url != r.batch.Endpoint:
+			addError(lineNumber, "invalid_url", fmt.Sprintf("The url '%s' does not match the batch endpoint '%s'.", request.Url, r.batch.Endpoint))
+		case len(request.Body) == 0 || request.Body[0] != '{':
+			addError(lineNumber, "invalid_body", "The body parameter must be a JSON object.")
+		default:
+			customIds[request.CustomId] = true
+			requests = append(requests, request)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(batchErrors) > 0 {
+		return nil, batchErrors, nil
+	}
+	if len(requests) == 0 {
+		return nil, []dto.BatchError{{Code: "empty_file", Message: "The input file contains no requests."}}, nil
+	}
+	if settings.MaxRequestsPerBatch > 0 && len(requests) > settings.MaxRequestsPerBatch {
+		return nil, []dto.BatchError{{Code: "too_many_requests", Message: fmt.Sprintf("The batch contains %d requests, the limit is %d.", len(requests), settings.MaxRequestsPerBatch)}}, nil
+	}
+	return requests, nil, nil
+}
+
+func (r *batchRunner) createOutputs() error {
+	outputFile, f, err := CreateFileForWrite(r.batch.UserId, fmt.Sprintf("%s_output.jsonl", r.batch.BatchId), FilePurposeBatchOutput)
+	if err != nil {
+		return err
+	}
+	r.output = &batchOutput{file: outputFile, f: f}
+	errorFile, f, err := CreateFileForWrite(r.batch.UserId, fmt.Sprintf("%s_error.jsonl", r.batch.BatchId), FilePurposeBatchOutput)
+	if err != nil {
+		r.output.f.Close()
+		_ = os.Remove(FileAbsPath(outputFile))
+		return err
+	}
+	r.errorOutput = &batchOutput{file: errorFile, f: f}
+	return nil
+}
+
+// openExistingOutputs 找回中断的批处理在本节点已写入的结果文件
+func (r *batchRunner) openExistingOutputs() {
+	open := func(fileId string, filename string) *batchOutput {
+		if fileId == "" {
+			return nil
+		}
+		file := &model.File{
+			FileId:      fileId,
+			UserId:      r.batch.UserId,
+			Filename:    filename,
+			Purpose:     FilePurposeBatchOutput,
+			StoragePath: fileStoragePath(r.batch.UserId, fileId),
+		}
+		stat, err := os.Stat(FileAbsPath(file))
+		if err != nil || stat.Size() == 0 {
+			return nil
+		}
+		return &batchOutput{file: file, lines: 1}
+	}
+	r.output = open(r.batch.OutputFileId, fmt.Sprintf("%s_output.jsonl", r.batch.BatchId))
+	r.errorOutput = open(r.batch.ErrorFileId, fmt.Sprintf("%s_error.jsonl", r.batch.BatchId))
+}
+
+func (r *batchRunner) heartbeat(done chan struct{}) {
+	ticker := time.NewTicker(batchHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			err := r.batch.UpdateProgress()
+			r.mu.Unlock()
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to update batch %s progress: %s", r.batch.BatchId, err.Error()))
+			}
+			// 取消请求可能由其他节点处理
+			if status, err := model.GetBatchStatus(r.batch.Id); err == nil && status == model.BatchStatusCancelling {
+				r.cancel()
+			}
+		}
+	}
+}
+
+// execute 通过网关自身的路由执行一个请求，并将结果写入输出或错误文件
+func (r *batchRunner) execute(request dto.BatchRequestLine) {
+	result := dto.BatchResponseLine{
+		Id:       fmt.Sprintf("batch_req_%s", common.GetUUID()),
+		CustomId: request.CustomId,
+	}
+	body, err := batchRequestBody(request.Body)
+	if err != nil {
+		result.Error = &dto.BatchError{Code: "invalid_body", Message: err.Error()}
+		r.write(result, false)
+		return
+	}
+	// 已发出的请求不随批处理取消而中断
+	ctx := relaycommon.WithBatchId(context.Background(), r.batch.BatchId)
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, request.Url, bytes.NewReader(body))
+	if err != nil {
+		result.Error = &dto.BatchError{Code: "invalid_request", Message: err.Error()}
+		r.write(result, false)
+		return
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Authorization", "Bearer sk-"+r.tokenKey)
+	if r.batch.ClientIP != "" {
+		// 按提交批处理的客户端 IP 执行令牌 IP 白名单、限流和路由规则
+		httpRequest.RemoteAddr = net.JoinHostPort(r.batch.ClientIP, "0")
+	}
+
+	w := &batchResponseWriter{header: make(http.Header)}
+	batchRelayHandler.ServeHTTP(w, httpRequest)
+
+	responseBody := w.body.Bytes()
+	if !json.Valid(responseBody) {
+		responseBody, _ = json.Marshal(string(responseBody))
+	}
+	result.Response = &dto.BatchResponseBody{
+		StatusCode: w.status(),
+		RequestId:  w.header.Get(common.RequestIdKey),
+		Body:       responseBody,
+	}
+	r.write(result, w.status() == http.StatusOK)
+}
+
+// batchRequestBody 批处理不支持流式响应，强制关闭 stream
+func batchRequestBody(body json.RawMessage) ([]byte, error) {
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, err
+	}
+	if _, ok := request["stream"]; ok {
+		request["stream"] = json.RawMessage("false")
+		delete(request, "stream_options")
+	}
+	return json.Marshal(request)
+}
+
+func (r *batchRunner) write(result dto.BatchResponseLine, success bool) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		common.SysError("failed to marshal batch result: " + err.Error())
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	output := r.errorOutput
+	if success {
+		output = r.output
+		r.batch.CompletedCount++
+	} else {
+		r.batch.FailedCount++
+	}
+	if _, err = output.f.Write(append(data, '\n')); err != nil {
+		common.SysError(fmt.Sprintf("failed to write batch %s result: %s", r.batch.BatchId, err.Error()))
+		return
+	}
+	output.lines++
+}
+
+// closeOutput 关闭结果文件，有内容时保存文件记录，否则删除文件，返回文件 id
+func (r *batchRunner) closeOutput(output *batchOutput) string {
+	if output == nil {
+		return ""
+	}
+	if output.f != nil {
+		if err := output.f.Close(); err != nil {
+			common.SysError(fmt.Sprintf("failed to close batch %s output: %s", r.batch.BatchId, err.Error()))
+		}
+	}
+	if output.lines == 0 {
+		_ = os.Remove(FileAbsPath(output.file))
+		return ""
+	}
+	if err := RegisterFile(output.file); err != nil {
+		common.SysError(fmt.Sprintf("failed to save batch %s output: %s", r.batch.BatchId, err.Error()))
+		return ""
+	}
+	return output.file.FileId
+}
+
+func (r *batchRunner) finalizeWithErrors(status string, batchErrors []dto.BatchError) {
+	r.batch.Errors, _ = json.Marshal(dto.BatchErrors{Object: "list", Data: batchErrors})
+	r.finalize(status)
+}
+
+func (r *batchRunner) finalize(status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := common.GetTimestamp()
+	batch := r.batch
+	if batch.InProgressAt != 0 {
+		batch.FinalizingAt = now
+	}
+	batch.OutputFileId = r.closeOutput(r.output)
+	batch.ErrorFileId = r.closeOutput(r.errorOutput)
+	batch.Status = status
+	batch.HeartbeatAt = now
+	switch status {
+	case model.BatchStatusCompleted:
+		batch.CompletedAt = now
+	case model.BatchStatusFailed:
+		batch.FailedAt = now
+	case model.BatchStatusExpired:
+		batch.ExpiredAt = now
+	case model.BatchStatusCancelled:
+		batch.CancelledAt = now
+	}
+	if err := batch.Update(); err != nil {
+		common.SysError(fmt.Sprintf("failed to update batch %s: %s", batch.BatchId, err.Error()))
+	}
+}
+
+// batchResponseWriter 收集网关处理单个批处理请求的响应
+type batchResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+func (w *batchResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *batchResponseWriter) Flush() {}
+
+func (w *batchResponseWriter) status() int {
+	if w.statusCode == 0 {
+		return http.StatusOK
+	}
+	return w.statusCode
+}
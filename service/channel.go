@@ -40,6 +40,26 @@ func DisableChannel(channelId int, channelName string, reason string) {
 	}
 }
 
+// DisableChannelKey 禁用多 key 渠道中的单个 key，所有 key 都被禁用时禁用整个渠道
+func DisableChannelKey(channelId int, channelName string, keyHash string, reason string) {
+	changed, err := model.UpdateChannelKeyStatus(channelId, keyHash, common.ChannelStatusAutoDisabled, reason)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to disable key of channel #%d: %s", channelId, err.Error()))
+		return
+	}
+	if !changed {
+		return
+	}
+	common.SysLog(fmt.Sprintf("key %s of channel #%d disabled: %s", keyHash, channelId, reason))
+	channel, err := model.GetChannelById(channelId, true)
+	if err != nil {
+		return
+	}
+	if model.CountEnabledChannelKeys(channel) == 0 {
+		DisableChannel(channelId, channelName, "所有 key 均已被禁用，最后一个 key 的禁用原因："+reason)
+	}
+}
+
 func EnableChannel(channelId int, channelName string) {
 	success := model.UpdateChannelStatusById(channelId, common.ChannelStatusEnabled, "")
 	if success {
@@ -99,6 +119,31 @@ func ShouldDisableChannel(channelType int, err *dto.OpenAIErrorWithStatusCode) b
 	return false
 }
 
+// ClassifyChannelBreakerOutcome 将请求结果归类为熔断统计使用的成功、失败或超时，返回 false 时不计入统计
+func ClassifyChannelBreakerOutcome(err *dto.OpenAIErrorWithStatusCode) (model.ChannelBreakerOutcome, bool) {
+	if err == nil {
+		return model.ChannelBreakerSuccess, true
+	}
+	if err.LocalError {
+		return model.ChannelBreakerSuccess, false
+	}
+	switch err.StatusCode {
+	case http.StatusGatewayTimeout, http.StatusRequestTimeout, 524:
+		return model.ChannelBreakerTimeout, true
+	case http.StatusTooManyRequests, http.StatusUnauthorized, http.StatusForbidden:
+		return model.ChannelBreakerFailure, true
+	}
+	if err.StatusCode/100 == 5 {
+		lowerMessage := strings.ToLower(err.Error.Message)
+		if strings.Contains(lowerMessage, "timeout") || strings.Contains(lowerMessage, "deadline exceeded") {
+			return model.ChannelBreakerTimeout, true
+		}
+		return model.ChannelBreakerFailure, true
+	}
+	// 其余 4xx 通常是请求本身的问题，说明上游可以正常响应
+	return model.ChannelBreakerSuccess, true
+}
+
 func ShouldEnableChannel(err error, openaiWithStatusErr *dto.OpenAIErrorWithStatusCode, status int) bool {
 	if !common.AutomaticEnableChannelEnabled {
 		return false
@@ -19,6 +19,7 @@ package service
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
 	"veloera/common"
 	"veloera/dto"
@@ -63,6 +64,9 @@ func ClaudeToOpenAIRequest(claudeRequest dto.ClaudeRequest, info *relaycommon.Re
 		openAITools = append(openAITools, openAITool)
 	}
 	openAIRequest.Tools = openAITools
+	if len(openAITools) > 0 && claudeRequest.ToolChoice != nil {
+		openAIRequest.ToolChoice = toolChoiceClaude2OpenAI(claudeRequest.ToolChoice)
+	}
 
 	// Convert messages
 	openAIMessages := make([]dto.Message, 0)
@@ -195,6 +199,33 @@ func ClaudeErrorToOpenAIError(claudeError *dto.ClaudeErrorWithStatusCode) *dto.O
 	}
 }
 
+func OpenAIErrorToGeminiError(openAIError *dto.OpenAIErrorWithStatusCode) *dto.GeminiError {
+	status := "UNKNOWN"
+	switch openAIError.StatusCode {
+	case http.StatusBadRequest:
+		status = "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		status = "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		status = "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		status = "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		status = "RESOURCE_EXHAUSTED"
+	case http.StatusInternalServerError:
+		status = "INTERNAL"
+	case http.StatusServiceUnavailable:
+		status = "UNAVAILABLE"
+	case http.StatusGatewayTimeout:
+		status = "DEADLINE_EXCEEDED"
+	}
+	return &dto.GeminiError{
+		Code:    openAIError.StatusCode,
+		Message: openAIError.Error.Message,
+		Status:  status,
+	}
+}
+
 func generateStopBlock(index int) *dto.ClaudeResponse {
 	return &dto.ClaudeResponse{
 		Type:  "content_block_stop",
@@ -202,6 +233,22 @@ func generateStopBlock(index int) *dto.ClaudeResponse {
 	}
 }
 
+// startClaudeBlock 关闭当前内容块（如果有）并开始一个新的内容块
+func startClaudeBlock(info *relaycommon.RelayInfo, messageType string, block *dto.ClaudeMediaMessage) []*dto.ClaudeResponse {
+	var claudeResponses []*dto.ClaudeResponse
+	if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeNone {
+		claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
+		info.ClaudeConvertInfo.Index++
+	}
+	claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
+		Index:        common.GetPointer[int](info.ClaudeConvertInfo.Index),
+		Type:         "content_block_start",
+		ContentBlock: block,
+	})
+	info.ClaudeConvertInfo.LastMessagesType = messageType
+	return claudeResponses
+}
+
 func StreamResponseOpenAI2Claude(openAIResponse *dto.ChatCompletionsStreamResponse, info *relaycommon.RelayInfo) []*dto.ClaudeResponse {
 	var claudeResponses []*dto.ClaudeResponse
 	if info.SendResponseCount == 1 {
@@ -220,141 +267,125 @@ func StreamResponseOpenAI2Claude(openAIResponse *dto.ChatCompletionsStreamRespon
 			Type:    "message_start",
 			Message: msg,
 		})
-		claudeResponses = append(claudeResponses)
 		//claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
 		//	Type: "ping",
 		//})
 		if openAIResponse.IsToolCall() {
-			resp := &dto.ClaudeResponse{
-				Type: "content_block_start",
-				ContentBlock: &dto.ClaudeMediaMessage{
-					Id:   openAIResponse.GetFirstToolCall().ID,
-					Type: "tool_use",
-					Name: openAIResponse.GetFirstToolCall().Function.Name,
-				},
+			toolCall := openAIResponse.GetFirstToolCall()
+			claudeResponses = append(claudeResponses, startClaudeBlock(info, relaycommon.LastMessageTypeTools, &dto.ClaudeMediaMessage{
+				Id:    toolCall.ID,
+				Type:  "tool_use",
+				Name:  toolCall.Function.Name,
+				Input: map[string]interface{}{},
+			})...)
+			info.ClaudeConvertInfo.ToolCallId = toolCall.ID
+			if toolCall.Function.Arguments != "" {
+				claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
+					Index: common.GetPointer[int](info.ClaudeConvertInfo.Index),
+					Type:  "content_block_delta",
+					Delta: &dto.ClaudeMediaMessage{
+						Type:        "input_json_delta",
+						PartialJson: common.GetPointer[string](toolCall.Function.Arguments),
+					},
+				})
 			}
-			resp.SetIndex(0)
-			claudeResponses = append(claudeResponses, resp)
-		} else {
-			//resp := &dto.ClaudeResponse{
-			//	Type: "content_block_start",
-			//	ContentBlock: &dto.ClaudeMediaMessage{
-			//		Type: "text",
-			//		Text: common.GetPointer[string](""),
-			//	},
-			//}
-			//resp.SetIndex(0)
-			//claudeResponses = append(claudeResponses, resp)
 		}
 		return claudeResponses
 	}
 
+	if info.Done {
+		if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeNone {
+			claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
+		}
+		messageDelta := &dto.ClaudeResponse{
+			Type:  "message_delta",
+			Usage: &dto.ClaudeUsage{},
+			Delta: &dto.ClaudeMediaMessage{
+				StopReason: common.GetPointer[string](stopReasonOpenAI2Claude(info.FinishReason)),
+			},
+		}
+		if info.ClaudeConvertInfo.Usage != nil {
+			messageDelta.Usage.InputTokens = info.ClaudeConvertInfo.Usage.PromptTokens
+			messageDelta.Usage.OutputTokens = info.ClaudeConvertInfo.Usage.CompletionTokens
+			messageDelta.Usage.CacheReadInputTokens = info.ClaudeConvertInfo.Usage.PromptTokensDetails.CachedTokens
+		}
+		claudeResponses = append(claudeResponses, messageDelta)
+		claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
+			Type: "message_stop",
+		})
+		return claudeResponses
+	}
+
 	if len(openAIResponse.Choices) == 0 {
-		// no choices
-		// TODO: handle this case
+		// usage only chunk
 		return claudeResponses
-	} else {
-		chosenChoice := openAIResponse.Choices[0]
-		if chosenChoice.FinishReason != nil && *chosenChoice.FinishReason != "" {
-			// should be done
-			info.FinishReason = *chosenChoice.FinishReason
-			return claudeResponses
-		}
-		if info.Done {
-			claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
-			if info.ClaudeConvertInfo.Usage != nil {
-				claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-					Type: "message_delta",
-					Usage: &dto.ClaudeUsage{
-						InputTokens:  info.ClaudeConvertInfo.Usage.PromptTokens,
-						OutputTokens: info.ClaudeConvertInfo.Usage.CompletionTokens,
-					},
-					Delta: &dto.ClaudeMediaMessage{
-						StopReason: common.GetPointer[string](stopReasonOpenAI2Claude(info.FinishReason)),
-					},
-				})
+	}
+	chosenChoice := openAIResponse.Choices[0]
+	if chosenChoice.FinishReason != nil && *chosenChoice.FinishReason != "" {
+		info.FinishReason = *chosenChoice.FinishReason
+	}
+
+	if len(chosenChoice.Delta.ToolCalls) > 0 {
+		for _, toolCall := range chosenChoice.Delta.ToolCalls {
+			// 新的工具调用开始一个新的 tool_use 块
+			if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeTools ||
+				(toolCall.ID != "" && toolCall.ID != info.ClaudeConvertInfo.ToolCallId) {
+				claudeResponses = append(claudeResponses, startClaudeBlock(info, relaycommon.LastMessageTypeTools, &dto.ClaudeMediaMessage{
+					Id:    toolCall.ID,
+					Type:  "tool_use",
+					Name:  toolCall.Function.Name,
+					Input: map[string]interface{}{},
+				})...)
+				info.ClaudeConvertInfo.ToolCallId = toolCall.ID
+			}
+			if toolCall.Function.Arguments == "" {
+				continue
 			}
 			claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-				Type: "message_stop",
-			})
-		} else {
-			var claudeResponse dto.ClaudeResponse
-			var isEmpty bool
-			claudeResponse.Type = "content_block_delta"
-			if len(chosenChoice.Delta.ToolCalls) > 0 {
-				if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeTools {
-					claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
-					info.ClaudeConvertInfo.Index++
-					claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-						Index: &info.ClaudeConvertInfo.Index,
-						Type:  "content_block_start",
-						ContentBlock: &dto.ClaudeMediaMessage{
-							Id:    openAIResponse.GetFirstToolCall().ID,
-							Type:  "tool_use",
-							Name:  openAIResponse.GetFirstToolCall().Function.Name,
-							Input: map[string]interface{}{},
-						},
-					})
-				}
-				info.ClaudeConvertInfo.LastMessagesType = relaycommon.LastMessageTypeTools
-				// tools delta
-				claudeResponse.Delta = &dto.ClaudeMediaMessage{
+				Index: common.GetPointer[int](info.ClaudeConvertInfo.Index),
+				Type:  "content_block_delta",
+				Delta: &dto.ClaudeMediaMessage{
 					Type:        "input_json_delta",
-					PartialJson: &chosenChoice.Delta.ToolCalls[0].Function.Arguments,
-				}
-			} else {
-				reasoning := chosenChoice.Delta.GetReasoningContent()
-				textContent := chosenChoice.Delta.GetContentString()
-				if reasoning != "" || textContent != "" {
-					if reasoning != "" {
-						if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeThinking {
-							//info.ClaudeConvertInfo.Index++
-							claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-								Index: &info.ClaudeConvertInfo.Index,
-								Type:  "content_block_start",
-								ContentBlock: &dto.ClaudeMediaMessage{
-									Type:     "thinking",
-									Thinking: "",
-								},
-							})
-						}
-						info.ClaudeConvertInfo.LastMessagesType = relaycommon.LastMessageTypeThinking
-						// text delta
-						claudeResponse.Delta = &dto.ClaudeMediaMessage{
-							Type:     "thinking_delta",
-							Thinking: reasoning,
-						}
-					} else {
-						if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeText {
-							if info.LastMessagesType == relaycommon.LastMessageTypeThinking || info.LastMessagesType == relaycommon.LastMessageTypeTools {
-								claudeResponses = append(claudeResponses, generateStopBlock(info.ClaudeConvertInfo.Index))
-								info.ClaudeConvertInfo.Index++
-							}
-							claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
-								Index: &info.ClaudeConvertInfo.Index,
-								Type:  "content_block_start",
-								ContentBlock: &dto.ClaudeMediaMessage{
-									Type: "text",
-									Text: common.GetPointer[string](""),
-								},
-							})
-						}
-						info.ClaudeConvertInfo.LastMessagesType = relaycommon.LastMessageTypeText
-						// text delta
-						claudeResponse.Delta = &dto.ClaudeMediaMessage{
-							Type: "text_delta",
-							Text: common.GetPointer[string](textContent),
-						}
-					}
-				} else {
-					isEmpty = true
-				}
-			}
-			claudeResponse.Index = &info.ClaudeConvertInfo.Index
-			if !isEmpty {
-				claudeResponses = append(claudeResponses, &claudeResponse)
-			}
+					PartialJson: common.GetPointer[string](toolCall.Function.Arguments),
+				},
+			})
 		}
+		return claudeResponses
+	}
+
+	reasoning := chosenChoice.Delta.GetReasoningContent()
+	if reasoning != "" {
+		if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeThinking {
+			claudeResponses = append(claudeResponses, startClaudeBlock(info, relaycommon.LastMessageTypeThinking, &dto.ClaudeMediaMessage{
+				Type:     "thinking",
+				Thinking: "",
+			})...)
+		}
+		claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
+			Index: common.GetPointer[int](info.ClaudeConvertInfo.Index),
+			Type:  "content_block_delta",
+			Delta: &dto.ClaudeMediaMessage{
+				Type:     "thinking_delta",
+				Thinking: reasoning,
+			},
+		})
+	}
+	textContent := chosenChoice.Delta.GetContentString()
+	if textContent != "" {
+		if info.ClaudeConvertInfo.LastMessagesType != relaycommon.LastMessageTypeText {
+			claudeResponses = append(claudeResponses, startClaudeBlock(info, relaycommon.LastMessageTypeText, &dto.ClaudeMediaMessage{
+				Type: "text",
+				Text: common.GetPointer[string](""),
+			})...)
+		}
+		claudeResponses = append(claudeResponses, &dto.ClaudeResponse{
+			Index: common.GetPointer[int](info.ClaudeConvertInfo.Index),
+			Type:  "content_block_delta",
+			Delta: &dto.ClaudeMediaMessage{
+				Type: "text_delta",
+				Text: common.GetPointer[string](textContent),
+			},
+		})
 	}
 
 	return claudeResponses
@@ -371,28 +402,51 @@ func ResponseOpenAI2Claude(openAIResponse *dto.OpenAITextResponse, info *relayco
 	}
 	for _, choice := range openAIResponse.Choices {
 		stopReason = stopReasonOpenAI2Claude(choice.FinishReason)
-		claudeContent := dto.ClaudeMediaMessage{}
-		if choice.FinishReason == "tool_calls" {
-			claudeContent.Type = "tool_use"
-			claudeContent.Id = choice.Message.ToolCallId
-			claudeContent.Name = choice.Message.ParseToolCalls()[0].Function.Name
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			contents = append(contents, dto.ClaudeMediaMessage{
+				Type:     "thinking",
+				Thinking: reasoning,
+			})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			claudeContent := dto.ClaudeMediaMessage{
+				Type: "text",
+			}
+			claudeContent.SetText(text)
+			contents = append(contents, claudeContent)
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			claudeContent := dto.ClaudeMediaMessage{
+				Type: "tool_use",
+				Id:   toolCall.ID,
+				Name: toolCall.Function.Name,
+			}
 			var mapParams map[string]interface{}
-			if err := json.Unmarshal([]byte(choice.Message.ParseToolCalls()[0].Function.Arguments), &mapParams); err == nil {
+			if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &mapParams); err == nil {
 				claudeContent.Input = mapParams
 			} else {
-				claudeContent.Input = choice.Message.ParseToolCalls()[0].Function.Arguments
+				claudeContent.Input = toolCall.Function.Arguments
 			}
-		} else {
-			claudeContent.Type = "text"
-			claudeContent.SetText(choice.Message.StringContent())
+			contents = append(contents, claudeContent)
+		}
+	}
+	if len(contents) == 0 {
+		claudeContent := dto.ClaudeMediaMessage{
+			Type: "text",
 		}
+		claudeContent.SetText("")
 		contents = append(contents, claudeContent)
 	}
 	claudeResponse.Content = contents
 	claudeResponse.StopReason = stopReason
 	claudeResponse.Usage = &dto.ClaudeUsage{
-		InputTokens:  openAIResponse.PromptTokens,
-		OutputTokens: openAIResponse.CompletionTokens,
+		InputTokens:          openAIResponse.PromptTokens,
+		OutputTokens:         openAIResponse.CompletionTokens,
+		CacheReadInputTokens: openAIResponse.PromptTokensDetails.CachedTokens,
 	}
 
 	return claudeResponse
@@ -400,11 +454,11 @@ func ResponseOpenAI2Claude(openAIResponse *dto.OpenAITextResponse, info *relayco
 
 func stopReasonOpenAI2Claude(reason string) string {
 	switch reason {
-	case "stop":
+	case "", "stop":
 		return "end_turn"
 	case "stop_sequence":
 		return "stop_sequence"
-	case "max_tokens":
+	case "max_tokens", "length":
 		return "max_tokens"
 	case "tool_calls":
 		return "tool_use"
@@ -413,6 +467,31 @@ func stopReasonOpenAI2Claude(reason string) string {
 	}
 }
 
+// toolChoiceClaude2OpenAI converts claude tool_choice ({"type": "auto|any|tool|none"}) to openai format
+func toolChoiceClaude2OpenAI(toolChoice any) any {
+	choice, ok := toolChoice.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	switch choice["type"] {
+	case "auto":
+		return "auto"
+	case "any":
+		return "required"
+	case "none":
+		return "none"
+	case "tool":
+		name, _ := choice["name"].(string)
+		return map[string]interface{}{
+			"type": "function",
+			"function": map[string]interface{}{
+				"name": name,
+			},
+		}
+	}
+	return nil
+}
+
 func toJSONString(v interface{}) string {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -0,0 +1,630 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"veloera/common"
+	"veloera/dto"
+	relaycommon "veloera/relay/common"
+)
+
+// ResponsesToOpenAIRequest converts a responses request into a chat completions request
+func ResponsesToOpenAIRequest(request dto.OpenAIResponsesRequest, info *relaycommon.RelayInfo) (*dto.GeneralOpenAIRequest, error) {
+	openAIRequest := dto.GeneralOpenAIRequest{
+		Model:     request.Model,
+		Stream:    request.Stream,
+		MaxTokens: request.MaxOutputTokens,
+		TopP:      request.TopP,
+		User:      request.User,
+	}
+	if request.Temperature != 0 {
+		openAIRequest.Temperature = common.GetPointer[float64](request.Temperature)
+	}
+	if request.Reasoning != nil && request.Reasoning.Effort != "" {
+		openAIRequest.ReasoningEffort = request.Reasoning.Effort
+	}
+
+	// Convert tools, only function tools can be expressed in chat completions
+	for _, tool := range request.Tools {
+		if tool.Type != "function" {
+			continue
+		}
+		var parameters any
+		if len(tool.Parameters) > 0 {
+			parameters = tool.Parameters
+		}
+		openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCallRequest{
+			Type: "function",
+			Function: dto.FunctionRequest{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  parameters,
+			},
+		})
+	}
+	if len(openAIRequest.Tools) > 0 && len(request.ToolChoice) > 0 {
+		openAIRequest.ToolChoice = toolChoiceResponses2OpenAI(request.ToolChoice)
+	}
+
+	if len(request.Text) > 0 {
+		var text struct {
+			Format *struct {
+				Type        string `json:"type"`
+				Name        string `json:"name"`
+				Description string `json:"description"`
+				Schema      any    `json:"schema"`
+				Strict      any    `json:"strict"`
+			} `json:"format"`
+		}
+		if err := json.Unmarshal(request.Text, &text); err == nil && text.Format != nil {
+			switch text.Format.Type {
+			case "json_schema":
+				openAIRequest.ResponseFormat = &dto.ResponseFormat{
+					Type: "json_schema",
+					JsonSchema: &dto.FormatJsonSchema{
+						Name:        text.Format.Name,
+						Description: text.Format.Description,
+						Schema:      text.Format.Schema,
+						Strict:      text.Format.Strict,
+					},
+				}
+			case "json_object":
+				openAIRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+			}
+		}
+	}
+
+	// Convert messages
+	openAIMessages := make([]dto.Message, 0)
+	if instructions := responsesInstructions(request); instructions != "" {
+		systemMessage := dto.Message{Role: "system"}
+		systemMessage.SetStringContent(instructions)
+		openAIMessages = append(openAIMessages, systemMessage)
+	}
+
+	items, err := request.ParseInput()
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+	for _, item := range items {
+		switch item.Type {
+		case "function_call":
+			toolCall := dto.ToolCallRequest{
+				ID:   item.CallId,
+				Type: "function",
+				Function: dto.FunctionRequest{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			}
+			// 连续的 function_call 合并到同一条 assistant 消息中
+			last := len(openAIMessages) - 1
+			if last >= 0 && openAIMessages[last].Role == "assistant" {
+				toolCalls := append(openAIMessages[last].ParseToolCalls(), toolCall)
+				openAIMessages[last].SetToolCalls(toolCalls)
+			} else {
+				assistantMessage := dto.Message{Role: "assistant"}
+				assistantMessage.SetNullContent()
+				assistantMessage.SetToolCalls([]dto.ToolCallRequest{toolCall})
+				openAIMessages = append(openAIMessages, assistantMessage)
+			}
+		case "function_call_output":
+			toolMessage := dto.Message{
+				Role:       "tool",
+				ToolCallId: item.CallId,
+			}
+			var output string
+			if err := json.Unmarshal(item.Output, &output); err != nil {
+				output = string(item.Output)
+			}
+			toolMessage.SetStringContent(output)
+			openAIMessages = append(openAIMessages, toolMessage)
+		case "message", "":
+			if item.Role == "" {
+				continue
+			}
+			openAIMessages = append(openAIMessages, responsesMessage2OpenAI(item))
+		default:
+			// reasoning, item_reference and built-in tool items have no chat completions equivalent
+			continue
+		}
+	}
+	openAIRequest.Messages = openAIMessages
+	return &openAIRequest, nil
+}
+
+func responsesInstructions(request dto.OpenAIResponsesRequest) string {
+	if len(request.Instructions) == 0 {
+		return ""
+	}
+	var instructions string
+	if err := json.Unmarshal(request.Instructions, &instructions); err != nil {
+		return ""
+	}
+	return instructions
+}
+
+func responsesMessage2OpenAI(item dto.ResponsesInputItem) dto.Message {
+	role := item.Role
+	if role == "developer" {
+		role = "system"
+	}
+	message := dto.Message{Role: role}
+	contents := item.ParseContent()
+	textOnly := true
+	mediaContents := make([]dto.MediaContent, 0, len(contents))
+	for _, content := range contents {
+		switch content.Type {
+		case "input_text", "output_text", "text":
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeText,
+				Text: content.Text,
+			})
+		case "input_image":
+			textOnly = false
+			url := content.ImageUrl
+			if url == "" {
+				url = content.FileId
+			}
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeImageURL,
+				ImageUrl: &dto.MessageImageUrl{
+					Url:    url,
+					Detail: content.Detail,
+				},
+			})
+		case "input_file":
+			textOnly = false
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeFile,
+				File: &dto.MessageFile{
+					FileName: content.Filename,
+					FileData: content.FileData,
+					FileId:   content.FileId,
+				},
+			})
+		}
+	}
+	if textOnly {
+		texts := make([]string, 0, len(mediaContents))
+		for _, mediaContent := range mediaContents {
+			texts = append(texts, mediaContent.Text)
+		}
+		message.SetStringContent(strings.Join(texts, ""))
+	} else {
+		message.SetMediaContent(mediaContents)
+	}
+	return message
+}
+
+func toolChoiceResponses2OpenAI(toolChoice json.RawMessage) any {
+	var mode string
+	if err := json.Unmarshal(toolChoice, &mode); err == nil {
+		return mode
+	}
+	var choice struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(toolChoice, &choice); err == nil && choice.Type == "function" {
+		return map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name": choice.Name,
+			},
+		}
+	}
+	return "auto"
+}
+
+func usageOpenAI2Responses(usage *dto.Usage) *dto.Usage {
+	if usage == nil {
+		return nil
+	}
+	totalTokens := usage.TotalTokens
+	if totalTokens == 0 {
+		totalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	return &dto.Usage{
+		InputTokens:  usage.PromptTokens,
+		OutputTokens: usage.CompletionTokens,
+		TotalTokens:  totalTokens,
+		InputTokensDetails: &dto.InputTokenDetails{
+			CachedTokens: usage.PromptTokensDetails.CachedTokens,
+		},
+		OutputTokensDetails: &dto.OutputTokenDetails{
+			ReasoningTokens: usage.CompletionTokenDetails.ReasoningTokens,
+		},
+	}
+}
+
+// newResponsesResponse builds the response object echoing the request parameters
+func newResponsesResponse(request *dto.OpenAIResponsesRequest, info *relaycommon.RelayInfo) *dto.OpenAIResponsesResponse {
+	response := &dto.OpenAIResponsesResponse{
+		ID:                 fmt.Sprintf("resp_%s", common.GetUUID()),
+		Object:             "response",
+		CreatedAt:          int(common.GetTimestamp()),
+		Status:             "in_progress",
+		Model:              info.UpstreamModelName,
+		Output:             make([]dto.ResponsesOutput, 0),
+		Instructions:       responsesInstructions(*request),
+		MaxOutputTokens:    int(request.MaxOutputTokens),
+		ParallelToolCalls:  request.ParallelToolCalls,
+		PreviousResponseID: request.PreviousResponseID,
+		Reasoning:          request.Reasoning,
+		Store:              request.ShouldStore(),
+		Temperature:        request.Temperature,
+		ToolChoice:         "auto",
+		Tools:              make([]interface{}, 0, len(request.Tools)),
+		TopP:               request.TopP,
+		Truncation:         common.GetStringIfEmpty(request.Truncation, "disabled"),
+		Metadata:           request.Metadata,
+	}
+	for _, tool := range request.Tools {
+		response.Tools = append(response.Tools, tool)
+	}
+	var toolChoice string
+	if err := json.Unmarshal(request.ToolChoice, &toolChoice); err == nil {
+		response.ToolChoice = toolChoice
+	}
+	if request.User != "" {
+		response.User, _ = json.Marshal(request.User)
+	}
+	return response
+}
+
+func completeResponsesResponse(response *dto.OpenAIResponsesResponse, finishReason string, usage *dto.Usage) {
+	response.Status = "completed"
+	if finishReason == "length" {
+		response.Status = "incomplete"
+		response.IncompleteDetails = &dto.IncompleteDetails{Reason: "max_output_tokens"}
+	} else if finishReason == "content_filter" {
+		response.Status = "incomplete"
+		response.IncompleteDetails = &dto.IncompleteDetails{Reason: "content_filter"}
+	}
+	response.Usage = usageOpenAI2Responses(usage)
+}
+
+func newResponsesItemId(prefix string) string {
+	return fmt.Sprintf("%s_%s", prefix, common.GetUUID())
+}
+
+// ResponseOpenAI2Responses converts a chat completions response into a responses response
+func ResponseOpenAI2Responses(openAIResponse *dto.OpenAITextResponse, request *dto.OpenAIResponsesRequest, info *relaycommon.RelayInfo, usage *dto.Usage) *dto.OpenAIResponsesResponse {
+	response := newResponsesResponse(request, info)
+	if openAIResponse.Model != "" {
+		response.Model = openAIResponse.Model
+	}
+	finishReason := ""
+	if len(openAIResponse.Choices) > 0 {
+		choice := openAIResponse.Choices[0]
+		finishReason = choice.FinishReason
+		reasoning := choice.Message.ReasoningContent
+		if reasoning == "" {
+			reasoning = choice.Message.Reasoning
+		}
+		if reasoning != "" {
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type:    "reasoning",
+				ID:      newResponsesItemId("rs"),
+				Summary: []dto.ResponsesOutputContent{{Type: "summary_text", Text: reasoning}},
+			})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type:    "message",
+				ID:      newResponsesItemId("msg"),
+				Status:  "completed",
+				Role:    "assistant",
+				Content: []dto.ResponsesOutputContent{{Type: "output_text", Text: text, Annotations: []interface{}{}}},
+			})
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type:      "function_call",
+				ID:        newResponsesItemId("fc"),
+				Status:    "completed",
+				CallId:    toolCall.ID,
+				Name:      toolCall.Function.Name,
+				Arguments: toolCall.Function.Arguments,
+			})
+		}
+	}
+	if usage == nil {
+		usage = &openAIResponse.Usage
+	}
+	completeResponsesResponse(response, finishReason, usage)
+	return response
+}
+
+type responsesStreamToolCall struct {
+	item        dto.ResponsesOutput
+	outputIndex int
+}
+
+// ResponsesStreamConverter synthesizes the typed response.* event stream from
+// chat completions chunks. Text and reasoning deltas open a message or reasoning
+// item which stays open until another kind of output arrives, every tool call
+// becomes a function_call item that is closed when the stream ends.
+type ResponsesStreamConverter struct {
+	response     *dto.OpenAIResponsesResponse
+	sequence     int
+	outputs      []dto.ResponsesOutput
+	current      int // output index of the open message or reasoning item, -1 if none
+	currentText  strings.Builder
+	toolCalls    map[int]*responsesStreamToolCall
+	finishReason string
+	usage        *dto.Usage
+}
+
+func NewResponsesStreamConverter(request *dto.OpenAIResponsesRequest, info *relaycommon.RelayInfo) *ResponsesStreamConverter {
+	return &ResponsesStreamConverter{
+		response:  newResponsesResponse(request, info),
+		current:   -1,
+		toolCalls: make(map[int]*responsesStreamToolCall),
+	}
+}
+
+// Response returns the response object, complete once Finish has been called
+func (s *ResponsesStreamConverter) Response() *dto.OpenAIResponsesResponse {
+	return s.response
+}
+
+func (s *ResponsesStreamConverter) event(event dto.ResponsesStreamResponse) dto.ResponsesStreamResponse {
+	event.SequenceNumber = s.sequence
+	s.sequence++
+	return event
+}
+
+// Start returns response.created and response.in_progress
+func (s *ResponsesStreamConverter) Start() []dto.ResponsesStreamResponse {
+	snapshot := *s.response
+	return []dto.ResponsesStreamResponse{
+		s.event(dto.ResponsesStreamResponse{Type: dto.ResponsesStreamTypeCreated, Response: &snapshot}),
+		s.event(dto.ResponsesStreamResponse{Type: dto.ResponsesStreamTypeInProgress, Response: &snapshot}),
+	}
+}
+
+func (s *ResponsesStreamConverter) openItem(item dto.ResponsesOutput) (int, dto.ResponsesStreamResponse) {
+	outputIndex := len(s.outputs)
+	s.outputs = append(s.outputs, item)
+	added := item
+	return outputIndex, s.event(dto.ResponsesStreamResponse{
+		Type:        dto.ResponsesOutputTypeItemAdded,
+		OutputIndex: common.GetPointer[int](outputIndex),
+		Item:        &added,
+	})
+}
+
+func (s *ResponsesStreamConverter) openCurrent(itemType string) []dto.ResponsesStreamResponse {
+	if s.current >= 0 && s.outputs[s.current].Type == itemType {
+		return nil
+	}
+	events := s.closeCurrent()
+	var item dto.ResponsesOutput
+	if itemType == "reasoning" {
+		item = dto.ResponsesOutput{Type: "reasoning", ID: newResponsesItemId("rs"), Summary: []dto.ResponsesOutputContent{}}
+	} else {
+		item = dto.ResponsesOutput{Type: "message", ID: newResponsesItemId("msg"), Status: "in_progress", Role: "assistant", Content: []dto.ResponsesOutputContent{}}
+	}
+	outputIndex, added := s.openItem(item)
+	events = append(events, added)
+	s.current = outputIndex
+	s.currentText.Reset()
+	if itemType == "reasoning" {
+		events = append(events, s.event(dto.ResponsesStreamResponse{
+			Type:         dto.ResponsesStreamTypeReasoningPartAdded,
+			ItemId:       item.ID,
+			OutputIndex:  common.GetPointer[int](outputIndex),
+			SummaryIndex: common.GetPointer[int](0),
+			Part:         &dto.ResponsesOutputContent{Type: "summary_text"},
+		}))
+	} else {
+		events = append(events, s.event(dto.ResponsesStreamResponse{
+			Type:         dto.ResponsesStreamTypeContentPartAdded,
+			ItemId:       item.ID,
+			OutputIndex:  common.GetPointer[int](outputIndex),
+			ContentIndex: common.GetPointer[int](0),
+			Part:         &dto.ResponsesOutputContent{Type: "output_text", Annotations: []interface{}{}},
+		}))
+	}
+	return events
+}
+
+func (s *ResponsesStreamConverter) closeCurrent() []dto.ResponsesStreamResponse {
+	if s.current < 0 {
+		return nil
+	}
+	outputIndex := s.current
+	s.current = -1
+	item := &s.outputs[outputIndex]
+	text := s.currentText.String()
+	var events []dto.ResponsesStreamResponse
+	if item.Type == "reasoning" {
+		part := dto.ResponsesOutputContent{Type: "summary_text", Text: text}
+		item.Summary = []dto.ResponsesOutputContent{part}
+		events = append(events,
+			s.event(dto.ResponsesStreamResponse{
+				Type:         dto.ResponsesStreamTypeReasoningTextDone,
+				ItemId:       item.ID,
+				OutputIndex:  common.GetPointer[int](outputIndex),
+				SummaryIndex: common.GetPointer[int](0),
+				Text:         text,
+			}),
+			s.event(dto.ResponsesStreamResponse{
+				Type:         dto.ResponsesStreamTypeReasoningPartDone,
+				ItemId:       item.ID,
+				OutputIndex:  common.GetPointer[int](outputIndex),
+				SummaryIndex: common.GetPointer[int](0),
+				Part:         &part,
+			}),
+		)
+	} else {
+		part := dto.ResponsesOutputContent{Type: "output_text", Text: text, Annotations: []interface{}{}}
+		item.Status = "completed"
+		item.Content = []dto.ResponsesOutputContent{part}
+		events = append(events,
+			s.event(dto.ResponsesStreamResponse{
+				Type:         dto.ResponsesStreamTypeOutputTextDone,
+				ItemId:       item.ID,
+				OutputIndex:  common.GetPointer[int](outputIndex),
+				ContentIndex: common.GetPointer[int](0),
+				Text:         text,
+			}),
+			s.event(dto.ResponsesStreamResponse{
+				Type:         dto.ResponsesStreamTypeContentPartDone,
+				ItemId:       item.ID,
+				OutputIndex:  common.GetPointer[int](outputIndex),
+				ContentIndex: common.GetPointer[int](0),
+				Part:         &part,
+			}),
+		)
+	}
+	done := *item
+	events = append(events, s.event(dto.ResponsesStreamResponse{
+		Type:        dto.ResponsesOutputTypeItemDone,
+		OutputIndex: common.GetPointer[int](outputIndex),
+		Item:        &done,
+	}))
+	return events
+}
+
+// Convert returns the events for one chat completions chunk
+func (s *ResponsesStreamConverter) Convert(streamResponse *dto.ChatCompletionsStreamResponse) []dto.ResponsesStreamResponse {
+	if streamResponse.Model != "" {
+		s.response.Model = streamResponse.Model
+	}
+	if ValidUsage(streamResponse.Usage) {
+		s.usage = streamResponse.Usage
+	}
+	if len(streamResponse.Choices) == 0 {
+		return nil
+	}
+	choice := streamResponse.Choices[0]
+	if choice.FinishReason != nil && *choice.FinishReason != "" {
+		s.finishReason = *choice.FinishReason
+	}
+	var events []dto.ResponsesStreamResponse
+	if reasoning := choice.Delta.GetReasoningContent(); reasoning != "" {
+		events = append(events, s.openCurrent("reasoning")...)
+		s.currentText.WriteString(reasoning)
+		events = append(events, s.event(dto.ResponsesStreamResponse{
+			Type:         dto.ResponsesStreamTypeReasoningTextDelta,
+			ItemId:       s.outputs[s.current].ID,
+			OutputIndex:  common.GetPointer[int](s.current),
+			SummaryIndex: common.GetPointer[int](0),
+			Delta:        reasoning,
+		}))
+	}
+	if text := choice.Delta.GetContentString(); text != "" {
+		events = append(events, s.openCurrent("message")...)
+		s.currentText.WriteString(text)
+		events = append(events, s.event(dto.ResponsesStreamResponse{
+			Type:         dto.ResponsesStreamTypeOutputTextDelta,
+			ItemId:       s.outputs[s.current].ID,
+			OutputIndex:  common.GetPointer[int](s.current),
+			ContentIndex: common.GetPointer[int](0),
+			Delta:        text,
+		}))
+	}
+	for i, toolCall := range choice.Delta.ToolCalls {
+		index := i
+		if toolCall.Index != nil {
+			index = *toolCall.Index
+		}
+		streamToolCall, ok := s.toolCalls[index]
+		if !ok {
+			events = append(events, s.closeCurrent()...)
+			callId := toolCall.ID
+			if callId == "" {
+				callId = fmt.Sprintf("call_%s", common.GetUUID())
+			}
+			item := dto.ResponsesOutput{
+				Type:   "function_call",
+				ID:     newResponsesItemId("fc"),
+				Status: "in_progress",
+				CallId: callId,
+				Name:   toolCall.Function.Name,
+			}
+			outputIndex, added := s.openItem(item)
+			events = append(events, added)
+			streamToolCall = &responsesStreamToolCall{item: item, outputIndex: outputIndex}
+			s.toolCalls[index] = streamToolCall
+		} else if toolCall.Function.Name != "" && streamToolCall.item.Name == "" {
+			streamToolCall.item.Name = toolCall.Function.Name
+		}
+		if toolCall.Function.Arguments != "" {
+			streamToolCall.item.Arguments += toolCall.Function.Arguments
+			events = append(events, s.event(dto.ResponsesStreamResponse{
+				Type:        dto.ResponsesStreamTypeFunctionArgumentDelta,
+				ItemId:      streamToolCall.item.ID,
+				OutputIndex: common.GetPointer[int](streamToolCall.outputIndex),
+				Delta:       toolCall.Function.Arguments,
+			}))
+		}
+	}
+	return events
+}
+
+// Finish closes every open item and returns the final response.completed
+// (or response.incomplete) event. usage is the usage returned by the adaptor.
+func (s *ResponsesStreamConverter) Finish(usage *dto.Usage) []dto.ResponsesStreamResponse {
+	if ValidUsage(usage) {
+		s.usage = usage
+	}
+	events := s.closeCurrent()
+	toolCalls := make([]*responsesStreamToolCall, 0, len(s.toolCalls))
+	for _, toolCall := range s.toolCalls {
+		toolCalls = append(toolCalls, toolCall)
+	}
+	sort.Slice(toolCalls, func(i, j int) bool {
+		return toolCalls[i].outputIndex < toolCalls[j].outputIndex
+	})
+	for _, toolCall := range toolCalls {
+		toolCall.item.Status = "completed"
+		s.outputs[toolCall.outputIndex] = toolCall.item
+		done := toolCall.item
+		events = append(events,
+			s.event(dto.ResponsesStreamResponse{
+				Type:        dto.ResponsesStreamTypeFunctionArgumentDone,
+				ItemId:      toolCall.item.ID,
+				OutputIndex: common.GetPointer[int](toolCall.outputIndex),
+				Arguments:   toolCall.item.Arguments,
+			}),
+			s.event(dto.ResponsesStreamResponse{
+				Type:        dto.ResponsesOutputTypeItemDone,
+				OutputIndex: common.GetPointer[int](toolCall.outputIndex),
+				Item:        &done,
+			}),
+		)
+	}
+	s.response.Output = s.outputs
+	if s.response.Output == nil {
+		s.response.Output = make([]dto.ResponsesOutput, 0)
+	}
+	completeResponsesResponse(s.response, s.finishReason, s.usage)
+	eventType := dto.ResponsesStreamTypeCompleted
+	if s.response.Status == "incomplete" {
+		eventType = dto.ResponsesStreamTypeIncomplete
+	}
+	events = append(events, s.event(dto.ResponsesStreamResponse{Type: eventType, Response: s.response}))
+	return events
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/model"
+	"veloera/setting/model_setting"
+)
+
+const (
+	FilePurposeBatch       = "batch"
+	FilePurposeBatchOutput = "batch_output"
+)
+
+// 允许上传的文件用途
+var uploadFilePurposes = map[string]bool{
+	FilePurposeBatch: true,
+	"assistants":     true,
+	"fine-tune":      true,
+	"vision":         true,
+	"user_data":      true,
+	"evals":          true,
+}
+
+var ErrFileTooLarge = errors.New("file exceeds the maximum allowed size")
+
+func IsValidUploadFilePurpose(purpose string) bool {
+	return uploadFilePurposes[purpose]
+}
+
+func NewFileId() string {
+	return fmt.Sprintf("file-%s", common.GetUUID())
+}
+
+// FileAbsPath 返回文件内容在本地存储中的路径
+func FileAbsPath(file *model.File) string {
+	return filepath.Join(common.FileStoragePath, file.StoragePath)
+}
+
+// fileStoragePath 按用户分目录存放文件，返回相对路径
+func fileStoragePath(userId int, fileId string) string {
+	return filepath.Join(fmt.Sprintf("%d", userId), fileId)
+}
+
+// CreateFileForWrite 创建一个文件用于写入内容，写入完成后调用 RegisterFile 保存文件记录
+func CreateFileForWrite(userId int, filename string, purpose string) (*model.File, *os.File, error) {
+	file := &model.File{
+		FileId:   NewFileId(),
+		UserId:   userId,
+		Filename: filename,
+		Purpose:  purpose,
+	}
+	file.StoragePath = fileStoragePath(userId, file.FileId)
+	absPath := FileAbsPath(file)
+	if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Create(absPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, f, nil
+}
+
+// RegisterFile 根据磁盘上的实际大小保存文件记录
+func RegisterFile(file *model.File) error {
+	stat, err := os.Stat(FileAbsPath(file))
+	if err != nil {
+		return err
+	}
+	file.Bytes = stat.Size()
+	return file.Insert()
+}
+
+// SaveUploadedFile 保存上传的文件内容，超过大小上限时返回 ErrFileTooLarge
+func SaveUploadedFile(userId int, filename string, purpose string, content io.Reader) (*model.File, error) {
+	file, f, err := CreateFileForWrite(userId, filename, purpose)
+	if err != nil {
+		return nil, err
+	}
+	maxBytes := int64(model_setting.GetBatchSettings().MaxFileSizeMB) << 20
+	written, err := io.Copy(f, io.LimitReader(content, maxBytes+1))
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && written > maxBytes {
+		err = ErrFileTooLarge
+	}
+	if err != nil {
+		_ = os.Remove(FileAbsPath(file))
+		return nil, err
+	}
+	if err = RegisterFile(file); err != nil {
+		_ = os.Remove(FileAbsPath(file))
+		return nil, err
+	}
+	return file, nil
+}
+
+// DeleteFile 删除文件记录和文件内容
+func DeleteFile(file *model.File) error {
+	if err := file.Delete(); err != nil {
+		return err
+	}
+	if err := os.Remove(FileAbsPath(file)); err != nil && !os.IsNotExist(err) {
+		common.SysError(fmt.Sprintf("failed to remove file %s: %s", file.FileId, err.Error()))
+	}
+	return nil
+}
+
+func FileToOpenAI(file *model.File) dto.OpenAIFile {
+	return dto.OpenAIFile{
+		Id:        file.FileId,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    "processed",
+	}
+}
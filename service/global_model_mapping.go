@@ -27,6 +27,12 @@ func GetActualModel(virtualModel string) (string, error) {
 	return model.GetActualModel(virtualModel)
 }
 
+// GetModelFallbacks 获取模型的降级链
+// 这是model包中GetModelFallbacks函数的包装函数
+func GetModelFallbacks(modelName string) []string {
+	return model.GetModelFallbacks(modelName)
+}
+
 // InitializeModelMappingService 初始化模型映射服务
 // 这是model包中InitializeModelMapping函数的包装函数
 func InitializeModelMappingService() error {
@@ -41,6 +41,10 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		other["is_model_mapped"] = true
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
 	}
+	if relayInfo.BatchId != "" {
+		other["batch_id"] = relayInfo.BatchId
+		other["batch_ratio"] = relayInfo.BatchRatio
+	}
 
 	// 添加输入输出内容
 	if relayInfo.Other != nil && common.LogChatContentEnabled {
@@ -55,8 +59,31 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		}
 	}
 
+	// 降级链记录依次尝试的模型，最后一个是实际提供服务的模型
+	if chain := ctx.GetStringSlice("model_fallback_chain"); len(chain) > 1 {
+		other["model_fallback_chain"] = chain
+	}
+
+	// 对冲请求记录胜出的一方
+	if attempt := relaycommon.GetHedgeAttempt(ctx); attempt != nil && attempt.State.Launched() {
+		other["hedge"] = map[string]interface{}{
+			"role":            attempt.Role,
+			"primary_channel": attempt.State.PrimaryChannelId,
+			"hedge_channel":   attempt.State.HedgeChannelId,
+		}
+	}
+
+	// 渠道并发已满时的排队时间
+	if queueTime := ctx.GetInt64("channel_queue_time"); queueTime > 0 {
+		other["queue_time"] = queueTime
+	}
+
 	adminInfo := make(map[string]interface{})
 	adminInfo["use_channel"] = ctx.GetStringSlice("use_channel")
+	// 命中的路由规则只对管理员可见
+	if rule := ctx.GetString("routing_rule"); rule != "" {
+		adminInfo["routing_rule"] = rule
+	}
 	other["admin_info"] = adminInfo
 	return other
 }
@@ -228,6 +228,7 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	completionRatio := priceData.CompletionRatio
 	modelRatio := priceData.ModelRatio
 	groupRatio := priceData.GroupRatio
+	batchRatio := priceData.BatchRatio
 	modelPrice := priceData.ModelPrice
 
 	cacheRatio := priceData.CacheRatio
@@ -242,9 +243,9 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		calculateQuota += float64(cacheTokens) * cacheRatio
 		calculateQuota += float64(cacheCreationTokens) * cacheCreationRatio
 		calculateQuota += float64(completionTokens) * completionRatio
-		calculateQuota = calculateQuota * groupRatio * modelRatio
+		calculateQuota = calculateQuota * groupRatio * batchRatio * modelRatio
 	} else {
-		calculateQuota = modelPrice * common.QuotaPerUnit * groupRatio
+		calculateQuota = modelPrice * common.QuotaPerUnit * groupRatio * batchRatio
 	}
 
 	if modelRatio != 0 && calculateQuota <= 0 {
@@ -325,6 +326,10 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	}
 
 	quota := calculateAudioQuota(quotaInfo)
+	if relayInfo.BatchId != "" {
+		// 批处理折扣作为独立倍率，不改变分组倍率
+		quota = int(decimal.NewFromInt(int64(quota)).Mul(decimal.NewFromFloat(priceData.BatchRatio)).Round(0).IntPart())
+	}
 
 	totalTokens := usage.TotalTokens
 	var logContent string
@@ -334,6 +339,9 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	} else {
 		logContent = fmt.Sprintf("模型价格 %.2f，分组倍率 %.2f", modelPrice, groupRatio)
 	}
+	if relayInfo.BatchId != "" {
+		logContent += fmt.Sprintf("，批处理倍率 %.2f", priceData.BatchRatio)
+	}
 
 	// record all the consume log even if quota is 0
 	if totalTokens == 0 {
@@ -0,0 +1,169 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"veloera/common"
+	"veloera/dto"
+	"veloera/model"
+	"veloera/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// previous_response_id 链的最大长度，防止异常数据导致无限回溯
+const maxResponsesChainDepth = 100
+
+var ErrPreviousResponseNotFound = errors.New("previous response not found")
+
+// ResponsesStoreOwner 返回存储的响应所属的用户和令牌，按用户共享时令牌为 0
+func ResponsesStoreOwner(c *gin.Context) (userId int, tokenId int) {
+	userId = c.GetInt("id")
+	if model_setting.IsResponsesStoreScopedByToken() {
+		tokenId = c.GetInt("token_id")
+	}
+	return
+}
+
+// NormalizeResponsesInput 将 input 统一为输入项数组，纯文本输入转换为一条 user 消息
+func NormalizeResponsesInput(input json.RawMessage) ([]map[string]any, error) {
+	if len(input) == 0 {
+		return make([]map[string]any, 0), nil
+	}
+	var text string
+	if err := json.Unmarshal(input, &text); err == nil {
+		return []map[string]any{{"type": "message", "role": "user", "content": text}}, nil
+	}
+	var items []map[string]any
+	if err := json.Unmarshal(input, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// ExpandPreviousResponse 将 previous_response_id 对应的历史输入和输出展开到 input 中，
+// 这样续写不依赖上游存储，可以在任意渠道上进行。
+func ExpandPreviousResponse(c *gin.Context, request *dto.OpenAIResponsesRequest) error {
+	if request.PreviousResponseID == "" {
+		return nil
+	}
+	userId, tokenId := ResponsesStoreOwner(c)
+
+	// 从最近的响应向前回溯整条链
+	chain := make([]*model.StoredResponse, 0)
+	responseId := request.PreviousResponseID
+	for responseId != "" && len(chain) < maxResponsesChainDepth {
+		storedResponse, err := model.GetStoredResponse(responseId, userId, tokenId)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				if len(chain) == 0 {
+					return fmt.Errorf("%w: %s", ErrPreviousResponseNotFound, responseId)
+				}
+				// 更早的响应已过期，从这里截断历史
+				break
+			}
+			return err
+		}
+		chain = append(chain, storedResponse)
+		responseId = storedResponse.PreviousResponseId
+	}
+
+	history := make([]map[string]any, 0)
+	for i := len(chain) - 1; i >= 0; i-- {
+		var input []map[string]any
+		if err := json.Unmarshal(chain[i].Input, &input); err != nil {
+			return fmt.Errorf("invalid stored input of %s: %w", chain[i].ResponseId, err)
+		}
+		var response struct {
+			Output []map[string]any `json:"output"`
+		}
+		if err := json.Unmarshal(chain[i].Response, &response); err != nil {
+			return fmt.Errorf("invalid stored response of %s: %w", chain[i].ResponseId, err)
+		}
+		history = append(history, input...)
+		for _, item := range response.Output {
+			// 推理项依赖上游的存储或加密内容，无法跨渠道复用
+			if item["type"] == "reasoning" {
+				continue
+			}
+			history = append(history, item)
+		}
+	}
+	// 网关生成的 id 上游并不认识，去掉以免被当作引用
+	for _, item := range history {
+		delete(item, "id")
+	}
+
+	input, err := NormalizeResponsesInput(request.Input)
+	if err != nil {
+		return err
+	}
+	request.Input, err = json.Marshal(append(history, input...))
+	return err
+}
+
+func responsesInputItemId(item map[string]any) string {
+	switch item["type"] {
+	case "function_call":
+		return fmt.Sprintf("fc_%s", common.GetUUID())
+	case "function_call_output":
+		return fmt.Sprintf("fco_%s", common.GetUUID())
+	default:
+		return fmt.Sprintf("msg_%s", common.GetUUID())
+	}
+}
+
+// StoreResponse 保存响应及其本次请求的输入项
+func StoreResponse(c *gin.Context, request *dto.OpenAIResponsesRequest, input json.RawMessage, response *dto.OpenAIResponsesResponse) error {
+	if response == nil || response.ID == "" {
+		return errors.New("response id is empty")
+	}
+	items, err := NormalizeResponsesInput(input)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if _, ok := item["id"]; !ok {
+			item["id"] = responsesInputItemId(item)
+		}
+	}
+	inputData, err := json.Marshal(items)
+	if err != nil {
+		return err
+	}
+	// 上游收到的是展开后的历史，这里还原客户端传入的 previous_response_id
+	response.PreviousResponseID = request.PreviousResponseID
+	responseData, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	userId := c.GetInt("id")
+	storedResponse := &model.StoredResponse{
+		ResponseId:         response.ID,
+		UserId:             userId,
+		TokenId:            c.GetInt("token_id"),
+		Model:              response.Model,
+		PreviousResponseId: request.PreviousResponseID,
+		Input:              inputData,
+		Response:           responseData,
+	}
+	return storedResponse.Insert()
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"veloera/common"
+	"veloera/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 路由规则：按令牌、分组、客户端 IP、请求头、模型、提示词长度和是否流式匹配请求，
+// 命中后可以固定渠道或标签、切换分组、改写模型、拒绝请求或调整重试次数。
+
+// RoutingRequest 参与路由规则匹配的请求信息
+type RoutingRequest struct {
+	TokenId   int         `json:"token_id"`
+	TokenName string      `json:"token_name"`
+	Group     string      `json:"group"`
+	IP        string      `json:"ip"`
+	Headers   http.Header `json:"headers"`
+	Model     string      `json:"model"`
+	// 估算的提示词 token 数，小于 0 表示尚未估算
+	PromptTokens int  `json:"prompt_tokens"`
+	Stream       bool `json:"stream"`
+}
+
+// 估算提示词 token 数时跳过的字段，这些字段不是提示词文本
+var routingSkipTextKeys = map[string]bool{
+	"model": true, "role": true, "type": true, "name": true, "id": true, "tool_call_id": true,
+	"image_url": true, "url": true, "detail": true, "input_audio": true, "file": true,
+	"inline_data": true, "inlineData": true, "file_data": true, "fileData": true,
+	"mime_type": true, "mimeType": true, "data": true, "source": true,
+}
+
+// 参与估算提示词 token 数的顶层字段
+var routingPromptKeys = []string{"messages", "system", "instructions", "prompt", "input", "contents", "systemInstruction"}
+
+// NewRoutingRequest 从请求上下文构建路由匹配信息，提示词 token 数在规则需要时才估算
+func NewRoutingRequest(c *gin.Context, group string, modelName string) *RoutingRequest {
+	request := &RoutingRequest{
+		TokenId:      c.GetInt("token_id"),
+		TokenName:    c.GetString("token_name"),
+		Group:        group,
+		IP:           c.ClientIP(),
+		Headers:      c.Request.Header,
+		Model:        modelName,
+		PromptTokens: -1,
+	}
+	if strings.Contains(c.Request.URL.Path, ":streamGenerateContent") {
+		request.Stream = true
+	} else if isJSONRequest(c) {
+		var body struct {
+			Stream bool `json:"stream"`
+		}
+		if err := common.UnmarshalBodyReusable(c, &body); err == nil {
+			request.Stream = body.Stream
+		}
+	}
+	return request
+}
+
+// MatchRoutingRule 返回第一条命中的规则及其序号，没有命中时返回 -1
+func MatchRoutingRule(c *gin.Context, rules []model_setting.RoutingRule, request *RoutingRequest) (int, *model_setting.RoutingRule) {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Disabled {
+			continue
+		}
+		if rule.Match.NeedPromptTokens() && request.PromptTokens < 0 {
+			request.PromptTokens = EstimatePromptTokens(c, request.Model)
+		}
+		if matchRoutingRule(&rule.Match, request) {
+			return i, rule
+		}
+	}
+	return -1, nil
+}
+
+func matchRoutingRule(match *model_setting.RoutingRuleMatch, request *RoutingRequest) bool {
+	if len(match.TokenIds) > 0 {
+		found := false
+		for _, id := range match.TokenIds {
+			if id == request.TokenId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(match.TokenNames) > 0 && !matchRoutingGlob(match.TokenNames, request.TokenName) {
+		return false
+	}
+	if len(match.Groups) > 0 && !common.StringsContains(match.Groups, request.Group) {
+		return false
+	}
+	if len(match.IPs) > 0 && !matchRoutingIP(match.IPs, request.IP) {
+		return false
+	}
+	for name, pattern := range match.Headers {
+		values, ok := request.Headers[http.CanonicalHeaderKey(name)]
+		if !ok || len(values) == 0 {
+			return false
+		}
+		if !matchRoutingGlob([]string{pattern}, values[0]) {
+			return false
+		}
+	}
+	if len(match.Models) > 0 && !matchRoutingGlob(match.Models, request.Model) {
+		return false
+	}
+	if match.MinPromptTokens > 0 && request.PromptTokens < match.MinPromptTokens {
+		return false
+	}
+	if match.MaxPromptTokens > 0 && request.PromptTokens > match.MaxPromptTokens {
+		return false
+	}
+	if match.Stream != nil && *match.Stream != request.Stream {
+		return false
+	}
+	return true
+}
+
+func matchRoutingGlob(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRoutingIP(rules []string, ip string) bool {
+	clientIP := net.ParseIP(ip)
+	if clientIP == nil {
+		return false
+	}
+	for _, rule := range rules {
+		if _, ipNet, err := net.ParseCIDR(rule); err == nil {
+			if ipNet.Contains(clientIP) {
+				return true
+			}
+		} else if ruleIP := net.ParseIP(rule); ruleIP != nil && ruleIP.Equal(clientIP) {
+			return true
+		}
+	}
+	return false
+}
+
+// EstimatePromptTokens 粗略估算请求的提示词 token 数，只统计文本内容
+func EstimatePromptTokens(c *gin.Context, modelName string) int {
+	if !isJSONRequest(c) {
+		return 0
+	}
+	var body map[string]any
+	if err := common.UnmarshalBodyReusable(c, &body); err != nil {
+		return 0
+	}
+	var builder strings.Builder
+	for _, key := range routingPromptKeys {
+		if value, ok := body[key]; ok {
+			collectPromptText(&builder, value)
+		}
+	}
+	if builder.Len() == 0 {
+		return 0
+	}
+	tokens, _ := CountTextToken(builder.String(), modelName)
+	return tokens
+}
+
+func collectPromptText(builder *strings.Builder, value any) {
+	switch v := value.(type) {
+	case string:
+		builder.WriteString(v)
+		builder.WriteString("\n")
+	case []any:
+		for _, item := range v {
+			collectPromptText(builder, item)
+		}
+	case map[string]any:
+		for key, item := range v {
+			if routingSkipTextKeys[key] {
+				continue
+			}
+			collectPromptText(builder, item)
+		}
+	}
+}
+
+func isJSONRequest(c *gin.Context) bool {
+	return strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json")
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"veloera/common"
+	"veloera/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 会话粘性路由：按 (令牌, 分组, 模型, 会话) 记录上次成功的渠道和 key 序号，
+// 同一会话的后续请求优先使用该渠道，以命中上游的提示词缓存。
+
+const stickySessionRedisPrefix = "sticky_session:"
+
+// StickySession 会话绑定的渠道和 key 序号
+type StickySession struct {
+	ChannelId int
+	KeyIndex  int
+}
+
+type stickySessionRequest struct {
+	System            json.RawMessage   `json:"system"`
+	Instructions      json.RawMessage   `json:"instructions"`
+	SystemInstruction json.RawMessage   `json:"systemInstruction"`
+	Messages          []json.RawMessage `json:"messages"`
+	Contents          []json.RawMessage `json:"contents"`
+	Input             json.RawMessage   `json:"input"`
+}
+
+// GetStickySessionKey 计算请求的会话键，未启用或无法识别会话时返回空字符串
+func GetStickySessionKey(c *gin.Context, group string, modelName string) string {
+	settings := model_setting.GetStickySessionSettings()
+	if !settings.Enabled {
+		return ""
+	}
+	session := ""
+	if settings.Header != "" {
+		if value := strings.TrimSpace(c.Request.Header.Get(settings.Header)); value != "" {
+			session = "header:" + value
+		}
+	}
+	if session == "" && settings.HashMessagesEnabled {
+		session = hashLeadingMessages(c, common.Max(settings.HashMessageCount, 1))
+	}
+	if session == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%s|%s", c.GetInt("token_id"), group, modelName, session)))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashLeadingMessages 按系统提示词和开头的若干条消息计算会话
+func hashLeadingMessages(c *gin.Context, count int) string {
+	if !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		return ""
+	}
+	var request stickySessionRequest
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+		return ""
+	}
+	messages := request.Messages
+	if len(messages) == 0 {
+		messages = request.Contents
+	}
+	if len(messages) == 0 && len(request.Input) > 0 {
+		// Responses API 的 input 可以是字符串或消息数组
+		var items []json.RawMessage
+		if err := json.Unmarshal(request.Input, &items); err == nil {
+			messages = items
+		} else {
+			messages = []json.RawMessage{request.Input}
+		}
+	}
+	if len(messages) == 0 {
+		return ""
+	}
+	hash := sha256.New()
+	hash.Write(request.System)
+	hash.Write(request.Instructions)
+	hash.Write(request.SystemInstruction)
+	// 系统消息不计入条数
+	for _, message := range messages {
+		if count <= 0 {
+			break
+		}
+		var role struct {
+			Role string `json:"role"`
+		}
+		_ = json.Unmarshal(message, &role)
+		if role.Role != "system" && role.Role != "developer" {
+			count--
+		}
+		hash.Write(message)
+	}
+	return "messages:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// GetStickySession 获取会话绑定的渠道
+func GetStickySession(key string) (*StickySession, bool) {
+	if key == "" {
+		return nil, false
+	}
+	var value string
+	if common.RedisEnabled {
+		v, err := common.RedisGet(stickySessionRedisPrefix + key)
+		if err != nil {
+			return nil, false
+		}
+		value = v
+	} else {
+		v, ok := stickySessionMemoryStore.get(key)
+		if !ok {
+			return nil, false
+		}
+		value = v
+	}
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	channelId, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, false
+	}
+	keyIndex, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, false
+	}
+	return &StickySession{ChannelId: channelId, KeyIndex: keyIndex}, true
+}
+
+// SaveStickySession 记录会话绑定的渠道，每次成功请求后刷新过期时间
+func SaveStickySession(key string, channelId int, keyIndex int) {
+	if key == "" {
+		return
+	}
+	ttl := time.Duration(common.Max(model_setting.GetStickySessionSettings().TTLSeconds, 1)) * time.Second
+	value := fmt.Sprintf("%d:%d", channelId, keyIndex)
+	if common.RedisEnabled {
+		if err := common.RedisSet(stickySessionRedisPrefix+key, value, ttl); err != nil {
+			common.SysError("failed to save sticky session: " + err.Error())
+		}
+		return
+	}
+	stickySessionMemoryStore.set(key, value, ttl)
+}
+
+type stickySessionEntry struct {
+	value     string
+	expiresAt time.Time
+}
+
+type stickySessionStore struct {
+	lock    sync.Mutex
+	entries map[string]stickySessionEntry
+	sets    int
+}
+
+var stickySessionMemoryStore = &stickySessionStore{entries: make(map[string]stickySessionEntry)}
+
+func (s *stickySessionStore) get(key string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	entry, ok := s.entries[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(s.entries, key)
+		return "", false
+	}
+	return entry.value, true
+}
+
+func (s *stickySessionStore) set(key string, value string, ttl time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	s.entries[key] = stickySessionEntry{value: value, expiresAt: now.Add(ttl)}
+	// 定期清理过期的会话
+	s.sets++
+	if s.sets%1000 == 0 {
+		for k, entry := range s.entries {
+			if now.After(entry.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,385 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+	"veloera/dto"
+	relaycommon "veloera/relay/common"
+	"veloera/setting/model_setting"
+)
+
+// StructuredOutputToolName 模拟 json_schema 时强制模型调用的工具名
+const StructuredOutputToolName = "structured_output"
+
+// GetStructuredOutputSchema 返回需要模拟的 json_schema，未开启模拟或请求不是 json_schema 时返回 nil
+func GetStructuredOutputSchema(request *dto.GeneralOpenAIRequest) *dto.FormatJsonSchema {
+	if !model_setting.GetStructuredOutputSettings().Enabled {
+		return nil
+	}
+	if request.ResponseFormat == nil || request.ResponseFormat.Type != "json_schema" {
+		return nil
+	}
+	if request.ResponseFormat.JsonSchema == nil || request.ResponseFormat.JsonSchema.Schema == nil {
+		return nil
+	}
+	return request.ResponseFormat.JsonSchema
+}
+
+// IsObjectSchema 判断 schema 的根节点是否为对象，只有对象可以作为工具参数
+func IsObjectSchema(schema any) bool {
+	m, ok := schema.(map[string]any)
+	if !ok {
+		return false
+	}
+	return m["type"] == "object"
+}
+
+// StructuredOutputInstruction 生成要求模型只输出符合 schema 的 JSON 的提示词
+func StructuredOutputInstruction(schema *dto.FormatJsonSchema) string {
+	schemaJson, _ := json.Marshal(schema.Schema)
+	var sb strings.Builder
+	sb.WriteString("You must respond with a single JSON value that conforms to the following JSON Schema. ")
+	sb.WriteString("Output only the JSON, without code fences, comments or any other text.\n")
+	if schema.Description != "" {
+		sb.WriteString("Description: ")
+		sb.WriteString(schema.Description)
+		sb.WriteString("\n")
+	}
+	sb.WriteString("JSON Schema:\n")
+	sb.Write(schemaJson)
+	return sb.String()
+}
+
+// ApplyStructuredOutputPrompt 对既不支持 json_schema 也不支持工具调用的上游，把 schema 写入系统提示词。
+// 返回修改后的副本，不修改传入的请求，修复输出时会用原请求重新转换
+func ApplyStructuredOutputPrompt(info *relaycommon.RelayInfo, request *dto.GeneralOpenAIRequest) *dto.GeneralOpenAIRequest {
+	schema := GetStructuredOutputSchema(request)
+	if schema == nil {
+		return request
+	}
+	info.StructuredOutput = schema
+	converted := *request
+	converted.ResponseFormat = nil
+	converted.Messages = append([]dto.Message{}, request.Messages...)
+	instruction := StructuredOutputInstruction(schema)
+	for i := range converted.Messages {
+		message := &converted.Messages[i]
+		if message.Role != "system" {
+			continue
+		}
+		if message.IsStringContent() {
+			message.SetStringContent(message.StringContent() + "\n\n" + instruction)
+		} else {
+			// 多模态的系统消息追加一个文本片段，保留其他内容
+			contents := append([]dto.MediaContent{}, message.ParseContent()...)
+			message.SetMediaContent(append(contents, dto.MediaContent{
+				Type: dto.ContentTypeText,
+				Text: instruction,
+			}))
+		}
+		return &converted
+	}
+	systemMessage := dto.Message{
+		Role: "system",
+	}
+	systemMessage.SetStringContent(instruction)
+	converted.Messages = append([]dto.Message{systemMessage}, converted.Messages...)
+	return &converted
+}
+
+// StructuredOutputRepairMessages 把不符合 schema 的输出和错误原因反馈给模型，要求重新输出
+func StructuredOutputRepairMessages(content string, validateErr error) []dto.Message {
+	assistantMessage := dto.Message{
+		Role: "assistant",
+	}
+	assistantMessage.SetStringContent(content)
+	userMessage := dto.Message{
+		Role: "user",
+	}
+	userMessage.SetStringContent(fmt.Sprintf("Your previous response does not conform to the required JSON Schema: %s. "+
+		"Respond again with only the corrected JSON.", validateErr.Error()))
+	return []dto.Message{assistantMessage, userMessage}
+}
+
+var structuredOutputFenceRegex = regexp.MustCompile("(?s)^```[a-zA-Z]*\\s*(.*?)\\s*```$")
+
+// ExtractStructuredOutputJSON 去掉模型输出中包裹 JSON 的代码块和首尾空白
+func ExtractStructuredOutputJSON(content string) string {
+	content = strings.TrimSpace(content)
+	if matches := structuredOutputFenceRegex.FindStringSubmatch(content); matches != nil {
+		content = matches[1]
+	}
+	return content
+}
+
+// ValidateStructuredOutput 校验输出是否为符合 schema 的 JSON
+func ValidateStructuredOutput(content string, schema *dto.FormatJsonSchema) error {
+	var value any
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return fmt.Errorf("output is not valid JSON: %s", err.Error())
+	}
+	validator := &jsonSchemaValidator{root: schema.Schema}
+	return validator.validate(value, schema.Schema, "$", 0)
+}
+
+// jsonSchemaValidator 实现了结构化输出常用的 JSON Schema 子集，本地 $ref 之外的引用和不认识的关键字会被忽略
+type jsonSchemaValidator struct {
+	root any
+}
+
+const maxJsonSchemaDepth = 64
+
+func (v *jsonSchemaValidator) validate(value any, schema any, path string, depth int) error {
+	if depth > maxJsonSchemaDepth {
+		return errors.New("schema is nested too deeply")
+	}
+	if b, ok := schema.(bool); ok {
+		if !b {
+			return fmt.Errorf("%s is not allowed", path)
+		}
+		return nil
+	}
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return nil
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		target, err := v.resolveRef(ref)
+		if err != nil {
+			return err
+		}
+		return v.validate(value, target, path, depth+1)
+	}
+
+	if t, ok := s["type"]; ok && !matchSchemaType(value, t) {
+		return fmt.Errorf("%s should be of type %v", path, t)
+	}
+	if enum, ok := s["enum"].([]any); ok {
+		matched := false
+		for _, e := range enum {
+			if jsonEqual(value, e) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s should be one of %s", path, marshalForError(enum))
+		}
+	}
+	if c, ok := s["const"]; ok && !jsonEqual(value, c) {
+		return fmt.Errorf("%s should be %s", path, marshalForError(c))
+	}
+
+	if allOf, ok := s["allOf"].([]any); ok {
+		for _, sub := range allOf {
+			if err := v.validate(value, sub, path, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]any); ok {
+		if err := v.validateAnyOf(value, anyOf, path, depth); err != nil {
+			return err
+		}
+	}
+	if oneOf, ok := s["oneOf"].([]any); ok {
+		if err := v.validateAnyOf(value, oneOf, path, depth); err != nil {
+			return err
+		}
+	}
+
+	switch val := value.(type) {
+	case map[string]any:
+		return v.validateObject(val, s, path, depth)
+	case []any:
+		return v.validateArray(val, s, path, depth)
+	case string:
+		length := float64(utf8.RuneCountInString(val))
+		if min, ok := s["minLength"].(float64); ok && length < min {
+			return fmt.Errorf("%s should have at least %v characters", path, min)
+		}
+		if max, ok := s["maxLength"].(float64); ok && length > max {
+			return fmt.Errorf("%s should have at most %v characters", path, max)
+		}
+		if pattern, ok := s["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(val) {
+				return fmt.Errorf("%s should match pattern %s", path, pattern)
+			}
+		}
+	case float64:
+		if min, ok := s["minimum"].(float64); ok && val < min {
+			return fmt.Errorf("%s should be >= %v", path, min)
+		}
+		if max, ok := s["maximum"].(float64); ok && val > max {
+			return fmt.Errorf("%s should be <= %v", path, max)
+		}
+		if min, ok := s["exclusiveMinimum"].(float64); ok && val <= min {
+			return fmt.Errorf("%s should be > %v", path, min)
+		}
+		if max, ok := s["exclusiveMaximum"].(float64); ok && val >= max {
+			return fmt.Errorf("%s should be < %v", path, max)
+		}
+	}
+	return nil
+}
+
+func (v *jsonSchemaValidator) validateAnyOf(value any, schemas []any, path string, depth int) error {
+	var firstErr error
+	for _, sub := range schemas {
+		err := v.validate(value, sub, path, depth+1)
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		return nil
+	}
+	return fmt.Errorf("%s does not match any of the allowed schemas: %s", path, firstErr.Error())
+}
+
+func (v *jsonSchemaValidator) validateObject(obj map[string]any, s map[string]any, path string, depth int) error {
+	properties, _ := s["properties"].(map[string]any)
+	if required, ok := s["required"].([]any); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, exists := obj[name]; !exists {
+				return fmt.Errorf("%s is missing required property %q", path, name)
+			}
+		}
+	}
+	for key, value := range obj {
+		childPath := path + "." + key
+		if propSchema, ok := properties[key]; ok {
+			if err := v.validate(value, propSchema, childPath, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		if additional, ok := s["additionalProperties"]; ok {
+			if b, isBool := additional.(bool); isBool && !b {
+				return fmt.Errorf("%s has unexpected property %q", path, key)
+			}
+			if err := v.validate(value, additional, childPath, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *jsonSchemaValidator) validateArray(arr []any, s map[string]any, path string, depth int) error {
+	if min, ok := s["minItems"].(float64); ok && float64(len(arr)) < min {
+		return fmt.Errorf("%s should have at least %v items", path, min)
+	}
+	if max, ok := s["maxItems"].(float64); ok && float64(len(arr)) > max {
+		return fmt.Errorf("%s should have at most %v items", path, max)
+	}
+	items, ok := s["items"]
+	if !ok {
+		return nil
+	}
+	for i, item := range arr {
+		if err := v.validate(item, items, fmt.Sprintf("%s[%d]", path, i), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveRef 解析 #/$defs/xxx 形式的本地引用
+func (v *jsonSchemaValidator) resolveRef(ref string) (any, error) {
+	if ref == "#" {
+		return v.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %q", ref)
+	}
+	cur := v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+	}
+	return cur, nil
+}
+
+func matchSchemaType(value any, t any) bool {
+	switch tt := t.(type) {
+	case string:
+		return matchSingleSchemaType(value, tt)
+	case []any:
+		for _, item := range tt {
+			if name, ok := item.(string); ok && matchSingleSchemaType(value, name) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchSingleSchemaType(value any, t string) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func jsonEqual(a, b any) bool {
+	aJson, errA := json.Marshal(a)
+	bJson, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aJson) == string(bJson)
+}
+
+func marshalForError(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"veloera/dto"
+	"veloera/model"
+	"veloera/setting/model_setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+type rateLimitHeaderGroup struct {
+	remaining string
+	limit     string
+	reset     string
+}
+
+// 按顺序使用第一组存在的响应头，OpenAI、Azure 等使用 x-ratelimit-*，Anthropic 使用 anthropic-ratelimit-*
+var (
+	requestRateLimitHeaders = []rateLimitHeaderGroup{
+		{"x-ratelimit-remaining-requests", "x-ratelimit-limit-requests", "x-ratelimit-reset-requests"},
+		{"anthropic-ratelimit-requests-remaining", "anthropic-ratelimit-requests-limit", "anthropic-ratelimit-requests-reset"},
+	}
+	tokenRateLimitHeaders = []rateLimitHeaderGroup{
+		{"x-ratelimit-remaining-tokens", "x-ratelimit-limit-tokens", "x-ratelimit-reset-tokens"},
+		{"anthropic-ratelimit-tokens-remaining", "anthropic-ratelimit-tokens-limit", "anthropic-ratelimit-tokens-reset"},
+		{"anthropic-ratelimit-input-tokens-remaining", "anthropic-ratelimit-input-tokens-limit", "anthropic-ratelimit-input-tokens-reset"},
+	}
+)
+
+// RecordUpstreamRateLimit 解析上游响应的限流头，更新渠道或 key 的剩余额度，上游返回 429 时冷却渠道或 key
+func RecordUpstreamRateLimit(c *gin.Context, channelId int, resp *http.Response) {
+	if !model_setting.GetUpstreamRateLimitSettings().Enabled {
+		return
+	}
+	headers := ParseRateLimitHeaders(resp.Header)
+	model.UpdateChannelRateLimit(channelId, c.GetString("channel_key_hash"), headers, resp.StatusCode == http.StatusTooManyRequests)
+}
+
+// IsUpstreamRateLimited 判断错误是否是上游 429 且渠道或 key 已经进入冷却，这类错误不计入熔断也不自动禁用渠道
+func IsUpstreamRateLimited(channelId int, keyHash string, err *dto.OpenAIErrorWithStatusCode) bool {
+	if err == nil || err.LocalError || err.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	return model.IsChannelRateLimitCoolingDown(channelId, keyHash)
+}
+
+// ParseRateLimitHeaders 解析上游返回的限流响应头
+func ParseRateLimitHeaders(header http.Header) *model.ChannelRateLimitHeaders {
+	headers := &model.ChannelRateLimitHeaders{}
+	headers.RemainingRequests, headers.LimitRequests, headers.RequestsReset = parseRateLimitHeaderGroups(header, requestRateLimitHeaders)
+	headers.RemainingTokens, headers.LimitTokens, headers.TokensReset = parseRateLimitHeaderGroups(header, tokenRateLimitHeaders)
+	if value := header.Get("retry-after-ms"); value != "" {
+		if ms, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && ms > 0 {
+			headers.RetryAfter = time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+	if headers.RetryAfter <= 0 {
+		headers.RetryAfter = parseRetryAfter(header.Get("retry-after"))
+	}
+	return headers
+}
+
+func parseRateLimitHeaderGroups(header http.Header, groups []rateLimitHeaderGroup) (int64, int64, time.Duration) {
+	for _, group := range groups {
+		value := strings.TrimSpace(header.Get(group.remaining))
+		if value == "" {
+			continue
+		}
+		remaining, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || remaining < 0 {
+			continue
+		}
+		limit, _ := strconv.ParseInt(strings.TrimSpace(header.Get(group.limit)), 10, 64)
+		return remaining, limit, parseRateLimitReset(header.Get(group.reset))
+	}
+	return -1, 0, 0
+}
+
+// parseRateLimitReset 解析额度恢复时间，支持 6m0s 形式的时长、秒数、Unix 时间戳和 RFC 3339 时间
+func parseRateLimitReset(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	var duration time.Duration
+	if d, err := time.ParseDuration(value); err == nil {
+		duration = d
+	} else if f, err := strconv.ParseFloat(value, 64); err == nil {
+		if f > 1e9 {
+			duration = time.Until(time.Unix(int64(f), 0))
+		} else {
+			duration = time.Duration(f * float64(time.Second))
+		}
+	} else if t, err := time.Parse(time.RFC3339, value); err == nil {
+		duration = time.Until(t)
+	}
+	if duration < 0 {
+		return 0
+	}
+	return duration
+}
+
+// parseRetryAfter 解析 retry-after，支持秒数和 HTTP 时间
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if duration := time.Until(t); duration > 0 {
+			return duration
+		}
+	}
+	return 0
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model_setting
+
+import (
+	"veloera/setting/config"
+)
+
+// BatchSettings 定义 Files 和 Batch API 的配置
+type BatchSettings struct {
+	Enabled             bool    `json:"enabled"`
+	DiscountRatio       float64 `json:"discount_ratio"`         // 批处理请求的计费倍率
+	WorkerCount         int     `json:"worker_count"`           // 同时执行的批处理请求数
+	MaxFileSizeMB       int     `json:"max_file_size_mb"`       // 上传文件大小上限
+	MaxRequestsPerBatch int     `json:"max_requests_per_batch"` // 单个批处理的请求数上限
+}
+
+// 默认配置
+var defaultBatchSettings = BatchSettings{
+	Enabled:             true,
+	DiscountRatio:       0.5,
+	WorkerCount:         4,
+	MaxFileSizeMB:       100,
+	MaxRequestsPerBatch: 50000,
+}
+
+// 全局实例
+var batchSettings = defaultBatchSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("batch", &batchSettings)
+}
+
+// GetBatchSettings 获取批处理配置
+func GetBatchSettings() *BatchSettings {
+	return &batchSettings
+}
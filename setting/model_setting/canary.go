@@ -0,0 +1,59 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model_setting
+
+import (
+	"veloera/setting/config"
+)
+
+// CanaryRampSettings 定义新增或重新启用渠道的灰度放量配置
+type CanaryRampSettings struct {
+	Enabled bool `json:"enabled"`
+	// 放量开始时占所在优先级流量的百分比
+	InitialPercent float64 `json:"initial_percent"`
+	// 每个阶段增加的百分比
+	StepPercent float64 `json:"step_percent"`
+	// 每个阶段的最短持续时间
+	StepIntervalSeconds int `json:"step_interval_seconds"`
+	// 阶段内错误率达到该值时回退到初始比例
+	ErrorRateThreshold float64 `json:"error_rate_threshold"`
+	// 阶段内至少有这么多请求才会判断错误率和进入下一阶段
+	MinSamples int `json:"min_samples"`
+}
+
+// 默认配置
+var defaultCanaryRampSettings = CanaryRampSettings{
+	Enabled:             false,
+	InitialPercent:      5,
+	StepPercent:         15,
+	StepIntervalSeconds: 600,
+	ErrorRateThreshold:  0.2,
+	MinSamples:          20,
+}
+
+// 全局实例
+var canaryRampSettings = defaultCanaryRampSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("canary_ramp", &canaryRampSettings)
+}
+
+// GetCanaryRampSettings 获取灰度放量配置
+func GetCanaryRampSettings() *CanaryRampSettings {
+	return &canaryRampSettings
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model_setting
+
+import (
+	"veloera/setting/config"
+)
+
+// ChannelConcurrencySettings 定义渠道并发已满时的排队配置，渠道的并发上限在渠道设置中配置
+type ChannelConcurrencySettings struct {
+	// 所有渠道的并发都已满时排队等待空闲渠道，而不是直接返回 503
+	QueueEnabled   bool `json:"queue_enabled"`
+	QueueTimeoutMs int  `json:"queue_timeout_ms"`
+	// 当前节点最多同时排队的请求数，0 表示不限制
+	QueueMaxSize int `json:"queue_max_size"`
+}
+
+// 默认配置
+var defaultChannelConcurrencySettings = ChannelConcurrencySettings{
+	QueueEnabled:   false,
+	QueueTimeoutMs: 10000,
+	QueueMaxSize:   100,
+}
+
+// 全局实例
+var channelConcurrencySettings = defaultChannelConcurrencySettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_concurrency", &channelConcurrencySettings)
+}
+
+// GetChannelConcurrencySettings 获取渠道并发排队配置
+func GetChannelConcurrencySettings() *ChannelConcurrencySettings {
+	return &channelConcurrencySettings
+}
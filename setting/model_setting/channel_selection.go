@@ -0,0 +1,75 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model_setting
+
+import (
+	"veloera/setting/config"
+)
+
+// 同一优先级内的渠道选择策略
+const (
+	ChannelSelectionWeightedRandom = "weighted_random" // 按权重随机
+	ChannelSelectionLatency        = "latency"         // 按请求耗时的 EWMA 加权，耗时越短越容易被选中
+	ChannelSelectionTTFT           = "ttft"            // 按首字时间的 EWMA 加权
+	ChannelSelectionLeastInFlight  = "least_inflight"  // 选择进行中请求最少的渠道
+)
+
+// ChannelSelectionSettings 定义渠道选择策略的配置，模型策略优先于分组策略
+type ChannelSelectionSettings struct {
+	DefaultStrategy string            `json:"default_strategy"`
+	GroupStrategies map[string]string `json:"group_strategies"`
+	ModelStrategies map[string]string `json:"model_strategies"`
+	// EWMA 平滑系数，越大越偏向最近的样本
+	EwmaAlpha float64 `json:"ewma_alpha"`
+}
+
+// 默认配置
+var defaultChannelSelectionSettings = ChannelSelectionSettings{
+	DefaultStrategy: ChannelSelectionWeightedRandom,
+	GroupStrategies: map[string]string{},
+	ModelStrategies: map[string]string{},
+	EwmaAlpha:       0.3,
+}
+
+// 全局实例
+var channelSelectionSettings = defaultChannelSelectionSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("channel_selection", &channelSelectionSettings)
+}
+
+// GetChannelSelectionSettings 获取渠道选择策略配置
+func GetChannelSelectionSettings() *ChannelSelectionSettings {
+	return &channelSelectionSettings
+}
+
+// GetChannelSelectionStrategy 获取分组和模型对应的渠道选择策略，未知的策略按权重随机处理
+func GetChannelSelectionStrategy(group string, model string) string {
+	strategy := channelSelectionSettings.DefaultStrategy
+	if s, ok := channelSelectionSettings.GroupStrategies[group]; ok {
+		strategy = s
+	}
+	if s, ok := channelSelectionSettings.ModelStrategies[model]; ok {
+		strategy = s
+	}
+	switch strategy {
+	case ChannelSelectionLatency, ChannelSelectionTTFT, ChannelSelectionLeastInFlight:
+		return strategy
+	}
+	return ChannelSelectionWeightedRandom
+}
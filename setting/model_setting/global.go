@@ -34,6 +34,14 @@ type GlobalSettings struct {
 	AutoRetryCount               int    `json:"auto_retry_count"`
 	AutoRetryForceChannelSwitch  bool   `json:"auto_retry_force_channel_switch"`
 	AutoRetryStatusCodes         string `json:"auto_retry_status_codes"`
+	// 渠道熔断，按 (渠道, 模型) 统计滚动窗口内的错误率和超时率
+	CircuitBreakerEnabled        bool    `json:"circuit_breaker_enabled"`
+	CircuitBreakerWindowSeconds  int     `json:"circuit_breaker_window_seconds"`
+	CircuitBreakerMinRequests    int     `json:"circuit_breaker_min_requests"`
+	CircuitBreakerErrorRate      float64 `json:"circuit_breaker_error_rate"`
+	CircuitBreakerTimeoutRate    float64 `json:"circuit_breaker_timeout_rate"`
+	CircuitBreakerOpenSeconds    int     `json:"circuit_breaker_open_seconds"`
+	CircuitBreakerHalfOpenProbes int     `json:"circuit_breaker_half_open_probes"`
 }
 
 // 默认配置
@@ -49,6 +57,13 @@ var defaultOpenaiSettings = GlobalSettings{
 	AutoRetryCount:               3,
 	AutoRetryForceChannelSwitch:  false,
 	AutoRetryStatusCodes:         "5xx,4xx",
+	CircuitBreakerEnabled:        false,
+	CircuitBreakerWindowSeconds:  60,
+	CircuitBreakerMinRequests:    10,
+	CircuitBreakerErrorRate:      0.5,
+	CircuitBreakerTimeoutRate:    0.3,
+	CircuitBreakerOpenSeconds:    30,
+	CircuitBreakerHalfOpenProbes: 1,
 }
 
 // 全局实例
@@ -0,0 +1,55 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model_setting
+
+import (
+	"veloera/setting/config"
+)
+
+// HedgeSettings 定义对冲请求的配置，非流式请求在主请求超过渠道耗时分位数后向另一个渠道再发一次请求，先返回的结果胜出
+type HedgeSettings struct {
+	Enabled bool `json:"enabled"`
+	// 触发对冲的耗时分位数，例如 95 表示 p95
+	Percentile float64 `json:"percentile"`
+	// 渠道耗时样本数不足时使用默认等待时间
+	MinSamples     int `json:"min_samples"`
+	DefaultDelayMs int `json:"default_delay_ms"`
+	// 最短等待时间，避免过早发出对冲请求
+	MinDelayMs int `json:"min_delay_ms"`
+}
+
+// 默认配置
+var defaultHedgeSettings = HedgeSettings{
+	Enabled:        false,
+	Percentile:     95,
+	MinSamples:     20,
+	DefaultDelayMs: 3000,
+	MinDelayMs:     200,
+}
+
+// 全局实例
+var hedgeSettings = defaultHedgeSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("hedge", &hedgeSettings)
+}
+
+// GetHedgeSettings 获取对冲请求配置
+func GetHedgeSettings() *HedgeSettings {
+	return &hedgeSettings
+}
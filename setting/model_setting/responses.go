@@ -0,0 +1,58 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model_setting
+
+import (
+	"veloera/setting/config"
+)
+
+const (
+	ResponsesStoreScopeUser  = "user"
+	ResponsesStoreScopeToken = "token"
+)
+
+// ResponsesSettings 定义 Responses API 网关侧存储的配置
+type ResponsesSettings struct {
+	StoreEnabled  bool   `json:"store_enabled"`
+	RetentionDays int    `json:"retention_days"` // 0 表示永久保留
+	StoreScope    string `json:"store_scope"`    // user 或 token，决定存储的 response 对谁可见
+}
+
+// 默认配置
+var defaultResponsesSettings = ResponsesSettings{
+	StoreEnabled:  true,
+	RetentionDays: 30,
+	StoreScope:    ResponsesStoreScopeToken,
+}
+
+// 全局实例
+var responsesSettings = defaultResponsesSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("responses", &responsesSettings)
+}
+
+// GetResponsesSettings 获取 Responses 配置
+func GetResponsesSettings() *ResponsesSettings {
+	return &responsesSettings
+}
+
+// IsResponsesStoreScopedByToken 存储的 response 是否仅对创建它的令牌可见
+func IsResponsesStoreScopedByToken() bool {
+	return responsesSettings.StoreScope != ResponsesStoreScopeUser
+}
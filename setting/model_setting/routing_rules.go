@@ -0,0 +1,186 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model_setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"veloera/setting/config"
+)
+
+// RoutingRuleSettings 定义路由规则的配置，规则按顺序匹配，使用第一条命中的规则
+type RoutingRuleSettings struct {
+	Enabled bool `json:"enabled"`
+	// 规则列表的 JSON 文本，修改后在下次匹配时重新解析
+	Rules string `json:"rules"`
+}
+
+// RoutingRule 一条路由规则，匹配条件都满足时执行动作
+type RoutingRule struct {
+	Name     string            `json:"name"`
+	Disabled bool              `json:"disabled,omitempty"`
+	Match    RoutingRuleMatch  `json:"match"`
+	Action   RoutingRuleAction `json:"action"`
+}
+
+// RoutingRuleMatch 匹配条件，未设置的条件不参与匹配，列表中任意一项匹配即满足
+type RoutingRuleMatch struct {
+	TokenIds   []int    `json:"token_ids,omitempty"`
+	TokenNames []string `json:"token_names,omitempty"` // 支持 * 通配符
+	Groups     []string `json:"groups,omitempty"`      // 用户分组，令牌指定分组时为令牌分组
+	IPs        []string `json:"ips,omitempty"`         // IP 或 CIDR
+	// 请求头名称到值的映射，值支持 * 通配符，* 表示请求头存在即可
+	Headers         map[string]string `json:"headers,omitempty"`
+	Models          []string          `json:"models,omitempty"` // 请求的模型名，支持 * 通配符
+	MinPromptTokens int               `json:"min_prompt_tokens,omitempty"`
+	MaxPromptTokens int               `json:"max_prompt_tokens,omitempty"`
+	Stream          *bool             `json:"stream,omitempty"`
+}
+
+// RoutingRuleAction 命中规则后执行的动作
+type RoutingRuleAction struct {
+	// 拒绝请求并返回该消息，设置后忽略其他动作
+	Reject string `json:"reject,omitempty"`
+	// 固定使用指定渠道，不再重试其他渠道
+	ChannelId int `json:"channel_id,omitempty"`
+	// 只从指定标签的渠道中选择
+	Tag   string `json:"tag,omitempty"`
+	Group string `json:"group,omitempty"`
+	Model string `json:"model,omitempty"`
+	// 覆盖自动重试次数
+	RetryTimes *int `json:"retry_times,omitempty"`
+}
+
+// 默认配置
+var defaultRoutingRuleSettings = RoutingRuleSettings{
+	Enabled: false,
+	Rules:   "[]",
+}
+
+// 全局实例
+var routingRuleSettings = defaultRoutingRuleSettings
+
+// 解析后的规则，规则文本变化时重新解析
+var (
+	routingRulesLock   sync.RWMutex
+	routingRulesRaw    string
+	routingRulesParsed []RoutingRule
+)
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("routing_rules", &routingRuleSettings)
+}
+
+// GetRoutingRuleSettings 获取路由规则配置
+func GetRoutingRuleSettings() *RoutingRuleSettings {
+	return &routingRuleSettings
+}
+
+// GetRoutingRules 获取已启用的路由规则，未启用路由规则时返回空
+func GetRoutingRules() []RoutingRule {
+	if !routingRuleSettings.Enabled {
+		return nil
+	}
+	raw := routingRuleSettings.Rules
+	routingRulesLock.RLock()
+	if raw == routingRulesRaw {
+		rules := routingRulesParsed
+		routingRulesLock.RUnlock()
+		return rules
+	}
+	routingRulesLock.RUnlock()
+
+	rules, err := ParseRoutingRules(raw)
+	if err != nil {
+		// 保存时已经校验过，这里只可能是数据库中的旧数据
+		rules = nil
+	}
+	routingRulesLock.Lock()
+	routingRulesRaw = raw
+	routingRulesParsed = rules
+	routingRulesLock.Unlock()
+	return rules
+}
+
+// ParseRoutingRules 解析并校验路由规则
+func ParseRoutingRules(raw string) ([]RoutingRule, error) {
+	rules := make([]RoutingRule, 0)
+	if strings.TrimSpace(raw) == "" {
+		return rules, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, fmt.Errorf("路由规则不是合法的 JSON: %w", err)
+	}
+	for i, rule := range rules {
+		if err := validateRoutingRule(&rule); err != nil {
+			name := rule.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i+1)
+			}
+			return nil, fmt.Errorf("路由规则 %s: %w", name, err)
+		}
+	}
+	return rules, nil
+}
+
+func validateRoutingRule(rule *RoutingRule) error {
+	for _, pattern := range append(append([]string{}, rule.Match.TokenNames...), rule.Match.Models...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("通配符 %s 不合法", pattern)
+		}
+	}
+	for name, pattern := range rule.Match.Headers {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("请求头名称不能为空")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("通配符 %s 不合法", pattern)
+		}
+	}
+	for _, ip := range rule.Match.IPs {
+		if _, _, err := net.ParseCIDR(ip); err != nil && net.ParseIP(ip) == nil {
+			return fmt.Errorf("IP 或 CIDR %s 不合法", ip)
+		}
+	}
+	if rule.Match.MaxPromptTokens > 0 && rule.Match.MinPromptTokens > rule.Match.MaxPromptTokens {
+		return fmt.Errorf("最小提示词 token 数不能大于最大值")
+	}
+	action := rule.Action
+	if action.Reject == "" && action.ChannelId == 0 && action.Tag == "" && action.Group == "" && action.Model == "" && action.RetryTimes == nil {
+		return fmt.Errorf("至少需要设置一个动作")
+	}
+	if action.ChannelId < 0 {
+		return fmt.Errorf("渠道 ID 不合法")
+	}
+	if action.ChannelId != 0 && action.Tag != "" {
+		return fmt.Errorf("不能同时指定渠道和标签")
+	}
+	if action.RetryTimes != nil && *action.RetryTimes < 0 {
+		return fmt.Errorf("重试次数不能小于 0")
+	}
+	return nil
+}
+
+// NeedPromptTokens 判断规则是否需要估算提示词 token 数
+func (m *RoutingRuleMatch) NeedPromptTokens() bool {
+	return m.MinPromptTokens > 0 || m.MaxPromptTokens > 0
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model_setting
+
+import (
+	"veloera/setting/config"
+)
+
+// ShadowSettings 定义影子流量的全局配置，镜像比例和目标渠道在渠道额外设置中配置
+type ShadowSettings struct {
+	Enabled        bool `json:"enabled"`
+	TimeoutSeconds int  `json:"timeout_seconds"`
+	// 每个节点同时进行的影子请求数，0 表示不限制
+	MaxInflight int `json:"max_inflight"`
+	// 开启响应对比时每一方记录的最大字符数
+	DiffMaxLength int `json:"diff_max_length"`
+	RetentionDays int `json:"retention_days"` // 0 表示永久保留
+}
+
+// 默认配置
+var defaultShadowSettings = ShadowSettings{
+	Enabled:        true,
+	TimeoutSeconds: 120,
+	MaxInflight:    32,
+	DiffMaxLength:  4096,
+	RetentionDays:  7,
+}
+
+// 全局实例
+var shadowSettings = defaultShadowSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("shadow", &shadowSettings)
+}
+
+// GetShadowSettings 获取影子流量配置
+func GetShadowSettings() *ShadowSettings {
+	return &shadowSettings
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model_setting
+
+import (
+	"veloera/setting/config"
+)
+
+// StickySessionSettings 定义会话粘性路由的配置，同一会话的请求优先发往上次成功的渠道和 key，以命中上游的提示词缓存
+type StickySessionSettings struct {
+	Enabled bool `json:"enabled"`
+	// 客户端传入会话 id 的请求头
+	Header string `json:"header"`
+	// 没有会话 id 时，按开头的消息计算会话
+	HashMessagesEnabled bool `json:"hash_messages_enabled"`
+	// 参与计算的消息条数，系统提示词始终参与计算
+	HashMessageCount int `json:"hash_message_count"`
+	TTLSeconds       int `json:"ttl_seconds"`
+}
+
+// 默认配置
+var defaultStickySessionSettings = StickySessionSettings{
+	Enabled:             false,
+	Header:              "X-Session-Id",
+	HashMessagesEnabled: true,
+	HashMessageCount:    1,
+	TTLSeconds:          3600,
+}
+
+// 全局实例
+var stickySessionSettings = defaultStickySessionSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("sticky_session", &stickySessionSettings)
+}
+
+// GetStickySessionSettings 获取会话粘性路由配置
+func GetStickySessionSettings() *StickySessionSettings {
+	return &stickySessionSettings
+}
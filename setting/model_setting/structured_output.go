@@ -0,0 +1,48 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model_setting
+
+import (
+	"veloera/setting/config"
+)
+
+// StructuredOutputSettings 定义 response_format json_schema 的模拟配置。
+// 对不支持 json_schema 的上游，Claude 通过强制调用以 schema 为参数的工具实现，不支持工具调用的上游把 schema 写入系统提示词
+type StructuredOutputSettings struct {
+	Enabled bool `json:"enabled"`
+	// 非流式输出不符合 schema 时，把错误反馈给模型再请求一次
+	RepairEnabled bool `json:"repair_enabled"`
+}
+
+// 默认配置
+var defaultStructuredOutputSettings = StructuredOutputSettings{
+	Enabled:       true,
+	RepairEnabled: false,
+}
+
+// 全局实例
+var structuredOutputSettings = defaultStructuredOutputSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("structured_output", &structuredOutputSettings)
+}
+
+// GetStructuredOutputSettings 获取结构化输出模拟配置
+func GetStructuredOutputSettings() *StructuredOutputSettings {
+	return &structuredOutputSettings
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2025 Tethys Plex
+//
+// This file is part of Veloera.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+package model_setting
+
+import (
+	"veloera/setting/config"
+)
+
+// UpstreamRateLimitSettings 定义上游限流响应头的处理配置
+type UpstreamRateLimitSettings struct {
+	// 解析上游返回的 x-ratelimit-* 和 retry-after 等响应头
+	Enabled bool `json:"enabled"`
+	// 剩余请求数或 token 数低于上限的该比例时，同优先级下优先选择其他渠道
+	LowHeadroomRatio float64 `json:"low_headroom_ratio"`
+	// 上游返回 429 后冷却渠道的最长时间，避免异常的 retry-after 长时间屏蔽渠道
+	MaxCooldownSeconds int `json:"max_cooldown_seconds"`
+	// 上游返回 429 但没有 retry-after 和额度恢复时间时的冷却时间，为 0 时不冷却
+	DefaultCooldownSeconds int `json:"default_cooldown_seconds"`
+}
+
+// 默认配置
+var defaultUpstreamRateLimitSettings = UpstreamRateLimitSettings{
+	Enabled:                false,
+	LowHeadroomRatio:       0.05,
+	MaxCooldownSeconds:     300,
+	DefaultCooldownSeconds: 30,
+}
+
+// 全局实例
+var upstreamRateLimitSettings = defaultUpstreamRateLimitSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("upstream_rate_limit", &upstreamRateLimitSettings)
+}
+
+// GetUpstreamRateLimitSettings 获取上游限流响应头的处理配置
+func GetUpstreamRateLimitSettings() *UpstreamRateLimitSettings {
+	return &upstreamRateLimitSettings
+}
@@ -992,6 +992,24 @@ const LogsTable = () => {
             value: other.upstream_model_name,
           });
         }
+        if (other?.model_fallback_chain?.length > 1) {
+          expandDataLocal.push({
+            key: t('模型降级'),
+            value: other.model_fallback_chain.join(' -> '),
+          });
+        }
+        if (other?.queue_time > 0) {
+          expandDataLocal.push({
+            key: t('排队时间'),
+            value: `${other.queue_time} ms`,
+          });
+        }
+        if (isAdminUser && other?.admin_info?.routing_rule) {
+          expandDataLocal.push({
+            key: t('路由规则'),
+            value: other.admin_info.routing_rule,
+          });
+        }
         let content = '';
         if (other?.ws || other?.audio) {
           content = renderAudioModelPrice(
@@ -36,6 +36,7 @@ const ModelSetting = () => {
     'claude.thinking_adapter_enabled': true,
     'claude.default_max_tokens': '',
     'claude.thinking_adapter_budget_tokens_percentage': 0.8,
+    'claude.count_tokens_forward_enabled': false,
     'global.pass_through_request_enabled': false,
     'global.hide_upstream_error_enabled': false,
     'global.block_browser_extension_enabled': false,
@@ -64,7 +65,9 @@ const ModelSetting = () => {
           item.key === 'claude.model_headers_settings' ||
           item.key === 'claude.default_max_tokens' ||
           item.key === 'gemini.supported_imagine_models' ||
-          item.key === 'gemini.models_supported_thinking_budget'
+          item.key === 'gemini.models_supported_thinking_budget' ||
+          item.key === 'channel_selection.group_strategies' ||
+          item.key === 'channel_selection.model_strategies'
         ) {
           item.value = JSON.stringify(JSON.parse(item.value), null, 2);
         }
@@ -269,6 +269,36 @@ const supportsMultiKeyView = (type) => {
   return type !== 41;
 };
 
+const customProviderSettingTemplate = {
+  custom_provider: {
+    url: '{base_url}/v1/chat/completions',
+    auth: {
+      type: 'header',
+      header: 'Authorization',
+      scheme: 'Bearer',
+    },
+    headers: {},
+    request: {
+      defaults: {},
+      rename: {},
+      remove: [],
+      override: {},
+    },
+    response: {
+      content: '$.choices[0].message.content',
+      finish_reason: '$.choices[0].finish_reason',
+      prompt_tokens: '$.usage.prompt_tokens',
+      completion_tokens: '$.usage.completion_tokens',
+      error: '$.error',
+    },
+    stream: {
+      content: '$.choices[0].delta.content',
+      finish_reason: '$.choices[0].finish_reason',
+      done: '[DONE]',
+    },
+  },
+};
+
 const EditChannel = (props) => {
   const { t } = useTranslation();
   const navigate = useNavigate();
@@ -370,6 +400,57 @@ const EditChannel = (props) => {
     // The main inputs.key will be updated by updateKeyListToInput when adding/removing or on submit
   };
 
+  const [validatingCustomProvider, setValidatingCustomProvider] =
+    useState(false);
+
+  const validateCustomProvider = async () => {
+    if (validatingCustomProvider) {
+      return;
+    }
+    if (inputs.setting !== '' && !verifyJSON(inputs.setting)) {
+      showInfo(t('渠道额外设置必须是合法的 JSON 格式！'));
+      return;
+    }
+    setValidatingCustomProvider(true);
+    try {
+      const res = await API.post('/api/channel/custom_provider/validate', {
+        channel_id: isEdit ? channelId : 0,
+        base_url: inputs.base_url,
+        key: inputs.key,
+        model: inputs.test_model || inputs.models[0] || '',
+        setting: inputs.setting,
+      });
+      const { success, message, data } = res.data;
+      if (success) {
+        showSuccess(t('配置验证成功'));
+      } else {
+        showError(message);
+      }
+      if (data) {
+        Modal.info({
+          title: t('验证结果'),
+          width: 720,
+          content: (
+            <pre
+              style={{
+                maxHeight: 480,
+                overflow: 'auto',
+                whiteSpace: 'pre-wrap',
+                wordBreak: 'break-all',
+              }}
+            >
+              {JSON.stringify(data, null, 2)}
+            </pre>
+          ),
+        });
+      }
+    } catch (error) {
+      showError(error.message);
+    } finally {
+      setValidatingCustomProvider(false);
+    }
+  };
+
   const handleInputChange = (name, value) => {
     if (name === 'base_url' && value.endsWith('/v1')) {
       Modal.confirm({
@@ -1254,6 +1335,18 @@ const EditChannel = (props) => {
               </div>
             </>
           )}
+          {inputs.type === 50 && (
+            <>
+              <div style={{ marginTop: 10 }}>
+                <Banner
+                  type={'info'}
+                  description={t(
+                    '自定义供应商的请求地址、鉴权方式、请求字段映射和响应解析路径在下方“渠道额外设置”的 custom_provider 中配置，保存前可点击“验证配置”发送示例请求。',
+                  )}
+                ></Banner>
+              </div>
+            </>
+          )}
           <div style={{ marginTop: 10 }}>
             <Typography.Text strong>{t('名称')}：</Typography.Text>
           </div>
@@ -1683,6 +1776,35 @@ const EditChannel = (props) => {
               >
                 {t('填入模板')}
               </Typography.Text>
+              {inputs.type === 50 && (
+                <>
+                  <Typography.Text
+                    style={{
+                      color: 'rgba(var(--semi-blue-5), 1)',
+                      userSelect: 'none',
+                      cursor: 'pointer',
+                    }}
+                    onClick={() => {
+                      handleInputChange(
+                        'setting',
+                        JSON.stringify(customProviderSettingTemplate, null, 2),
+                      );
+                    }}
+                  >
+                    {t('填入自定义供应商模板')}
+                  </Typography.Text>
+                  <Typography.Text
+                    style={{
+                      color: 'rgba(var(--semi-blue-5), 1)',
+                      userSelect: 'none',
+                      cursor: 'pointer',
+                    }}
+                    onClick={validateCustomProvider}
+                  >
+                    {validatingCustomProvider ? t('验证中...') : t('验证配置')}
+                  </Typography.Text>
+                </>
+              )}
               <Typography.Text
                 style={{
                   color: 'rgba(var(--semi-blue-5), 1)',
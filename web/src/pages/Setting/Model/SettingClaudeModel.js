@@ -54,6 +54,7 @@ export default function SettingClaudeModel(props) {
     'claude.thinking_adapter_enabled': true,
     'claude.default_max_tokens': '',
     'claude.thinking_adapter_budget_tokens_percentage': 0.8,
+    'claude.count_tokens_forward_enabled': false,
   });
   const refForm = useRef();
   const [inputsRow, setInputsRow] = useState(inputs);
@@ -213,6 +214,23 @@ export default function SettingClaudeModel(props) {
                 />
               </Col>
             </Row>
+            <Row>
+              <Col span={16}>
+                <Form.Switch
+                  label={t('转发 count_tokens 请求到上游')}
+                  field={'claude.count_tokens_forward_enabled'}
+                  extraText={t(
+                    '开启后 /v1/messages/count_tokens 在 Anthropic、Bedrock、Vertex 渠道上使用上游返回的精确值，其他渠道以及上游失败时在本地计算',
+                  )}
+                  onChange={(value) =>
+                    setInputs({
+                      ...inputs,
+                      'claude.count_tokens_forward_enabled': value,
+                    })
+                  }
+                />
+              </Col>
+            </Row>
 
             <Row>
               <Button size='default' onClick={onSubmit}>
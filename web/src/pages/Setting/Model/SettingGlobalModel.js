@@ -28,6 +28,13 @@ import {
 } from '../../../helpers';
 import { useTranslation } from 'react-i18next';
 
+const CHANNEL_SELECTION_STRATEGIES = [
+  { label: '按权重随机', value: 'weighted_random' },
+  { label: '按请求耗时', value: 'latency' },
+  { label: '按首字时间', value: 'ttft' },
+  { label: '最少进行中请求', value: 'least_inflight' },
+];
+
 export default function SettingGlobalModel(props) {
   const { t } = useTranslation();
 
@@ -44,8 +51,59 @@ export default function SettingGlobalModel(props) {
     'global.auto_retry_count': 3,
     'global.auto_retry_force_channel_switch': false,
     'global.auto_retry_status_codes': '5xx,4xx',
+    'global.circuit_breaker_enabled': false,
+    'global.circuit_breaker_window_seconds': 60,
+    'global.circuit_breaker_min_requests': 10,
+    'global.circuit_breaker_error_rate': 0.5,
+    'global.circuit_breaker_timeout_rate': 0.3,
+    'global.circuit_breaker_open_seconds': 30,
+    'global.circuit_breaker_half_open_probes': 1,
+    'channel_selection.default_strategy': 'weighted_random',
+    'channel_selection.group_strategies': '',
+    'channel_selection.model_strategies': '',
+    'channel_selection.ewma_alpha': 0.3,
+    'sticky_session.enabled': false,
+    'sticky_session.header': 'X-Session-Id',
+    'sticky_session.hash_messages_enabled': true,
+    'sticky_session.hash_message_count': 1,
+    'sticky_session.ttl_seconds': 3600,
+    'hedge.enabled': false,
+    'hedge.percentile': 95,
+    'hedge.min_samples': 20,
+    'hedge.default_delay_ms': 3000,
+    'hedge.min_delay_ms': 200,
+    'channel_concurrency.queue_enabled': false,
+    'channel_concurrency.queue_timeout_ms': 10000,
+    'channel_concurrency.queue_max_size': 100,
+    'upstream_rate_limit.enabled': false,
+    'upstream_rate_limit.low_headroom_ratio': 0.05,
+    'upstream_rate_limit.max_cooldown_seconds': 300,
+    'upstream_rate_limit.default_cooldown_seconds': 30,
+    'routing_rules.enabled': false,
+    'routing_rules.rules': '[]',
+    'shadow.enabled': true,
+    'shadow.timeout_seconds': 120,
+    'shadow.max_inflight': 32,
+    'shadow.diff_max_length': 4096,
+    'shadow.retention_days': 7,
+    'canary_ramp.enabled': false,
+    'canary_ramp.initial_percent': 5,
+    'canary_ramp.step_percent': 15,
+    'canary_ramp.step_interval_seconds': 600,
+    'canary_ramp.error_rate_threshold': 0.2,
+    'canary_ramp.min_samples': 20,
     'general_setting.ping_interval_enabled': false,
     'general_setting.ping_interval_seconds': 60,
+    'responses.store_enabled': true,
+    'responses.retention_days': 30,
+    'responses.store_scope': 'token',
+    'batch.enabled': true,
+    'batch.discount_ratio': 0.5,
+    'batch.worker_count': 4,
+    'batch.max_file_size_mb': 100,
+    'batch.max_requests_per_batch': 50000,
+    'structured_output.enabled': true,
+    'structured_output.repair_enabled': false,
   });
   const refForm = useRef();
   const [inputsRow, setInputsRow] = useState(inputs);
@@ -254,6 +312,536 @@ export default function SettingGlobalModel(props) {
               </Row>
             </Form.Section>
 
+            <Form.Section text={t('渠道熔断设置')}>
+              <Row style={{ marginTop: 10 }}>
+                <Col span={24}>
+                  <Banner
+                    type='info'
+                    description='按渠道和模型统计滚动窗口内的错误率和超时率，超过阈值后熔断，熔断期间不会选择该渠道；熔断结束后放行少量探测请求，探测成功后恢复'
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Switch
+                    label={t('启用渠道熔断')}
+                    field={'global.circuit_breaker_enabled'}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('统计窗口（秒）')}
+                    field={'global.circuit_breaker_window_seconds'}
+                    min={10}
+                    disabled={!inputs['global.circuit_breaker_enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('最少请求数')}
+                    field={'global.circuit_breaker_min_requests'}
+                    min={1}
+                    extraText={'窗口内请求数达到该值后才会判断是否熔断'}
+                    disabled={!inputs['global.circuit_breaker_enabled']}
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('错误率阈值')}
+                    field={'global.circuit_breaker_error_rate'}
+                    min={0}
+                    max={1}
+                    step={0.05}
+                    extraText={'错误（含超时）占比，0 表示不按错误率熔断'}
+                    disabled={!inputs['global.circuit_breaker_enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('超时率阈值')}
+                    field={'global.circuit_breaker_timeout_rate'}
+                    min={0}
+                    max={1}
+                    step={0.05}
+                    extraText={'超时占比，0 表示不按超时率熔断'}
+                    disabled={!inputs['global.circuit_breaker_enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('熔断时长（秒）')}
+                    field={'global.circuit_breaker_open_seconds'}
+                    min={1}
+                    disabled={!inputs['global.circuit_breaker_enabled']}
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('半开探测请求数')}
+                    field={'global.circuit_breaker_half_open_probes'}
+                    min={1}
+                    extraText={'探测请求全部成功后关闭熔断，任一失败则重新熔断'}
+                    disabled={!inputs['global.circuit_breaker_enabled']}
+                  />
+                </Col>
+              </Row>
+            </Form.Section>
+
+            <Form.Section text={t('渠道选择策略')}>
+              <Row style={{ marginTop: 10 }}>
+                <Col span={24}>
+                  <Banner
+                    type='info'
+                    description='决定同一优先级内如何选择渠道，模型策略优先于分组策略，分组策略优先于默认策略；延迟和进行中请求数在每个节点内存中统计'
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Select
+                    label={t('默认策略')}
+                    field={'channel_selection.default_strategy'}
+                    optionList={CHANNEL_SELECTION_STRATEGIES.map((item) => ({
+                      label: t(item.label),
+                      value: item.value,
+                    }))}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('EWMA 平滑系数')}
+                    field={'channel_selection.ewma_alpha'}
+                    min={0.01}
+                    max={1}
+                    step={0.05}
+                    extraText={'越大越偏向最近的请求'}
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={12} lg={12} xl={12}>
+                  <Form.TextArea
+                    label={t('分组策略')}
+                    field={'channel_selection.group_strategies'}
+                    placeholder={
+                      t('为一个 JSON 文本，例如：') +
+                      '\n' +
+                      JSON.stringify({ default: 'latency' }, null, 2)
+                    }
+                    extraText={t(
+                      '可选策略：weighted_random、latency、ttft、least_inflight',
+                    )}
+                    autosize={{ minRows: 4, maxRows: 12 }}
+                    trigger='blur'
+                    stopValidateWithError
+                    rules={[
+                      {
+                        validator: (rule, value) => verifyJSON(value),
+                        message: t('不是合法的 JSON 字符串'),
+                      },
+                    ]}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={12} lg={12} xl={12}>
+                  <Form.TextArea
+                    label={t('模型策略')}
+                    field={'channel_selection.model_strategies'}
+                    placeholder={
+                      t('为一个 JSON 文本，例如：') +
+                      '\n' +
+                      JSON.stringify({ 'gpt-4o': 'ttft' }, null, 2)
+                    }
+                    extraText={t(
+                      '可选策略：weighted_random、latency、ttft、least_inflight',
+                    )}
+                    autosize={{ minRows: 4, maxRows: 12 }}
+                    trigger='blur'
+                    stopValidateWithError
+                    rules={[
+                      {
+                        validator: (rule, value) => verifyJSON(value),
+                        message: t('不是合法的 JSON 字符串'),
+                      },
+                    ]}
+                  />
+                </Col>
+              </Row>
+            </Form.Section>
+
+            <Form.Section text={t('会话粘性路由')}>
+              <Row style={{ marginTop: 10 }}>
+                <Col span={24}>
+                  <Banner
+                    type='info'
+                    description='同一令牌的同一会话优先发往上次成功的渠道和 key，以命中上游的提示词缓存；绑定的渠道被禁用、熔断或不再支持该模型时重新选择'
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Switch
+                    label={t('启用会话粘性路由')}
+                    field={'sticky_session.enabled'}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Input
+                    label={t('会话请求头')}
+                    field={'sticky_session.header'}
+                    placeholder='X-Session-Id'
+                    extraText={'客户端通过该请求头传入会话 id'}
+                    disabled={!inputs['sticky_session.enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('绑定有效期（秒）')}
+                    field={'sticky_session.ttl_seconds'}
+                    min={1}
+                    extraText={'每次成功请求后刷新'}
+                    disabled={!inputs['sticky_session.enabled']}
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Switch
+                    label={t('按开头消息识别会话')}
+                    field={'sticky_session.hash_messages_enabled'}
+                    extraText={'没有会话请求头时，按系统提示词和开头的消息识别会话'}
+                    disabled={!inputs['sticky_session.enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('参与识别的消息数')}
+                    field={'sticky_session.hash_message_count'}
+                    min={1}
+                    disabled={
+                      !inputs['sticky_session.enabled'] ||
+                      !inputs['sticky_session.hash_messages_enabled']
+                    }
+                  />
+                </Col>
+              </Row>
+            </Form.Section>
+
+            <Form.Section text={t('对冲请求')}>
+              <Row style={{ marginTop: 10 }}>
+                <Col span={24}>
+                  <Banner
+                    type='info'
+                    description='非流式的对话和向量请求超过渠道耗时分位数仍未返回时，向另一个渠道再发一次请求，先返回的结果胜出，另一个请求被取消且不计费'
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Switch
+                    label={t('启用对冲请求')}
+                    field={'hedge.enabled'}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('耗时分位数')}
+                    field={'hedge.percentile'}
+                    min={1}
+                    max={100}
+                    extraText={'例如 95 表示主请求超过渠道 p95 耗时后发出对冲请求'}
+                    disabled={!inputs['hedge.enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('最少样本数')}
+                    field={'hedge.min_samples'}
+                    min={1}
+                    extraText={'样本不足时使用默认等待时间'}
+                    disabled={!inputs['hedge.enabled']}
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('默认等待时间（毫秒）')}
+                    field={'hedge.default_delay_ms'}
+                    min={0}
+                    disabled={!inputs['hedge.enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('最短等待时间（毫秒）')}
+                    field={'hedge.min_delay_ms'}
+                    min={0}
+                    disabled={!inputs['hedge.enabled']}
+                  />
+                </Col>
+              </Row>
+            </Form.Section>
+
+            <Form.Section text={t('渠道并发排队')}>
+              <Row style={{ marginTop: 10 }}>
+                <Col span={24}>
+                  <Banner
+                    type='info'
+                    description='渠道的并发上限在渠道额外设置中通过 max_concurrency 和 key_max_concurrency 配置，所有渠道的并发都已满时排队等待空闲渠道，超时后返回 429'
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Switch
+                    label={t('启用排队')}
+                    field={'channel_concurrency.queue_enabled'}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('排队超时时间（毫秒）')}
+                    field={'channel_concurrency.queue_timeout_ms'}
+                    min={0}
+                    disabled={!inputs['channel_concurrency.queue_enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('最大排队请求数')}
+                    field={'channel_concurrency.queue_max_size'}
+                    min={0}
+                    extraText={'每个节点同时排队的请求数，0 表示不限制'}
+                    disabled={!inputs['channel_concurrency.queue_enabled']}
+                  />
+                </Col>
+              </Row>
+            </Form.Section>
+
+            <Form.Section text={t('上游限流')}>
+              <Row style={{ marginTop: 10 }}>
+                <Col span={24}>
+                  <Banner
+                    type='info'
+                    description='解析上游返回的 x-ratelimit-*、anthropic-ratelimit-* 和 retry-after 响应头，剩余额度不足的渠道和 key 在同优先级中靠后选择，上游返回 429 后在 retry-after 之前不再选择，也不会被自动禁用'
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Switch
+                    label={t('启用上游限流感知')}
+                    field={'upstream_rate_limit.enabled'}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('剩余额度比例阈值')}
+                    field={'upstream_rate_limit.low_headroom_ratio'}
+                    min={0}
+                    max={1}
+                    step={0.01}
+                    extraText={'剩余请求数或 token 数低于上限的该比例时视为额度不足'}
+                    disabled={!inputs['upstream_rate_limit.enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('最长冷却时间（秒）')}
+                    field={'upstream_rate_limit.max_cooldown_seconds'}
+                    min={0}
+                    disabled={!inputs['upstream_rate_limit.enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('默认冷却时间（秒）')}
+                    field={'upstream_rate_limit.default_cooldown_seconds'}
+                    min={0}
+                    extraText={'上游返回 429 但没有 retry-after 等限流头时的冷却时间，为 0 时不冷却'}
+                    disabled={!inputs['upstream_rate_limit.enabled']}
+                  />
+                </Col>
+              </Row>
+            </Form.Section>
+
+            <Form.Section text={t('路由规则')}>
+              <Row style={{ marginTop: 10 }}>
+                <Col span={24}>
+                  <Banner
+                    type='info'
+                    description='按顺序匹配令牌、分组、客户端 IP、请求头、模型、提示词 token 数和是否流式，使用第一条命中的规则；动作可以拒绝请求、固定渠道或标签、切换分组、改写模型和设置重试次数。可以通过 POST /api/option/routing_rules/dry_run 试运行规则'
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Switch
+                    label={t('启用路由规则')}
+                    field={'routing_rules.enabled'}
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col span={24}>
+                  <Form.TextArea
+                    label={t('规则列表')}
+                    field={'routing_rules.rules'}
+                    placeholder={
+                      t('为一个 JSON 文本，例如：') +
+                      '\n' +
+                      JSON.stringify(
+                        [
+                          {
+                            name: 'long-context',
+                            match: { models: ['gpt-4o*'], min_prompt_tokens: 32000 },
+                            action: { tag: 'long-context', retry_times: 1 },
+                          },
+                        ],
+                        null,
+                        2,
+                      )
+                    }
+                    autosize={{ minRows: 6, maxRows: 20 }}
+                    trigger='blur'
+                    stopValidateWithError
+                    rules={[
+                      {
+                        validator: (rule, value) => verifyJSON(value),
+                        message: t('不是合法的 JSON 字符串'),
+                      },
+                    ]}
+                  />
+                </Col>
+              </Row>
+            </Form.Section>
+
+            <Form.Section text={t('影子流量')}>
+              <Row style={{ marginTop: 10 }}>
+                <Col span={24}>
+                  <Banner
+                    type='info'
+                    description='在渠道额外设置中通过 shadow_channel_id 或 shadow_tag 指定候选渠道，shadow_ratio 指定镜像的请求百分比，shadow_diff 记录双方的响应内容。主请求成功后异步把对话、补全和向量请求镜像到候选渠道，不影响客户端也不计费，结果可以通过 /api/shadow_log/ 查询'
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Switch
+                    label={t('启用影子流量')}
+                    field={'shadow.enabled'}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('影子请求超时时间（秒）')}
+                    field={'shadow.timeout_seconds'}
+                    min={1}
+                    disabled={!inputs['shadow.enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('最大并发影子请求数')}
+                    field={'shadow.max_inflight'}
+                    min={0}
+                    extraText={'每个节点同时进行的影子请求数，0 表示不限制'}
+                    disabled={!inputs['shadow.enabled']}
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('响应对比最大长度')}
+                    field={'shadow.diff_max_length'}
+                    min={0}
+                    extraText={'每一方记录的最大字符数，0 表示不限制'}
+                    disabled={!inputs['shadow.enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('影子日志保留天数')}
+                    field={'shadow.retention_days'}
+                    min={0}
+                    extraText={'0 表示永久保留'}
+                  />
+                </Col>
+              </Row>
+            </Form.Section>
+
+            <Form.Section text={t('灰度放量')}>
+              <Row style={{ marginTop: 10 }}>
+                <Col span={24}>
+                  <Banner
+                    type='info'
+                    description='新增或重新启用的渠道先只承担所在优先级的一小部分流量，每个阶段结束且错误率低于阈值时提高比例，达到 100% 后结束放量；阶段内错误率达到阈值时自动回退到初始比例。渠道额外设置中 canary_ramp 为 false 时不参与放量，放量状态可以通过 /api/channel/canary 查询'
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Switch
+                    label={t('启用灰度放量')}
+                    field={'canary_ramp.enabled'}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('初始流量比例（%）')}
+                    field={'canary_ramp.initial_percent'}
+                    min={1}
+                    max={100}
+                    disabled={!inputs['canary_ramp.enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('每阶段增加比例（%）')}
+                    field={'canary_ramp.step_percent'}
+                    min={1}
+                    max={100}
+                    disabled={!inputs['canary_ramp.enabled']}
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('每阶段最短时长（秒）')}
+                    field={'canary_ramp.step_interval_seconds'}
+                    min={0}
+                    disabled={!inputs['canary_ramp.enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('回退错误率阈值')}
+                    field={'canary_ramp.error_rate_threshold'}
+                    min={0}
+                    max={1}
+                    step={0.05}
+                    extraText={'0 表示不回退'}
+                    disabled={!inputs['canary_ramp.enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('每阶段最少请求数')}
+                    field={'canary_ramp.min_samples'}
+                    min={1}
+                    extraText={'请求数不足时不判断错误率，也不进入下一阶段'}
+                    disabled={!inputs['canary_ramp.enabled']}
+                  />
+                </Col>
+              </Row>
+            </Form.Section>
+
             <Form.Section text={t('连接保活设置')}>
               <Row style={{ marginTop: 10 }}>
                 <Col span={24}>
@@ -294,6 +882,129 @@ export default function SettingGlobalModel(props) {
               </Row>
             </Form.Section>
 
+            <Form.Section text={t('Responses 存储设置')}>
+              <Row style={{ marginTop: 10 }}>
+                <Col span={24}>
+                  <Banner
+                    type='info'
+                    description='在网关侧保存 Responses API 的响应，支持跨渠道的 previous_response_id 续写以及查询、删除已存储的响应'
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Switch
+                    label={t('启用响应存储')}
+                    field={'responses.store_enabled'}
+                    extraText={'请求中 store 为 false 时不会存储'}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('保留天数')}
+                    field={'responses.retention_days'}
+                    min={0}
+                    extraText={'超过保留天数的响应将被清理，0 表示永久保留'}
+                    disabled={!inputs['responses.store_enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Select
+                    label={t('可见范围')}
+                    field={'responses.store_scope'}
+                    optionList={[
+                      { label: t('仅创建该响应的令牌'), value: 'token' },
+                      { label: t('同一用户的所有令牌'), value: 'user' },
+                    ]}
+                    disabled={!inputs['responses.store_enabled']}
+                  />
+                </Col>
+              </Row>
+            </Form.Section>
+
+            <Form.Section text={t('批处理设置')}>
+              <Row style={{ marginTop: 10 }}>
+                <Col span={24}>
+                  <Banner
+                    type='info'
+                    description='在网关侧实现 Files 和 Batch API，批处理中的请求按普通请求走渠道分发并按折扣倍率计费'
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Switch
+                    label={t('启用批处理')}
+                    field={'batch.enabled'}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('批处理折扣倍率')}
+                    field={'batch.discount_ratio'}
+                    min={0}
+                    step={0.1}
+                    extraText={'批处理请求的额度消耗乘以该倍率'}
+                    disabled={!inputs['batch.enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('并发执行数')}
+                    field={'batch.worker_count'}
+                    min={1}
+                    extraText={'每个节点同时执行的批处理请求数'}
+                    disabled={!inputs['batch.enabled']}
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('文件大小上限（MB）')}
+                    field={'batch.max_file_size_mb'}
+                    min={1}
+                    disabled={!inputs['batch.enabled']}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.InputNumber
+                    label={t('单个批处理请求数上限')}
+                    field={'batch.max_requests_per_batch'}
+                    min={1}
+                    disabled={!inputs['batch.enabled']}
+                  />
+                </Col>
+              </Row>
+            </Form.Section>
+
+            <Form.Section text={t('结构化输出模拟')}>
+              <Row style={{ marginTop: 10 }}>
+                <Col span={24}>
+                  <Banner
+                    type='info'
+                    description='请求 response_format 为 json_schema 时，Claude 渠道强制调用一个以该 schema 为参数的工具并把参数还原为输出，Cohere、百度等不支持工具调用的渠道把 schema 写入系统提示词；非流式输出会按 schema 校验'
+                  />
+                </Col>
+              </Row>
+              <Row>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Switch
+                    label={t('启用结构化输出模拟')}
+                    field={'structured_output.enabled'}
+                  />
+                </Col>
+                <Col xs={24} sm={12} md={8} lg={8} xl={8}>
+                  <Form.Switch
+                    label={t('校验失败时修复重试')}
+                    field={'structured_output.repair_enabled'}
+                    extraText={'输出不符合 schema 时把错误反馈给模型再请求一次，两次请求都会计费'}
+                    disabled={!inputs['structured_output.enabled']}
+                  />
+                </Col>
+              </Row>
+            </Form.Section>
+
             <Row>
               <Button size='default' onClick={onSubmit}>
                 {t('保存')}
@@ -31,6 +31,7 @@ import {
   Card,
   Spin,
   Tag,
+  TextArea,
 } from '@douyinfe/semi-ui';
 import { API, showError, showSuccess, showWarning } from '../../../helpers';
 import { useTranslation } from 'react-i18next';
@@ -48,6 +49,8 @@ export default function SettingModelMapping() {
   
   const [loading, setLoading] = useState(false);
   const [mappings, setMappings] = useState({});
+  const [fallbacks, setFallbacks] = useState({});
+  const [fallbacksText, setFallbacksText] = useState('');
   const [modalVisible, setModalVisible] = useState(false);
   const [editingMapping, setEditingMapping] = useState(null);
   const [models, setModels] = useState([{ model: '', priorities: 0 }]);
@@ -62,6 +65,13 @@ export default function SettingModelMapping() {
       const res = await API.get('/api/model_mapping/');
       if (res.data.success) {
         setMappings(res.data.data.mapping || {});
+        const fallbackChains = res.data.data.fallbacks || {};
+        setFallbacks(fallbackChains);
+        setFallbacksText(
+          Object.keys(fallbackChains).length > 0
+            ? JSON.stringify(fallbackChains, null, 2)
+            : '',
+        );
       } else {
         showError(res.data.message);
       }
@@ -201,6 +211,7 @@ export default function SettingModelMapping() {
 
       const res = await API.put('/api/model_mapping/', {
         mapping: newMappings,
+        fallbacks,
       });
       if (res.data.success) {
         showSuccess(res.data.message || '保存成功');
@@ -233,6 +244,7 @@ export default function SettingModelMapping() {
 
       const res = await API.put('/api/model_mapping/', {
         mapping: newMappings,
+        fallbacks,
       });
       if (res.data.success) {
         showSuccess('删除成功');
@@ -245,6 +257,33 @@ export default function SettingModelMapping() {
     }
   };
 
+  // 保存模型降级链
+  const handleSaveFallbacks = async () => {
+    let newFallbacks = {};
+    if (fallbacksText.trim() !== '') {
+      try {
+        newFallbacks = JSON.parse(fallbacksText);
+      } catch (error) {
+        showError('降级链不是合法的 JSON');
+        return;
+      }
+    }
+    try {
+      const res = await API.put('/api/model_mapping/', {
+        mapping: mappings,
+        fallbacks: newFallbacks,
+      });
+      if (res.data.success) {
+        showSuccess(res.data.message || '保存成功');
+        setFallbacks(newFallbacks);
+      } else {
+        showError(res.data.message);
+      }
+    } catch (error) {
+      showError('保存失败：' + error.message);
+    }
+  };
+
   // 重新加载配置
   const handleReload = async () => {
     try {
@@ -463,6 +502,27 @@ export default function SettingModelMapping() {
               />
             </div>
           </Form.Section>
+          <Form.Section text='模型降级链'>
+            <Banner
+              type='info'
+              description='某个模型的所有渠道都失败或没有可用渠道时，按顺序切换到降级模型，按实际提供服务的模型计费。键可以是虚拟模型名或实际模型名。'
+              style={{ marginBottom: 16 }}
+            />
+            <TextArea
+              value={fallbacksText}
+              placeholder={JSON.stringify(
+                { 'gpt-4o': ['gpt-4.1', 'claude-sonnet-4-20250514'] },
+                null,
+                2,
+              )}
+              autosize={{ minRows: 6, maxRows: 12 }}
+              onChange={(value) => setFallbacksText(value)}
+              style={{ marginBottom: 16 }}
+            />
+            <Button type='primary' onClick={handleSaveFallbacks}>
+              保存降级链
+            </Button>
+          </Form.Section>
         </Form>
       </Spin>
 